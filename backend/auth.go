@@ -0,0 +1,429 @@
+package main
+
+import (
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"database/sql"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+// 用户角色，权限由低到高
+const (
+	RoleViewer      = "viewer"
+	RoleContributor = "contributor"
+	RoleAdmin       = "admin"
+)
+
+var roleLevels = map[string]int{
+	RoleViewer:      1,
+	RoleContributor: 2,
+	RoleAdmin:       3,
+}
+
+// gin上下文中保存当前用户的键
+const ctxUserKey = "auth_user"
+
+type User struct {
+	ID        int    `json:"id" db:"id"`
+	Username  string `json:"username" db:"username"`
+	Role      string `json:"role" db:"role"`
+	Disabled  bool   `json:"disabled" db:"disabled"`
+	CreatedAt string `json:"created_at" db:"created_at"`
+}
+
+type TokenRequest struct {
+	APIKey string `json:"api_key" binding:"required"`
+}
+
+type CreateUserRequest struct {
+	Username string `json:"username" binding:"required"`
+	Role     string `json:"role"`
+}
+
+type UpdateUserRequest struct {
+	Role     string `json:"role"`
+	Disabled *bool  `json:"disabled"`
+}
+
+// JWT载荷
+type TokenClaims struct {
+	Subject   int    `json:"sub"`
+	Username  string `json:"name"`
+	Role      string `json:"role"`
+	IssuedAt  int64  `json:"iat"`
+	ExpiresAt int64  `json:"exp"`
+}
+
+var (
+	errTokenMalformed = errors.New("token格式错误")
+	errTokenSignature = errors.New("token签名无效")
+	errTokenExpired   = errors.New("token已过期")
+)
+
+// TokenIssuer 使用HS256签发和校验会话JWT
+type TokenIssuer struct {
+	secret []byte
+	ttl    time.Duration
+	now    func() time.Time
+}
+
+func NewTokenIssuer(secret []byte, ttl time.Duration) *TokenIssuer {
+	return &TokenIssuer{secret: secret, ttl: ttl, now: time.Now}
+}
+
+var tokenIssuer *TokenIssuer
+
+var jwtHeader = base64.RawURLEncoding.EncodeToString([]byte(`{"alg":"HS256","typ":"JWT"}`))
+
+// 为用户签发会话token
+func (t *TokenIssuer) Issue(user User) (string, TokenClaims, error) {
+	now := t.now()
+	claims := TokenClaims{
+		Subject:   user.ID,
+		Username:  user.Username,
+		Role:      user.Role,
+		IssuedAt:  now.Unix(),
+		ExpiresAt: now.Add(t.ttl).Unix(),
+	}
+	payload, err := json.Marshal(claims)
+	if err != nil {
+		return "", claims, err
+	}
+	signingInput := jwtHeader + "." + base64.RawURLEncoding.EncodeToString(payload)
+	return signingInput + "." + t.sign(signingInput), claims, nil
+}
+
+// 校验token签名和有效期，返回载荷
+func (t *TokenIssuer) Parse(token string) (*TokenClaims, error) {
+	parts := strings.Split(token, ".")
+	if len(parts) != 3 {
+		return nil, errTokenMalformed
+	}
+	if parts[0] != jwtHeader {
+		return nil, errTokenMalformed
+	}
+	expected := t.sign(parts[0] + "." + parts[1])
+	if !hmac.Equal([]byte(expected), []byte(parts[2])) {
+		return nil, errTokenSignature
+	}
+	payload, err := base64.RawURLEncoding.DecodeString(parts[1])
+	if err != nil {
+		return nil, errTokenMalformed
+	}
+	var claims TokenClaims
+	if err := json.Unmarshal(payload, &claims); err != nil {
+		return nil, errTokenMalformed
+	}
+	if t.now().Unix() >= claims.ExpiresAt {
+		return nil, errTokenExpired
+	}
+	return &claims, nil
+}
+
+func (t *TokenIssuer) sign(input string) string {
+	mac := hmac.New(sha256.New, t.secret)
+	mac.Write([]byte(input))
+	return base64.RawURLEncoding.EncodeToString(mac.Sum(nil))
+}
+
+// env.example中的示例密钥
+const jwtSecretPlaceholder = "change_me"
+
+// JWT密钥：未设置或仍为示例值时拒绝启动
+func jwtSecretFromEnv() ([]byte, error) {
+	secret := strings.TrimSpace(os.Getenv("JWT_SECRET"))
+	if secret == "" || secret == jwtSecretPlaceholder {
+		return nil, fmt.Errorf("JWT_SECRET未设置或仍为示例值 %s，请设置随机密钥后再启动", jwtSecretPlaceholder)
+	}
+	return []byte(secret), nil
+}
+
+// 初始化鉴权：JWT密钥、会话时长、管理员引导账号
+func initAuth() {
+	secret, err := jwtSecretFromEnv()
+	if err != nil {
+		log.Fatalf("[鉴权] %v", err)
+	}
+	ttl := time.Duration(envPositiveInt("JWT_TTL_MINUTES", 720)) * time.Minute
+	tokenIssuer = NewTokenIssuer(secret, ttl)
+
+	// 通过ADMIN_API_KEY引导首个管理员
+	if adminKey := os.Getenv("ADMIN_API_KEY"); adminKey != "" {
+		var count int
+		db.QueryRow("SELECT COUNT(*) FROM users WHERE role = ?", RoleAdmin).Scan(&count)
+		if count == 0 {
+			_, err := db.Exec(`INSERT INTO users (username, role, api_key_hash) VALUES (?, ?, ?)`,
+				"admin", RoleAdmin, hashAPIKey(adminKey))
+			if err != nil {
+				log.Printf("[鉴权] 创建管理员失败: %v", err)
+			} else {
+				log.Println("[鉴权] 已通过ADMIN_API_KEY创建管理员账号 admin")
+			}
+		}
+	}
+}
+
+// 生成新的API Key（仅在创建/轮换时返回一次明文）
+func generateAPIKey() string {
+	buf := make([]byte, 24)
+	rand.Read(buf)
+	return "hs_" + hex.EncodeToString(buf)
+}
+
+func hashAPIKey(key string) string {
+	sum := sha256.Sum256([]byte(key))
+	return hex.EncodeToString(sum[:])
+}
+
+func validRole(role string) bool {
+	_, ok := roleLevels[role]
+	return ok
+}
+
+func findUserByAPIKey(key string) (*User, error) {
+	var u User
+	err := db.QueryRow(`
+		SELECT id, username, role, disabled, created_at
+		FROM users
+		WHERE api_key_hash = ?
+	`, hashAPIKey(key)).Scan(&u.ID, &u.Username, &u.Role, &u.Disabled, &u.CreatedAt)
+	if err != nil {
+		return nil, err
+	}
+	return &u, nil
+}
+
+func findUserByID(id int) (*User, error) {
+	var u User
+	err := db.QueryRow(`
+		SELECT id, username, role, disabled, created_at
+		FROM users
+		WHERE id = ?
+	`, id).Scan(&u.ID, &u.Username, &u.Role, &u.Disabled, &u.CreatedAt)
+	if err != nil {
+		return nil, err
+	}
+	return &u, nil
+}
+
+// 解析请求凭证：Authorization: Bearer <JWT> 或 X-API-Key
+// 未携带凭证的请求按匿名处理，携带无效凭证直接返回401
+func authMiddleware() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		var user *User
+		if apiKey := c.GetHeader("X-API-Key"); apiKey != "" {
+			u, err := findUserByAPIKey(apiKey)
+			if err != nil {
+				c.AbortWithStatusJSON(http.StatusUnauthorized, gin.H{"error": "API Key无效"})
+				return
+			}
+			user = u
+		} else if authz := c.GetHeader("Authorization"); strings.HasPrefix(authz, "Bearer ") {
+			claims, err := tokenIssuer.Parse(strings.TrimPrefix(authz, "Bearer "))
+			if err != nil {
+				c.AbortWithStatusJSON(http.StatusUnauthorized, gin.H{"error": err.Error()})
+				return
+			}
+			// 以数据库为准，角色变更或禁用立即生效
+			u, err := findUserByID(claims.Subject)
+			if err != nil {
+				c.AbortWithStatusJSON(http.StatusUnauthorized, gin.H{"error": "用户不存在"})
+				return
+			}
+			user = u
+		}
+		if user != nil {
+			if user.Disabled {
+				c.AbortWithStatusJSON(http.StatusForbidden, gin.H{"error": "用户已被禁用"})
+				return
+			}
+			c.Set(ctxUserKey, *user)
+		}
+		c.Next()
+	}
+}
+
+// 要求当前用户至少具备指定角色
+func requireRole(role string) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		user, ok := currentUser(c)
+		if !ok {
+			c.AbortWithStatusJSON(http.StatusUnauthorized, gin.H{"error": "需要登录"})
+			return
+		}
+		if roleLevels[user.Role] < roleLevels[role] {
+			c.AbortWithStatusJSON(http.StatusForbidden, gin.H{"error": "权限不足", "required_role": role})
+			return
+		}
+		c.Next()
+	}
+}
+
+func currentUser(c *gin.Context) (User, bool) {
+	v, ok := c.Get(ctxUserKey)
+	if !ok {
+		return User{}, false
+	}
+	user, ok := v.(User)
+	return user, ok
+}
+
+// 用API Key换取会话JWT
+func issueToken(c *gin.Context) {
+	var req TokenRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	user, err := findUserByAPIKey(req.APIKey)
+	if err != nil {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "API Key无效"})
+		return
+	}
+	if user.Disabled {
+		c.JSON(http.StatusForbidden, gin.H{"error": "用户已被禁用"})
+		return
+	}
+	token, claims, err := tokenIssuer.Issue(*user)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{
+		"status":     "success",
+		"token":      token,
+		"expires_at": time.Unix(claims.ExpiresAt, 0).Format(time.RFC3339),
+		"data":       user,
+	})
+}
+
+// 当前登录用户信息
+func getCurrentUser(c *gin.Context) {
+	user, _ := currentUser(c)
+	c.JSON(http.StatusOK, gin.H{"status": "success", "data": user})
+}
+
+// 管理员：用户列表
+func listUsers(c *gin.Context) {
+	rows, err := db.Query(`
+		SELECT id, username, role, disabled, created_at
+		FROM users
+		ORDER BY id
+	`)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+	defer rows.Close()
+
+	var users []User
+	for rows.Next() {
+		var u User
+		if err := rows.Scan(&u.ID, &u.Username, &u.Role, &u.Disabled, &u.CreatedAt); err != nil {
+			continue
+		}
+		users = append(users, u)
+	}
+	c.JSON(http.StatusOK, gin.H{"status": "success", "count": len(users), "data": users})
+}
+
+// 管理员：创建用户并返回一次性API Key
+func createUser(c *gin.Context) {
+	var req CreateUserRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	if req.Role == "" {
+		req.Role = RoleViewer
+	}
+	if !validRole(req.Role) {
+		c.JSON(http.StatusBadRequest, gin.H{"error": fmt.Sprintf("未知角色: %s", req.Role)})
+		return
+	}
+	apiKey := generateAPIKey()
+	result, err := db.Exec(`INSERT INTO users (username, role, api_key_hash) VALUES (?, ?, ?)`,
+		req.Username, req.Role, hashAPIKey(apiKey))
+	if err != nil {
+		c.JSON(http.StatusConflict, gin.H{"error": err.Error()})
+		return
+	}
+	id, _ := result.LastInsertId()
+	user, err := findUserByID(int(id))
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"status": "success", "api_key": apiKey, "data": user})
+}
+
+// 管理员：修改角色/禁用用户
+func updateUser(c *gin.Context) {
+	id, err := strconv.Atoi(c.Param("id"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid user ID"})
+		return
+	}
+	var req UpdateUserRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	user, err := findUserByID(id)
+	if err == sql.ErrNoRows {
+		c.JSON(http.StatusNotFound, gin.H{"error": "User not found"})
+		return
+	} else if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+	if req.Role != "" {
+		if !validRole(req.Role) {
+			c.JSON(http.StatusBadRequest, gin.H{"error": fmt.Sprintf("未知角色: %s", req.Role)})
+			return
+		}
+		user.Role = req.Role
+	}
+	if req.Disabled != nil {
+		user.Disabled = *req.Disabled
+	}
+	_, err = db.Exec(`UPDATE users SET role = ?, disabled = ? WHERE id = ?`, user.Role, user.Disabled, id)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"status": "success", "data": user})
+}
+
+// 管理员：轮换用户API Key
+func rotateUserKey(c *gin.Context) {
+	id, err := strconv.Atoi(c.Param("id"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid user ID"})
+		return
+	}
+	apiKey := generateAPIKey()
+	result, err := db.Exec(`UPDATE users SET api_key_hash = ? WHERE id = ?`, hashAPIKey(apiKey), id)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+	if n, _ := result.RowsAffected(); n == 0 {
+		c.JSON(http.StatusNotFound, gin.H{"error": "User not found"})
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"status": "success", "api_key": apiKey})
+}
@@ -0,0 +1,194 @@
+package main
+
+import (
+	"bytes"
+	"database/sql"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+// 使用内存数据库替换全局db
+func setupTestDB(t *testing.T) {
+	t.Helper()
+	testDB, err := sql.Open("sqlite3", ":memory:")
+	if err != nil {
+		t.Fatal(err)
+	}
+	// 内存库每个连接相互独立，固定为单连接
+	testDB.SetMaxOpenConns(1)
+	prev := db
+	db = testDB
+	createTables()
+	t.Cleanup(func() {
+		testDB.Close()
+		db = prev
+	})
+}
+
+// 进程内签发器，时钟可控
+func setupTestIssuer(t *testing.T, now time.Time) *TokenIssuer {
+	t.Helper()
+	issuer := NewTokenIssuer([]byte("test-secret"), time.Hour)
+	issuer.now = func() time.Time { return now }
+	prev := tokenIssuer
+	tokenIssuer = issuer
+	t.Cleanup(func() { tokenIssuer = prev })
+	return issuer
+}
+
+func createTestUser(t *testing.T, username, role string) (User, string) {
+	t.Helper()
+	apiKey := generateAPIKey()
+	result, err := db.Exec(`INSERT INTO users (username, role, api_key_hash) VALUES (?, ?, ?)`,
+		username, role, hashAPIKey(apiKey))
+	if err != nil {
+		t.Fatal(err)
+	}
+	id, _ := result.LastInsertId()
+	user, err := findUserByID(int(id))
+	if err != nil {
+		t.Fatal(err)
+	}
+	return *user, apiKey
+}
+
+func newAuthTestRouter() *gin.Engine {
+	gin.SetMode(gin.TestMode)
+	r := gin.New()
+	r.Use(authMiddleware())
+	ok := func(c *gin.Context) { c.JSON(http.StatusOK, gin.H{"status": "success"}) }
+	r.GET("/public", ok)
+	r.POST("/write", requireRole(RoleContributor), ok)
+	r.GET("/admin", requireRole(RoleAdmin), ok)
+	r.POST("/api/auth/token", issueToken)
+	return r
+}
+
+func doRequest(r http.Handler, method, path string, body []byte, headers map[string]string) *httptest.ResponseRecorder {
+	req := httptest.NewRequest(method, path, bytes.NewReader(body))
+	req.Header.Set("Content-Type", "application/json")
+	for k, v := range headers {
+		req.Header.Set(k, v)
+	}
+	w := httptest.NewRecorder()
+	r.ServeHTTP(w, req)
+	return w
+}
+
+func TestTokenIssuerRoundTrip(t *testing.T) {
+	now := time.Date(2025, 7, 1, 8, 0, 0, 0, time.UTC)
+	issuer := NewTokenIssuer([]byte("secret"), time.Hour)
+	issuer.now = func() time.Time { return now }
+
+	token, _, err := issuer.Issue(User{ID: 7, Username: "alice", Role: RoleContributor})
+	if err != nil {
+		t.Fatal(err)
+	}
+	claims, err := issuer.Parse(token)
+	if err != nil {
+		t.Fatalf("Parse: %v", err)
+	}
+	if claims.Subject != 7 || claims.Role != RoleContributor || claims.Username != "alice" {
+		t.Errorf("unexpected claims: %+v", claims)
+	}
+
+	// 过期
+	issuer.now = func() time.Time { return now.Add(2 * time.Hour) }
+	if _, err := issuer.Parse(token); err != errTokenExpired {
+		t.Errorf("expected errTokenExpired, got %v", err)
+	}
+
+	// 其他密钥签发的token
+	other := NewTokenIssuer([]byte("other"), time.Hour)
+	other.now = issuer.now
+	forged, _, _ := other.Issue(User{ID: 7, Role: RoleAdmin})
+	if _, err := issuer.Parse(forged); err != errTokenSignature {
+		t.Errorf("expected errTokenSignature, got %v", err)
+	}
+
+	if _, err := issuer.Parse("not-a-token"); err != errTokenMalformed {
+		t.Errorf("expected errTokenMalformed, got %v", err)
+	}
+}
+
+func TestJWTSecretRequired(t *testing.T) {
+	for _, v := range []string{"", "  ", jwtSecretPlaceholder} {
+		t.Setenv("JWT_SECRET", v)
+		if _, err := jwtSecretFromEnv(); err == nil {
+			t.Errorf("JWT_SECRET=%q 应拒绝启动", v)
+		}
+	}
+	t.Setenv("JWT_SECRET", "s3cr3t-value")
+	if secret, err := jwtSecretFromEnv(); err != nil || string(secret) != "s3cr3t-value" {
+		t.Fatalf("有效密钥: %q %v", secret, err)
+	}
+}
+
+func TestRoutePermissions(t *testing.T) {
+	setupTestDB(t)
+	issuer := setupTestIssuer(t, time.Now())
+	r := newAuthTestRouter()
+
+	_, viewerKey := createTestUser(t, "viewer", RoleViewer)
+	admin, _ := createTestUser(t, "root", RoleAdmin)
+	adminToken, _, _ := issuer.Issue(admin)
+
+	cases := []struct {
+		name    string
+		method  string
+		path    string
+		headers map[string]string
+		want    int
+	}{
+		{"anonymous public", "GET", "/public", nil, http.StatusOK},
+		{"anonymous write", "POST", "/write", nil, http.StatusUnauthorized},
+		{"viewer write", "POST", "/write", map[string]string{"X-API-Key": viewerKey}, http.StatusForbidden},
+		{"viewer admin", "GET", "/admin", map[string]string{"X-API-Key": viewerKey}, http.StatusForbidden},
+		{"admin jwt", "GET", "/admin", map[string]string{"Authorization": "Bearer " + adminToken}, http.StatusOK},
+		{"admin jwt write", "POST", "/write", map[string]string{"Authorization": "Bearer " + adminToken}, http.StatusOK},
+		{"bad api key", "GET", "/public", map[string]string{"X-API-Key": "hs_bogus"}, http.StatusUnauthorized},
+		{"bad jwt", "GET", "/public", map[string]string{"Authorization": "Bearer a.b.c"}, http.StatusUnauthorized},
+	}
+	for _, tc := range cases {
+		w := doRequest(r, tc.method, tc.path, nil, tc.headers)
+		if w.Code != tc.want {
+			t.Errorf("%s: got %d, want %d (%s)", tc.name, w.Code, tc.want, w.Body.String())
+		}
+	}
+}
+
+func TestIssueTokenAndDisabledUser(t *testing.T) {
+	setupTestDB(t)
+	setupTestIssuer(t, time.Now())
+	r := newAuthTestRouter()
+
+	user, apiKey := createTestUser(t, "bob", RoleContributor)
+	body, _ := json.Marshal(TokenRequest{APIKey: apiKey})
+	w := doRequest(r, "POST", "/api/auth/token", body, nil)
+	if w.Code != http.StatusOK {
+		t.Fatalf("token exchange: %d %s", w.Code, w.Body.String())
+	}
+	var resp struct {
+		Token string `json:"token"`
+	}
+	json.Unmarshal(w.Body.Bytes(), &resp)
+
+	auth := map[string]string{"Authorization": "Bearer " + resp.Token}
+	if w := doRequest(r, "POST", "/write", nil, auth); w.Code != http.StatusOK {
+		t.Fatalf("contributor write: %d", w.Code)
+	}
+
+	// 禁用后已签发的token立即失效
+	db.Exec(`UPDATE users SET disabled = 1 WHERE id = ?`, user.ID)
+	if w := doRequest(r, "POST", "/write", nil, auth); w.Code != http.StatusForbidden {
+		t.Errorf("disabled user: got %d, want 403", w.Code)
+	}
+	if w := doRequest(r, "POST", "/api/auth/token", body, nil); w.Code != http.StatusForbidden {
+		t.Errorf("disabled token exchange: got %d, want 403", w.Code)
+	}
+}
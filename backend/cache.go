@@ -0,0 +1,479 @@
+package main
+
+import (
+	"container/list"
+	"crypto/sha1"
+	"database/sql"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"log"
+	"net/http"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+// 缓存键：位置按geohash取整，附近重复搜索命中同一条缓存
+type CacheKey struct {
+	Provider string
+	Endpoint string
+	Location string // "lng,lat"，地理编码等非位置查询可为任意字符串
+	Radius   string
+	Typecode string
+	Page     int
+}
+
+var cacheGeohashPrecision = 7 // 约150米
+
+func (k CacheKey) String() string {
+	loc := k.Location
+	if lng, lat, ok := parseLngLat(k.Location); ok {
+		loc = encodeGeohash(lat, lng, cacheGeohashPrecision)
+	}
+	return strings.Join([]string{k.Provider, k.Endpoint, loc, k.Radius, k.Typecode, strconv.Itoa(k.Page)}, "|")
+}
+
+// 解析"lng,lat"格式坐标
+func parseLngLat(location string) (float64, float64, bool) {
+	parts := strings.Split(location, ",")
+	if len(parts) != 2 {
+		return 0, 0, false
+	}
+	lng, err1 := strconv.ParseFloat(strings.TrimSpace(parts[0]), 64)
+	lat, err2 := strconv.ParseFloat(strings.TrimSpace(parts[1]), 64)
+	if err1 != nil || err2 != nil {
+		return 0, 0, false
+	}
+	return lng, lat, true
+}
+
+const geohashBase32 = "0123456789bcdefghjkmnpqrstuvwxyz"
+
+// geohash编码
+func encodeGeohash(lat, lng float64, precision int) string {
+	latRange := [2]float64{-90, 90}
+	lngRange := [2]float64{-180, 180}
+	var sb strings.Builder
+	bit, ch, even := 0, 0, true
+	for sb.Len() < precision {
+		if even {
+			mid := (lngRange[0] + lngRange[1]) / 2
+			if lng >= mid {
+				ch |= 1 << uint(4-bit)
+				lngRange[0] = mid
+			} else {
+				lngRange[1] = mid
+			}
+		} else {
+			mid := (latRange[0] + latRange[1]) / 2
+			if lat >= mid {
+				ch |= 1 << uint(4-bit)
+				latRange[0] = mid
+			} else {
+				latRange[1] = mid
+			}
+		}
+		even = !even
+		if bit < 4 {
+			bit++
+		} else {
+			sb.WriteByte(geohashBase32[ch])
+			bit, ch = 0, 0
+		}
+	}
+	return sb.String()
+}
+
+type cacheEntry struct {
+	key      string
+	body     []byte
+	storedAt time.Time
+}
+
+// 磁盘缓存文件格式
+type diskCacheFile struct {
+	Key      string          `json:"key"`
+	StoredAt time.Time       `json:"stored_at"`
+	Body     json.RawMessage `json:"body"`
+}
+
+// ResponseCache 两级响应缓存：内存LRU + 磁盘目录，均带TTL和容量上限
+type ResponseCache struct {
+	mu           sync.Mutex // 只保护内存状态，不在持有时读写磁盘
+	evictMu      sync.Mutex
+	ttl          time.Duration
+	maxEntries   int
+	ll           *list.List
+	items        map[string]*list.Element
+	dir          string
+	maxDiskBytes int64
+	diskBytes    int64
+	hits         int64
+	misses       int64
+	now          func() time.Time
+}
+
+var responseCache *ResponseCache
+
+func NewResponseCache(ttl time.Duration, maxEntries int, dir string, maxDiskBytes int64) *ResponseCache {
+	rc := &ResponseCache{
+		ttl:          ttl,
+		maxEntries:   maxEntries,
+		ll:           list.New(),
+		items:        make(map[string]*list.Element),
+		dir:          dir,
+		maxDiskBytes: maxDiskBytes,
+		now:          time.Now,
+	}
+	if dir != "" {
+		if err := os.MkdirAll(dir, 0755); err != nil {
+			log.Printf("[缓存] 创建缓存目录失败，仅使用内存缓存: %v", err)
+			rc.dir = ""
+		} else {
+			rc.diskBytes = rc.scanDisk()
+		}
+	}
+	return rc
+}
+
+func initResponseCache() {
+	cacheGeohashPrecision = envInt("CACHE_GEOHASH_PRECISION", 7)
+	responseCache = NewResponseCache(
+		time.Duration(envInt("CACHE_TTL_MINUTES", 1440))*time.Minute,
+		envInt("CACHE_MEMORY_ENTRIES", 2000),
+		getEnvDefault("CACHE_DIR", "backend/cache/responses"),
+		int64(envInt("CACHE_DISK_MAX_MB", 200))<<20,
+	)
+	log.Printf("[缓存] TTL %v，内存 %d 条，磁盘目录 %s", responseCache.ttl, responseCache.maxEntries, responseCache.dir)
+}
+
+func getEnvDefault(name, def string) string {
+	if v := os.Getenv(name); v != "" {
+		return v
+	}
+	return def
+}
+
+// 读取缓存：先查内存，未命中再查磁盘并回填内存；磁盘读写不持有锁
+func (rc *ResponseCache) Get(key CacheKey) ([]byte, bool) {
+	if rc == nil {
+		return nil, false
+	}
+	k := key.String()
+	rc.mu.Lock()
+	if el, ok := rc.items[k]; ok {
+		entry := el.Value.(*cacheEntry)
+		if rc.now().Sub(entry.storedAt) < rc.ttl {
+			rc.ll.MoveToFront(el)
+			rc.hits++
+			rc.mu.Unlock()
+			return entry.body, true
+		}
+		rc.removeElement(el)
+	}
+	rc.mu.Unlock()
+
+	entry, ok := rc.readDisk(k)
+	rc.mu.Lock()
+	defer rc.mu.Unlock()
+	if !ok {
+		rc.misses++
+		return nil, false
+	}
+	// 读盘期间可能已有更新的写入
+	if el, found := rc.items[k]; !found || el.Value.(*cacheEntry).storedAt.Before(entry.storedAt) {
+		rc.addMemory(entry)
+	}
+	rc.hits++
+	return entry.body, true
+}
+
+func (rc *ResponseCache) Set(key CacheKey, body []byte) {
+	if rc == nil {
+		return
+	}
+	entry := &cacheEntry{key: key.String(), body: body, storedAt: rc.now()}
+	rc.mu.Lock()
+	rc.addMemory(entry)
+	rc.mu.Unlock()
+	rc.writeDisk(entry)
+}
+
+func (rc *ResponseCache) addMemory(entry *cacheEntry) {
+	if el, ok := rc.items[entry.key]; ok {
+		el.Value = entry
+		rc.ll.MoveToFront(el)
+		return
+	}
+	rc.items[entry.key] = rc.ll.PushFront(entry)
+	for rc.maxEntries > 0 && rc.ll.Len() > rc.maxEntries {
+		rc.removeElement(rc.ll.Back())
+	}
+}
+
+func (rc *ResponseCache) removeElement(el *list.Element) {
+	rc.ll.Remove(el)
+	delete(rc.items, el.Value.(*cacheEntry).key)
+}
+
+func (rc *ResponseCache) diskPath(key string) string {
+	sum := sha1.Sum([]byte(key))
+	return filepath.Join(rc.dir, hex.EncodeToString(sum[:])+".json")
+}
+
+// 调整磁盘占用统计
+func (rc *ResponseCache) addDiskBytes(delta int64) int64 {
+	rc.mu.Lock()
+	defer rc.mu.Unlock()
+	rc.diskBytes += delta
+	return rc.diskBytes
+}
+
+func (rc *ResponseCache) readDisk(key string) (*cacheEntry, bool) {
+	if rc.dir == "" {
+		return nil, false
+	}
+	path := rc.diskPath(key)
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, false
+	}
+	var f diskCacheFile
+	if err := json.Unmarshal(data, &f); err != nil || f.Key != key {
+		return nil, false
+	}
+	now := rc.now()
+	if now.Sub(f.StoredAt) >= rc.ttl {
+		if os.Remove(path) == nil {
+			rc.addDiskBytes(-int64(len(data)))
+		}
+		return nil, false
+	}
+	// 更新访问时间，磁盘淘汰按最近访问排序
+	os.Chtimes(path, now, now)
+	return &cacheEntry{key: key, body: f.Body, storedAt: f.StoredAt}, true
+}
+
+// 先写临时文件再改名，并发读取不会读到写了一半的文件
+func (rc *ResponseCache) writeDisk(entry *cacheEntry) {
+	if rc.dir == "" {
+		return
+	}
+	data, err := json.Marshal(diskCacheFile{Key: entry.key, StoredAt: entry.storedAt, Body: entry.body})
+	if err != nil {
+		return
+	}
+	path := rc.diskPath(entry.key)
+	var prev int64
+	if info, err := os.Stat(path); err == nil {
+		prev = info.Size()
+	}
+	tmp, err := ioutil.TempFile(rc.dir, "tmp-*")
+	if err != nil {
+		log.Printf("[缓存] 写入磁盘缓存失败: %v", err)
+		return
+	}
+	_, err = tmp.Write(data)
+	if cerr := tmp.Close(); err == nil {
+		err = cerr
+	}
+	if err == nil {
+		err = os.Rename(tmp.Name(), path)
+	}
+	if err != nil {
+		os.Remove(tmp.Name())
+		log.Printf("[缓存] 写入磁盘缓存失败: %v", err)
+		return
+	}
+	if total := rc.addDiskBytes(int64(len(data)) - prev); rc.maxDiskBytes > 0 && total > rc.maxDiskBytes {
+		rc.evictDisk()
+	}
+}
+
+type diskFileInfo struct {
+	path    string
+	size    int64
+	modTime time.Time
+}
+
+func (rc *ResponseCache) listDisk() []diskFileInfo {
+	entries, err := ioutil.ReadDir(rc.dir)
+	if err != nil {
+		return nil
+	}
+	var files []diskFileInfo
+	for _, e := range entries {
+		if e.IsDir() || !strings.HasSuffix(e.Name(), ".json") {
+			continue
+		}
+		files = append(files, diskFileInfo{path: filepath.Join(rc.dir, e.Name()), size: e.Size(), modTime: e.ModTime()})
+	}
+	return files
+}
+
+func (rc *ResponseCache) scanDisk() int64 {
+	var total int64
+	for _, f := range rc.listDisk() {
+		total += f.size
+	}
+	return total
+}
+
+// 按最近访问时间淘汰磁盘缓存，直到低于上限的90%；同一时间只有一个淘汰在进行
+func (rc *ResponseCache) evictDisk() {
+	if !rc.evictMu.TryLock() {
+		return
+	}
+	defer rc.evictMu.Unlock()
+	files := rc.listDisk()
+	sort.Slice(files, func(i, j int) bool { return files[i].modTime.Before(files[j].modTime) })
+	var total int64
+	for _, f := range files {
+		total += f.size
+	}
+	target := rc.maxDiskBytes * 9 / 10
+	removed := 0
+	for _, f := range files {
+		if total <= target {
+			break
+		}
+		if os.Remove(f.path) == nil {
+			total -= f.size
+			removed++
+		}
+	}
+	rc.mu.Lock()
+	rc.diskBytes = total
+	rc.mu.Unlock()
+	log.Printf("[缓存] 磁盘缓存淘汰 %d 个文件，当前 %d 字节", removed, total)
+}
+
+// 清空两级缓存
+func (rc *ResponseCache) Purge() int {
+	rc.mu.Lock()
+	removed := rc.ll.Len()
+	rc.ll.Init()
+	rc.items = make(map[string]*list.Element)
+	rc.mu.Unlock()
+	if rc.dir != "" {
+		for _, f := range rc.listDisk() {
+			if os.Remove(f.path) == nil {
+				removed++
+			}
+		}
+		rc.mu.Lock()
+		rc.diskBytes = 0
+		rc.mu.Unlock()
+	}
+	return removed
+}
+
+func (rc *ResponseCache) Stats() gin.H {
+	rc.mu.Lock()
+	defer rc.mu.Unlock()
+	return gin.H{
+		"ttl_seconds":       int(rc.ttl.Seconds()),
+		"memory_entries":    rc.ll.Len(),
+		"memory_max":        rc.maxEntries,
+		"disk_dir":          rc.dir,
+		"disk_bytes":        rc.diskBytes,
+		"disk_max_bytes":    rc.maxDiskBytes,
+		"hits":              rc.hits,
+		"misses":            rc.misses,
+		"geohash_precision": cacheGeohashPrecision,
+	}
+}
+
+// 单次请求的缓存命中统计
+type cacheStats struct {
+	mu     sync.Mutex
+	Hits   int
+	Misses int
+}
+
+func (s *cacheStats) record(hit bool) {
+	if s == nil {
+		return
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if hit {
+		s.Hits++
+	} else {
+		s.Misses++
+	}
+}
+
+// 写入缓存命中响应头：X-Cache为HIT/MISS/PARTIAL
+func (s *cacheStats) setHeaders(c *gin.Context) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	status := "MISS"
+	switch {
+	case s.Misses == 0 && s.Hits > 0:
+		status = "HIT"
+	case s.Hits > 0:
+		status = "PARTIAL"
+	}
+	c.Header("X-Cache", status)
+	c.Header("X-Cache-Hits", strconv.Itoa(s.Hits))
+	c.Header("X-Cache-Misses", strconv.Itoa(s.Misses))
+}
+
+// 管理员：缓存统计
+func getCacheStats(c *gin.Context) {
+	c.JSON(http.StatusOK, gin.H{"status": "success", "data": responseCache.Stats()})
+}
+
+// 管理员：清空缓存
+func purgeCache(c *gin.Context) {
+	removed := responseCache.Purge()
+	c.JSON(http.StatusOK, gin.H{"status": "success", "message": fmt.Sprintf("已清除 %d 条缓存", removed)})
+}
+
+// 从数据库加载的只读数据（登记表、直付网络名单等）的内存缓存，随db切换或invalidate后重新加载。
+// 加载在锁外查询数据库；加载期间发生的invalidate会使这次结果只返回给调用方、不写入缓存，
+// 避免导入前的旧数据在导入后仍被当作已加载
+type dbCache[T any] struct {
+	mu     sync.RWMutex
+	db     *sql.DB
+	gen    uint64
+	loaded bool
+	value  T
+}
+
+func (c *dbCache[T]) invalidate() {
+	c.mu.Lock()
+	c.gen++
+	c.loaded = false
+	c.mu.Unlock()
+}
+
+func (c *dbCache[T]) get(load func() (T, error)) (T, error) {
+	c.mu.RLock()
+	if c.loaded && c.db == db {
+		value := c.value
+		c.mu.RUnlock()
+		return value, nil
+	}
+	gen, conn := c.gen, db
+	c.mu.RUnlock()
+	value, err := load()
+	if err != nil {
+		return value, err
+	}
+	c.mu.Lock()
+	if c.gen == gen {
+		c.db, c.loaded, c.value = conn, true, value
+	}
+	c.mu.Unlock()
+	return value, nil
+}
@@ -0,0 +1,178 @@
+package main
+
+import (
+	"fmt"
+	"net/http"
+	"os"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+func testCacheKey(i int) CacheKey {
+	return CacheKey{Provider: ProviderAmap, Endpoint: "place/around/25", Location: e2eLocation, Radius: "3000", Typecode: "090100", Page: i}
+}
+
+func TestResponseCacheTTL(t *testing.T) {
+	now := time.Date(2025, 7, 1, 12, 0, 0, 0, time.UTC)
+	dir := t.TempDir()
+	rc := NewResponseCache(time.Hour, 10, dir, 0)
+	rc.now = func() time.Time { return now }
+
+	rc.Set(testCacheKey(1), []byte(`{"n":1}`))
+	now = now.Add(59 * time.Minute)
+	if body, ok := rc.Get(testCacheKey(1)); !ok || string(body) != `{"n":1}` {
+		t.Fatalf("未过期应命中: %s %v", body, ok)
+	}
+	// 同一geohash格内的邻近位置命中同一条
+	near := testCacheKey(1)
+	near.Location = "116.446700,39.958110"
+	if _, ok := rc.Get(near); !ok {
+		t.Fatal("邻近位置应命中")
+	}
+	now = now.Add(time.Minute)
+	if _, ok := rc.Get(testCacheKey(1)); ok {
+		t.Fatal("内存和磁盘都已过期")
+	}
+	if files := rc.listDisk(); len(files) != 0 || rc.diskBytes != 0 {
+		t.Fatalf("过期的磁盘文件应删除: %v %d", files, rc.diskBytes)
+	}
+	if s := rc.Stats(); s["hits"] != int64(2) || s["misses"] != int64(1) {
+		t.Fatalf("统计: %v", s)
+	}
+}
+
+func TestResponseCacheLRU(t *testing.T) {
+	rc := NewResponseCache(time.Hour, 2, "", 0)
+	for i := 1; i <= 2; i++ {
+		rc.Set(testCacheKey(i), []byte(fmt.Sprint(i)))
+	}
+	rc.Get(testCacheKey(1)) // 1变为最近使用
+	rc.Set(testCacheKey(3), []byte("3"))
+	if _, ok := rc.Get(testCacheKey(2)); ok {
+		t.Fatal("最久未使用的2应被淘汰")
+	}
+	for _, i := range []int{1, 3} {
+		if _, ok := rc.Get(testCacheKey(i)); !ok {
+			t.Fatalf("%d 应保留", i)
+		}
+	}
+
+	// 内存淘汰后从磁盘读回并回填内存
+	rc = NewResponseCache(time.Hour, 1, t.TempDir(), 0)
+	rc.Set(testCacheKey(1), []byte(`"a"`))
+	rc.Set(testCacheKey(2), []byte(`"b"`))
+	if rc.ll.Len() != 1 {
+		t.Fatalf("内存条数 %d", rc.ll.Len())
+	}
+	if body, ok := rc.Get(testCacheKey(1)); !ok || string(body) != `"a"` {
+		t.Fatalf("磁盘回读: %s %v", body, ok)
+	}
+	if _, ok := rc.items[testCacheKey(1).String()]; !ok {
+		t.Fatal("磁盘命中应回填内存")
+	}
+}
+
+func TestResponseCacheDiskEviction(t *testing.T) {
+	now := time.Date(2025, 7, 1, 12, 0, 0, 0, time.UTC)
+	dir := t.TempDir()
+	body := []byte(`"` + strings.Repeat("x", 900) + `"`)
+	// 每个文件约1KB，上限3.5KB
+	rc := NewResponseCache(time.Hour, 1, dir, 3500)
+	rc.now = func() time.Time { return now }
+	for i := 1; i <= 3; i++ {
+		rc.Set(testCacheKey(i), body)
+		// 磁盘按文件修改时间淘汰
+		mod := now.Add(time.Duration(i-10) * time.Minute)
+		os.Chtimes(rc.diskPath(testCacheKey(i).String()), mod, mod)
+	}
+	rc.Get(testCacheKey(1)) // 读取更新访问时间，1变为最近访问
+	rc.Set(testCacheKey(4), body)
+
+	files := rc.listDisk()
+	var total int64
+	for _, f := range files {
+		total += f.size
+	}
+	if total > 3500*9/10 || rc.diskBytes != total {
+		t.Fatalf("淘汰后 %d 字节，统计 %d", total, rc.diskBytes)
+	}
+	for i, want := range map[int]bool{1: true, 2: false, 4: true} {
+		if _, err := os.Stat(rc.diskPath(testCacheKey(i).String())); (err == nil) != want {
+			t.Errorf("%d 保留=%v，应为 %v", i, err == nil, want)
+		}
+	}
+
+	// 重启后按目录内容恢复占用统计
+	if reopened := NewResponseCache(time.Hour, 1, dir, 3500); reopened.diskBytes != total {
+		t.Fatalf("重新扫描 %d，应为 %d", reopened.diskBytes, total)
+	}
+	if removed := rc.Purge(); removed != len(files)+1 || len(rc.listDisk()) != 0 || rc.diskBytes != 0 {
+		t.Fatalf("清空: %d", removed)
+	}
+}
+
+func TestResponseCacheConcurrent(t *testing.T) {
+	rc := NewResponseCache(time.Hour, 4, t.TempDir(), 4000)
+	var wg sync.WaitGroup
+	for w := 0; w < 8; w++ {
+		wg.Add(1)
+		go func(w int) {
+			defer wg.Done()
+			for i := 0; i < 50; i++ {
+				k := testCacheKey(i % 10)
+				if body, ok := rc.Get(k); ok && string(body) != fmt.Sprintf(`"%d"`, i%10) {
+					t.Errorf("读到错误内容: %s", body)
+				}
+				rc.Set(k, []byte(fmt.Sprintf(`"%d"`, i%10)))
+			}
+		}(w)
+	}
+	wg.Wait()
+}
+
+func TestE2EForceRequiresAdmin(t *testing.T) {
+	e := setupE2E(t, nil)
+	e.get("/api/merged-pois?location=" + e2eLocation)
+	before := e.fake.Requests("/v3/place/around")
+
+	if w, _ := e.get("/api/merged-pois?force=true&location=" + e2eLocation); w.Code != http.StatusForbidden {
+		t.Fatalf("匿名force: %d", w.Code)
+	}
+	if e.fake.Requests("/v3/place/around") != before {
+		t.Fatal("匿名force不应请求高德")
+	}
+	w := doRequest(e.router, http.MethodGet, "/api/merged-pois?force=true&location="+e2eLocation, nil, map[string]string{"X-API-Key": e.adminKey})
+	if w.Code != http.StatusOK || w.Header().Get("X-Cache") != "MISS" || e.fake.Requests("/v3/place/around") == before {
+		t.Fatalf("管理员force %d X-Cache=%s", w.Code, w.Header().Get("X-Cache"))
+	}
+}
+
+func TestDBCacheInvalidateDuringLoad(t *testing.T) {
+	setupTestDB(t)
+	var cache dbCache[int]
+	loads := 0
+	load := func() (int, error) {
+		loads++
+		return loads, nil
+	}
+	// 加载期间被invalidate（如导入完成）：本次结果照常返回但不缓存，下次重新加载
+	v, _ := cache.get(func() (int, error) {
+		cache.invalidate()
+		return load()
+	})
+	if v != 1 {
+		t.Fatalf("首次加载 %d", v)
+	}
+	if v, _ = cache.get(load); v != 2 {
+		t.Fatalf("加载期间的invalidate丢失，返回了旧数据 %d", v)
+	}
+	if v, _ = cache.get(load); v != 2 {
+		t.Fatalf("未被invalidate时应命中缓存，得到 %d", v)
+	}
+	cache.invalidate()
+	if v, _ = cache.get(load); v != 3 {
+		t.Fatalf("invalidate后应重新加载，得到 %d", v)
+	}
+}
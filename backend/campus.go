@@ -0,0 +1,264 @@
+package main
+
+import (
+	"math"
+	"net/http"
+	"sort"
+	"strings"
+
+	"github.com/gin-gonic/gin"
+)
+
+// 医院院区：一个规范坐标点（医院POI本身的位置）加若干带坐标的出入口（正门、急诊、门诊等）。
+// 出入口来自子POI（出入口、急诊科、门诊楼、住院楼）以及合并时被并入的同院POI。
+// 搜索距离按用途取最近的相关出入口
+
+// 出入口种类
+const (
+	EntranceMain       = "main"       // 正门
+	EntranceGate       = "gate"       // 其他大门
+	EntranceEmergency  = "emergency"  // 急诊
+	EntranceOutpatient = "outpatient" // 门诊
+	EntranceInpatient  = "inpatient"  // 住院
+	EntranceCampus     = "campus"     // 合并时并入的同院POI
+)
+
+// 距离计算的用途
+const (
+	PurposeGeneral    = "general"
+	PurposeEmergency  = "emergency"
+	PurposeOutpatient = "outpatient"
+)
+
+type Entrance struct {
+	ID        string  `json:"id,omitempty"`
+	Name      string  `json:"name"`
+	Kind      string  `json:"kind"`
+	Longitude float64 `json:"longitude"`
+	Latitude  float64 `json:"latitude"`
+}
+
+type Campus struct {
+	Longitude float64    `json:"longitude"`
+	Latitude  float64    `json:"latitude"`
+	Entrances []Entrance `json:"entrances"`
+}
+
+var emergencySuffixes = []string{"急诊", "急诊科", "急诊部", "急诊中心", "急救中心", "急救站"}
+
+// 按名称判断出入口种类，无法判断返回空串
+func entranceKindFromName(name string) string {
+	name = strings.TrimRight(strings.TrimSpace(name), ")）")
+	switch {
+	case strings.Contains(name, "急诊") || strings.Contains(name, "急救"):
+		return EntranceEmergency
+	case strings.Contains(name, "门诊"):
+		return EntranceOutpatient
+	case strings.Contains(name, "住院") || strings.Contains(name, "病房"):
+		return EntranceInpatient
+	case strings.HasSuffix(name, "正门") || strings.HasSuffix(name, "大门") || strings.HasSuffix(name, "主门") ||
+		strings.HasSuffix(name, "主入口"):
+		return EntranceMain
+	case isEntranceName(name):
+		return EntranceGate
+	}
+	return ""
+}
+
+// 子POI对应的出入口：出入口、急诊科（318或名称以急诊结尾）、门诊楼（317）、住院楼（319）
+func entranceFromChild(ch ChildPOI) (Entrance, bool) {
+	e := Entrance{ID: ch.ID, Name: ch.Name, Longitude: ch.Longitude, Latitude: ch.Latitude}
+	if ch.Longitude == 0 && ch.Latitude == 0 {
+		return e, false
+	}
+	switch ch.Kind {
+	case ChildEntrance:
+		e.Kind = entranceKindFromName(ch.Name)
+	case ChildDepartment:
+		if ch.Childtype == "318" {
+			e.Kind = EntranceEmergency
+		}
+		for _, suffix := range emergencySuffixes {
+			if strings.HasSuffix(ch.Name, suffix) {
+				e.Kind = EntranceEmergency
+			}
+		}
+	case ChildBuilding:
+		switch ch.Childtype {
+		case "317":
+			e.Kind = EntranceOutpatient
+		case "319":
+			e.Kind = EntranceInpatient
+		default:
+			if kind := entranceKindFromName(ch.Name); kind == EntranceEmergency || kind == EntranceOutpatient {
+				e.Kind = kind
+			}
+		}
+	}
+	return e, e.Kind != ""
+}
+
+// 由医院坐标、子POI和合并时并入的POI构建院区，没有任何出入口时返回nil
+func buildCampus(lng, lat float64, children *POIChildren, absorbed []Entrance) *Campus {
+	campus := &Campus{Longitude: lng, Latitude: lat, Entrances: []Entrance{}}
+	if children != nil {
+		for _, group := range [][]ChildPOI{children.Entrances, children.Departments, children.Buildings} {
+			for _, ch := range group {
+				if e, ok := entranceFromChild(ch); ok {
+					campus.Entrances = append(campus.Entrances, e)
+				}
+			}
+		}
+	}
+	campus.Entrances = append(campus.Entrances, absorbed...)
+	if len(campus.Entrances) == 0 {
+		return nil
+	}
+	return campus
+}
+
+// 各用途相关的出入口种类，前者没有时依次退回后者
+var purposeEntranceKinds = map[string][][]string{
+	PurposeGeneral:    {{EntranceMain, EntranceGate, EntranceOutpatient, EntranceCampus}},
+	PurposeEmergency:  {{EntranceEmergency}, {EntranceMain, EntranceGate, EntranceOutpatient, EntranceCampus}},
+	PurposeOutpatient: {{EntranceOutpatient}, {EntranceMain, EntranceGate, EntranceCampus}},
+}
+
+// 到最近相关出入口的距离（米）；没有相关出入口时为到规范坐标的距离，entrance为nil
+func (c *Campus) Nearest(lng, lat float64, purpose string) (float64, *Entrance) {
+	for _, kinds := range purposeEntranceKinds[purpose] {
+		best, bestDist := -1, math.MaxFloat64
+		for i, e := range c.Entrances {
+			if !containsString(kinds, e.Kind) {
+				continue
+			}
+			if d := haversine(lng, lat, e.Longitude, e.Latitude); d < bestDist {
+				best, bestDist = i, d
+			}
+		}
+		if best >= 0 {
+			e := c.Entrances[best]
+			return bestDist, &e
+		}
+	}
+	return haversine(lng, lat, c.Longitude, c.Latitude), nil
+}
+
+func containsString(list []string, s string) bool {
+	for _, v := range list {
+		if v == s {
+			return true
+		}
+	}
+	return false
+}
+
+// purpose查询参数，默认general；取值错误时已写入400响应
+func purposeParam(c *gin.Context) (string, bool) {
+	purpose := c.DefaultQuery("purpose", PurposeGeneral)
+	if _, ok := purposeEntranceKinds[purpose]; !ok {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "purpose应为general、emergency或outpatient"})
+		return "", false
+	}
+	return purpose, true
+}
+
+// 合并时被并入的POI保留为院区出入口；主POI本身是急诊、门诊等出入口而被并入的不是时，交换规范坐标
+func absorbCampusPoint(merged, primary, absorbed map[string]interface{}) {
+	name, _ := absorbed["name"].(string)
+	id, _ := absorbed["id"].(string)
+	loc, _ := absorbed["location"].(string)
+	lng, lat, ok := parseLngLat(loc)
+	if !ok {
+		return
+	}
+	point := Entrance{ID: id, Name: name, Kind: entranceKindFromName(name), Longitude: lng, Latitude: lat}
+	// 合并后的名称可能已改为较短者，按主POI原名判断
+	primaryName, _ := primary["name"].(string)
+	if point.Kind == "" && entranceKindFromName(primaryName) != "" {
+		// 并入的POI更像医院本身：以其位置为规范坐标，原主POI位置作为出入口
+		primaryLoc, _ := primary["location"].(string)
+		if pLng, pLat, ok := parseLngLat(primaryLoc); ok {
+			primaryID, _ := primary["id"].(string)
+			point = Entrance{ID: primaryID, Name: primaryName, Kind: entranceKindFromName(primaryName), Longitude: pLng, Latitude: pLat}
+			merged["location"] = loc
+			merged["location_lng"], merged["location_lat"] = absorbed["location_lng"], absorbed["location_lat"]
+		}
+	}
+	if point.Kind == "" {
+		point.Kind = EntranceCampus
+	}
+	points, _ := merged["campus_points"].([]Entrance)
+	merged["campus_points"] = append(points, point)
+}
+
+// 合并结果中的POI构建院区（campus字段）
+func attachCampuses(pois []map[string]interface{}) {
+	for _, poi := range pois {
+		children, _ := poi["children"].(*POIChildren)
+		absorbed, _ := poi["campus_points"].([]Entrance)
+		if children == nil && len(absorbed) == 0 {
+			continue
+		}
+		loc, _ := poi["location"].(string)
+		lng, lat, _ := parseLngLat(loc)
+		if campus := buildCampus(lng, lat, children, absorbed); campus != nil {
+			poi["campus"] = campus
+		}
+		delete(poi, "campus_points")
+	}
+}
+
+// 合并结果按用途计算到查询点的出入口距离：entrance_distance（米）及nearest_entrance
+func applyEntranceDistances(pois []map[string]interface{}, lng, lat float64, purpose string) {
+	for _, poi := range pois {
+		if campus, _ := poi["campus"].(*Campus); campus != nil {
+			dist, entrance := campus.Nearest(lng, lat, purpose)
+			poi["entrance_distance"] = math.Round(dist)
+			if entrance != nil {
+				poi["nearest_entrance"] = entrance
+			}
+			continue
+		}
+		loc, _ := poi["location"].(string)
+		if pLng, pLat, ok := parseLngLat(loc); ok {
+			poi["entrance_distance"] = math.Round(haversine(lng, lat, pLng, pLat))
+		}
+	}
+}
+
+// 医院关联已抓取POI的距离上限（米）；出入口可能在医院规范坐标之外，按半径预筛选时放宽这么多
+const campusMatchRadius = 500.0
+
+// 距离改为到最近相关出入口的距离（公里），再按最终距离筛选radiusKm（<0不限）、升序后截取limit条（<0不限）
+func nearestEntranceDistances(hospitals []Hospital, center lngLat, purpose string, radiusKm float64, limit int) []Hospital {
+	res := hospitals[:0]
+	for _, h := range hospitals {
+		if _, _, campus := hospitalCampus(h); campus != nil {
+			dist, entrance := campus.Nearest(center.Lng, center.Lat, purpose)
+			h.Distance = dist / 1000
+			h.NearestEntrance = entrance
+		}
+		if radiusKm < 0 || h.Distance <= radiusKm {
+			res = append(res, h)
+		}
+	}
+	sort.SliceStable(res, func(i, j int) bool { return res[i].Distance < res[j].Distance })
+	if limit >= 0 && len(res) > limit {
+		res = res[:limit]
+	}
+	return res
+}
+
+// 数据库医院对应的院区：关联已抓取POI及其子POI
+func hospitalCampus(h Hospital) (string, *POIChildren, *Campus) {
+	poiID, ok := findHospitalPOI(h.Name, h.Longitude, h.Latitude)
+	if !ok {
+		return "", nil, nil
+	}
+	children, err := queryPOIChildren(poiID)
+	if err != nil {
+		return poiID, nil, nil
+	}
+	return poiID, children, buildCampus(h.Longitude, h.Latitude, children, nil)
+}
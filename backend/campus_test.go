@@ -0,0 +1,184 @@
+package main
+
+import (
+	"math"
+	"net/http"
+	"testing"
+	"time"
+)
+
+func TestEntranceFromChild(t *testing.T) {
+	cases := []struct {
+		child ChildPOI
+		kind  string
+	}{
+		{ChildPOI{Name: "中日友好医院(东门)", Kind: ChildEntrance}, EntranceGate},
+		{ChildPOI{Name: "北京协和医院正门", Kind: ChildEntrance}, EntranceMain},
+		{ChildPOI{Name: "北京协和医院急诊入口", Kind: ChildEntrance}, EntranceEmergency},
+		{ChildPOI{Name: "应急总医院本院区急诊", Kind: ChildDepartment, Childtype: "318"}, EntranceEmergency},
+		{ChildPOI{Name: "中日友好医院急救站", Kind: ChildDepartment, Childtype: "307"}, EntranceEmergency},
+		{ChildPOI{Name: "同仁医院西区急诊部输血科", Kind: ChildDepartment, Childtype: "307"}, ""},
+		{ChildPOI{Name: "应急总医院本院区门诊部", Kind: ChildBuilding, Childtype: "317"}, EntranceOutpatient},
+		{ChildPOI{Name: "中日友好医院本部K1住院楼", Kind: ChildBuilding, Childtype: "319"}, EntranceInpatient},
+		{ChildPOI{Name: "中日友好医院本部手术楼", Kind: ChildBuilding, Childtype: "308"}, ""},
+	}
+	for _, tc := range cases {
+		tc.child.Longitude, tc.child.Latitude = 116.4, 39.9
+		e, ok := entranceFromChild(tc.child)
+		if ok != (tc.kind != "") || e.Kind != tc.kind {
+			t.Errorf("%s: %q %v, 期望 %q", tc.child.Name, e.Kind, ok, tc.kind)
+		}
+	}
+}
+
+func TestCampusNearest(t *testing.T) {
+	campus := &Campus{Longitude: 116.400, Latitude: 39.900, Entrances: []Entrance{
+		{Name: "正门", Kind: EntranceMain, Longitude: 116.400, Latitude: 39.898},
+		{Name: "急诊", Kind: EntranceEmergency, Longitude: 116.403, Latitude: 39.900},
+		{Name: "住院楼", Kind: EntranceInpatient, Longitude: 116.399, Latitude: 39.902},
+	}}
+	// 查询点在南侧：一般取正门，急诊取急诊入口，门诊无门诊入口时退回正门
+	lng, lat := 116.400, 39.890
+	if _, e := campus.Nearest(lng, lat, PurposeGeneral); e == nil || e.Name != "正门" {
+		t.Fatalf("一般用途: %+v", e)
+	}
+	dist, e := campus.Nearest(lng, lat, PurposeEmergency)
+	if e == nil || e.Name != "急诊" || math.Abs(dist-haversine(lng, lat, 116.403, 39.900)) > 0.001 {
+		t.Fatalf("急诊用途: %.1f %+v", dist, e)
+	}
+	if _, e := campus.Nearest(lng, lat, PurposeOutpatient); e == nil || e.Name != "正门" {
+		t.Fatalf("门诊用途: %+v", e)
+	}
+	// 只有住院楼时回到规范坐标
+	inpatientOnly := &Campus{Longitude: 116.400, Latitude: 39.900, Entrances: campus.Entrances[2:]}
+	if dist, e := inpatientOnly.Nearest(lng, lat, PurposeEmergency); e != nil || math.Abs(dist-haversine(lng, lat, 116.400, 39.900)) > 0.001 {
+		t.Fatalf("无相关出入口: %.1f %+v", dist, e)
+	}
+}
+
+func TestMergeKeepsCampusPoints(t *testing.T) {
+	ledger := []RawPOIRecord{{Typecode: "090200", POIs: []interface{}{
+		map[string]interface{}{"id": "B0ER", "name": "测试专科医院急诊", "typecode": "090200", "location": "116.401000,39.900000",
+			"location_lng": "116.401", "location_lat": "39.9"},
+		map[string]interface{}{"id": "B0MAIN", "name": "测试专科医院", "typecode": "090200", "location": "116.400000,39.900000",
+			"location_lng": "116.4", "location_lat": "39.9"},
+	}}}
+	result := mergeLedger(ledger, mergedPoisMergeProfile)
+	classifyMergedPOIs(result)
+	pois := result["pois"].([]map[string]interface{})
+	if len(pois) != 1 {
+		t.Fatalf("应合并为1个POI: %d", len(pois))
+	}
+	poi := pois[0]
+	campus, _ := poi["campus"].(*Campus)
+	if poi["location"] != "116.400000,39.900000" || campus == nil || len(campus.Entrances) != 1 {
+		t.Fatalf("规范坐标应取医院本身: %v %+v", poi["location"], campus)
+	}
+	if e := campus.Entrances[0]; e.ID != "B0ER" || e.Kind != EntranceEmergency {
+		t.Fatalf("急诊POI应保留为出入口: %+v", e)
+	}
+}
+
+func TestAroundEntranceDistances(t *testing.T) {
+	e := setupE2E(t, nil)
+	find := func(body map[string]interface{}) map[string]interface{} {
+		for _, v := range body["pois"].([]interface{}) {
+			if poi := v.(map[string]interface{}); poi["name"] == "应急管理部应急总医院" {
+				return poi
+			}
+		}
+		t.Fatal("结果中没有应急总医院")
+		return nil
+	}
+
+	w, body := e.get("/api/amap/around?location=" + e2eLocation + "&radius=5000&purpose=emergency")
+	if w.Code != http.StatusOK {
+		t.Fatalf("周边查询 %d: %s", w.Code, w.Body.String())
+	}
+	poi := find(body)
+	entrance := poi["nearest_entrance"].(map[string]interface{})
+	want := math.Round(haversine(116.446695, 39.958106, 116.436507, 39.960296))
+	if entrance["kind"] != EntranceEmergency || poi["entrance_distance"].(float64) != want {
+		t.Fatalf("急诊距离: %v %v, 期望 %v", entrance, poi["entrance_distance"], want)
+	}
+
+	_, body = e.get("/api/amap/around?location=" + e2eLocation + "&radius=5000")
+	if entrance := find(body)["nearest_entrance"].(map[string]interface{}); entrance["kind"] != EntranceOutpatient {
+		t.Fatalf("一般用途: %v", entrance)
+	}
+	if w, _ := e.get("/api/amap/around?location=" + e2eLocation + "&purpose=dental"); w.Code != http.StatusBadRequest {
+		t.Fatalf("非法purpose %d", w.Code)
+	}
+}
+
+func TestSearchDistancesFinal(t *testing.T) {
+	e := setupE2E(t, nil)
+	insertTestHospital(t, "测试一公里医院", 116.4, 39.909, "hospital_type", "综合医院")
+	insertTestHospital(t, "测试十公里医院", 116.4, 39.99, "hospital_type", "综合医院")
+	w, body := e.get("/api/hospitals/search?lat=39.9&lng=116.4&radius=3&limit=500")
+	if w.Code != http.StatusOK {
+		t.Fatalf("搜索 %d: %s", w.Code, w.Body.String())
+	}
+	data := body["data"].([]interface{})
+	prev, found := 0.0, false
+	for _, v := range data {
+		h := v.(map[string]interface{})
+		dist := h["distance"].(float64)
+		// 全部为公里，按最终距离筛选并升序
+		if dist > 3 || dist < prev {
+			t.Fatalf("%v 距离 %.3f（上一条 %.3f）", h["name"], dist, prev)
+		}
+		prev = dist
+		if h["nearest_entrance"] == nil {
+			if want := haversine(116.4, 39.9, h["longitude"].(float64), h["latitude"].(float64)) / 1000; math.Abs(dist-want) > 0.001 {
+				t.Fatalf("%v 距离 %.3f，应为 %.3f", h["name"], dist, want)
+			}
+		}
+		switch h["name"] {
+		case "测试一公里医院":
+			found = true
+		case "测试十公里医院":
+			t.Fatal("半径外的医院不应返回")
+		}
+	}
+	if !found {
+		t.Fatalf("缺少半径内的数据库医院: %d 条", len(data))
+	}
+	if _, body = e.get("/api/hospitals/search?lat=39.9&lng=116.4&radius=3&limit=1"); len(body["data"].([]interface{})) != 1 ||
+		body["data"].([]interface{})[0].(map[string]interface{})["distance"].(float64) != data[0].(map[string]interface{})["distance"].(float64) {
+		t.Fatalf("limit=1 应返回最近的一条: %v", body["data"])
+	}
+}
+
+func TestSearchEntranceInsideRadius(t *testing.T) {
+	e := setupE2E(t, nil)
+	// 规范坐标在3公里半径外（约3.2公里），急诊入口在半径内（约2.85公里）；另一家医院规范坐标更近（约3公里）但没有出入口
+	ledger := []RawPOIRecord{{Typecode: "090100", POIs: []interface{}{
+		map[string]interface{}{"id": "B0FAR", "name": "测试远端医院", "typecode": "090100", "childtype": []interface{}{},
+			"parent": []interface{}{}, "location": "115.500000,39.528830"},
+		map[string]interface{}{"id": "B0FARER", "name": "测试远端医院急诊", "typecode": "090100", "childtype": "318",
+			"parent": "B0FAR", "location": "115.500000,39.525680"},
+	}}}
+	if _, err := upsertCrawledPOIs(1, ledger, time.Now()); err != nil {
+		t.Fatal(err)
+	}
+	insertTestHospital(t, "测试远端医院", 115.5, 39.52883)
+	insertTestHospital(t, "测试东郊医院", 115.535, 39.5)
+
+	_, body := e.get("/api/hospitals/search?lat=39.5&lng=115.5&radius=3&purpose=emergency")
+	var far map[string]interface{}
+	for _, v := range body["data"].([]interface{}) {
+		if h := v.(map[string]interface{}); h["name"] == "测试远端医院" {
+			far = h
+		}
+	}
+	if far == nil || far["nearest_entrance"] == nil || far["distance"].(float64) > 3 {
+		t.Fatalf("急诊入口在半径内的医院: %v", body["data"])
+	}
+
+	// 范围搜索先按出入口距离排序再截取
+	_, body = e.get("/api/hospitals/search?bbox=115.4,39.45,115.6,39.6&lat=39.5&lng=115.5&purpose=emergency&limit=1")
+	if data := body["data"].([]interface{}); len(data) != 1 || data[0].(map[string]interface{})["name"] != "测试远端医院" {
+		t.Fatalf("limit=1 应返回急诊入口最近的医院: %v", data)
+	}
+}
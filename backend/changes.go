@@ -0,0 +1,450 @@
+package main
+
+import (
+	"database/sql"
+	"fmt"
+	"math"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+	"unicode"
+
+	"github.com/gin-gonic/gin"
+)
+
+// POI变化类型
+const (
+	ChangeAdded           = "added"
+	ChangeRemoved         = "removed"
+	ChangeMoved           = "moved"
+	ChangeRenamed         = "renamed"
+	ChangeTelChanged      = "tel_changed"
+	ChangeTypecodeChanged = "typecode_changed"
+)
+
+var changeTypes = []string{ChangeAdded, ChangeRemoved, ChangeMoved, ChangeRenamed, ChangeTelChanged, ChangeTypecodeChanged}
+
+var (
+	changeMoveThreshold = 100.0 // 位置变化超过该距离（米）记为moved
+	changeMatchRadius   = 300.0 // id变化时按名称匹配旧POI的搜索半径（米）
+)
+
+func initChangeDetection() {
+	changeMoveThreshold = envFloat("CHANGE_MOVE_THRESHOLD_M", changeMoveThreshold)
+	changeMatchRadius = envFloat("CHANGE_MATCH_RADIUS_M", changeMatchRadius)
+}
+
+// POI变化记录（poi_changes表）
+type POIChange struct {
+	ID           int64    `json:"id"`
+	JobID        int64    `json:"job_id,omitempty"`
+	POIID        string   `json:"poi_id"`
+	Type         string   `json:"type"`
+	Name         string   `json:"name"`
+	OldValue     string   `json:"old_value,omitempty"`
+	NewValue     string   `json:"new_value,omitempty"`
+	DistanceM    *float64 `json:"distance_m,omitempty"`
+	MatchedPOIID string   `json:"matched_poi_id,omitempty"` // 高德id变化时匹配到的旧POI
+	DetectedAt   string   `json:"detected_at"`
+}
+
+// 参与比对的POI字段
+type crawledPOI struct {
+	ID            string
+	Name          string
+	Address       string
+	Typecode      string
+	QueryTypecode string
+	Lng, Lat      float64
+	Tel           string
+	LastJobID     int64
+	RemovedAt     string
+}
+
+func crawledPOIFromMap(poi map[string]interface{}, queryTypecode string) crawledPOI {
+	p := crawledPOI{QueryTypecode: queryTypecode}
+	p.ID, _ = poi["id"].(string)
+	p.Name, _ = poi["name"].(string)
+	p.Address, _ = poi["address"].(string)
+	p.Typecode, _ = poi["typecode"].(string)
+	p.Tel, _ = poi["tel"].(string) // 无电话时高德返回[]
+	location, _ := poi["location"].(string)
+	p.Lng, p.Lat, _ = parseLngLat(location)
+	return p
+}
+
+const crawledPOIColumns = `id, name, COALESCE(address, ''), COALESCE(typecode, ''), COALESCE(query_typecode, ''),
+	COALESCE(longitude, 0), COALESCE(latitude, 0), COALESCE(tel, ''), COALESCE(last_job_id, 0), COALESCE(removed_at, '')`
+
+func scanCrawledPOI(row interface{ Scan(...interface{}) error }) (crawledPOI, error) {
+	var p crawledPOI
+	err := row.Scan(&p.ID, &p.Name, &p.Address, &p.Typecode, &p.QueryTypecode, &p.Lng, &p.Lat, &p.Tel, &p.LastJobID, &p.RemovedAt)
+	return p, err
+}
+
+// 与库中同id的POI比对并写入变化记录；同一任务内重复出现的POI不再比对。
+// 新id在任务结束时由finalizeCrawlChanges统一处理
+func detectPOIChanges(tx *sql.Tx, jobID int64, cur crawledPOI, ts string) error {
+	prev, err := scanCrawledPOI(tx.QueryRow(`SELECT `+crawledPOIColumns+` FROM pois WHERE id = ?`, cur.ID))
+	if err == sql.ErrNoRows {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	var changes []POIChange
+	switch {
+	case prev.LastJobID == jobID:
+	case prev.RemovedAt != "":
+		changes = append(changes, POIChange{Type: ChangeAdded, OldValue: "removed_at " + prev.RemovedAt, NewValue: cur.Address})
+	default:
+		changes = comparePOIs(prev, cur)
+	}
+	for _, ch := range changes {
+		ch.JobID, ch.POIID, ch.Name, ch.DetectedAt = jobID, cur.ID, cur.Name, ts
+		if err := insertPOIChange(tx, ch); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// 比较同一POI的两个版本
+func comparePOIs(old, cur crawledPOI) []POIChange {
+	var changes []POIChange
+	if old.Name != cur.Name {
+		changes = append(changes, POIChange{Type: ChangeRenamed, OldValue: old.Name, NewValue: cur.Name})
+	}
+	if old.Lng != 0 && cur.Lng != 0 {
+		if d := haversine(old.Lng, old.Lat, cur.Lng, cur.Lat); d > changeMoveThreshold {
+			d = math.Round(d)
+			changes = append(changes, POIChange{
+				Type:      ChangeMoved,
+				OldValue:  fmt.Sprintf("%.6f,%.6f", old.Lng, old.Lat),
+				NewValue:  fmt.Sprintf("%.6f,%.6f", cur.Lng, cur.Lat),
+				DistanceM: &d,
+			})
+		}
+	}
+	if old.Tel != cur.Tel {
+		changes = append(changes, POIChange{Type: ChangeTelChanged, OldValue: old.Tel, NewValue: cur.Tel})
+	}
+	if old.Typecode != cur.Typecode {
+		changes = append(changes, POIChange{Type: ChangeTypecodeChanged, OldValue: old.Typecode, NewValue: cur.Typecode})
+	}
+	return changes
+}
+
+// 任务结束时处理新出现和未再出现的POI：
+// 区域全部区块抓取成功时，区域内本次未出现的POI若与新POI位置相近、名称相似，视为高德更换了id，
+// 按同一POI比对；其余新POI记为added，未匹配的旧POI记为removed。部分区块失败时只记录added
+func finalizeCrawlChanges(job *CrawlJob, now time.Time) (added, removed, matched int, err error) {
+	tx, err := db.Begin()
+	if err != nil {
+		return 0, 0, 0, err
+	}
+	defer tx.Rollback()
+
+	newPOIs, err := queryCrawledPOIs(tx, `first_job_id = ?`, job.ID)
+	if err != nil {
+		return 0, 0, 0, err
+	}
+	var gone []crawledPOI
+	// 有区块结果不完整时无法判断未出现的POI是否消失
+	if job.TilesDone == job.TilesTotal && job.TilesTruncated == 0 && len(job.Polygon) >= 3 && len(job.Typecodes) > 0 {
+		min, max := polygonBounds(job.Polygon)
+		args := []interface{}{job.ID, min.Lat, max.Lat, min.Lng, max.Lng}
+		for _, tc := range job.Typecodes {
+			args = append(args, tc)
+		}
+		candidates, err := queryCrawledPOIs(tx, `removed_at IS NULL AND COALESCE(last_job_id, 0) != ?
+			AND latitude BETWEEN ? AND ? AND longitude BETWEEN ? AND ?
+			AND query_typecode IN (`+strings.TrimSuffix(strings.Repeat("?,", len(job.Typecodes)), ",")+`)`, args...)
+		if err != nil {
+			return 0, 0, 0, err
+		}
+		for _, p := range candidates {
+			if pointInPolygon(lngLat{p.Lng, p.Lat}, job.Polygon) {
+				gone = append(gone, p)
+			}
+		}
+	}
+
+	ts := now.UTC().Format(time.RFC3339)
+	used := make([]bool, len(gone))
+	for _, cur := range newPOIs {
+		changes := []POIChange{{Type: ChangeAdded, NewValue: cur.Address}}
+		if i := matchGonePOI(cur, gone, used); i >= 0 {
+			used[i] = true
+			matched++
+			changes = comparePOIs(gone[i], cur)
+			for j := range changes {
+				changes[j].MatchedPOIID = gone[i].ID
+			}
+			// 旧POI由新id取代，不再报告为消失
+			if _, err := tx.Exec(`UPDATE pois SET removed_at = ? WHERE id = ?`, ts, gone[i].ID); err != nil {
+				return 0, 0, 0, err
+			}
+		} else {
+			added++
+		}
+		for _, ch := range changes {
+			ch.JobID, ch.POIID, ch.Name, ch.DetectedAt = job.ID, cur.ID, cur.Name, ts
+			if err := insertPOIChange(tx, ch); err != nil {
+				return 0, 0, 0, err
+			}
+		}
+	}
+	for i, p := range gone {
+		if used[i] {
+			continue
+		}
+		if _, err := tx.Exec(`UPDATE pois SET removed_at = ? WHERE id = ?`, ts, p.ID); err != nil {
+			return 0, 0, 0, err
+		}
+		err := insertPOIChange(tx, POIChange{
+			JobID: job.ID, POIID: p.ID, Type: ChangeRemoved, Name: p.Name, OldValue: p.Address, DetectedAt: ts,
+		})
+		if err != nil {
+			return 0, 0, 0, err
+		}
+		removed++
+	}
+	if err := tx.Commit(); err != nil {
+		return 0, 0, 0, err
+	}
+	invalidateMapPoints()
+	return added, removed, matched, nil
+}
+
+// 在changeMatchRadius内找名称最相似的未匹配旧POI，没有返回-1
+func matchGonePOI(cur crawledPOI, gone []crawledPOI, used []bool) int {
+	best, bestScore := -1, 0.0
+	for i, p := range gone {
+		if used[i] || cur.Lng == 0 || haversine(p.Lng, p.Lat, cur.Lng, cur.Lat) > changeMatchRadius {
+			continue
+		}
+		if score := nameSimilarity(p.Name, cur.Name); score >= 0.6 && score > bestScore {
+			best, bestScore = i, score
+		}
+	}
+	return best
+}
+
+func queryCrawledPOIs(tx *sql.Tx, where string, args ...interface{}) ([]crawledPOI, error) {
+	rows, err := tx.Query(`SELECT `+crawledPOIColumns+` FROM pois WHERE `+where+` ORDER BY id`, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	var res []crawledPOI
+	for rows.Next() {
+		p, err := scanCrawledPOI(rows)
+		if err != nil {
+			return nil, err
+		}
+		res = append(res, p)
+	}
+	return res, rows.Err()
+}
+
+func insertPOIChange(tx *sql.Tx, ch POIChange) error {
+	_, err := tx.Exec(`
+		INSERT INTO poi_changes (job_id, poi_id, change_type, name, old_value, new_value, distance_m, matched_poi_id, detected_at)
+		VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?)
+	`, ch.JobID, ch.POIID, ch.Type, ch.Name, ch.OldValue, ch.NewValue, ch.DistanceM, ch.MatchedPOIID, ch.DetectedAt)
+	return err
+}
+
+// 名称相似度：去掉括号内容和常见前缀后，按最长公共子序列计算，包含关系视为相同
+func nameSimilarity(a, b string) float64 {
+	ra, rb := []rune(normalizePOIName(a)), []rune(normalizePOIName(b))
+	if len(ra) == 0 || len(rb) == 0 {
+		return 0
+	}
+	if string(ra) == string(rb) || strings.Contains(string(ra), string(rb)) || strings.Contains(string(rb), string(ra)) {
+		return 1
+	}
+	return lcsDice(ra, rb)
+}
+
+// 最长公共子序列的Dice系数：2*LCS/(len(a)+len(b))
+func lcsDice(ra, rb []rune) float64 {
+	if len(ra) == 0 || len(rb) == 0 {
+		return 0
+	}
+	dp := make([]int, len(rb)+1)
+	for i := 1; i <= len(ra); i++ {
+		prev := 0
+		for j := 1; j <= len(rb); j++ {
+			tmp := dp[j]
+			if ra[i-1] == rb[j-1] {
+				dp[j] = prev + 1
+			} else if dp[j-1] > dp[j] {
+				dp[j] = dp[j-1]
+			}
+			prev = tmp
+		}
+	}
+	return 2 * float64(dp[len(rb)]) / float64(len(ra)+len(rb))
+}
+
+func normalizePOIName(name string) string {
+	s := stripPOIName(name)
+	for _, prefix := range []string{"北京市", "北京"} {
+		if strings.HasPrefix(s, prefix) && len(s) > len(prefix) {
+			s = strings.TrimPrefix(s, prefix)
+			break
+		}
+	}
+	return s
+}
+
+// 去掉括号内容、空白和标点
+func stripPOIName(name string) string {
+	var b strings.Builder
+	depth := 0
+	for _, r := range name {
+		switch r {
+		case '(', '（':
+			depth++
+			continue
+		case ')', '）':
+			if depth > 0 {
+				depth--
+			}
+			continue
+		}
+		if depth == 0 && !unicode.IsSpace(r) && !unicode.IsPunct(r) {
+			b.WriteRune(r)
+		}
+	}
+	return b.String()
+}
+
+func isChangeType(t string) bool {
+	for _, ct := range changeTypes {
+		if ct == t {
+			return true
+		}
+	}
+	return false
+}
+
+// 变化记录查询条件
+type changeFilter struct {
+	Types   []string
+	POIID   string
+	JobID   int64
+	Since   string
+	SinceID int64
+	Limit   int
+	Offset  int
+}
+
+func queryPOIChanges(f changeFilter) ([]POIChange, int, error) {
+	var conds []string
+	var args []interface{}
+	if len(f.Types) > 0 {
+		conds = append(conds, "change_type IN ("+strings.TrimSuffix(strings.Repeat("?,", len(f.Types)), ",")+")")
+		for _, t := range f.Types {
+			args = append(args, t)
+		}
+	}
+	if f.POIID != "" {
+		conds = append(conds, "(poi_id = ? OR matched_poi_id = ?)")
+		args = append(args, f.POIID, f.POIID)
+	}
+	if f.JobID > 0 {
+		conds = append(conds, "job_id = ?")
+		args = append(args, f.JobID)
+	}
+	if f.Since != "" {
+		conds = append(conds, "detected_at >= ?")
+		args = append(args, f.Since)
+	}
+	if f.SinceID > 0 {
+		conds = append(conds, "id > ?")
+		args = append(args, f.SinceID)
+	}
+	where := ""
+	if len(conds) > 0 {
+		where = " WHERE " + strings.Join(conds, " AND ")
+	}
+	var total int
+	if err := db.QueryRow(`SELECT COUNT(*) FROM poi_changes`+where, args...).Scan(&total); err != nil {
+		return nil, 0, err
+	}
+	// 按since_id增量拉取时按id升序，否则最新的在前
+	order := " ORDER BY id DESC"
+	if f.SinceID > 0 {
+		order = " ORDER BY id ASC"
+	}
+	rows, err := db.Query(`
+		SELECT id, COALESCE(job_id, 0), poi_id, change_type, COALESCE(name, ''), COALESCE(old_value, ''),
+			COALESCE(new_value, ''), distance_m, COALESCE(matched_poi_id, ''), detected_at
+		FROM poi_changes`+where+order+` LIMIT ? OFFSET ?`, append(args, f.Limit, f.Offset)...)
+	if err != nil {
+		return nil, 0, err
+	}
+	defer rows.Close()
+	changes := []POIChange{}
+	for rows.Next() {
+		var ch POIChange
+		var dist sql.NullFloat64
+		if err := rows.Scan(&ch.ID, &ch.JobID, &ch.POIID, &ch.Type, &ch.Name, &ch.OldValue, &ch.NewValue, &dist,
+			&ch.MatchedPOIID, &ch.DetectedAt); err != nil {
+			return nil, 0, err
+		}
+		if dist.Valid {
+			ch.DistanceM = &dist.Float64
+		}
+		changes = append(changes, ch)
+	}
+	return changes, total, rows.Err()
+}
+
+// 变化记录：支持type（逗号分隔）、poi_id、job_id、since（时间）、since_id（增量游标）、limit、offset
+func getPOIChanges(c *gin.Context) {
+	f := changeFilter{POIID: c.Query("poi_id")}
+	if v := c.Query("type"); v != "" {
+		for _, t := range strings.Split(v, ",") {
+			if !isChangeType(t) {
+				c.JSON(http.StatusBadRequest, gin.H{"error": "未知的变化类型: " + t, "types": changeTypes})
+				return
+			}
+			f.Types = append(f.Types, t)
+		}
+	}
+	f.JobID, _ = strconv.ParseInt(c.Query("job_id"), 10, 64)
+	f.SinceID, _ = strconv.ParseInt(c.Query("since_id"), 10, 64)
+	f.Since = parseLedgerTime(c.Query("since"))
+	f.Limit, _ = strconv.Atoi(c.DefaultQuery("limit", "50"))
+	f.Offset, _ = strconv.Atoi(c.DefaultQuery("offset", "0"))
+	if f.Limit <= 0 || f.Limit > 500 {
+		f.Limit = 50
+	}
+	if f.Offset < 0 {
+		f.Offset = 0
+	}
+	changes, total, err := queryPOIChanges(f)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+	nextSinceID := f.SinceID
+	for _, ch := range changes {
+		if ch.ID > nextSinceID {
+			nextSinceID = ch.ID
+		}
+	}
+	c.JSON(http.StatusOK, gin.H{
+		"status":        "success",
+		"data":          changes,
+		"total":         total,
+		"limit":         f.Limit,
+		"offset":        f.Offset,
+		"next_since_id": nextSinceID,
+	})
+}
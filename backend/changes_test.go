@@ -0,0 +1,252 @@
+package main
+
+import (
+	"database/sql"
+	"fmt"
+	"net/http"
+	"testing"
+	"time"
+)
+
+func TestNameSimilarity(t *testing.T) {
+	cases := []struct {
+		a, b string
+		same bool
+	}{
+		{"北京协和医院", "协和医院", true},
+		{"北京市朝阳医院", "首都医科大学附属北京朝阳医院", true},
+		{"中日友好医院(东门)", "中日友好医院", true},
+		{"北京中医药大学东直门医院", "东直门医院(东城院区)", true},
+		{"北京协和医院", "北京朝阳医院", false},
+		{"和平里医院", "安贞医院", false},
+	}
+	for _, tc := range cases {
+		if got := nameSimilarity(tc.a, tc.b) >= 0.6; got != tc.same {
+			t.Errorf("%s / %s: 相似度 %.2f", tc.a, tc.b, nameSimilarity(tc.a, tc.b))
+		}
+	}
+}
+
+// 变化检测任务：与testCrawlSpec同一区域，改查090200以获得足够多名称互不相似的POI
+func changeCrawlSpec() CrawlSpec {
+	spec := testCrawlSpec()
+	spec.Typecodes = []string{"090100", "090200"}
+	return spec
+}
+
+// 在测试区域内挑选名称互不相似的已抓取POI
+func pickCrawledPOIs(t *testing.T, n int) []crawledPOI {
+	t.Helper()
+	spec := changeCrawlSpec()
+	rows, err := db.Query(`SELECT ` + crawledPOIColumns + ` FROM pois ORDER BY id`)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer rows.Close()
+	var res []crawledPOI
+	for rows.Next() && len(res) < n {
+		p, _ := scanCrawledPOI(rows)
+		if !pointInPolygon(lngLat{p.Lng, p.Lat}, spec.Polygon) {
+			continue
+		}
+		distinct := true
+		for _, q := range res {
+			if nameSimilarity(p.Name, q.Name) >= 0.5 {
+				distinct = false
+			}
+		}
+		if distinct {
+			res = append(res, p)
+		}
+	}
+	if len(res) < n {
+		t.Fatalf("测试区域内只有 %d 个可用POI", len(res))
+	}
+	return res
+}
+
+func runTestCrawl(t *testing.T, cr *Crawler) *CrawlJob {
+	t.Helper()
+	job, err := cr.CreateJob(changeCrawlSpec())
+	if err != nil {
+		t.Fatal(err)
+	}
+	cr.Start(job.ID)
+	job = waitCrawl(t, cr, job.ID)
+	if job.Status != CrawlCompleted {
+		t.Fatalf("任务 #%d 状态 %s: %s", job.ID, job.Status, job.LastError)
+	}
+	return job
+}
+
+func TestCrawlChangeDetection(t *testing.T) {
+	e := setupCrawlAPI(t, nil)
+	first := runTestCrawl(t, crawler)
+	_, resp := e.get(fmt.Sprintf("/api/changes?job_id=%d&type=added&limit=1", first.ID))
+	if int(resp["total"].(float64)) != first.POIsFound {
+		t.Fatalf("首次抓取新增 %v 条，抓取POI %d 个", resp["total"], first.POIsFound)
+	}
+
+	picked := pickCrawledPOIs(t, 6)
+	renamed, moved, tel, typecode, removed, reID := picked[0], picked[1], picked[2], picked[3], picked[4], picked[5]
+	e.fake.UpdatePOI(renamed.ID, map[string]interface{}{"name": renamed.Name + "新院区"})
+	e.fake.UpdatePOI(moved.ID, map[string]interface{}{"location": fmt.Sprintf("%.6f,%.6f", moved.Lng, moved.Lat+0.002)})
+	e.fake.UpdatePOI(tel.ID, map[string]interface{}{"tel": "010-12345678"})
+	e.fake.UpdatePOI(typecode.ID, map[string]interface{}{"typecode": "090102"})
+	e.fake.RemovePOI(removed.ID)
+	// 同一家医院换了高德id，电话也变了
+	e.fake.RemovePOI(reID.ID)
+	e.fake.AddPOI(reID.QueryTypecode, map[string]interface{}{
+		"id": "B0TESTNEWID", "name": reID.Name, "address": reID.Address, "typecode": reID.Typecode,
+		"location": fmt.Sprintf("%.6f,%.6f", reID.Lng, reID.Lat), "tel": "010-87654321",
+	})
+	e.fake.AddPOI("090100", map[string]interface{}{
+		"id": "B0TESTOPENED", "name": "测试新开诊所", "address": "左家庄", "typecode": "090100",
+		"location": "116.447500,39.958000", "tel": "",
+	})
+
+	second := runTestCrawl(t, crawler)
+	w, resp := e.get(fmt.Sprintf("/api/changes?job_id=%d&limit=100", second.ID))
+	if w.Code != http.StatusOK {
+		t.Fatalf("变化记录 %d: %s", w.Code, w.Body.String())
+	}
+	got := map[string]map[string]interface{}{}
+	for _, v := range resp["data"].([]interface{}) {
+		ch := v.(map[string]interface{})
+		got[ch["type"].(string)+" "+ch["poi_id"].(string)] = ch
+	}
+	want := []string{
+		ChangeRenamed + " " + renamed.ID,
+		ChangeMoved + " " + moved.ID,
+		ChangeTelChanged + " " + tel.ID,
+		ChangeTypecodeChanged + " " + typecode.ID,
+		ChangeRemoved + " " + removed.ID,
+		ChangeTelChanged + " B0TESTNEWID",
+		ChangeAdded + " B0TESTOPENED",
+	}
+	for _, k := range want {
+		if got[k] == nil {
+			t.Errorf("缺少变化记录 %s", k)
+		}
+	}
+	if len(got) != len(want) {
+		t.Errorf("变化记录 %d 条，期望 %d 条: %v", len(got), len(want), got)
+	}
+	if ch := got[ChangeMoved+" "+moved.ID]; ch != nil && ch["distance_m"].(float64) < 200 {
+		t.Errorf("移动距离 %v", ch["distance_m"])
+	}
+	if ch := got[ChangeTelChanged+" B0TESTNEWID"]; ch != nil && ch["matched_poi_id"] != reID.ID {
+		t.Errorf("id变化未匹配到旧POI: %v", ch)
+	}
+	if got[ChangeRemoved+" "+reID.ID] != nil || got[ChangeAdded+" B0TESTNEWID"] != nil {
+		t.Error("id变化不应记为消失+新增")
+	}
+
+	// 按POI查询包含id变化前的记录；since_id增量拉取
+	_, resp = e.get("/api/changes?poi_id=" + reID.ID)
+	if resp["total"].(float64) < 2 {
+		t.Fatalf("按旧id查询: %v", resp["data"])
+	}
+	_, resp = e.get("/api/changes?limit=1")
+	latest := resp["next_since_id"].(float64)
+	_, resp = e.get(fmt.Sprintf("/api/changes?since_id=%.0f", latest))
+	if resp["total"].(float64) != 0 {
+		t.Fatalf("最新游标之后仍有记录: %v", resp["data"])
+	}
+	if w, _ := e.get("/api/changes?type=closed"); w.Code != http.StatusBadRequest {
+		t.Fatalf("未知类型 %d", w.Code)
+	}
+
+	// 第三次抓取没有变化
+	third := runTestCrawl(t, crawler)
+	_, resp = e.get(fmt.Sprintf("/api/changes?job_id=%d", third.ID))
+	if resp["total"].(float64) != 0 {
+		t.Fatalf("数据未变化仍记录: %v", resp["data"])
+	}
+}
+
+func TestCrawlPartialFailureSkipsRemoved(t *testing.T) {
+	e := setupCrawlAPI(t, map[string]string{"BREAKER_FAILURE_THRESHOLD": "100", "AMAP_FETCH_WORKERS": "1"})
+	runTestCrawl(t, crawler)
+	victim := pickCrawledPOIs(t, 1)[0]
+
+	// 区块重试用尽仍失败时，无法判断未出现的POI是否已消失
+	e.fake.InjectFault(FakeFaultServerDown, 0, 0)
+	job, _ := crawler.CreateJob(changeCrawlSpec())
+	crawler.Start(job.ID)
+	job = waitCrawl(t, crawler, job.ID)
+	if job.TilesDone == job.TilesTotal {
+		t.Fatal("区块应全部失败")
+	}
+	_, resp := e.get(fmt.Sprintf("/api/changes?job_id=%d&type=removed", job.ID))
+	if resp["total"].(float64) != 0 {
+		t.Fatalf("部分失败时记录了消失: %v", resp["data"])
+	}
+	var removedAt string
+	db.QueryRow(`SELECT COALESCE(removed_at, '') FROM pois WHERE id = ?`, victim.ID).Scan(&removedAt)
+	if removedAt != "" {
+		t.Fatal("部分失败时POI被标记为消失")
+	}
+}
+
+// 抓取功能上线时（变化检测之前）创建的数据库升级后补齐新增列
+func TestCreateTablesMigratesCrawlSchema(t *testing.T) {
+	testDB, err := sql.Open("sqlite3", ":memory:")
+	if err != nil {
+		t.Fatal(err)
+	}
+	testDB.SetMaxOpenConns(1)
+	prev := db
+	db = testDB
+	t.Cleanup(func() {
+		testDB.Close()
+		db = prev
+	})
+	for _, q := range []string{
+		`CREATE TABLE crawl_jobs (
+			id INTEGER PRIMARY KEY AUTOINCREMENT, area TEXT NOT NULL, polygon TEXT NOT NULL, typecodes TEXT NOT NULL,
+			provider TEXT NOT NULL, tile_radius INTEGER NOT NULL, trigger TEXT NOT NULL, status TEXT NOT NULL,
+			tiles_total INTEGER NOT NULL DEFAULT 0, tiles_done INTEGER NOT NULL DEFAULT 0, pois_found INTEGER NOT NULL DEFAULT 0,
+			errors INTEGER NOT NULL DEFAULT 0, last_error TEXT, created_at TEXT NOT NULL, started_at TEXT, finished_at TEXT
+		)`,
+		`CREATE TABLE crawl_tiles (
+			job_id INTEGER NOT NULL, idx INTEGER NOT NULL, longitude REAL NOT NULL, latitude REAL NOT NULL, status TEXT NOT NULL,
+			pois INTEGER NOT NULL DEFAULT 0, attempts INTEGER NOT NULL DEFAULT 0, error TEXT, updated_at TEXT,
+			PRIMARY KEY (job_id, idx)
+		)`,
+		`CREATE TABLE pois (
+			id TEXT PRIMARY KEY, name TEXT NOT NULL, address TEXT, typecode TEXT, query_typecode TEXT,
+			longitude REAL, latitude REAL, tel TEXT, raw TEXT, first_seen TEXT NOT NULL, last_seen TEXT NOT NULL, last_job_id INTEGER
+		)`,
+		`CREATE INDEX idx_pois_last_job ON pois(last_job_id)`,
+		`INSERT INTO pois (id, name, query_typecode, longitude, latitude, first_seen, last_seen, last_job_id)
+			VALUES ('B0OLD', '旧医院', '090100', 116.45, 39.96, '2025-01-01T00:00:00Z', '2025-01-01T00:00:00Z', 1)`,
+	} {
+		if _, err := db.Exec(q); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	createTables()
+	var n int
+	db.QueryRow(`SELECT COUNT(*) FROM sqlite_master WHERE type = 'index' AND name = 'idx_pois_first_job'`).Scan(&n)
+	if n != 1 {
+		t.Fatal("升级后缺少idx_pois_first_job索引")
+	}
+	found, err := upsertCrawledPOIs(2, []RawPOIRecord{{Typecode: "090100", POIs: []interface{}{
+		map[string]interface{}{"id": "B0NEW", "name": "新医院", "typecode": "090100", "location": "116.450000,39.961000"},
+	}}}, time.Now())
+	if err != nil || found != 1 {
+		t.Fatalf("升级后写入抓取结果: %d %v", found, err)
+	}
+	if err := db.QueryRow(`SELECT COUNT(*) FROM pois WHERE removed_at IS NULL AND COALESCE(first_job_id, 0) != 2`).Scan(&n); err != nil || n != 1 {
+		t.Fatalf("升级前的POI: %d %v", n, err)
+	}
+	if _, err := db.Exec(`INSERT INTO crawl_tiles (job_id, idx, longitude, latitude, status, radius, typecodes, truncated)
+		VALUES (1, 0, 116.45, 39.96, 'pending', 500, '090100', 0)`); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := db.Exec(`UPDATE crawl_jobs SET tiles_truncated = 0`); err != nil {
+		t.Fatal(err)
+	}
+}
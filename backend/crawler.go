@@ -0,0 +1,817 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+// 抓取任务状态
+const (
+	CrawlPending   = "pending"
+	CrawlRunning   = "running"
+	CrawlPaused    = "paused" // 配额不足，等待下次调度继续
+	CrawlCompleted = "completed"
+	CrawlFailed    = "failed"
+	CrawlCanceled  = "canceled"
+)
+
+// 单个区块最多重试次数
+const crawlTileMaxAttempts = 3
+
+// 区块结果被最大页数截断时，细分子区块的最小半径（米）
+const defaultCrawlMinTileRadius = 250
+
+// 默认抓取区域：北京五环内（近似）
+const defaultCrawlPolygon = "116.2050,39.7580;116.5450,39.7580;116.5450,40.0250;116.2050,40.0250"
+
+var crawlStatusNames = map[string]string{
+	CrawlPending:   "等待",
+	CrawlRunning:   "运行",
+	CrawlPaused:    "暂停",
+	CrawlCompleted: "完成",
+	CrawlFailed:    "失败",
+	CrawlCanceled:  "取消",
+}
+
+// 抓取任务参数
+type CrawlSpec struct {
+	Area       string   `json:"area"`
+	Polygon    []lngLat `json:"polygon"`
+	Typecodes  []string `json:"typecodes"`
+	Provider   string   `json:"provider"`
+	TileRadius int      `json:"tile_radius"`
+	Trigger    string   `json:"trigger"` // schedule | manual
+}
+
+// 抓取任务（crawl_jobs表）
+type CrawlJob struct {
+	ID             int64      `json:"id"`
+	Area           string     `json:"area"`
+	Polygon        []lngLat   `json:"polygon"`
+	Typecodes      []string   `json:"typecodes"`
+	Provider       string     `json:"provider"`
+	TileRadius     int        `json:"tile_radius"`
+	Trigger        string     `json:"trigger"`
+	Status         string     `json:"status"`
+	TilesTotal     int        `json:"tiles_total"`
+	TilesDone      int        `json:"tiles_done"`
+	TilesTruncated int        `json:"tiles_truncated"` // 已达最小半径仍被截断、结果不完整的区块数
+	POIsFound      int        `json:"pois_found"`
+	Errors         int        `json:"errors"`
+	LastError      string     `json:"last_error,omitempty"`
+	CreatedAt      time.Time  `json:"created_at"`
+	StartedAt      *time.Time `json:"started_at,omitempty"`
+	FinishedAt     *time.Time `json:"finished_at,omitempty"`
+}
+
+// Crawler 后台抓取：按区块依次查询周边POI写入pois表，进度持久化在SQLite，可中断后续抓
+type Crawler struct {
+	mu          sync.Mutex
+	key         string
+	reserve     int // 为用户请求保留的每日配额
+	minRadius   int // 细分区块的最小半径
+	active      map[int64]*crawlRun
+	wg          sync.WaitGroup
+	defaultSpec CrawlSpec
+	schedule    *cronSchedule
+	now         func() time.Time
+}
+
+var crawler *Crawler
+
+func NewCrawler(key string, reserve int, spec CrawlSpec) *Crawler {
+	return &Crawler{
+		key:         key,
+		reserve:     reserve,
+		minRadius:   defaultCrawlMinTileRadius,
+		active:      make(map[int64]*crawlRun),
+		defaultSpec: spec,
+		now:         time.Now,
+	}
+}
+
+// 初始化抓取：恢复中断的任务，配置了CRAWL_SCHEDULE时启动定时调度
+func initCrawler() {
+	polygon, err := parsePolygon(getEnvDefault("CRAWL_POLYGON", defaultCrawlPolygon))
+	if err != nil {
+		log.Printf("[抓取] CRAWL_POLYGON无效，使用默认区域: %v", err)
+		polygon, _ = parsePolygon(defaultCrawlPolygon)
+	}
+	typecodes := mergedPoisMergeProfile.Typecodes
+	if v := os.Getenv("CRAWL_TYPECODES"); v != "" {
+		typecodes = strings.Split(v, ",")
+	}
+	spec := CrawlSpec{
+		Area:       getEnvDefault("CRAWL_AREA_NAME", "北京五环内"),
+		Polygon:    polygon,
+		Typecodes:  typecodes,
+		Provider:   ProviderAmap,
+		TileRadius: envInt("CRAWL_TILE_RADIUS", 3000),
+		Trigger:    "schedule",
+	}
+	crawler = NewCrawler(os.Getenv("AMAP_KEY"), envInt("CRAWL_QUOTA_RESERVE", 1000), spec)
+	crawler.minRadius = envInt("CRAWL_MIN_TILE_RADIUS", defaultCrawlMinTileRadius)
+	initChangeDetection()
+	if offlineMode || crawler.key == "" {
+		log.Println("[抓取] 离线模式或未配置AMAP_KEY，后台抓取不启动")
+		return
+	}
+	crawler.ResumeInterrupted()
+	if expr := os.Getenv("CRAWL_SCHEDULE"); expr != "" {
+		schedule, err := parseCron(expr)
+		if err != nil {
+			log.Printf("[抓取] CRAWL_SCHEDULE无效: %v", err)
+			return
+		}
+		crawler.schedule = schedule
+		go crawler.scheduleLoop()
+		log.Printf("[抓取] 定时抓取已启用: %s，区域 %s，下次 %s", expr, spec.Area, schedule.Next(crawler.now()).Format("2006-01-02 15:04"))
+	}
+}
+
+// 创建任务并生成区块
+func (cr *Crawler) CreateJob(spec CrawlSpec) (*CrawlJob, error) {
+	if spec.Provider == "" {
+		spec.Provider = ProviderAmap
+	}
+	if spec.Provider != ProviderAmap {
+		return nil, fmt.Errorf("暂不支持的服务商: %s", spec.Provider)
+	}
+	if len(spec.Polygon) < 3 {
+		return nil, errors.New("抓取区域至少需要3个顶点")
+	}
+	if len(spec.Typecodes) == 0 {
+		spec.Typecodes = cr.defaultSpec.Typecodes
+	}
+	if spec.TileRadius <= 0 {
+		spec.TileRadius = cr.defaultSpec.TileRadius
+	}
+	if spec.TileRadius < 500 || spec.TileRadius > 50000 {
+		return nil, errors.New("区块半径需在500-50000米之间")
+	}
+	if spec.Trigger == "" {
+		spec.Trigger = "manual"
+	}
+	tiles := tileCircles(spec.Polygon, float64(spec.TileRadius))
+
+	tx, err := db.Begin()
+	if err != nil {
+		return nil, err
+	}
+	defer tx.Rollback()
+	polygon, _ := json.Marshal(spec.Polygon)
+	res, err := tx.Exec(`
+		INSERT INTO crawl_jobs (area, polygon, typecodes, provider, tile_radius, trigger, status, tiles_total, created_at)
+		VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?)
+	`, spec.Area, string(polygon), strings.Join(spec.Typecodes, ","), spec.Provider, spec.TileRadius, spec.Trigger,
+		CrawlPending, len(tiles), cr.now().UTC().Format(time.RFC3339))
+	if err != nil {
+		return nil, err
+	}
+	jobID, _ := res.LastInsertId()
+	stmt, err := tx.Prepare(`INSERT INTO crawl_tiles (job_id, idx, longitude, latitude, status) VALUES (?, ?, ?, ?, ?)`)
+	if err != nil {
+		return nil, err
+	}
+	defer stmt.Close()
+	for i, t := range tiles {
+		if _, err := stmt.Exec(jobID, i, t.Lng, t.Lat, CrawlPending); err != nil {
+			return nil, err
+		}
+	}
+	if err := tx.Commit(); err != nil {
+		return nil, err
+	}
+	log.Printf("[抓取] 创建任务 #%d %s：%d 个区块（半径 %dm），%d 个typecode", jobID, spec.Area, len(tiles), spec.TileRadius, len(spec.Typecodes))
+	return getCrawlJob(jobID)
+}
+
+// 运行中的任务
+type crawlRun struct {
+	cancel context.CancelFunc
+	done   chan struct{}
+}
+
+// 后台运行任务；已在运行时直接返回
+func (cr *Crawler) Start(jobID int64) error {
+	cr.mu.Lock()
+	defer cr.mu.Unlock()
+	if _, ok := cr.active[jobID]; ok {
+		return nil
+	}
+	if cr.key == "" {
+		return errors.New("未配置AMAP_KEY，无法抓取")
+	}
+	ctx, cancel := context.WithCancel(context.Background())
+	run := &crawlRun{cancel: cancel, done: make(chan struct{})}
+	cr.active[jobID] = run
+	cr.wg.Add(1)
+	go func() {
+		defer cr.wg.Done()
+		defer close(run.done)
+		defer func() {
+			cr.mu.Lock()
+			delete(cr.active, jobID)
+			cr.mu.Unlock()
+		}()
+		cr.run(ctx, jobID)
+	}()
+	return nil
+}
+
+func (cr *Crawler) isActive(jobID int64) bool {
+	cr.mu.Lock()
+	defer cr.mu.Unlock()
+	_, ok := cr.active[jobID]
+	return ok
+}
+
+// 停止所有运行中的任务（状态保持running，下次启动时恢复）
+func (cr *Crawler) Stop() {
+	cr.mu.Lock()
+	for _, run := range cr.active {
+		run.cancel()
+	}
+	cr.mu.Unlock()
+	cr.wg.Wait()
+}
+
+// 取消任务：停止运行并标记为canceled，已抓取的POI保留
+func (cr *Crawler) Cancel(jobID int64) (*CrawlJob, error) {
+	job, err := getCrawlJob(jobID)
+	if err != nil {
+		return nil, err
+	}
+	if job.Status == CrawlCompleted || job.Status == CrawlCanceled {
+		return nil, fmt.Errorf("任务已%s，无法取消", crawlStatusNames[job.Status])
+	}
+	cr.mu.Lock()
+	run := cr.active[jobID]
+	cr.mu.Unlock()
+	if run != nil {
+		run.cancel()
+		<-run.done
+	}
+	cr.finish(jobID, CrawlCanceled, "已取消")
+	return getCrawlJob(jobID)
+}
+
+// 继续暂停、失败或已取消的任务；失败次数用尽的区块重新排队
+func (cr *Crawler) Resume(jobID int64) (*CrawlJob, error) {
+	job, err := getCrawlJob(jobID)
+	if err != nil {
+		return nil, err
+	}
+	if job.Status == CrawlCompleted {
+		return nil, errors.New("任务已完成，无法继续")
+	}
+	if !cr.isActive(jobID) {
+		db.Exec(`UPDATE crawl_tiles SET status = ?, attempts = 0 WHERE job_id = ? AND status = ?`, CrawlPending, jobID, CrawlFailed)
+		db.Exec(`UPDATE crawl_jobs SET status = ?, finished_at = NULL, last_error = '' WHERE id = ?`, CrawlPending, jobID)
+	}
+	if err := cr.Start(jobID); err != nil {
+		return nil, err
+	}
+	return getCrawlJob(jobID)
+}
+
+// 恢复上次进程退出时仍在运行的任务
+func (cr *Crawler) ResumeInterrupted() {
+	rows, err := db.Query(`SELECT id FROM crawl_jobs WHERE status IN (?, ?)`, CrawlRunning, CrawlPending)
+	if err != nil {
+		return
+	}
+	var ids []int64
+	for rows.Next() {
+		var id int64
+		rows.Scan(&id)
+		ids = append(ids, id)
+	}
+	rows.Close()
+	for _, id := range ids {
+		log.Printf("[抓取] 恢复任务 #%d", id)
+		cr.Start(id)
+	}
+}
+
+// 定时调度：到点时继续因配额暂停的任务，没有未完成任务时按默认区域创建新任务
+func (cr *Crawler) scheduleLoop() {
+	next := cr.schedule.Next(cr.now())
+	for !next.IsZero() {
+		time.Sleep(next.Sub(cr.now()))
+		cr.runScheduled()
+		next = cr.schedule.Next(cr.now())
+	}
+}
+
+func (cr *Crawler) runScheduled() {
+	var id int64
+	err := db.QueryRow(`
+		SELECT id FROM crawl_jobs WHERE status IN (?, ?, ?) ORDER BY id LIMIT 1
+	`, CrawlPaused, CrawlRunning, CrawlPending).Scan(&id)
+	if err == nil {
+		log.Printf("[抓取] 定时调度：继续任务 #%d", id)
+		cr.Start(id)
+		return
+	}
+	job, err := cr.CreateJob(cr.defaultSpec)
+	if err != nil {
+		log.Printf("[抓取] 定时调度创建任务失败: %v", err)
+		return
+	}
+	cr.Start(job.ID)
+}
+
+// 执行任务：逐个处理未完成区块，每个区块查询全部typecode（细分出的子区块只查询被截断的typecode）
+func (cr *Crawler) run(ctx context.Context, jobID int64) {
+	job, err := getCrawlJob(jobID)
+	if err != nil {
+		log.Printf("[抓取] 读取任务 #%d 失败: %v", jobID, err)
+		return
+	}
+	if job.Status == CrawlCompleted || job.Status == CrawlCanceled {
+		return
+	}
+	db.Exec(`UPDATE crawl_jobs SET status = ?, started_at = COALESCE(started_at, ?), last_error = '' WHERE id = ?`,
+		CrawlRunning, cr.now().UTC().Format(time.RFC3339), jobID)
+	log.Printf("[抓取] 任务 #%d 开始：%d/%d 区块已完成", jobID, job.TilesDone, job.TilesTotal)
+
+	for {
+		if ctx.Err() != nil {
+			return
+		}
+		var idx, radius int
+		var lng, lat float64
+		var tileTypecodes sql.NullString
+		err := db.QueryRow(`
+			SELECT idx, longitude, latitude, radius, typecodes FROM crawl_tiles
+			WHERE job_id = ? AND (status = ? OR (status = ? AND attempts < ?))
+			ORDER BY attempts, idx LIMIT 1
+		`, jobID, CrawlPending, CrawlFailed, crawlTileMaxAttempts).Scan(&idx, &lng, &lat, &radius, &tileTypecodes)
+		if err == sql.ErrNoRows {
+			if job, err := getCrawlJob(jobID); err == nil {
+				added, removed, matched, err := finalizeCrawlChanges(job, cr.now())
+				if err != nil {
+					log.Printf("[变化检测] 任务 #%d 失败: %v", jobID, err)
+				} else {
+					log.Printf("[变化检测] 任务 #%d 新增 %d，消失 %d，id变化 %d", jobID, added, removed, matched)
+				}
+			}
+			cr.finish(jobID, CrawlCompleted, "")
+			return
+		}
+		if err != nil {
+			cr.finish(jobID, CrawlFailed, err.Error())
+			return
+		}
+
+		if radius <= 0 {
+			radius = job.TileRadius
+		}
+		typecodes := job.Typecodes
+		if tileTypecodes.String != "" {
+			typecodes = strings.Split(tileTypecodes.String, ",")
+		}
+
+		// 保留部分配额给用户请求，不足时暂停等待下次调度；每个typecode最多翻amapMaxPages页
+		if remaining := upstreamQuota.Remaining(job.Provider); remaining >= 0 && remaining-len(typecodes)*amapMaxPages < cr.reserve {
+			cr.finish(jobID, CrawlPaused, fmt.Sprintf("配额不足（剩余 %d，保留 %d），等待下次调度", remaining, cr.reserve))
+			return
+		}
+
+		tileCtx := withLedgerRequest(ctx, fmt.Sprintf("crawl_%d_%d", jobID, idx))
+		ledger, err := fetchAroundTypecodes(tileCtx, aroundQuery{
+			Key:       cr.key,
+			Location:  fmt.Sprintf("%.6f,%.6f", lng, lat),
+			Radius:    strconv.Itoa(radius),
+			Typecodes: typecodes,
+			// 抓取需要最新数据，跳过缓存读取
+			BypassCache: true,
+		}, nil)
+		if err != nil {
+			if ctx.Err() != nil {
+				return
+			}
+			var quotaErr *QuotaExceededError
+			var amapErr *AmapError
+			var openErr *CircuitOpenError
+			switch {
+			case errors.As(err, &quotaErr), errors.As(err, &amapErr) && amapErr.Kind == AmapErrQuotaExceeded:
+				cr.finish(jobID, CrawlPaused, "配额用尽: "+err.Error())
+				return
+			case errors.As(err, &amapErr) && amapErr.Kind == AmapErrInvalidKey:
+				cr.finish(jobID, CrawlFailed, err.Error())
+				return
+			case errors.As(err, &openErr):
+				// 熔断期间等待冷却，不计入区块失败
+				log.Printf("[抓取] 任务 #%d 上游熔断，%v 后继续", jobID, openErr.RetryAfter)
+				if sleepContext(ctx, openErr.RetryAfter) != nil {
+					return
+				}
+				continue
+			}
+			log.Printf("[抓取] 任务 #%d 区块 %d 失败: %v", jobID, idx, err)
+			db.Exec(`UPDATE crawl_tiles SET status = ?, attempts = attempts + 1, error = ?, updated_at = ? WHERE job_id = ? AND idx = ?`,
+				CrawlFailed, err.Error(), cr.now().UTC().Format(time.RFC3339), jobID, idx)
+			db.Exec(`UPDATE crawl_jobs SET errors = errors + 1, last_error = ? WHERE id = ?`, err.Error(), jobID)
+			continue
+		}
+
+		found, err := upsertCrawledPOIs(jobID, ledger, cr.now())
+		if err != nil {
+			cr.finish(jobID, CrawlFailed, err.Error())
+			return
+		}
+		if err := cr.completeTile(jobID, idx, found, lngLat{lng, lat}, radius, truncatedTypecodes(ledger)); err != nil {
+			cr.finish(jobID, CrawlFailed, err.Error())
+			return
+		}
+		db.Exec(`
+			UPDATE crawl_jobs SET
+				tiles_total = (SELECT COUNT(*) FROM crawl_tiles WHERE job_id = ?),
+				tiles_done = (SELECT COUNT(*) FROM crawl_tiles WHERE job_id = ? AND status = 'done'),
+				tiles_truncated = (SELECT COUNT(*) FROM crawl_tiles WHERE job_id = ? AND truncated = 1),
+				pois_found = (SELECT COUNT(*) FROM pois WHERE last_job_id = ?)
+			WHERE id = ?
+		`, jobID, jobID, jobID, jobID, jobID)
+	}
+}
+
+// 标记区块完成。有typecode被最大页数截断时，用半径减半的子区块重新覆盖该区块，
+// 子区块只查询被截断的typecode；半径已不能再减小时标记区块不完整
+func (cr *Crawler) completeTile(jobID int64, idx, found int, center lngLat, radius int, truncated []string) error {
+	tx, err := db.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+	ts := cr.now().UTC().Format(time.RFC3339)
+	incomplete := 0
+	if len(truncated) > 0 {
+		half := radius / 2
+		if half < cr.minRadius {
+			incomplete = 1
+			log.Printf("[抓取] 任务 #%d 区块 %d（半径 %dm）typecode %v 仍被截断，已达最小半径", jobID, idx, radius, truncated)
+		} else {
+			var next int
+			if err := tx.QueryRow(`SELECT COALESCE(MAX(idx), -1) + 1 FROM crawl_tiles WHERE job_id = ?`, jobID).Scan(&next); err != nil {
+				return err
+			}
+			children := subdivideCircle(center, float64(radius), float64(half))
+			for i, c := range children {
+				if _, err := tx.Exec(`INSERT INTO crawl_tiles (job_id, idx, longitude, latitude, status, radius, typecodes) VALUES (?, ?, ?, ?, ?, ?, ?)`,
+					jobID, next+i, c.Lng, c.Lat, CrawlPending, half, strings.Join(truncated, ",")); err != nil {
+					return err
+				}
+			}
+			log.Printf("[抓取] 任务 #%d 区块 %d typecode %v 被截断，细分为 %d 个 %dm 子区块", jobID, idx, truncated, len(children), half)
+		}
+	}
+	if _, err := tx.Exec(`UPDATE crawl_tiles SET status = 'done', attempts = attempts + 1, pois = ?, error = '', truncated = ?, updated_at = ? WHERE job_id = ? AND idx = ?`,
+		found, incomplete, ts, jobID, idx); err != nil {
+		return err
+	}
+	return tx.Commit()
+}
+
+func (cr *Crawler) finish(jobID int64, status, message string) {
+	finishedAt := interface{}(nil)
+	if status == CrawlCompleted || status == CrawlFailed || status == CrawlCanceled {
+		finishedAt = cr.now().UTC().Format(time.RFC3339)
+	}
+	db.Exec(`UPDATE crawl_jobs SET status = ?, last_error = ?, finished_at = ? WHERE id = ?`, status, message, finishedAt, jobID)
+	log.Printf("[抓取] 任务 #%d %s %s", jobID, status, message)
+}
+
+// 写入/更新抓取到的POI并记录变化，返回本区块的POI数
+func upsertCrawledPOIs(jobID int64, ledger []RawPOIRecord, now time.Time) (int, error) {
+	// 等级匹配使用内存中的登记表，须在开启事务前加载
+	gradeEntries()
+	tx, err := db.Begin()
+	if err != nil {
+		return 0, err
+	}
+	defer tx.Rollback()
+	stmt, err := tx.Prepare(`
+		INSERT INTO pois (id, name, address, typecode, query_typecode, longitude, latitude, tel, raw, first_seen, last_seen,
+			last_job_id, first_job_id, parent_id, childtype)
+		VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?)
+		ON CONFLICT(id) DO UPDATE SET
+			name = excluded.name, address = excluded.address, typecode = excluded.typecode,
+			query_typecode = excluded.query_typecode, longitude = excluded.longitude, latitude = excluded.latitude,
+			tel = excluded.tel, raw = excluded.raw, last_seen = excluded.last_seen, last_job_id = excluded.last_job_id,
+			parent_id = excluded.parent_id, childtype = excluded.childtype, removed_at = NULL
+	`)
+	if err != nil {
+		return 0, err
+	}
+	defer stmt.Close()
+	seen := make(map[string]bool)
+	var links []gradeLinkRow
+	ts := now.UTC().Format(time.RFC3339)
+	for _, rec := range ledger {
+		for _, raw := range rec.POIs {
+			poi, ok := raw.(map[string]interface{})
+			if !ok {
+				continue
+			}
+			cur := crawledPOIFromMap(poi, rec.Typecode)
+			if cur.ID == "" || seen[cur.ID] {
+				continue
+			}
+			seen[cur.ID] = true
+			if err := detectPOIChanges(tx, jobID, cur, ts); err != nil {
+				return 0, err
+			}
+			body, _ := json.Marshal(poi)
+			parent, childtype := poiParentInfo(poi)
+			if _, err := stmt.Exec(cur.ID, cur.Name, cur.Address, cur.Typecode, cur.QueryTypecode, cur.Lng, cur.Lat, cur.Tel,
+				string(body), ts, ts, jobID, jobID, parent, childtype); err != nil {
+				return 0, err
+			}
+			if parent == "" {
+				province, _ := poi["pname"].(string)
+				links = append(links, gradeLinkRow{Kind: gradeLinkPOI, ID: cur.ID, Name: cur.Name, Province: province})
+			}
+		}
+	}
+	if err := saveGradeLinks(tx, links); err != nil {
+		return 0, err
+	}
+	if err := tx.Commit(); err != nil {
+		return 0, err
+	}
+	invalidateMapPoints()
+	return len(seen), nil
+}
+
+const crawlJobColumns = `id, area, polygon, typecodes, provider, tile_radius, trigger, status, tiles_total, tiles_done,
+	tiles_truncated, pois_found, errors, last_error, created_at, started_at, finished_at`
+
+func scanCrawlJob(row interface{ Scan(...interface{}) error }) (*CrawlJob, error) {
+	var job CrawlJob
+	var polygon, typecodes, createdAt string
+	var lastError, startedAt, finishedAt sql.NullString
+	err := row.Scan(&job.ID, &job.Area, &polygon, &typecodes, &job.Provider, &job.TileRadius, &job.Trigger, &job.Status,
+		&job.TilesTotal, &job.TilesDone, &job.TilesTruncated, &job.POIsFound, &job.Errors, &lastError, &createdAt, &startedAt, &finishedAt)
+	if err != nil {
+		return nil, err
+	}
+	json.Unmarshal([]byte(polygon), &job.Polygon)
+	job.Typecodes = strings.Split(typecodes, ",")
+	job.LastError = lastError.String
+	job.CreatedAt, _ = time.Parse(time.RFC3339, createdAt)
+	if t, err := time.Parse(time.RFC3339, startedAt.String); err == nil {
+		job.StartedAt = &t
+	}
+	if t, err := time.Parse(time.RFC3339, finishedAt.String); err == nil {
+		job.FinishedAt = &t
+	}
+	return &job, nil
+}
+
+func getCrawlJob(id int64) (*CrawlJob, error) {
+	return scanCrawlJob(db.QueryRow(`SELECT `+crawlJobColumns+` FROM crawl_jobs WHERE id = ?`, id))
+}
+
+// SSE进度推送的轮询间隔
+var crawlEventInterval = time.Second
+
+// 任务是否已停止推进（暂停需等待调度或手动继续）
+func crawlJobSettled(status string) bool {
+	return status == CrawlCompleted || status == CrawlFailed || status == CrawlCanceled || status == CrawlPaused
+}
+
+func crawlJobID(c *gin.Context) (int64, bool) {
+	id, err := strconv.ParseInt(c.Param("id"), 10, 64)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "无效的任务ID"})
+		return 0, false
+	}
+	return id, true
+}
+
+func requireCrawler(c *gin.Context) bool {
+	if crawler == nil {
+		c.JSON(http.StatusServiceUnavailable, gin.H{"error": "后台抓取未初始化"})
+		return false
+	}
+	return true
+}
+
+// 管理员：创建抓取任务并立即开始
+func createCrawlJob(c *gin.Context) {
+	if !requireCrawler(c) {
+		return
+	}
+	var req struct {
+		Area       string          `json:"area"`
+		Polygon    json.RawMessage `json:"polygon"` // "lng,lat;..." 字符串或GeoJSON
+		Typecodes  []string        `json:"typecodes"`
+		Provider   string          `json:"provider"`
+		TileRadius int             `json:"tile_radius"`
+	}
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	if offlineMode || crawler.key == "" {
+		c.JSON(http.StatusServiceUnavailable, gin.H{"error": "离线模式或未配置AMAP_KEY，无法抓取", "offline": true})
+		return
+	}
+	spec := crawler.defaultSpec
+	spec.Trigger = "manual"
+	if req.Area != "" {
+		spec.Area = req.Area
+	}
+	if len(req.Polygon) > 0 {
+		raw := string(req.Polygon)
+		var s string
+		if json.Unmarshal(req.Polygon, &s) == nil {
+			raw = s
+		}
+		poly, err := parsePolygon(raw)
+		if err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "polygon无效: " + err.Error()})
+			return
+		}
+		spec.Polygon = poly
+	}
+	if len(req.Typecodes) > 0 {
+		spec.Typecodes = req.Typecodes
+	}
+	if req.Provider != "" {
+		spec.Provider = req.Provider
+	}
+	if req.TileRadius > 0 {
+		spec.TileRadius = req.TileRadius
+	}
+	job, err := crawler.CreateJob(spec)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	if err := crawler.Start(job.ID); err != nil {
+		c.JSON(http.StatusServiceUnavailable, gin.H{"error": err.Error()})
+		return
+	}
+	c.JSON(http.StatusCreated, gin.H{"status": "success", "data": job})
+}
+
+// 管理员：任务列表，按创建时间倒序，支持status/limit/offset
+func listCrawlJobs(c *gin.Context) {
+	limit, _ := strconv.Atoi(c.DefaultQuery("limit", "20"))
+	offset, _ := strconv.Atoi(c.DefaultQuery("offset", "0"))
+	if limit <= 0 || limit > 200 {
+		limit = 20
+	}
+	if offset < 0 {
+		offset = 0
+	}
+	where, args := "", []interface{}{}
+	if status := c.Query("status"); status != "" {
+		where = " WHERE status = ?"
+		args = append(args, status)
+	}
+	var total int
+	if err := db.QueryRow(`SELECT COUNT(*) FROM crawl_jobs`+where, args...).Scan(&total); err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+	rows, err := db.Query(`SELECT `+crawlJobColumns+` FROM crawl_jobs`+where+` ORDER BY id DESC LIMIT ? OFFSET ?`,
+		append(args, limit, offset)...)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+	defer rows.Close()
+	jobs := []*CrawlJob{}
+	for rows.Next() {
+		job, err := scanCrawlJob(rows)
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+			return
+		}
+		job.Polygon = nil // 列表不返回区域坐标
+		jobs = append(jobs, job)
+	}
+	c.JSON(http.StatusOK, gin.H{"status": "success", "data": jobs, "total": total, "limit": limit, "offset": offset})
+}
+
+// 管理员：任务详情，附带各状态区块数
+func getCrawlJobHandler(c *gin.Context) {
+	id, ok := crawlJobID(c)
+	if !ok {
+		return
+	}
+	job, err := getCrawlJob(id)
+	if err == sql.ErrNoRows {
+		c.JSON(http.StatusNotFound, gin.H{"error": "任务不存在"})
+		return
+	}
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+	tiles := map[string]int{}
+	rows, err := db.Query(`SELECT status, COUNT(*) FROM crawl_tiles WHERE job_id = ? GROUP BY status`, id)
+	if err == nil {
+		for rows.Next() {
+			var status string
+			var n int
+			rows.Scan(&status, &n)
+			tiles[status] = n
+		}
+		rows.Close()
+	}
+	c.JSON(http.StatusOK, gin.H{"status": "success", "data": job, "tiles": tiles})
+}
+
+// 管理员：SSE推送任务进度，任务停止推进（完成/失败/取消/暂停）后结束
+func streamCrawlJob(c *gin.Context) {
+	if !requireCrawler(c) {
+		return
+	}
+	id, ok := crawlJobID(c)
+	if !ok {
+		return
+	}
+	if _, err := getCrawlJob(id); err != nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "任务不存在"})
+		return
+	}
+	c.Header("Content-Type", "text/event-stream")
+	c.Header("Cache-Control", "no-cache")
+	c.Header("Connection", "keep-alive")
+	c.Header("X-Accel-Buffering", "no")
+	c.Status(http.StatusOK)
+
+	var last []byte
+	for {
+		job, err := getCrawlJob(id)
+		if err != nil {
+			c.SSEvent("error", gin.H{"error": err.Error()})
+			c.Writer.Flush()
+			return
+		}
+		data, _ := json.Marshal(job)
+		if !bytes.Equal(data, last) {
+			c.SSEvent("progress", job)
+			c.Writer.Flush()
+			last = data
+		}
+		if crawlJobSettled(job.Status) && !crawler.isActive(id) {
+			c.SSEvent("end", job)
+			c.Writer.Flush()
+			return
+		}
+		select {
+		case <-c.Request.Context().Done():
+			return
+		case <-time.After(crawlEventInterval):
+		}
+	}
+}
+
+// 管理员：取消任务
+func cancelCrawlJob(c *gin.Context) {
+	crawlJobAction(c, crawler.Cancel)
+}
+
+// 管理员：继续任务
+func resumeCrawlJob(c *gin.Context) {
+	crawlJobAction(c, crawler.Resume)
+}
+
+func crawlJobAction(c *gin.Context, action func(int64) (*CrawlJob, error)) {
+	if !requireCrawler(c) {
+		return
+	}
+	id, ok := crawlJobID(c)
+	if !ok {
+		return
+	}
+	if _, err := getCrawlJob(id); err != nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "任务不存在"})
+		return
+	}
+	job, err := action(id)
+	if err != nil {
+		c.JSON(http.StatusConflict, gin.H{"error": err.Error()})
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"status": "success", "data": job})
+}
@@ -0,0 +1,358 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"math"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestCronNext(t *testing.T) {
+	loc := time.FixedZone("CST", 8*3600)
+	base := time.Date(2024, 3, 15, 10, 30, 0, 0, loc) // 周五
+	cases := []struct {
+		expr string
+		want time.Time
+	}{
+		{"* * * * *", time.Date(2024, 3, 15, 10, 31, 0, 0, loc)},
+		{"0 3 * * *", time.Date(2024, 3, 16, 3, 0, 0, 0, loc)},
+		{"*/20 * * * *", time.Date(2024, 3, 15, 10, 40, 0, 0, loc)},
+		{"0 2 * * 0", time.Date(2024, 3, 17, 2, 0, 0, 0, loc)},
+		{"0 2 * * 7", time.Date(2024, 3, 17, 2, 0, 0, 0, loc)},
+		{"0 0 1 * *", time.Date(2024, 4, 1, 0, 0, 0, 0, loc)},
+		{"15 9-11 * * 1-5", time.Date(2024, 3, 15, 11, 15, 0, 0, loc)},
+		// 日、周同时限定时满足其一即可
+		{"0 0 20 * 1", time.Date(2024, 3, 18, 0, 0, 0, 0, loc)},
+		{"0 0 29 2 *", time.Date(2024, 2, 29, 0, 0, 0, 0, loc).AddDate(4, 0, 0)},
+	}
+	for _, tc := range cases {
+		s, err := parseCron(tc.expr)
+		if err != nil {
+			t.Fatalf("%s: %v", tc.expr, err)
+		}
+		if got := s.Next(base); !got.Equal(tc.want) {
+			t.Errorf("%s: Next = %s，期望 %s", tc.expr, got, tc.want)
+		}
+	}
+	for _, expr := range []string{"", "* * * *", "60 * * * *", "* * * 13 *", "*/0 * * * *", "5-1 * * * *"} {
+		if _, err := parseCron(expr); err == nil {
+			t.Errorf("%q 应解析失败", expr)
+		}
+	}
+}
+
+func TestTileCirclesCoverPolygon(t *testing.T) {
+	poly, err := parsePolygon(defaultCrawlPolygon)
+	if err != nil {
+		t.Fatal(err)
+	}
+	const radius = 3000.0
+	tiles := tileCircles(poly, radius)
+	if len(tiles) == 0 {
+		t.Fatal("未生成区块")
+	}
+	// 区域内任一点都应落在某个圆内
+	min, max := polygonBounds(poly)
+	for lng := min.Lng; lng <= max.Lng; lng += 0.01 {
+		for lat := min.Lat; lat <= max.Lat; lat += 0.01 {
+			covered := false
+			for _, c := range tiles {
+				if haversine(lng, lat, c.Lng, c.Lat) <= radius {
+					covered = true
+					break
+				}
+			}
+			if !covered {
+				t.Fatalf("点 %.4f,%.4f 未被覆盖", lng, lat)
+			}
+		}
+	}
+	// 区块数量应接近面积下限，避免过度重叠浪费配额
+	width := haversine(min.Lng, min.Lat, max.Lng, min.Lat)
+	height := haversine(min.Lng, min.Lat, min.Lng, max.Lat)
+	ideal := width * height / (1.5 * math.Sqrt(3) * radius * radius)
+	if float64(len(tiles)) > ideal*2 {
+		t.Fatalf("区块 %d 个，理想约 %.0f 个", len(tiles), ideal)
+	}
+}
+
+func TestParsePolygonFormats(t *testing.T) {
+	for _, s := range []string{
+		"116.40,39.90;116.45,39.90;116.45,39.95;116.40,39.90",
+		`{"type":"Polygon","coordinates":[[[116.40,39.90],[116.45,39.90],[116.45,39.95],[116.40,39.90]]]}`,
+		`{"type":"Feature","geometry":{"type":"Polygon","coordinates":[[[116.40,39.90],[116.45,39.90],[116.45,39.95]]]}}`,
+	} {
+		poly, err := parsePolygon(s)
+		if err != nil || len(poly) != 3 {
+			t.Fatalf("%s: %v %v", s, poly, err)
+		}
+	}
+	if _, err := parsePolygon("116.40,39.90;116.45,39.90"); err == nil {
+		t.Fatal("两个点不应构成多边形")
+	}
+}
+
+// 覆盖录制数据所在区域的小范围任务
+func testCrawlSpec() CrawlSpec {
+	poly, _ := parsePolygon("116.435,39.950;116.460,39.950;116.460,39.966;116.435,39.966")
+	return CrawlSpec{Area: "测试区域", Polygon: poly, Typecodes: []string{"090100", "090101"}, TileRadius: 1000}
+}
+
+func waitCrawl(t *testing.T, cr *Crawler, jobID int64) *CrawlJob {
+	t.Helper()
+	cr.wg.Wait()
+	job, err := getCrawlJob(jobID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return job
+}
+
+func TestCrawlJobRunsAllTiles(t *testing.T) {
+	e := setupE2E(t, nil)
+	cr := NewCrawler(e2eAmapKey, 0, testCrawlSpec())
+	job, err := cr.CreateJob(testCrawlSpec())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if job.TilesTotal < 2 {
+		t.Fatalf("区块数 %d", job.TilesTotal)
+	}
+	if err := cr.Start(job.ID); err != nil {
+		t.Fatal(err)
+	}
+	job = waitCrawl(t, cr, job.ID)
+	if job.Status != CrawlCompleted || job.TilesDone != job.TilesTotal || job.TilesTruncated != 0 {
+		t.Fatalf("任务状态 %s，完成 %d/%d，截断 %d: %s", job.Status, job.TilesDone, job.TilesTotal, job.TilesTruncated, job.LastError)
+	}
+	if job.POIsFound == 0 {
+		t.Fatal("未抓取到POI")
+	}
+	if e.fake.Requests("/v3/place/around") < job.TilesTotal*2 {
+		t.Fatal("未按区块和typecode请求高德")
+	}
+	// 抓取调用记入台账
+	if _, total, _ := queryLedger(ledgerFilter{RequestID: fmt.Sprintf("crawl_%d_0", job.ID)}, false); total == 0 {
+		t.Fatal("台账缺少抓取记录")
+	}
+}
+
+func TestCrawlJobPausesOnQuotaAndResumes(t *testing.T) {
+	// 每个区块按 typecode数×最大页数 预留配额：2×1，5次配额可完成2个区块
+	e := setupE2E(t, map[string]string{"AMAP_DAILY_QUOTA": "5", "AMAP_MAX_PAGES": "1"})
+	cr := NewCrawler(e2eAmapKey, 0, testCrawlSpec())
+	job, _ := cr.CreateJob(testCrawlSpec())
+	cr.Start(job.ID)
+	job = waitCrawl(t, cr, job.ID)
+	if job.Status != CrawlPaused {
+		t.Fatalf("配额不足时应暂停，实际 %s", job.Status)
+	}
+	if job.TilesDone != 2 || job.TilesDone >= job.TilesTotal {
+		t.Fatalf("暂停前完成 %d/%d 区块", job.TilesDone, job.TilesTotal)
+	}
+	done := job.TilesDone
+	before := e.fake.Requests("/v3/place/around")
+
+	// 次日配额恢复后由调度继续，已完成的区块不再请求
+	upstreamQuota = NewUpstreamQuota(map[string]int{ProviderAmap: 1000})
+	cr.runScheduled()
+	job = waitCrawl(t, cr, job.ID)
+	if job.Status != CrawlCompleted || job.TilesDone != job.TilesTotal {
+		t.Fatalf("恢复后状态 %s，完成 %d/%d", job.Status, job.TilesDone, job.TilesTotal)
+	}
+	if got := e.fake.Requests("/v3/place/around") - before; got != (job.TilesTotal-done)*2 {
+		t.Fatalf("恢复后请求 %d 次，期望 %d 次", got, (job.TilesTotal-done)*2)
+	}
+}
+
+func TestCrawlSubdividesTruncatedTiles(t *testing.T) {
+	e := setupE2E(t, map[string]string{"AMAP_PAGE_SIZE": "2", "AMAP_MAX_PAGES": "1"})
+	cr := NewCrawler(e2eAmapKey, 0, testCrawlSpec())
+	cr.minRadius = 500
+	job, _ := cr.CreateJob(testCrawlSpec())
+	initial := job.TilesTotal
+	cr.Start(job.ID)
+	job = waitCrawl(t, cr, job.ID)
+	if job.Status != CrawlCompleted || job.TilesDone != job.TilesTotal || job.TilesTotal <= initial {
+		t.Fatalf("状态 %s，完成 %d/%d，初始 %d", job.Status, job.TilesDone, job.TilesTotal, initial)
+	}
+	// 子区块半径减半，只查询被截断的typecode；500m子区块仍被截断时标记不完整
+	rows, err := db.Query(`SELECT idx, longitude, latitude, radius, COALESCE(typecodes, ''), truncated FROM crawl_tiles WHERE job_id = ? ORDER BY idx`, job.ID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	type tile struct {
+		idx, radius, truncated int
+		lng, lat               float64
+		typecodes              string
+	}
+	var tiles []tile
+	for rows.Next() {
+		var tl tile
+		rows.Scan(&tl.idx, &tl.lng, &tl.lat, &tl.radius, &tl.typecodes, &tl.truncated)
+		tiles = append(tiles, tl)
+	}
+	rows.Close()
+	truncated := 0
+	for _, tl := range tiles {
+		if tl.idx < initial {
+			if tl.radius != 0 || tl.typecodes != "" || tl.truncated != 0 {
+				t.Fatalf("原区块: %+v", tl)
+			}
+			continue
+		}
+		if tl.radius != 500 || tl.typecodes == "" || strings.Count(tl.typecodes, ",") > 1 {
+			t.Fatalf("子区块: %+v", tl)
+		}
+		truncated += tl.truncated
+	}
+	if truncated == 0 || job.TilesTruncated != truncated {
+		t.Fatalf("不完整区块 %d，任务记录 %d", truncated, job.TilesTruncated)
+	}
+	// 子区块请求使用子区块半径
+	if e.fake.Requests("/v3/place/around") <= initial*2 {
+		t.Fatal("子区块未请求高德")
+	}
+
+	// 子区块覆盖原区块
+	center := lngLat{116.45, 39.96}
+	children := subdivideCircle(center, 1000, 500)
+	for dx := -1000.0; dx <= 1000; dx += 50 {
+		for dy := -1000.0; dy <= 1000; dy += 50 {
+			lng := center.Lng + dx/(metersPerDegreeLat*math.Cos(center.Lat*math.Pi/180))
+			lat := center.Lat + dy/metersPerDegreeLat
+			if haversine(center.Lng, center.Lat, lng, lat) > 1000 {
+				continue
+			}
+			covered := false
+			for _, c := range children {
+				if haversine(lng, lat, c.Lng, c.Lat) <= 500 {
+					covered = true
+					break
+				}
+			}
+			if !covered {
+				t.Fatalf("点 %.5f,%.5f 未被子区块覆盖", lng, lat)
+			}
+		}
+	}
+}
+
+func TestCrawlTileFailureRetried(t *testing.T) {
+	e := setupE2E(t, map[string]string{"BREAKER_FAILURE_THRESHOLD": "100", "AMAP_FETCH_WORKERS": "1"})
+	e.fake.InjectFault(FakeFaultMalformed, 2, 0) // 超过客户端重试次数，区块失败一次
+	cr := NewCrawler(e2eAmapKey, 0, testCrawlSpec())
+	job, _ := cr.CreateJob(testCrawlSpec())
+	cr.Start(job.ID)
+	job = waitCrawl(t, cr, job.ID)
+	if job.Status != CrawlCompleted || job.Errors != 1 || job.TilesDone != job.TilesTotal {
+		t.Fatalf("状态 %s，错误 %d，完成 %d/%d", job.Status, job.Errors, job.TilesDone, job.TilesTotal)
+	}
+}
+
+// 完整路由 + 测试用抓取器
+func setupCrawlAPI(t *testing.T, env map[string]string) *e2eEnv {
+	e := setupE2E(t, env)
+	prev, prevInterval := crawler, crawlEventInterval
+	crawler = NewCrawler(e2eAmapKey, 0, testCrawlSpec())
+	crawlEventInterval = 10 * time.Millisecond
+	t.Cleanup(func() {
+		crawler.Stop()
+		crawler, crawlEventInterval = prev, prevInterval
+	})
+	return e
+}
+
+func (e *e2eEnv) admin(method, path string, body string) (*httptest.ResponseRecorder, map[string]interface{}) {
+	w := doRequest(e.router, method, path, []byte(body), map[string]string{"X-API-Key": e.adminKey})
+	var resp map[string]interface{}
+	json.Unmarshal(w.Body.Bytes(), &resp)
+	return w, resp
+}
+
+func TestCrawlAPICreateStreamAndList(t *testing.T) {
+	e := setupCrawlAPI(t, nil)
+	body := `{"area":"左家庄","polygon":"116.435,39.950;116.460,39.950;116.460,39.966","typecodes":["090100"],"tile_radius":1000}`
+	if w := doRequest(e.router, http.MethodPost, "/api/admin/crawls", []byte(body), nil); w.Code != http.StatusUnauthorized {
+		t.Fatalf("未鉴权创建应返回401，实际 %d", w.Code)
+	}
+	w, resp := e.admin(http.MethodPost, "/api/admin/crawls", body)
+	if w.Code != http.StatusCreated {
+		t.Fatalf("创建任务 %d: %s", w.Code, w.Body.String())
+	}
+	id := int64(resp["data"].(map[string]interface{})["id"].(float64))
+
+	// SSE推送进度直到任务结束
+	w, _ = e.admin(http.MethodGet, fmt.Sprintf("/api/admin/crawls/%d/events", id), "")
+	if ct := w.Header().Get("Content-Type"); !strings.HasPrefix(ct, "text/event-stream") {
+		t.Fatalf("Content-Type = %s", ct)
+	}
+	stream := w.Body.String()
+	if !strings.Contains(stream, "event:progress") || !strings.Contains(stream, "event:end") ||
+		!strings.Contains(stream, `"status":"completed"`) {
+		t.Fatalf("SSE内容: %s", stream)
+	}
+
+	w, resp = e.admin(http.MethodGet, "/api/admin/crawls?status=completed", "")
+	jobs, _ := resp["data"].([]interface{})
+	if w.Code != http.StatusOK || len(jobs) != 1 {
+		t.Fatalf("任务列表: %s", w.Body.String())
+	}
+	job := jobs[0].(map[string]interface{})
+	if job["area"] != "左家庄" || job["tiles_done"] != job["tiles_total"] || job["pois_found"].(float64) == 0 {
+		t.Fatalf("任务进度: %v", job)
+	}
+
+	w, resp = e.admin(http.MethodGet, fmt.Sprintf("/api/admin/crawls/%d", id), "")
+	if tiles, _ := resp["tiles"].(map[string]interface{}); w.Code != http.StatusOK || tiles["done"] != job["tiles_total"] {
+		t.Fatalf("任务详情: %s", w.Body.String())
+	}
+
+	// 已完成的任务不能取消或继续
+	if w, _ := e.admin(http.MethodPost, fmt.Sprintf("/api/admin/crawls/%d/cancel", id), ""); w.Code != http.StatusConflict {
+		t.Fatalf("取消已完成任务 %d", w.Code)
+	}
+	if w, _ := e.admin(http.MethodPost, fmt.Sprintf("/api/admin/crawls/%d/resume", id), ""); w.Code != http.StatusConflict {
+		t.Fatalf("继续已完成任务 %d", w.Code)
+	}
+	if w, _ := e.admin(http.MethodGet, "/api/admin/crawls/999", ""); w.Code != http.StatusNotFound {
+		t.Fatalf("不存在的任务 %d", w.Code)
+	}
+}
+
+func TestCrawlAPICancelAndResume(t *testing.T) {
+	e := setupCrawlAPI(t, map[string]string{"AMAP_FETCH_WORKERS": "1"})
+	e.fake.InjectFault(FakeFaultSlow, 0, 50*time.Millisecond)
+	w, resp := e.admin(http.MethodPost, "/api/admin/crawls", `{"typecodes":["090100","090101"]}`)
+	if w.Code != http.StatusCreated {
+		t.Fatalf("创建任务 %d: %s", w.Code, w.Body.String())
+	}
+	id := int64(resp["data"].(map[string]interface{})["id"].(float64))
+
+	w, resp = e.admin(http.MethodPost, fmt.Sprintf("/api/admin/crawls/%d/cancel", id), "")
+	job, _ := resp["data"].(map[string]interface{})
+	if w.Code != http.StatusOK || job["status"] != CrawlCanceled || job["tiles_done"] == job["tiles_total"] {
+		t.Fatalf("取消任务: %s", w.Body.String())
+	}
+
+	e.fake.ClearFaults()
+	w, _ = e.admin(http.MethodPost, fmt.Sprintf("/api/admin/crawls/%d/resume", id), "")
+	if w.Code != http.StatusOK {
+		t.Fatalf("继续任务 %d: %s", w.Code, w.Body.String())
+	}
+	final := waitCrawl(t, crawler, id)
+	if final.Status != CrawlCompleted || final.TilesDone != final.TilesTotal {
+		t.Fatalf("继续后状态 %s，完成 %d/%d", final.Status, final.TilesDone, final.TilesTotal)
+	}
+}
+
+func TestCrawlAPIUnavailableOffline(t *testing.T) {
+	e := setupCrawlAPI(t, map[string]string{"OFFLINE_MODE": "true"})
+	if w, _ := e.admin(http.MethodPost, "/api/admin/crawls", `{}`); w.Code != http.StatusServiceUnavailable {
+		t.Fatalf("离线模式创建任务 %d", w.Code)
+	}
+}
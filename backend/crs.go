@@ -0,0 +1,491 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"log"
+	"math"
+	"net/http"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+// 坐标系：高德返回GCJ-02（国测局加密坐标），Google Places在中国大陆返回GCJ-02、其他地区返回WGS-84，百度使用BD-09。
+// 库中统一存为GCJ-02（主要数据源为高德），各表的crs列记录坐标系；外部数据入库时转换，
+// 接口的crs参数指定请求和返回坐标所用的坐标系
+
+const (
+	CRSWGS84 = "wgs84"
+	CRSGCJ02 = "gcj02"
+	CRSBD09  = "bd09"
+)
+
+// 存储坐标系
+const storageCRS = CRSGCJ02
+
+var crsAliases = map[string]string{
+	"wgs84": CRSWGS84, "wgs-84": CRSWGS84, "epsg:4326": CRSWGS84, "4326": CRSWGS84, "gps": CRSWGS84,
+	"gcj02": CRSGCJ02, "gcj-02": CRSGCJ02, "amap": CRSGCJ02, "mars": CRSGCJ02,
+	"bd09": CRSBD09, "bd-09": CRSBD09, "bd09ll": CRSBD09, "baidu": CRSBD09,
+}
+
+// 解析坐标系名称，忽略大小写
+func parseCRS(s string) (string, error) {
+	if crs, ok := crsAliases[strings.ToLower(strings.TrimSpace(s))]; ok {
+		return crs, nil
+	}
+	return "", fmt.Errorf("未知坐标系: %s，应为 %s、%s 或 %s", s, CRSWGS84, CRSGCJ02, CRSBD09)
+}
+
+// GCJ-02参数：克拉索夫斯基椭球
+const (
+	gcjA  = 6378245.0
+	gcjEE = 0.00669342162296594323
+	bdXPi = math.Pi * 3000.0 / 180.0
+)
+
+// 中国大陆的粗略边界（约10～20公里精度，沿海部分取在海上），不含港澳台。
+// 只有大陆使用GCJ-02，朝鲜半岛、日本、蒙古、俄罗斯等周边地区和港澳台都不偏移
+var mainlandChinaBoundary = []lngLat{
+	{124.25, 40.05}, {124.1, 39.8}, {123.6, 38.6}, {123, 37.6}, {123.6, 36}, {123.2, 32},
+	{122.8, 30}, {122.2, 28}, {120.7, 26.6}, {120.1, 25.6}, {119.2, 24.7}, {118.6, 24},
+	{117.5, 23.2}, {116.5, 22.6}, {114.6, 22.3}, {113.3, 21.6}, {111.6, 21}, {111.6, 19},
+	{110.6, 17.8}, {108.4, 18}, {108.3, 19.6}, {108.9, 20.9}, {108.3, 21.45}, {107.97, 21.535},
+	{107.9, 21.6}, {106.7, 22}, {106.7, 22.9}, {105.5, 23.3}, {104, 22.7}, {102.5, 22.4},
+	{101.8, 21.1}, {101.1, 21.7}, {100.2, 21.4}, {99.2, 22.1}, {99.5, 23}, {98.7, 23.9},
+	{97.6, 23.9}, {97.6, 24.8}, {98.7, 27.5}, {98.6, 28.3}, {97.3, 28.2}, {96.5, 28.6},
+	{94, 29.1}, {92, 27.9}, {89.6, 28.2}, {88.9, 27.3}, {88.1, 27.9}, {86.5, 28},
+	{86, 27.95}, {85, 28.6}, {84, 28.9}, {82, 30.2}, {81.1, 30.1}, {79.5, 30.9},
+	{78.7, 31.4}, {79, 32.5}, {78.3, 33.6}, {78, 35.5}, {76, 35.8}, {74.9, 37},
+	{74.5, 37.3}, {74.8, 38.5}, {73.6, 39.5}, {73.8, 39.8}, {75.5, 40.6}, {76.8, 41},
+	{78.3, 41.4}, {80.3, 42.1}, {80.2, 42.9}, {80.8, 43.2}, {80.25, 44.25}, {80.5, 45.1},
+	{82.5, 45.3}, {82.3, 46}, {83, 47.2}, {85.5, 47.1}, {85.7, 48.4}, {87, 49.1},
+	{87.8, 49.17}, {88.9, 48.1}, {90, 47.9}, {90.9, 46.4}, {90.9, 45.3}, {93.5, 44.9},
+	{95.3, 44.3}, {96.4, 42.7}, {100, 42.6}, {101.8, 42.5}, {104, 41.8}, {105, 41.6},
+	{106.8, 42.3}, {109.3, 42.4}, {110.4, 42.8}, {111.8, 43.6}, {113, 44.8}, {114.5, 45.4},
+	{116.5, 46.4}, {117.4, 46.6}, {119.7, 46.7}, {119.9, 47.6}, {118.2, 48}, {116.7, 49.85},
+	{117.9, 49.6}, {119.5, 50.3}, {120.8, 52.5}, {122, 53.4}, {123.5, 53.6}, {125.6, 53},
+	{127.3, 50.4}, {127.7, 50.1}, {130, 48.9}, {132.5, 47.7}, {134.3, 48.4}, {134.75, 48.35},
+	{133.2, 45.1}, {131.8, 45.3}, {131.25, 44.8}, {131.25, 44}, {130.9, 42.9}, {130.65, 42.42},
+	{129.85, 42.95}, {129, 42.2}, {128.1, 41.9}, {126.6, 41.6}, {125.3, 40.6}, {124.55, 40.2},
+}
+
+var hongKongBoundary = []lngLat{
+	{113.82, 22.17}, {114.45, 22.13}, {114.45, 22.56}, {114.22, 22.56}, {114.15, 22.54}, {114.08, 22.51},
+	{114.03, 22.5}, {113.97, 22.49}, {113.93, 22.43}, {113.82, 22.4},
+}
+
+var macauBoundary = []lngLat{
+	{113.528, 22.215}, {113.555, 22.215}, {113.6, 22.16}, {113.6, 22.1}, {113.55, 22.11}, {113.555, 22.16},
+	{113.53, 22.18},
+}
+
+// 中国大陆以外不做GCJ-02偏移
+func outOfChina(lng, lat float64) bool {
+	if lng < 72.004 || lng > 137.8347 || lat < 0.8293 || lat > 55.8271 {
+		return true
+	}
+	p := lngLat{lng, lat}
+	return !pointInPolygon(p, mainlandChinaBoundary) || pointInPolygon(p, hongKongBoundary) || pointInPolygon(p, macauBoundary)
+}
+
+// Google Places结果的坐标系：GOOGLE_CRS为wgs84或gcj02时固定使用；默认auto，
+// 中国大陆的结果为GCJ-02，其他地区为WGS-84
+const googleCRSAuto = "auto"
+
+var googleCRS = googleCRSAuto
+
+func initGoogleCRS() {
+	googleCRS = googleCRSAuto
+	v := os.Getenv("GOOGLE_CRS")
+	if v == "" || strings.EqualFold(v, googleCRSAuto) {
+		return
+	}
+	crs, err := parseCRS(v)
+	if err != nil {
+		log.Printf("[坐标系] GOOGLE_CRS无效，按auto处理: %v", err)
+		return
+	}
+	googleCRS = crs
+}
+
+// 该位置的Google坐标所用坐标系
+func googleSourceCRS(lng, lat float64) string {
+	if googleCRS != googleCRSAuto {
+		return googleCRS
+	}
+	if outOfChina(lng, lat) {
+		return CRSWGS84
+	}
+	return CRSGCJ02
+}
+
+func gcjTransformLat(x, y float64) float64 {
+	ret := -100.0 + 2.0*x + 3.0*y + 0.2*y*y + 0.1*x*y + 0.2*math.Sqrt(math.Abs(x))
+	ret += (20.0*math.Sin(6.0*x*math.Pi) + 20.0*math.Sin(2.0*x*math.Pi)) * 2.0 / 3.0
+	ret += (20.0*math.Sin(y*math.Pi) + 40.0*math.Sin(y/3.0*math.Pi)) * 2.0 / 3.0
+	ret += (160.0*math.Sin(y/12.0*math.Pi) + 320*math.Sin(y*math.Pi/30.0)) * 2.0 / 3.0
+	return ret
+}
+
+func gcjTransformLng(x, y float64) float64 {
+	ret := 300.0 + x + 2.0*y + 0.1*x*x + 0.1*x*y + 0.1*math.Sqrt(math.Abs(x))
+	ret += (20.0*math.Sin(6.0*x*math.Pi) + 20.0*math.Sin(2.0*x*math.Pi)) * 2.0 / 3.0
+	ret += (20.0*math.Sin(x*math.Pi) + 40.0*math.Sin(x/3.0*math.Pi)) * 2.0 / 3.0
+	ret += (150.0*math.Sin(x/12.0*math.Pi) + 300.0*math.Sin(x/30.0*math.Pi)) * 2.0 / 3.0
+	return ret
+}
+
+func wgs84ToGCJ02(lng, lat float64) (float64, float64) {
+	if outOfChina(lng, lat) {
+		return lng, lat
+	}
+	dLat := gcjTransformLat(lng-105.0, lat-35.0)
+	dLng := gcjTransformLng(lng-105.0, lat-35.0)
+	radLat := lat / 180.0 * math.Pi
+	magic := math.Sin(radLat)
+	magic = 1 - gcjEE*magic*magic
+	sqrtMagic := math.Sqrt(magic)
+	dLat = (dLat * 180.0) / ((gcjA * (1 - gcjEE)) / (magic * sqrtMagic) * math.Pi)
+	dLng = (dLng * 180.0) / (gcjA / sqrtMagic * math.Cos(radLat) * math.Pi)
+	return lng + dLng, lat + dLat
+}
+
+// GCJ-02没有解析逆变换，迭代求解，误差小于1e-9度（约0.1毫米）
+func gcj02ToWGS84(lng, lat float64) (float64, float64) {
+	if outOfChina(lng, lat) {
+		return lng, lat
+	}
+	wLng, wLat := lng, lat
+	for i := 0; i < 30; i++ {
+		gLng, gLat := wgs84ToGCJ02(wLng, wLat)
+		dLng, dLat := gLng-lng, gLat-lat
+		wLng, wLat = wLng-dLng, wLat-dLat
+		if math.Abs(dLng) < 1e-9 && math.Abs(dLat) < 1e-9 {
+			break
+		}
+	}
+	return wLng, wLat
+}
+
+func gcj02ToBD09(lng, lat float64) (float64, float64) {
+	z := math.Sqrt(lng*lng+lat*lat) + 0.00002*math.Sin(lat*bdXPi)
+	theta := math.Atan2(lat, lng) + 0.000003*math.Cos(lng*bdXPi)
+	return z*math.Cos(theta) + 0.0065, z*math.Sin(theta) + 0.006
+}
+
+func bd09ToGCJ02(lng, lat float64) (float64, float64) {
+	x, y := lng-0.0065, lat-0.006
+	z := math.Sqrt(x*x+y*y) - 0.00002*math.Sin(y*bdXPi)
+	theta := math.Atan2(y, x) - 0.000003*math.Cos(x*bdXPi)
+	return z * math.Cos(theta), z * math.Sin(theta)
+}
+
+// 坐标系转换，经GCJ-02中转；坐标系不同时结果保留6位小数（约0.1米，与高德一致）
+func convertCoord(lng, lat float64, from, to string) (float64, float64) {
+	if from == to || (lng == 0 && lat == 0) {
+		return lng, lat
+	}
+	switch from {
+	case CRSWGS84:
+		lng, lat = wgs84ToGCJ02(lng, lat)
+	case CRSBD09:
+		lng, lat = bd09ToGCJ02(lng, lat)
+	}
+	switch to {
+	case CRSWGS84:
+		lng, lat = gcj02ToWGS84(lng, lat)
+	case CRSBD09:
+		lng, lat = gcj02ToBD09(lng, lat)
+	}
+	return roundCoord(lng), roundCoord(lat)
+}
+
+func roundCoord(v float64) float64 {
+	return math.Round(v*1e6) / 1e6
+}
+
+// 转换"经度,纬度"字符串，无法解析时原样返回
+func convertLocation(loc string, from, to string) string {
+	lng, lat, ok := parseLngLat(loc)
+	if !ok || from == to {
+		return loc
+	}
+	lng, lat = convertCoord(lng, lat, from, to)
+	return formatExportFloat(lng) + "," + formatExportFloat(lat)
+}
+
+// crs参数：请求中的坐标（lat/lng、location、bbox、polygon）和返回的坐标都使用该坐标系，默认为存储坐标系
+func crsParam(c *gin.Context) (string, bool) {
+	s := c.Query("crs")
+	if s == "" {
+		return storageCRS, true
+	}
+	crs, err := parseCRS(s)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return "", false
+	}
+	return crs, true
+}
+
+// 导出文件的坐标系：GeoJSON（RFC 7946）和KML规定为WGS-84，未指定crs时按WGS-84导出
+func exportCRS(c *gin.Context, format, crs string) string {
+	if c.Query("crs") == "" && (format == ExportFormatGeoJSON || format == ExportFormatKML) {
+		return CRSWGS84
+	}
+	return crs
+}
+
+// 将医院坐标从其坐标系（未标注时为存储坐标系）转为crs
+func (h *Hospital) toCRS(crs string) {
+	from := h.CRS
+	if from == "" {
+		from = storageCRS
+	}
+	h.Longitude, h.Latitude = convertCoord(h.Longitude, h.Latitude, from, crs)
+	if h.NearestEntrance != nil {
+		e := *h.NearestEntrance
+		e.Longitude, e.Latitude = convertCoord(e.Longitude, e.Latitude, from, crs)
+		h.NearestEntrance = &e
+	}
+	h.CRS = crs
+}
+
+func hospitalsToCRS(hospitals []Hospital, crs string) {
+	for i := range hospitals {
+		hospitals[i].toCRS(crs)
+	}
+}
+
+// 院区和子POI均为存储坐标系
+func (cp *Campus) toCRS(crs string) {
+	if cp == nil {
+		return
+	}
+	cp.Longitude, cp.Latitude = convertCoord(cp.Longitude, cp.Latitude, storageCRS, crs)
+	for i := range cp.Entrances {
+		e := &cp.Entrances[i]
+		e.Longitude, e.Latitude = convertCoord(e.Longitude, e.Latitude, storageCRS, crs)
+	}
+}
+
+func (pc *POIChildren) toCRS(crs string) {
+	if pc == nil {
+		return
+	}
+	for _, group := range [][]ChildPOI{pc.Departments, pc.Buildings, pc.Entrances, pc.Branches, pc.Others} {
+		for i := range group {
+			group[i].Longitude, group[i].Latitude = convertCoord(group[i].Longitude, group[i].Latitude, storageCRS, crs)
+		}
+	}
+}
+
+// 高德POI中的坐标字段
+var poiLocationFields = []string{"location", "entr_location", "exit_location"}
+
+// 将高德格式POI（GCJ-02）的坐标字段转为crs，包括合并结果附加的子POI、院区和最近出入口
+func poisToCRS(pois []map[string]interface{}, crs string) {
+	if crs == CRSGCJ02 {
+		return
+	}
+	for _, poi := range pois {
+		for _, field := range poiLocationFields {
+			if loc, ok := poi[field].(string); ok {
+				poi[field] = convertLocation(loc, CRSGCJ02, crs)
+			}
+		}
+		// 最近出入口可能指向院区中的元素，先复制再转换院区
+		if e, ok := poi["nearest_entrance"].(*Entrance); ok && e != nil {
+			converted := *e
+			converted.Longitude, converted.Latitude = convertCoord(e.Longitude, e.Latitude, CRSGCJ02, crs)
+			poi["nearest_entrance"] = &converted
+		}
+		if children, ok := poi["children"].(*POIChildren); ok {
+			children.toCRS(crs)
+		}
+		if campus, ok := poi["campus"].(*Campus); ok {
+			campus.toCRS(crs)
+		}
+	}
+}
+
+// 将存储坐标系的表格转为crs，并追加crs列
+func sheetToCRS(sheet XLSXSheet, crs string) XLSXSheet {
+	if len(sheet.Rows) == 0 {
+		return sheet
+	}
+	coords, ok := findSheetCoordinates(sheet.Rows[0])
+	if !ok {
+		return sheet
+	}
+	out := XLSXSheet{Name: sheet.Name, Hidden: sheet.Hidden, Numeric: append(append([]bool{}, sheet.Numeric...), false)}
+	out.Rows = append(out.Rows, append(append([]string{}, sheet.Rows[0]...), "crs"))
+	for _, row := range sheet.Rows[1:] {
+		row = append(append([]string{}, row...), crs)
+		if lng, lat, found := coords.point(row); found && crs != storageCRS {
+			lng, lat = convertCoord(lng, lat, storageCRS, crs)
+			if coords.lng >= 0 && coords.lat >= 0 && row[coords.lng] != "" && row[coords.lat] != "" {
+				row[coords.lng], row[coords.lat] = formatExportFloat(lng), formatExportFloat(lat)
+			}
+			if coords.location >= 0 && row[coords.location] != "" {
+				row[coords.location] = formatExportFloat(lng) + "," + formatExportFloat(lat)
+			}
+		}
+		out.Rows = append(out.Rows, row)
+	}
+	return out
+}
+
+// 范围的各顶点由from转为to坐标系
+func (a *searchArea) convert(from, to string) *searchArea {
+	if from == to {
+		return a
+	}
+	polys := make([][][]lngLat, len(a.Polygons))
+	for i, poly := range a.Polygons {
+		for _, ring := range poly {
+			r := make([]lngLat, len(ring))
+			for j, p := range ring {
+				r[j].Lng, r[j].Lat = convertCoord(p.Lng, p.Lat, from, to)
+			}
+			polys[i] = append(polys[i], r)
+		}
+	}
+	return polygonArea(polys)
+}
+
+// 将请求中crs坐标系的范围转为存储坐标系
+func (a *searchArea) toStorage(crs string) *searchArea {
+	return a.convert(crs, storageCRS)
+}
+
+// 库中crs不是存储坐标系的点（如外部工具按WGS-84写入的行）转为存储坐标系。
+// 医院记录新版本，POI同时更新原始数据中的location
+func normalizeStoredCRS() {
+	now := time.Now()
+	type storedPoint struct {
+		id       string
+		lng, lat float64
+		crs, raw string
+	}
+	collect := func(query string) []storedPoint {
+		rows, err := db.Query(query, storageCRS)
+		if err != nil {
+			log.Printf("[坐标系] 查询失败: %v", err)
+			return nil
+		}
+		defer rows.Close()
+		var points []storedPoint
+		for rows.Next() {
+			var p storedPoint
+			if err := rows.Scan(&p.id, &p.lng, &p.lat, &p.crs, &p.raw); err != nil {
+				log.Printf("[坐标系] 读取失败: %v", err)
+				continue
+			}
+			points = append(points, p)
+		}
+		return points
+	}
+
+	hospitals := collect(`SELECT CAST(id AS TEXT), COALESCE(longitude, 0), COALESCE(latitude, 0), COALESCE(crs, ''), '' FROM hospitals WHERE crs != ?`)
+	pois := collect(`SELECT id, COALESCE(longitude, 0), COALESCE(latitude, 0), COALESCE(crs, ''), COALESCE(raw, '') FROM pois WHERE crs != ?`)
+	converted := 0
+	for _, p := range hospitals {
+		from, err := parseCRS(p.crs)
+		if err != nil {
+			log.Printf("[坐标系] 医院 %s: %v", p.id, err)
+			continue
+		}
+		lng, lat := convertCoord(p.lng, p.lat, from, storageCRS)
+		if _, err := db.Exec(`UPDATE hospitals SET longitude = ?, latitude = ?, crs = ?, updated_at = ? WHERE id = ?`,
+			lng, lat, storageCRS, now.Format("2006-01-02 15:04:05"), p.id); err != nil {
+			log.Printf("[坐标系] 医院 %s: %v", p.id, err)
+			continue
+		}
+		id, _ := strconv.Atoi(p.id)
+		if err := recordHospitalVersion(id, "crs_normalize", now); err != nil {
+			log.Printf("[坐标系] 记录医院 %s 版本失败: %v", p.id, err)
+		}
+		converted++
+	}
+	for _, p := range pois {
+		from, err := parseCRS(p.crs)
+		if err != nil {
+			log.Printf("[坐标系] POI %s: %v", p.id, err)
+			continue
+		}
+		lng, lat := convertCoord(p.lng, p.lat, from, storageCRS)
+		var raw map[string]interface{}
+		if json.Unmarshal([]byte(p.raw), &raw) == nil {
+			for _, field := range poiLocationFields {
+				if loc, ok := raw[field].(string); ok {
+					raw[field] = convertLocation(loc, from, storageCRS)
+				}
+			}
+			if data, err := json.Marshal(raw); err == nil {
+				p.raw = string(data)
+			}
+		}
+		if _, err := db.Exec(`UPDATE pois SET longitude = ?, latitude = ?, raw = ?, crs = ? WHERE id = ?`,
+			lng, lat, p.raw, storageCRS, p.id); err != nil {
+			log.Printf("[坐标系] POI %s: %v", p.id, err)
+			continue
+		}
+		converted++
+	}
+	if converted > 0 {
+		invalidateMapPoints()
+		log.Printf("[坐标系] %d 个点已转为 %s", converted, storageCRS)
+	}
+}
+
+// Google Places响应中results[].geometry.location由Google所用坐标系转为crs，其余字段原样保留；无法解析时返回原文
+func googleResultsToCRS(body []byte, crs string) []byte {
+	var resp map[string]interface{}
+	if err := json.Unmarshal(body, &resp); err != nil {
+		return body
+	}
+	results, _ := resp["results"].([]interface{})
+	for _, r := range results {
+		result, _ := r.(map[string]interface{})
+		geometry, _ := result["geometry"].(map[string]interface{})
+		loc, _ := geometry["location"].(map[string]interface{})
+		lng, okLng := loc["lng"].(float64)
+		lat, okLat := loc["lat"].(float64)
+		if okLng && okLat {
+			loc["lng"], loc["lat"] = convertCoord(lng, lat, googleSourceCRS(lng, lat), crs)
+		}
+	}
+	resp["crs"] = crs
+	out, err := json.Marshal(resp)
+	if err != nil {
+		return body
+	}
+	return out
+}
+
+// 高德地理编码响应中geocodes[].location由GCJ-02转为crs，其余字段原样保留；无法解析时返回原文
+func amapGeocodesToCRS(body []byte, crs string) []byte {
+	var resp map[string]interface{}
+	if err := json.Unmarshal(body, &resp); err != nil {
+		return body
+	}
+	geocodes, _ := resp["geocodes"].([]interface{})
+	for _, g := range geocodes {
+		geocode, _ := g.(map[string]interface{})
+		if loc, ok := geocode["location"].(string); ok {
+			geocode["location"] = convertLocation(loc, CRSGCJ02, crs)
+		}
+	}
+	resp["crs"] = crs
+	out, err := json.Marshal(resp)
+	if err != nil {
+		return body
+	}
+	return out
+}
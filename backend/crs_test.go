@@ -0,0 +1,274 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"math"
+	"net/http"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestCoordinateConversion(t *testing.T) {
+	near := func(name string, gotLng, gotLat, wantLng, wantLat, tol float64) {
+		t.Helper()
+		if math.Abs(gotLng-wantLng) > tol || math.Abs(gotLat-wantLat) > tol {
+			t.Errorf("%s: %.9f,%.9f，应为 %.9f,%.9f", name, gotLng, gotLat, wantLng, wantLat)
+		}
+	}
+	// 参考值与常用的coordtransform实现一致
+	lng, lat := wgs84ToGCJ02(116.404, 39.915)
+	near("WGS-84→GCJ-02", lng, lat, 116.41024449916938, 39.91640428150164, 1e-9)
+	lng, lat = gcj02ToBD09(116.404, 39.915)
+	near("GCJ-02→BD-09", lng, lat, 116.41036949371029, 39.92133699351021, 1e-9)
+	lng, lat = bd09ToGCJ02(116.404, 39.915)
+	near("BD-09→GCJ-02", lng, lat, 116.39762729119315, 39.90865673957631, 1e-9)
+
+	// 逆变换往返
+	lng, lat = gcj02ToWGS84(wgs84ToGCJ02(116.404, 39.915))
+	near("GCJ-02往返", lng, lat, 116.404, 39.915, 1e-8)
+	lng, lat = bd09ToGCJ02(gcj02ToBD09(121.4737, 31.2304))
+	near("BD-09往返", lng, lat, 121.4737, 31.2304, 1e-6)
+	// 北京的GCJ-02偏移为数百米
+	if d := haversine(116.404, 39.915, 116.41024449916938, 39.91640428150164); d < 300 || d > 800 {
+		t.Errorf("偏移 %.0f 米", d)
+	}
+	// 境外不偏移
+	if lng, lat := convertCoord(-0.1276, 51.5072, CRSWGS84, CRSGCJ02); lng != -0.1276 || lat != 51.5072 {
+		t.Errorf("伦敦: %v,%v", lng, lat)
+	}
+	// 经GCJ-02中转，结果保留6位小数
+	lng, lat = convertCoord(116.404, 39.915, CRSWGS84, CRSBD09)
+	if lng != math.Round(lng*1e6)/1e6 {
+		t.Errorf("未取整: %v", lng)
+	}
+	lng, lat = convertCoord(lng, lat, CRSBD09, CRSWGS84)
+	near("WGS-84→BD-09→WGS-84", lng, lat, 116.404, 39.915, 2e-6)
+	if lng, lat := convertCoord(0, 0, CRSWGS84, CRSGCJ02); lng != 0 || lat != 0 {
+		t.Error("缺失坐标(0,0)不转换")
+	}
+
+	for in, want := range map[string]string{"WGS84": CRSWGS84, "EPSG:4326": CRSWGS84, "gcj-02": CRSGCJ02, "bd09ll": CRSBD09} {
+		if got, err := parseCRS(in); err != nil || got != want {
+			t.Errorf("parseCRS(%s) = %s, %v", in, got, err)
+		}
+	}
+	if _, err := parseCRS("cgcs2000"); err == nil {
+		t.Error("未知坐标系应报错")
+	}
+	if got := convertLocation("116.404,39.915", CRSWGS84, CRSGCJ02); got != "116.410244,39.916404" {
+		t.Errorf("location: %s", got)
+	}
+}
+
+func TestOutOfChina(t *testing.T) {
+	for name, p := range map[string]lngLat{
+		"北京": {116.40, 39.90}, "深圳福田": {114.05, 22.54}, "深圳蛇口": {113.91, 22.48}, "珠海横琴": {113.52, 22.13},
+		"乌鲁木齐": {87.6, 43.8}, "拉萨": {91.1, 29.65}, "三亚": {109.5, 18.25}, "二连浩特": {111.98, 43.65},
+		"丹东": {124.3947, 40.1292}, "黑河": {127.489, 50.245}, "厦门": {118.09, 24.48},
+	} {
+		if outOfChina(p.Lng, p.Lat) {
+			t.Errorf("%s 应在大陆", name)
+		}
+	}
+	for name, p := range map[string]lngLat{
+		"首尔": {126.98, 37.57}, "平壤": {125.75, 39.03}, "新义州": {124.3981, 40.1006}, "釜山": {129.07, 35.18},
+		"东京": {139.69, 35.69}, "大阪": {135.5, 34.69}, "福冈": {130.4, 33.59}, "那霸": {127.68, 26.21},
+		"乌兰巴托": {106.9, 47.9}, "乔巴山": {114.5, 48.07}, "香港": {114.16, 22.28}, "澳门": {113.54, 22.19},
+		"台北": {121.56, 25.04}, "符拉迪沃斯托克": {131.9, 43.12}, "河内": {105.85, 21.03}, "加德满都": {85.32, 27.7},
+	} {
+		if !outOfChina(p.Lng, p.Lat) {
+			t.Errorf("%s 应在大陆以外", name)
+		}
+	}
+	if lng, lat := wgs84ToGCJ02(126.98, 37.57); lng != 126.98 || lat != 37.57 {
+		t.Fatalf("首尔不应偏移: %v %v", lng, lat)
+	}
+}
+
+func TestSheetAndGoogleCRS(t *testing.T) {
+	sheet := sheetToCRS(XLSXSheet{Name: "pois", Rows: [][]string{
+		{"id", "location"},
+		{"B0", "116.410244,39.916404"},
+		{"B1", ""},
+	}, Numeric: []bool{false, false}}, CRSWGS84)
+	if len(sheet.Rows[0]) != 3 || sheet.Rows[0][2] != "crs" || len(sheet.Numeric) != 3 {
+		t.Fatalf("表头: %v", sheet.Rows[0])
+	}
+	lng, lat, _ := parseLngLat(sheet.Rows[1][1])
+	if math.Abs(lng-116.404) > 2e-6 || math.Abs(lat-39.915) > 2e-6 || sheet.Rows[1][2] != CRSWGS84 || sheet.Rows[2][1] != "" {
+		t.Fatalf("行: %v", sheet.Rows[1:])
+	}
+	if noCoords := sheetToCRS(XLSXSheet{Rows: [][]string{{"id", "rating"}}}, CRSWGS84); len(noCoords.Rows[0]) != 2 {
+		t.Fatalf("无坐标的表格不加crs列: %v", noCoords.Rows[0])
+	}
+
+	// 大陆的Google结果已是GCJ-02，首尔的为WGS-84，两者都不需要偏移
+	googleBody := []byte(`{"status":"OK","results":[{"name":"协和","geometry":{"location":{"lat":39.916404,"lng":116.410244}}},` +
+		`{"name":"首尔大学医院","geometry":{"location":{"lat":37.5796,"lng":126.999}}}]}`)
+	var resp struct {
+		Status  string
+		CRS     string
+		Results []struct {
+			Name     string
+			Geometry struct{ Location struct{ Lat, Lng float64 } }
+		}
+	}
+	if err := json.Unmarshal(googleResultsToCRS(googleBody, CRSGCJ02), &resp); err != nil {
+		t.Fatal(err)
+	}
+	if loc := resp.Results[0].Geometry.Location; resp.Status != "OK" || resp.CRS != CRSGCJ02 || loc.Lng != 116.410244 || loc.Lat != 39.916404 {
+		t.Fatalf("大陆的Google结果: %+v", resp)
+	}
+	if loc := resp.Results[1].Geometry.Location; loc.Lng != 126.999 || loc.Lat != 37.5796 {
+		t.Fatalf("首尔的Google结果: %+v", loc)
+	}
+	// 返回WGS-84时大陆结果去掉偏移，首尔不变
+	json.Unmarshal(googleResultsToCRS(googleBody, CRSWGS84), &resp)
+	if loc := resp.Results[0].Geometry.Location; math.Abs(loc.Lng-116.404) > 2e-6 || math.Abs(loc.Lat-39.915) > 2e-6 {
+		t.Fatalf("大陆结果转WGS-84: %+v", loc)
+	}
+	if loc := resp.Results[1].Geometry.Location; loc.Lng != 126.999 || loc.Lat != 37.5796 {
+		t.Fatalf("首尔结果转WGS-84: %+v", loc)
+	}
+	// GOOGLE_CRS固定为wgs84时大陆结果也按WGS-84转换
+	t.Cleanup(initGoogleCRS) // 在恢复环境变量之后执行
+	t.Setenv("GOOGLE_CRS", "wgs84")
+	initGoogleCRS()
+	json.Unmarshal(googleResultsToCRS([]byte(`{"results":[{"geometry":{"location":{"lat":39.915,"lng":116.404}}}]}`), CRSGCJ02), &resp)
+	if loc := resp.Results[0].Geometry.Location; loc.Lng != 116.410244 || loc.Lat != 39.916404 {
+		t.Fatalf("GOOGLE_CRS=wgs84: %+v", loc)
+	}
+	if raw := googleResultsToCRS([]byte("not json"), CRSGCJ02); string(raw) != "not json" {
+		t.Fatalf("无法解析时原样返回: %s", raw)
+	}
+}
+
+func TestCRSAPI(t *testing.T) {
+	e := setupE2E(t, nil)
+	const gLng, gLat = 116.417, 39.912 // 协和，GCJ-02
+	insertTestHospital(t, "北京协和医院", gLng, gLat, "address", "北京市东城区帅府园1号", "hospital_type", "综合医院", "qualifications", "三级甲等")
+	wLng, wLat := convertCoord(gLng, gLat, CRSGCJ02, CRSWGS84)
+
+	_, body := e.get("/api/hospitals?crs=wgs84")
+	h := body["data"].([]interface{})[0].(map[string]interface{})
+	if body["crs"] != CRSWGS84 || h["crs"] != CRSWGS84 || h["longitude"].(float64) != wLng || h["latitude"].(float64) != wLat {
+		t.Fatalf("WGS-84输出: %v", body)
+	}
+	if _, body = e.get("/api/hospitals"); body["data"].([]interface{})[0].(map[string]interface{})["longitude"].(float64) != gLng {
+		t.Fatalf("默认GCJ-02: %v", body)
+	}
+	if w, _ := e.get("/api/hospitals?crs=utm"); w.Code != http.StatusBadRequest {
+		t.Fatalf("未知坐标系 %d", w.Code)
+	}
+
+	// 请求坐标按crs解释：WGS-84的小范围只在crs=wgs84时包含协和
+	bbox := fmt.Sprintf("%f,%f,%f,%f", wLng-0.001, wLat-0.001, wLng+0.001, wLat+0.001)
+	if _, body = e.get("/api/hospitals/search?crs=wgs84&bbox=" + bbox); body["count"].(float64) != 1 {
+		t.Fatalf("WGS-84范围: %v", body)
+	}
+	if _, body = e.get("/api/hospitals/search?bbox=" + bbox); body["count"].(float64) != 0 {
+		t.Fatalf("GCJ-02范围: %v", body)
+	}
+	_, body = e.get(fmt.Sprintf("/api/hospitals/search?crs=wgs84&bbox=%s&lat=%f&lng=%f", bbox, wLat, wLng))
+	if d := body["data"].([]interface{})[0].(map[string]interface{})["distance"]; d != nil && d.(float64) > 0.001 {
+		t.Fatalf("同一点距离应为0: %v", d)
+	}
+	_, body = e.get("/api/hospitals/1?crs=bd09")
+	if d := body["data"].(map[string]interface{}); d["crs"] != CRSBD09 || d["longitude"].(float64) <= gLng {
+		t.Fatalf("详情BD-09: %v", d)
+	}
+	// 已抓取POI详情：POI、子POI和院区同样按crs输出
+	ledger := []RawPOIRecord{{Typecode: "090101", POIs: []interface{}{
+		map[string]interface{}{"id": "B0CRSP", "name": "北京协和医院", "typecode": "090101", "childtype": []interface{}{},
+			"parent": []interface{}{}, "location": "116.417000,39.912000"},
+		map[string]interface{}{"id": "B0CRSG", "name": "北京协和医院(东门)", "typecode": "090101", "childtype": "308",
+			"parent": "B0CRSP", "location": "116.418000,39.912000"},
+	}}}
+	if _, err := upsertCrawledPOIs(1, ledger, time.Now()); err != nil {
+		t.Fatal(err)
+	}
+	gateLng, _ := convertCoord(116.418, gLat, CRSGCJ02, CRSWGS84)
+	_, body = e.get("/api/pois/B0CRSP?crs=wgs84")
+	d := body["data"].(map[string]interface{})
+	gate := body["children"].(map[string]interface{})["entrances"].([]interface{})[0].(map[string]interface{})
+	campus := body["campus"].(map[string]interface{})
+	if d["crs"] != CRSWGS84 || d["longitude"].(float64) != wLng || d["latitude"].(float64) != wLat ||
+		gate["longitude"].(float64) != gateLng || campus["longitude"].(float64) != wLng ||
+		campus["entrances"].([]interface{})[0].(map[string]interface{})["longitude"].(float64) != gateLng {
+		t.Fatalf("POI详情WGS-84: %v", body)
+	}
+	if w, _ := e.get("/api/pois/B0CRSP?crs=utm"); w.Code != http.StatusBadRequest {
+		t.Fatalf("POI详情未知坐标系 %d", w.Code)
+	}
+
+	// GeoJSON导出默认WGS-84，可用crs指定
+	admin := map[string]string{"X-API-Key": e.adminKey}
+	var fc struct {
+		Features []struct {
+			Geometry   struct{ Coordinates []float64 }
+			Properties map[string]interface{}
+		}
+	}
+	w := doRequest(e.router, http.MethodGet, "/api/export/hospitals?format=geojson", nil, admin)
+	json.Unmarshal(w.Body.Bytes(), &fc)
+	if c := fc.Features[0].Geometry.Coordinates; c[0] != wLng || c[1] != wLat || fc.Features[0].Properties["crs"] != CRSWGS84 {
+		t.Fatalf("GeoJSON默认WGS-84: %s", w.Body.String())
+	}
+	w = doRequest(e.router, http.MethodGet, "/api/export/hospitals?format=geojson&crs=gcj02", nil, admin)
+	json.Unmarshal(w.Body.Bytes(), &fc)
+	if c := fc.Features[0].Geometry.Coordinates; c[0] != gLng || c[1] != gLat {
+		t.Fatalf("GeoJSON GCJ-02: %v", c)
+	}
+	w = doRequest(e.router, http.MethodGet, "/api/export/hospitals?format=csv", nil, admin)
+	if lines := strings.Split(w.Body.String(), "\n"); !strings.HasSuffix(strings.TrimSpace(lines[0]), ",crs") || !strings.Contains(lines[1], fmt.Sprint(gLng)) {
+		t.Fatalf("CSV默认存储坐标系: %s", w.Body.String())
+	}
+
+	// 导入：文件为WGS-84，行中crs列优先
+	csv := fmt.Sprintf("name,longitude,latitude,坐标系\n测试WGS医院,%f,%f,\n测试百度医院,%f,%f,bd09\n", wLng, wLat, wLng, wLat)
+	if w, body := e.admin(http.MethodPost, "/api/admin/import/hospitals?crs=wgs84", csv); w.Code != http.StatusOK || body["data"].(map[string]interface{})["inserted"].(float64) != 2 {
+		t.Fatalf("导入 %d: %v", w.Code, body)
+	}
+	var lng, lat float64
+	db.QueryRow(`SELECT longitude, latitude FROM hospitals WHERE name = '测试WGS医院'`).Scan(&lng, &lat)
+	if math.Abs(lng-gLng) > 2e-6 || math.Abs(lat-gLat) > 2e-6 {
+		t.Fatalf("WGS-84导入: %v,%v", lng, lat)
+	}
+	db.QueryRow(`SELECT longitude, latitude FROM hospitals WHERE name = '测试百度医院'`).Scan(&lng, &lat)
+	if want, _ := convertCoord(wLng, wLat, CRSBD09, CRSGCJ02); lng != want {
+		t.Fatalf("按行坐标系导入: %v", lng)
+	}
+
+	// 外部写入的WGS-84点在启动时转为存储坐标系
+	insertTestHospital(t, "测试外部医院", wLng, wLat, "crs", "WGS-84")
+	db.Exec(`INSERT INTO pois (id, name, typecode, longitude, latitude, raw, first_seen, last_seen, crs)
+		VALUES ('B0CRS1', '测试外部POI', '090100', ?, ?, ?, '2025-07-01T00:00:00Z', '2025-07-01T00:00:00Z', 'wgs84')`,
+		wLng, wLat, fmt.Sprintf(`{"id":"B0CRS1","location":"%f,%f"}`, wLng, wLat))
+	normalizeStoredCRS()
+	var crs, raw string
+	var versions int
+	db.QueryRow(`SELECT longitude, crs FROM hospitals WHERE name = '测试外部医院'`).Scan(&lng, &crs)
+	db.QueryRow(`SELECT COUNT(*) FROM hospital_versions v JOIN hospitals h ON h.id = v.hospital_id WHERE h.name = '测试外部医院' AND v.source = 'crs_normalize'`).Scan(&versions)
+	if math.Abs(lng-gLng) > 2e-6 || crs != CRSGCJ02 || versions != 1 {
+		t.Fatalf("医院归一化: %v %s %d", lng, crs, versions)
+	}
+	db.QueryRow(`SELECT longitude, crs, raw FROM pois WHERE id = 'B0CRS1'`).Scan(&lng, &crs, &raw)
+	if math.Abs(lng-gLng) > 2e-6 || crs != CRSGCJ02 || strings.Contains(raw, fmt.Sprintf("%f", wLng)) {
+		t.Fatalf("POI归一化: %v %s %s", lng, crs, raw)
+	}
+
+	// 地图聚合按底图坐标系返回
+	_, body = e.get("/api/map/clusters?bbox=116.0,39.8,116.6,40.0&zoom=18&crs=wgs84")
+	found := false
+	for _, cl := range body["clusters"].([]interface{}) {
+		cl := cl.(map[string]interface{})
+		if poi, _ := cl["poi"].(map[string]interface{}); poi != nil && poi["name"] == "北京协和医院" {
+			found = cl["lng"].(float64) == wLng && cl["lat"].(float64) == wLat
+		}
+	}
+	if body["crs"] != CRSWGS84 || !found {
+		t.Fatalf("WGS-84聚合: %v", body)
+	}
+}
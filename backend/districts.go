@@ -0,0 +1,538 @@
+package main
+
+import (
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"log"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+// 行政区划边界：从GeoJSON（如DataV GeoAtlas导出）或高德行政区查询接口（extensions=all）的响应导入，
+// 按名称或adcode查询，用作医院和POI的范围搜索条件
+
+type District struct {
+	Adcode       string    `json:"adcode"`
+	Name         string    `json:"name"`
+	Level        string    `json:"level,omitempty"` // province / city / district
+	ParentAdcode string    `json:"parent_adcode,omitempty"`
+	Center       string    `json:"center,omitempty"` // "经度,纬度"
+	BBox         []float64 `json:"bbox"`             // minLng, minLat, maxLng, maxLat
+	Source       string    `json:"source,omitempty"`
+	ImportedAt   string    `json:"imported_at"`
+	CRS          string    `json:"crs"`
+	// 仅详情接口返回，GeoJSON MultiPolygon
+	Geometry map[string]interface{} `json:"geometry,omitempty"`
+	area     *searchArea
+}
+
+// 从导入文件读出的行政区
+type districtRecord struct {
+	District
+	polygons [][][]lngLat
+}
+
+// 解析导入文件：含districts数组的为高德行政区接口响应，否则按GeoJSON Feature/FeatureCollection解析
+func parseDistrictImport(data []byte) ([]districtRecord, error) {
+	var probe struct {
+		Type      string          `json:"type"`
+		Districts json.RawMessage `json:"districts"`
+	}
+	if err := json.Unmarshal(data, &probe); err != nil {
+		return nil, fmt.Errorf("解析行政区文件失败: %v", err)
+	}
+	if probe.Districts != nil {
+		var records []districtRecord
+		err := parseAmapDistricts(probe.Districts, "", &records)
+		return records, err
+	}
+	var features []json.RawMessage
+	switch probe.Type {
+	case "FeatureCollection":
+		var fc struct {
+			Features []json.RawMessage `json:"features"`
+		}
+		if err := json.Unmarshal(data, &fc); err != nil {
+			return nil, err
+		}
+		features = fc.Features
+	case "Feature":
+		features = []json.RawMessage{data}
+	default:
+		return nil, errors.New("应为GeoJSON Feature/FeatureCollection或高德行政区查询结果")
+	}
+	var records []districtRecord
+	for i, raw := range features {
+		var f struct {
+			Properties map[string]interface{} `json:"properties"`
+			Geometry   json.RawMessage        `json:"geometry"`
+		}
+		if err := json.Unmarshal(raw, &f); err != nil {
+			return nil, fmt.Errorf("第%d个要素: %v", i+1, err)
+		}
+		props := f.Properties
+		r := districtRecord{District: District{
+			Adcode: exportCellValue(props["adcode"]),
+			Name:   exportCellValue(props["name"]),
+			Level:  exportCellValue(props["level"]),
+		}}
+		// DataV为 parent: {"adcode": 110000}，也接受 parent_adcode
+		if parent, ok := props["parent"].(map[string]interface{}); ok {
+			r.ParentAdcode = exportCellValue(parent["adcode"])
+		} else {
+			r.ParentAdcode = exportCellValue(props["parent_adcode"])
+		}
+		switch center := props["center"].(type) {
+		case []interface{}:
+			if len(center) == 2 {
+				r.Center = exportCellValue(center[0]) + "," + exportCellValue(center[1])
+			}
+		case string:
+			r.Center = center
+		}
+		if r.Adcode == "" || r.Name == "" {
+			return nil, fmt.Errorf("第%d个要素缺少adcode或name", i+1)
+		}
+		if len(f.Geometry) == 0 || string(f.Geometry) == "null" {
+			return nil, fmt.Errorf("%s 没有边界", r.Name)
+		}
+		polys, err := geoJSONPolygons(f.Geometry)
+		if err != nil {
+			return nil, fmt.Errorf("%s: %v", r.Name, err)
+		}
+		r.polygons = polys
+		records = append(records, r)
+	}
+	return records, nil
+}
+
+// 高德行政区：polyline为 "lng,lat;lng,lat|lng,lat;..."，"|"分隔的每部分为一个多边形。
+// 下级行政区递归导入，没有polyline的跳过
+func parseAmapDistricts(raw json.RawMessage, parent string, records *[]districtRecord) error {
+	var districts []struct {
+		Adcode    string          `json:"adcode"`
+		Name      string          `json:"name"`
+		Level     string          `json:"level"`
+		Center    string          `json:"center"`
+		Polyline  string          `json:"polyline"`
+		Districts json.RawMessage `json:"districts"`
+	}
+	if err := json.Unmarshal(raw, &districts); err != nil {
+		return fmt.Errorf("解析高德行政区失败: %v", err)
+	}
+	for _, d := range districts {
+		if d.Polyline != "" {
+			r := districtRecord{District: District{Adcode: d.Adcode, Name: d.Name, Level: d.Level, ParentAdcode: parent, Center: d.Center}}
+			for _, part := range strings.Split(d.Polyline, "|") {
+				ring, err := parsePolygon(part)
+				if err != nil {
+					return fmt.Errorf("%s: %v", d.Name, err)
+				}
+				r.polygons = append(r.polygons, [][]lngLat{ring})
+			}
+			*records = append(*records, r)
+		}
+		if len(d.Districts) > 0 {
+			if err := parseAmapDistricts(d.Districts, d.Adcode, records); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+// 按adcode写入或覆盖行政区
+func importDistricts(records []districtRecord, source string, now time.Time) (inserted, updated int, err error) {
+	tx, err := db.Begin()
+	if err != nil {
+		return 0, 0, err
+	}
+	defer tx.Rollback()
+	ts := now.UTC().Format(time.RFC3339)
+	for _, r := range records {
+		area := polygonArea(r.polygons)
+		geometry, _ := json.Marshal(area.multiPolygonCoordinates())
+		var exists int
+		tx.QueryRow(`SELECT COUNT(*) FROM districts WHERE adcode = ?`, r.Adcode).Scan(&exists)
+		if _, err := tx.Exec(`
+			INSERT INTO districts (adcode, name, level, parent_adcode, center, min_lng, min_lat, max_lng, max_lat, geometry, source, imported_at, crs)
+			VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?)
+			ON CONFLICT(adcode) DO UPDATE SET name = excluded.name, level = excluded.level, parent_adcode = excluded.parent_adcode,
+				center = excluded.center, min_lng = excluded.min_lng, min_lat = excluded.min_lat, max_lng = excluded.max_lng,
+				max_lat = excluded.max_lat, geometry = excluded.geometry, source = excluded.source, imported_at = excluded.imported_at,
+				crs = excluded.crs
+		`, r.Adcode, r.Name, r.Level, r.ParentAdcode, r.Center, area.Min.Lng, area.Min.Lat, area.Max.Lng, area.Max.Lat,
+			string(geometry), source, ts, storageCRS); err != nil {
+			return 0, 0, fmt.Errorf("%s: %v", r.Name, err)
+		}
+		if exists > 0 {
+			updated++
+		} else {
+			inserted++
+		}
+	}
+	return inserted, updated, tx.Commit()
+}
+
+const districtColumns = `adcode, name, COALESCE(level, ''), COALESCE(parent_adcode, ''), COALESCE(center, ''),
+	min_lng, min_lat, max_lng, max_lat, COALESCE(source, ''), imported_at`
+
+func scanDistrict(row interface{ Scan(...interface{}) error }) (District, error) {
+	var d District
+	d.BBox = make([]float64, 4)
+	err := row.Scan(&d.Adcode, &d.Name, &d.Level, &d.ParentAdcode, &d.Center,
+		&d.BBox[0], &d.BBox[1], &d.BBox[2], &d.BBox[3], &d.Source, &d.ImportedAt)
+	d.CRS = storageCRS
+	return d, err
+}
+
+// 中心点、外接矩形和边界（详情）转为crs；有边界时按转换后的边界重新计算外接矩形
+func (d *District) toCRS(crs string) {
+	if crs == d.CRS {
+		return
+	}
+	d.Center = convertLocation(d.Center, d.CRS, crs)
+	if d.area != nil {
+		area := d.area.convert(d.CRS, crs)
+		d.BBox = []float64{area.Min.Lng, area.Min.Lat, area.Max.Lng, area.Max.Lat}
+		d.Geometry = map[string]interface{}{"type": "MultiPolygon", "coordinates": area.multiPolygonCoordinates()}
+	} else {
+		d.BBox[0], d.BBox[1] = convertCoord(d.BBox[0], d.BBox[1], d.CRS, crs)
+		d.BBox[2], d.BBox[3] = convertCoord(d.BBox[2], d.BBox[3], d.CRS, crs)
+	}
+	d.CRS = crs
+}
+
+// 读取行政区及其边界
+func loadDistrict(adcode string) (*District, error) {
+	var geometry string
+	d, err := scanDistrict(db.QueryRow(`SELECT `+districtColumns+` FROM districts WHERE adcode = ?`, adcode))
+	if err != nil {
+		return nil, err
+	}
+	if err := db.QueryRow(`SELECT geometry FROM districts WHERE adcode = ?`, adcode).Scan(&geometry); err != nil {
+		return nil, err
+	}
+	polys, err := geoJSONPolygons([]byte(`{"type":"MultiPolygon","coordinates":` + geometry + `}`))
+	if err != nil {
+		return nil, err
+	}
+	d.area = polygonArea(polys)
+	d.Geometry = map[string]interface{}{"type": "MultiPolygon", "coordinates": d.area.multiPolygonCoordinates()}
+	return &d, nil
+}
+
+// 按名称或adcode查询行政区：q为空时列出全部
+func queryDistricts(q, level, parent string) ([]District, error) {
+	query := `SELECT ` + districtColumns + ` FROM districts WHERE 1 = 1`
+	var args []interface{}
+	if q != "" {
+		query += ` AND (adcode = ? OR name LIKE ?)`
+		args = append(args, q, "%"+q+"%")
+	}
+	if level != "" {
+		query += ` AND level = ?`
+		args = append(args, level)
+	}
+	if parent != "" {
+		query += ` AND parent_adcode = ?`
+		args = append(args, parent)
+	}
+	rows, err := db.Query(query+` ORDER BY adcode`, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	districts := []District{}
+	for rows.Next() {
+		d, err := scanDistrict(rows)
+		if err != nil {
+			return nil, err
+		}
+		districts = append(districts, d)
+	}
+	return districts, rows.Err()
+}
+
+// 解析搜索用的行政区：adcode或全名精确匹配优先，否则要求名称模糊匹配唯一。
+// 重名（如北京、长春都有朝阳区）时返回候选，调用方应改用adcode
+func resolveDistrict(q string) (*District, []District, error) {
+	candidates, err := queryDistricts(q, "", "")
+	if err != nil {
+		return nil, nil, err
+	}
+	var exact []District
+	for _, d := range candidates {
+		if d.Adcode == q || d.Name == q {
+			exact = append(exact, d)
+		}
+	}
+	if len(exact) == 0 {
+		exact = candidates
+	}
+	if len(exact) != 1 {
+		return nil, exact, nil
+	}
+	d, err := loadDistrict(exact[0].Adcode)
+	return d, nil, err
+}
+
+// 范围搜索参数：bbox、polygon（"lng,lat;..."或GeoJSON）、district（名称或adcode）至多一个；
+// bbox和polygon为crs坐标系，返回的范围为存储坐标系。都没有时返回nil
+func searchAreaParam(c *gin.Context, crs string) (*searchArea, bool) {
+	bbox, polygon, district := c.Query("bbox"), c.Query("polygon"), c.Query("district")
+	given := 0
+	for _, v := range []string{bbox, polygon, district} {
+		if v != "" {
+			given++
+		}
+	}
+	if given > 1 {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "bbox、polygon、district只能指定一个"})
+		return nil, false
+	}
+	switch {
+	case bbox != "":
+		min, max, err := parseBBox(bbox)
+		if err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return nil, false
+		}
+		return bboxArea(min, max).toStorage(crs), true
+	case polygon != "":
+		var polys [][][]lngLat
+		if p := strings.TrimSpace(polygon); strings.HasPrefix(p, "{") {
+			var err error
+			if polys, err = geoJSONPolygons([]byte(p)); err != nil {
+				c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+				return nil, false
+			}
+		} else {
+			ring, err := parsePolygon(p)
+			if err != nil {
+				c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+				return nil, false
+			}
+			polys = [][][]lngLat{{ring}}
+		}
+		return polygonArea(polys).toStorage(crs), true
+	case district != "":
+		d, candidates, err := resolveDistrict(district)
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+			return nil, false
+		}
+		if d == nil {
+			if len(candidates) == 0 {
+				c.JSON(http.StatusNotFound, gin.H{"error": "未找到行政区: " + district})
+			} else {
+				c.JSON(http.StatusBadRequest, gin.H{"error": "行政区名称不唯一，请使用adcode", "candidates": candidates})
+			}
+			return nil, false
+		}
+		return d.area, true
+	}
+	return nil, true
+}
+
+// hospitals表中落在范围外接矩形内的医院，多边形判断由hospitalsInArea完成
+func storedHospitalsInArea(area *searchArea) ([]Hospital, error) {
+	rows, err := db.Query(`
+		SELECT id, name, address, latitude, longitude, phone, hospital_type, main_departments, business_hours, qualifications, created_at, updated_at
+		FROM hospitals
+		WHERE longitude BETWEEN ? AND ? AND latitude BETWEEN ? AND ?
+		ORDER BY id
+	`, area.Min.Lng, area.Max.Lng, area.Min.Lat, area.Max.Lat)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	var hospitals []Hospital
+	for rows.Next() {
+		h, err := scanStoredHospital(rows)
+		if err != nil {
+			return nil, err
+		}
+		hospitals = append(hospitals, h)
+	}
+	return hospitals, rows.Err()
+}
+
+// 范围内的医院，距离为到center的距离（公里）；出入口距离、排序和截取由nearestEntranceDistances完成
+func hospitalsInArea(hospitals []Hospital, area *searchArea, center lngLat) []Hospital {
+	var res []Hospital
+	for _, h := range hospitals {
+		if area.Contains(lngLat{h.Longitude, h.Latitude}) {
+			h.Distance = haversine(center.Lng, center.Lat, h.Longitude, h.Latitude) / 1000
+			res = append(res, h)
+		}
+	}
+	return res
+}
+
+// 距center不超过radiusKm公里的医院，距离为到center的距离（公里）
+func hospitalsInRadius(hospitals []Hospital, center lngLat, radiusKm float64) []Hospital {
+	var res []Hospital
+	for _, h := range hospitals {
+		h.Distance = haversine(center.Lng, center.Lat, h.Longitude, h.Latitude) / 1000
+		if h.Distance <= radiusKm {
+			res = append(res, h)
+		}
+	}
+	return res
+}
+
+// 范围内后台抓取的POI（不含子POI和分类体系不显示的类别），按id排序
+func crawledPOIsInArea(area *searchArea) ([]map[string]interface{}, error) {
+	rows, err := db.Query(`
+		SELECT COALESCE(raw, ''), longitude, latitude FROM pois
+		WHERE removed_at IS NULL AND COALESCE(parent_id, '') = ''
+			AND longitude BETWEEN ? AND ? AND latitude BETWEEN ? AND ?
+		ORDER BY id
+	`, area.Min.Lng, area.Max.Lng, area.Min.Lat, area.Max.Lat)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	pois := []map[string]interface{}{}
+	for rows.Next() {
+		var raw string
+		var lng, lat float64
+		if err := rows.Scan(&raw, &lng, &lat); err != nil {
+			return nil, err
+		}
+		var poi map[string]interface{}
+		if json.Unmarshal([]byte(raw), &poi) != nil || !area.Contains(lngLat{lng, lat}) {
+			continue
+		}
+		typecode, _ := poi["typecode"].(string)
+		_, childtypeEmpty := poiChildtype(poi)
+		cat := taxonomy.Classify(typecode, childtypeEmpty)
+		if cat == nil {
+			continue
+		}
+		poi["algo_hospital_category"], poi["algo_icon_type"], poi["algo_display_order"] = cat.Labels["zh"], cat.Icon, cat.DisplayOrder
+		pois = append(pois, poi)
+	}
+	return pois, rows.Err()
+}
+
+// 按范围搜索后台抓取的POI：GET /api/pois/search?bbox=|polygon=|district=，支持format导出
+func searchStoredPOIs(c *gin.Context) {
+	crs, ok := crsParam(c)
+	if !ok {
+		return
+	}
+	area, ok := searchAreaParam(c, crs)
+	if !ok {
+		return
+	}
+	if area == nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "需要bbox、polygon或district"})
+		return
+	}
+	format, ok := exportFormatParam(c, ExportFormatJSON)
+	if !ok {
+		return
+	}
+	pois, err := crawledPOIsInArea(area)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+	if format != ExportFormatJSON {
+		writeExport(c, "pois-search", format, sheetToCRS(poisSheet("pois", pois), exportCRS(c, format, crs)))
+		return
+	}
+	poisToCRS(pois, crs)
+	c.JSON(http.StatusOK, gin.H{"status": "success", "count": len(pois), "crs": crs, "pois": pois})
+}
+
+// 管理员：导入行政区边界，请求体为GeoJSON或高德行政区查询结果，source标注数据来源，
+// crs为文件坐标系（高德、DataV均为GCJ-02，即默认值）
+func importDistrictsHandler(c *gin.Context) {
+	crs, ok := crsParam(c)
+	if !ok {
+		return
+	}
+	data, err := ioutil.ReadAll(io.LimitReader(c.Request.Body, 64<<20))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	records, err := parseDistrictImport(data)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	if len(records) == 0 {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "没有带边界的行政区"})
+		return
+	}
+	for i := range records {
+		records[i].polygons = polygonArea(records[i].polygons).toStorage(crs).Polygons
+		records[i].Center = convertLocation(records[i].Center, crs, storageCRS)
+	}
+	inserted, updated, err := importDistricts(records, c.DefaultQuery("source", "import"), time.Now())
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+	log.Printf("[行政区] 导入 %d 个（%s），新增 %d，更新 %d", len(records), crs, inserted, updated)
+	c.JSON(http.StatusOK, gin.H{"status": "success", "inserted": inserted, "updated": updated})
+}
+
+// 行政区列表：GET /api/districts?q=朝阳&level=district&parent=110100
+func listDistricts(c *gin.Context) {
+	crs, ok := crsParam(c)
+	if !ok {
+		return
+	}
+	districts, err := queryDistricts(strings.TrimSpace(c.Query("q")), c.Query("level"), c.Query("parent"))
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+	for i := range districts {
+		districts[i].toCRS(crs)
+	}
+	c.JSON(http.StatusOK, gin.H{"status": "success", "count": len(districts), "data": districts})
+}
+
+// 行政区详情，含GeoJSON边界
+func getDistrict(c *gin.Context) {
+	crs, ok := crsParam(c)
+	if !ok {
+		return
+	}
+	d, err := loadDistrict(c.Param("adcode"))
+	if err == sql.ErrNoRows {
+		c.JSON(http.StatusNotFound, gin.H{"error": "行政区不存在"})
+		return
+	}
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+	d.toCRS(crs)
+	c.JSON(http.StatusOK, gin.H{"status": "success", "data": d})
+}
+
+// 范围搜索时距离的参照点（存储坐标系）：显式给出lat/lng（crs坐标系）时用该点，否则用范围中心
+func areaSearchCenter(c *gin.Context, area *searchArea, crs string) lngLat {
+	lat, errLat := strconv.ParseFloat(c.Query("lat"), 64)
+	lng, errLng := strconv.ParseFloat(c.Query("lng"), 64)
+	if errLat == nil && errLng == nil {
+		lng, lat = convertCoord(lng, lat, crs, storageCRS)
+		return lngLat{lng, lat}
+	}
+	return area.Center()
+}
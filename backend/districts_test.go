@@ -0,0 +1,188 @@
+package main
+
+import (
+	"fmt"
+	"net/http"
+	"net/url"
+	"testing"
+)
+
+func TestSearchAreaContains(t *testing.T) {
+	// 外环10x10，中间挖去4x4的洞，另有一块独立的部分
+	polys, err := geoJSONPolygons([]byte(`{"type":"MultiPolygon","coordinates":[
+		[[[0,0],[10,0],[10,10],[0,10],[0,0]],[[3,3],[7,3],[7,7],[3,7],[3,3]]],
+		[[[20,0],[22,0],[22,2],[20,2],[20,0]]]
+	]}`))
+	if err != nil {
+		t.Fatal(err)
+	}
+	area := polygonArea(polys)
+	for _, c := range []struct {
+		p    lngLat
+		want bool
+	}{
+		{lngLat{1, 1}, true},
+		{lngLat{5, 5}, false}, // 洞内
+		{lngLat{21, 1}, true},
+		{lngLat{15, 1}, false}, // 两部分之间，在外接矩形内
+		{lngLat{-1, 5}, false},
+	} {
+		if got := area.Contains(c.p); got != c.want {
+			t.Errorf("%v: %v", c.p, got)
+		}
+	}
+	if area.Min != (lngLat{0, 0}) || area.Max != (lngLat{22, 10}) {
+		t.Fatalf("外接矩形 %v %v", area.Min, area.Max)
+	}
+	if coords := area.multiPolygonCoordinates(); len(coords) != 2 || len(coords[0]) != 2 || len(coords[0][0]) != 5 {
+		t.Fatalf("MultiPolygon坐标: %v", coords)
+	}
+
+	if _, err := geoJSONPolygons([]byte(`{"type":"Point","coordinates":[1,2]}`)); err == nil {
+		t.Fatal("Point不是多边形")
+	}
+	if _, err := geoJSONPolygons([]byte(`{"type":"Polygon","coordinates":[[[0,0],[1,1],[0,0]]]}`)); err == nil {
+		t.Fatal("顶点不足")
+	}
+}
+
+// 两个同名的朝阳区（北京、长春），边界为简化的矩形
+const testAmapDistricts = `{"status":"1","districts":[
+	{"adcode":"110000","name":"北京市","level":"province","center":"116.407387,39.904179","polyline":"","districts":[
+		{"adcode":"110105","name":"朝阳区","level":"district","center":"116.443136,39.921444",
+		 "polyline":"116.40,39.85;116.60,39.85;116.60,40.05;116.40,40.05","districts":[]}
+	]},
+	{"adcode":"220000","name":"吉林省","level":"province","center":"125.3245,43.886841","polyline":"","districts":[
+		{"adcode":"220104","name":"朝阳区","level":"district","center":"125.288319,43.833513",
+		 "polyline":"125.20,43.75;125.35,43.75;125.35,43.90;125.20,43.90|125.50,43.50;125.55,43.50;125.55,43.55","districts":[]}
+	]}
+]}`
+
+func TestDistrictSearchAPI(t *testing.T) {
+	e := setupE2E(t, nil)
+
+	if w, body := e.admin(http.MethodPost, "/api/admin/districts?source=amap", testAmapDistricts); w.Code != http.StatusOK || body["inserted"].(float64) != 2 {
+		t.Fatalf("导入高德行政区 %d: %v", w.Code, body)
+	}
+	// GeoJSON：东城区带一个洞（故宫），adcode为数字，重复导入覆盖
+	dongcheng := `{"type":"FeatureCollection","features":[{"type":"Feature",
+		"properties":{"adcode":110101,"name":"东城区","level":"district","center":[116.418757,39.917544],"parent":{"adcode":110000}},
+		"geometry":{"type":"Polygon","coordinates":[
+			[[116.38,39.86],[116.44,39.86],[116.44,39.97],[116.38,39.97],[116.38,39.86]],
+			[[116.39,39.91],[116.40,39.91],[116.40,39.92],[116.39,39.92],[116.39,39.91]]
+		]}}]}`
+	for i, want := range []string{"inserted", "updated"} {
+		if w, body := e.admin(http.MethodPost, "/api/admin/districts?source=datav", dongcheng); w.Code != http.StatusOK || body[want].(float64) != 1 {
+			t.Fatalf("第%d次导入GeoJSON %d: %v", i+1, w.Code, body)
+		}
+	}
+	if w, _ := e.admin(http.MethodPost, "/api/admin/districts", `{"type":"Feature","properties":{"name":"无编码"},"geometry":null}`); w.Code != http.StatusBadRequest {
+		t.Fatalf("缺少adcode: %d", w.Code)
+	}
+
+	_, body := e.get("/api/districts?q=" + url.QueryEscape("朝阳"))
+	if body["count"].(float64) != 2 {
+		t.Fatalf("按名称查询: %v", body)
+	}
+	if _, body = e.get("/api/districts?parent=110000"); body["count"].(float64) != 2 {
+		t.Fatalf("按上级查询: %v", body)
+	}
+	w, body := e.get("/api/districts/220104")
+	d := body["data"].(map[string]interface{})
+	geom := d["geometry"].(map[string]interface{})
+	if w.Code != http.StatusOK || d["parent_adcode"] != "220000" || d["source"] != "amap" || len(geom["coordinates"].([]interface{})) != 2 {
+		t.Fatalf("行政区详情 %d: %v", w.Code, body)
+	}
+	if w, _ := e.get("/api/districts/999999"); w.Code != http.StatusNotFound {
+		t.Fatalf("不存在的行政区: %d", w.Code)
+	}
+
+	for _, h := range []struct {
+		name     string
+		lng, lat float64
+	}{
+		{"测试朝阳医院", 116.45, 39.93},
+		{"测试望京医院", 116.47, 40.00},
+		{"测试东城医院", 116.39, 39.88},
+		{"测试故宫诊所", 116.395, 39.915}, // 东城区的洞内
+		{"测试长春朝阳医院", 125.30, 43.85},
+	} {
+		insertTestHospital(t, h.name, h.lng, h.lat)
+	}
+	names := func(body map[string]interface{}) []string {
+		var res []string
+		for _, h := range body["data"].([]interface{}) {
+			res = append(res, h.(map[string]interface{})["name"].(string))
+		}
+		return res
+	}
+
+	// 同名行政区需用adcode
+	w, body = e.get("/api/hospitals/search?district=" + url.QueryEscape("朝阳区"))
+	if w.Code != http.StatusBadRequest || len(body["candidates"].([]interface{})) != 2 {
+		t.Fatalf("同名行政区 %d: %v", w.Code, body)
+	}
+	// 距离相对给定的lat/lng排序
+	_, body = e.get("/api/hospitals/search?district=110105&lat=40.001&lng=116.47")
+	if got := fmt.Sprint(names(body)); got != "[测试望京医院 测试朝阳医院]" {
+		t.Fatalf("朝阳区: %s", got)
+	}
+	if d := body["data"].([]interface{})[0].(map[string]interface{})["distance"].(float64); d < 0.1 || d > 0.12 {
+		t.Fatalf("距离应为公里: %v", d)
+	}
+	if _, body = e.get("/api/hospitals/search?district=" + url.QueryEscape("东城区")); fmt.Sprint(names(body)) != "[测试东城医院]" {
+		t.Fatalf("东城区: %v", names(body))
+	}
+	if _, body = e.get("/api/hospitals/search?bbox=116.3,39.8,116.7,40.1&limit=3"); body["count"].(float64) != 3 {
+		t.Fatalf("bbox: %v", body)
+	}
+	if _, body = e.get("/api/hospitals/search?polygon=" + url.QueryEscape("125.2,43.7;125.4,43.7;125.4,43.9;125.2,43.9")); fmt.Sprint(names(body)) != "[测试长春朝阳医院]" {
+		t.Fatalf("多边形: %v", body)
+	}
+	poly := `{"type":"Polygon","coordinates":[[[116.44,39.92],[116.46,39.92],[116.46,39.94],[116.44,39.94],[116.44,39.92]]]}`
+	if _, body = e.get("/api/hospitals/search?polygon=" + url.QueryEscape(poly)); fmt.Sprint(names(body)) != "[测试朝阳医院]" {
+		t.Fatalf("GeoJSON多边形: %v", body)
+	}
+	for _, path := range []string{
+		"/api/hospitals/search?bbox=116.7,39.8,116.3,40.1",
+		"/api/hospitals/search?bbox=116.3,39.8,116.7,40.1&district=110105",
+		"/api/hospitals/search?polygon=116.4,39.9%3B116.5,39.9",
+		"/api/pois/search",
+	} {
+		if w, _ := e.get(path); w.Code != http.StatusBadRequest {
+			t.Fatalf("%s: %d", path, w.Code)
+		}
+	}
+	if w, _ := e.get("/api/hospitals/search?district=" + url.QueryEscape("不存在区")); w.Code != http.StatusNotFound {
+		t.Fatalf("不存在的行政区: %d", w.Code)
+	}
+
+	// 已抓取POI：子POI和不显示的类别不计入
+	for _, p := range []struct {
+		id, name, typecode string
+		lng, lat           float64
+	}{
+		{"B0DIS1", "测试朝阳医院", "090100", 116.45, 39.93},
+		{"B0DIS2", "测试朝阳专科门诊", "090201", 116.46, 39.93},
+		{"B0DIS3", "测试东城医院", "090100", 116.39, 39.88},
+	} {
+		db.Exec(`
+			INSERT INTO pois (id, name, typecode, longitude, latitude, raw, first_seen, last_seen)
+			VALUES (?, ?, ?, ?, ?, ?, '2025-07-01T00:00:00Z', '2025-07-01T00:00:00Z')
+		`, p.id, p.name, p.typecode, p.lng, p.lat,
+			fmt.Sprintf(`{"id":%q,"name":%q,"typecode":%q,"location":"%f,%f"}`, p.id, p.name, p.typecode, p.lng, p.lat))
+	}
+	db.Exec(`INSERT INTO pois (id, name, typecode, longitude, latitude, raw, first_seen, last_seen, parent_id, childtype)
+		VALUES ('B0DIS1C', '测试朝阳医院-急诊', '090100', 116.451, 39.931, '{}', '2025-07-01T00:00:00Z', '2025-07-01T00:00:00Z', 'B0DIS1', 'entrance')`)
+	w, body = e.get("/api/pois/search?district=110105")
+	if w.Code != http.StatusOK || body["count"].(float64) != 1 {
+		t.Fatalf("POI范围搜索 %d: %v", w.Code, body)
+	}
+	if poi := body["pois"].([]interface{})[0].(map[string]interface{}); poi["id"] != "B0DIS1" || poi["algo_hospital_category"] == nil {
+		t.Fatalf("POI: %v", poi)
+	}
+	w = doRequest(e.router, http.MethodGet, "/api/pois/search?bbox=116.3,39.8,116.7,40.1&format=geojson", nil, nil)
+	if w.Code != http.StatusOK || w.Header().Get("Content-Type") != exportContentTypes[ExportFormatGeoJSON] {
+		t.Fatalf("GeoJSON导出 %d: %s", w.Code, w.Header().Get("Content-Type"))
+	}
+}
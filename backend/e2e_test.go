@@ -0,0 +1,363 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"log"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"sort"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+const (
+	e2eAmapKey  = "test-amap-key"
+	e2eLocation = "116.446695,39.958106" // 录制数据所在的左家庄附近
+)
+
+type e2eEnv struct {
+	router   *gin.Engine
+	fake     *FakeAmap
+	adminKey string
+}
+
+// 完整路由 + 本地fake高德服务 + 内存数据库；env在全局初始化前设置
+func setupE2E(t *testing.T, env map[string]string) *e2eEnv {
+	t.Helper()
+	log.SetOutput(ioutil.Discard)
+	t.Cleanup(func() { log.SetOutput(os.Stderr) })
+	gin.SetMode(gin.TestMode)
+	setupTestDB(t)
+
+	fake := NewFakeAmap()
+	fake.Key = e2eAmapKey
+	if err := fake.LoadFixtures("testdata/merge/ledger_beijing.json", "testdata/fakeamap/geocode.json"); err != nil {
+		t.Fatal(err)
+	}
+	srv := httptest.NewServer(fake)
+	t.Cleanup(srv.Close)
+
+	defaults := map[string]string{
+		"AMAP_KEY":                  e2eAmapKey,
+		"AMAP_BASE_URL":             srv.URL,
+		"AMAP_TIMEOUT_MS":           "200",
+		"AMAP_QPS":                  "0",
+		"UPSTREAM_MAX_RETRIES":      "1",
+		"UPSTREAM_BACKOFF_MS":       "1",
+		"BREAKER_FAILURE_THRESHOLD": "3",
+		"OFFLINE_MODE":              "false",
+		"OFFLINE_SOURCES":           "testdata/none.json",
+	}
+	for k, v := range env {
+		defaults[k] = v
+	}
+	for k, v := range defaults {
+		t.Setenv(k, v)
+	}
+
+	prevCache, prevQuota, prevLimiter, prevStore := responseCache, upstreamQuota, clientLimiter, offlineStore
+	t.Cleanup(func() {
+		responseCache, upstreamQuota, clientLimiter, offlineStore = prevCache, prevQuota, prevLimiter, prevStore
+		offlineMode = false
+		upstreamClients = map[string]*UpstreamClient{}
+	})
+	initUpstreamClients()
+	initFetchLimits()
+	initGoogleCRS()
+	responseCache = NewResponseCache(time.Hour, 1000, "", 0)
+	upstreamQuota = NewUpstreamQuota(map[string]int{ProviderAmap: envInt("AMAP_DAILY_QUOTA", 0)})
+	clientLimiter = NewRateLimiter(1000, 1000)
+	offlineStore = &offlinePOIStore{}
+	offlineMode = os.Getenv("OFFLINE_MODE") == "true"
+
+	_, adminKey := createTestUser(t, "admin", RoleAdmin)
+	return &e2eEnv{router: setupRouter(), fake: fake, adminKey: adminKey}
+}
+
+// 插入一家数据库医院并返回id；cols为需要覆盖的列和取值，如 "address", "帅府园1号", "qualifications", "三级甲等"
+func insertTestHospital(t *testing.T, name string, lng, lat float64, cols ...string) int64 {
+	t.Helper()
+	values := map[string]interface{}{"name": name, "longitude": lng, "latitude": lat}
+	for _, col := range []string{"address", "phone", "hospital_type", "main_departments", "business_hours", "qualifications"} {
+		values[col] = ""
+	}
+	if len(cols)%2 != 0 {
+		t.Fatalf("insertTestHospital: 列和取值须成对给出: %v", cols)
+	}
+	for i := 0; i < len(cols); i += 2 {
+		values[cols[i]] = cols[i+1]
+	}
+	names := make([]string, 0, len(values))
+	for col := range values {
+		names = append(names, col)
+	}
+	sort.Strings(names)
+	args := make([]interface{}, len(names))
+	for i, col := range names {
+		args[i] = values[col]
+	}
+	res, err := db.Exec(fmt.Sprintf(`INSERT INTO hospitals (%s) VALUES (?%s)`,
+		strings.Join(names, ", "), strings.Repeat(", ?", len(names)-1)), args...)
+	if err != nil {
+		t.Fatalf("插入医院 %s 失败: %v", name, err)
+	}
+	id, _ := res.LastInsertId()
+	return id
+}
+
+func (e *e2eEnv) get(path string) (*httptest.ResponseRecorder, map[string]interface{}) {
+	w := doRequest(e.router, http.MethodGet, path, nil, nil)
+	var body map[string]interface{}
+	json.Unmarshal(w.Body.Bytes(), &body)
+	return w, body
+}
+
+func TestE2EAroundMergesAndCaches(t *testing.T) {
+	e := setupE2E(t, nil)
+
+	w, body := e.get("/api/amap/around?location=" + e2eLocation + "&radius=5000")
+	if w.Code != http.StatusOK {
+		t.Fatalf("状态码 %d: %s", w.Code, w.Body.String())
+	}
+	count, _ := body["count"].(float64)
+	if count == 0 {
+		t.Fatal("合并结果为空")
+	}
+	if body["offline"] != nil {
+		t.Fatal("在线查询不应标记为离线")
+	}
+	pois := body["pois"].([]interface{})
+	if _, ok := pois[0].(map[string]interface{})["algo_hospital_category"]; !ok {
+		t.Fatal("缺少分类字段")
+	}
+	if w.Header().Get("X-Cache") != "MISS" {
+		t.Fatalf("X-Cache = %s", w.Header().Get("X-Cache"))
+	}
+	requests := e.fake.Requests("/v3/place/around")
+	if requests != len(aroundMergeProfile.Typecodes) {
+		t.Fatalf("请求高德 %d 次，期望每个typecode一次", requests)
+	}
+
+	// 每次上游调用都记入台账
+	requestID := w.Header().Get("X-Ledger-Request-ID")
+	entries, total, err := queryLedger(ledgerFilter{RequestID: requestID}, false)
+	if err != nil || total != requests {
+		t.Fatalf("台账记录 %d 条，期望 %d: %v", total, requests, err)
+	}
+	if entries[0].Params["key"] != "***" {
+		t.Fatal("台账未隐藏key")
+	}
+
+	// 相同位置再次查询命中缓存，不再请求高德
+	w2, body2 := e.get("/api/amap/around?location=" + e2eLocation + "&radius=5000")
+	if w2.Header().Get("X-Cache") != "HIT" {
+		t.Fatalf("第二次 X-Cache = %s", w2.Header().Get("X-Cache"))
+	}
+	if e.fake.Requests("/v3/place/around") != requests {
+		t.Fatal("缓存命中仍请求了高德")
+	}
+	if body2["count"] != body["count"] {
+		t.Fatalf("缓存结果不一致: %v vs %v", body2["count"], body["count"])
+	}
+}
+
+func TestE2EPaginationMatchesSinglePage(t *testing.T) {
+	single := setupE2E(t, nil)
+	_, want := single.get("/api/merged-pois?location=" + e2eLocation)
+
+	paged := setupE2E(t, map[string]string{"AMAP_PAGE_SIZE": "5"})
+	w, got := paged.get("/api/merged-pois?location=" + e2eLocation)
+	if w.Code != http.StatusOK {
+		t.Fatalf("状态码 %d: %s", w.Code, w.Body.String())
+	}
+	if paged.fake.Requests("/v3/place/around") <= len(mergedPoisMergeProfile.Typecodes) {
+		t.Fatal("未发生分页请求")
+	}
+	if got["count"] != want["count"] {
+		t.Fatalf("分页结果 %v 条，单页 %v 条", got["count"], want["count"])
+	}
+}
+
+func TestE2EUpstreamFaults(t *testing.T) {
+	cases := []struct {
+		name   string
+		mode   string
+		delay  time.Duration
+		status int
+	}{
+		{"配额用尽", FakeFaultQuota, 0, http.StatusTooManyRequests},
+		{"QPS超限", FakeFaultQPS, 0, http.StatusTooManyRequests},
+		{"非法JSON", FakeFaultMalformed, 0, http.StatusBadGateway},
+		{"服务端错误", FakeFaultServerDown, 0, http.StatusBadGateway},
+		{"响应超时", FakeFaultSlow, time.Second, http.StatusBadGateway},
+	}
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			e := setupE2E(t, nil)
+			e.fake.InjectFault(tc.mode, 0, tc.delay)
+			w, _ := e.get("/api/amap/around?location=" + e2eLocation)
+			if w.Code != tc.status {
+				t.Fatalf("状态码 %d，期望 %d: %s", w.Code, tc.status, w.Body.String())
+			}
+			entries, _, _ := queryLedger(ledgerFilter{RequestID: w.Header().Get("X-Ledger-Request-ID")}, false)
+			if len(entries) == 0 || entries[0].Status == LedgerStatusOK {
+				t.Fatalf("台账未记录失败调用: %+v", entries)
+			}
+		})
+	}
+}
+
+func TestE2ETransientFaultRetried(t *testing.T) {
+	e := setupE2E(t, map[string]string{"AMAP_FETCH_WORKERS": "1"})
+	e.fake.InjectFault(FakeFaultServerDown, 1, 0)
+	w, _ := e.get("/api/amap/around?location=" + e2eLocation)
+	if w.Code != http.StatusOK {
+		t.Fatalf("单次故障应被重试: %d %s", w.Code, w.Body.String())
+	}
+}
+
+func TestE2ECircuitBreakerOpens(t *testing.T) {
+	e := setupE2E(t, nil)
+	e.fake.InjectFault(FakeFaultServerDown, 0, 0)
+	var w *httptest.ResponseRecorder
+	for i := 0; i < 6; i++ {
+		w, _ = e.get("/api/amap/around?location=" + e2eLocation)
+		if w.Code == http.StatusServiceUnavailable {
+			break
+		}
+	}
+	if w.Code != http.StatusServiceUnavailable {
+		t.Fatalf("熔断未打开，最后状态码 %d", w.Code)
+	}
+	before := e.fake.Requests("/v3/place/around")
+	e.get("/api/amap/around?location=" + e2eLocation)
+	if e.fake.Requests("/v3/place/around") != before {
+		t.Fatal("熔断打开后仍请求了高德")
+	}
+	w, body := e.get("/api/health")
+	if body["status"] != "degraded" {
+		t.Fatalf("健康检查: %s", w.Body.String())
+	}
+}
+
+func TestE2EInvalidKey(t *testing.T) {
+	e := setupE2E(t, map[string]string{"AMAP_KEY": "wrong-key"})
+	w, _ := e.get("/api/amap/around?location=" + e2eLocation)
+	if w.Code != http.StatusBadGateway {
+		t.Fatalf("状态码 %d: %s", w.Code, w.Body.String())
+	}
+}
+
+func TestE2EOfflineFallback(t *testing.T) {
+	e := setupE2E(t, map[string]string{"OFFLINE_SOURCES": "testdata/merge/ledger_beijing.json"})
+	e.fake.InjectFault(FakeFaultQuota, 0, 0)
+	w, body := e.get("/api/amap/around?location=" + e2eLocation)
+	if w.Code != http.StatusOK || body["offline"] != true {
+		t.Fatalf("上游失败时应回退本地数据: %d %s", w.Code, w.Body.String())
+	}
+	if w.Header().Get("X-Data-Source") != "offline" {
+		t.Fatal("缺少 X-Data-Source: offline")
+	}
+}
+
+func TestE2EOfflineMode(t *testing.T) {
+	e := setupE2E(t, map[string]string{"OFFLINE_MODE": "true", "OFFLINE_SOURCES": "testdata/merge/ledger_beijing.json"})
+	w, body := e.get("/api/merged-pois?location=" + e2eLocation)
+	if w.Code != http.StatusOK || body["offline"] != true || body["stale"] != true {
+		t.Fatalf("离线模式: %d %s", w.Code, w.Body.String())
+	}
+	if e.fake.Requests("/v3/place/around") != 0 {
+		t.Fatal("离线模式请求了高德")
+	}
+}
+
+func TestE2EGeocode(t *testing.T) {
+	e := setupE2E(t, nil)
+	address := "北京市东城区东单北大街53号"
+	w, body := e.get("/api/amap/geo?address=" + address)
+	if w.Code != http.StatusOK {
+		t.Fatalf("状态码 %d: %s", w.Code, w.Body.String())
+	}
+	geocodes, _ := body["geocodes"].([]interface{})
+	if len(geocodes) != 1 || geocodes[0].(map[string]interface{})["location"] != "116.417671,39.920235" {
+		t.Fatalf("地理编码结果: %s", w.Body.String())
+	}
+	w, _ = e.get("/api/amap/geo?address=" + address)
+	if w.Header().Get("X-Cache") != "HIT" || e.fake.Requests("/v3/geocode/geo") != 1 {
+		t.Fatal("地理编码未命中缓存")
+	}
+	// 缓存中为GCJ-02原始响应，按crs转换后输出
+	w, body = e.get("/api/amap/geo?crs=wgs84&address=" + address)
+	geocodes, _ = body["geocodes"].([]interface{})
+	if want := convertLocation("116.417671,39.920235", CRSGCJ02, CRSWGS84); len(geocodes) != 1 || body["crs"] != CRSWGS84 ||
+		geocodes[0].(map[string]interface{})["location"] != want {
+		t.Fatalf("WGS-84地理编码: %s", w.Body.String())
+	}
+	if w, _ = e.get("/api/amap/geo?crs=utm&address=" + address); w.Code != http.StatusBadRequest {
+		t.Fatalf("未知坐标系 %d", w.Code)
+	}
+}
+
+func TestE2EAdminLedgerReplay(t *testing.T) {
+	e := setupE2E(t, nil)
+	w, body := e.get("/api/amap/around?location=" + e2eLocation)
+	if w.Code != http.StatusOK {
+		t.Fatalf("状态码 %d", w.Code)
+	}
+	requestID := w.Header().Get("X-Ledger-Request-ID")
+
+	replayBody := []byte(`{"request_id":"` + requestID + `","profile":"around"}`)
+	if w := doRequest(e.router, http.MethodPost, "/api/admin/ledger/replay", replayBody, nil); w.Code != http.StatusUnauthorized {
+		t.Fatalf("未鉴权重放应返回401，实际 %d", w.Code)
+	}
+	w = doRequest(e.router, http.MethodPost, "/api/admin/ledger/replay", replayBody, map[string]string{"X-API-Key": e.adminKey})
+	if w.Code != http.StatusOK {
+		t.Fatalf("重放失败 %d: %s", w.Code, w.Body.String())
+	}
+	var replayed map[string]interface{}
+	json.Unmarshal(w.Body.Bytes(), &replayed)
+	if replayed["count"] != body["count"] {
+		t.Fatalf("重放结果 %v 条，原请求 %v 条", replayed["count"], body["count"])
+	}
+}
+
+func TestOfflineReloadLatestLedgerOnly(t *testing.T) {
+	setupTestDB(t)
+	t.Setenv("OFFLINE_SOURCES", "testdata/none.json")
+	record := func(location, page, name string) {
+		url := "http://amap.test/v3/place/around?key=k&location=" + location + "&radius=3000&types=090100&page=" + page
+		body := fmt.Sprintf(`{"status":"1","count":"1","pois":[{"id":"B0%s","name":%q,"location":%q}]}`, page, name, location)
+		recordUpstreamCall(context.Background(), ProviderAmap, url, []byte(body), nil, 1, 0)
+	}
+	// 同一区块反复抓取，邻近位置落在同一geohash格
+	for i := 0; i < 5; i++ {
+		record("116.446695,39.958106", "1", fmt.Sprintf("旧名称%d", i))
+	}
+	record("116.446700,39.958110", "1", "最新名称")
+	record("116.446695,39.958106", "2", "第二页")
+	recordUpstreamCall(context.Background(), ProviderAmap, "http://amap.test/v3/place/around?location=116.5,39.9&types=090100&page=1",
+		[]byte(`{"status":"0","infocode":"10016"}`), &AmapError{Infocode: "10016", Kind: AmapErrServiceBusy}, 1, 0)
+
+	if ids := latestAroundLedgerIDs(); fmt.Sprint(ids) != "[6 7]" {
+		t.Fatalf("最新记录: %v", ids)
+	}
+	store := &offlinePOIStore{}
+	if n := store.Reload(); n != 2 {
+		t.Fatalf("加载 %d 条", n)
+	}
+	ledger, _ := store.Around(e2eLocation, 1000, []string{"090100"})
+	var names []string
+	for _, poi := range ledger[0].POIs {
+		names = append(names, poi.(map[string]interface{})["name"].(string))
+	}
+	if fmt.Sprint(names) != "[最新名称 第二页]" {
+		t.Fatalf("离线POI: %v", names)
+	}
+}
@@ -0,0 +1,772 @@
+package main
+
+import (
+	"bytes"
+	"database/sql"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"log"
+	"net/http"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+// Excel导入导出：医院、评分、POI导出为XLSX（其他格式见geoexport.go）；医院从XLSX/CSV导入，
+// 表头按同义词映射到医院字段，支持dry_run预览。HTTP接口和 export/import 子命令共用同一套逻辑
+
+// 导出过滤条件
+type exportFilter struct {
+	// 城市名，如"北京"或"北京市"；POI按省/市/区名，医院按地址匹配
+	City string
+}
+
+func (f exportFilter) matchCity(fields ...string) bool {
+	city := strings.TrimSuffix(strings.TrimSpace(f.City), "市")
+	if city == "" {
+		return true
+	}
+	for _, s := range fields {
+		if strings.Contains(s, city) {
+			return true
+		}
+	}
+	return false
+}
+
+// 可导出的数据集
+var exportDatasets = map[string]func(exportFilter) (XLSXSheet, error){
+	"hospitals":   exportHospitalsSheet,
+	"ratings":     exportRatingsSheet,
+	"merged-pois": exportMergedPOIsSheet,
+	"pois":        exportCrawledPOIsSheet,
+}
+
+func exportDatasetNames() []string {
+	names := make([]string, 0, len(exportDatasets))
+	for name := range exportDatasets {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+func formatExportFloat(v float64) string {
+	return strconv.FormatFloat(v, 'f', -1, 64)
+}
+
+var hospitalExportColumns = []string{
+	"id", "name", "address", "latitude", "longitude", "phone", "hospital_type", "main_departments",
+	"business_hours", "qualifications", "grade", "grade_source", "rating", "confidence", "created_at", "updated_at",
+	"category", "icon",
+}
+
+func exportHospitalsSheet(f exportFilter) (XLSXSheet, error) {
+	rows, err := db.Query(`
+		SELECT id, name, address, latitude, longitude, phone, hospital_type, main_departments, business_hours, qualifications, created_at, updated_at
+		FROM hospitals ORDER BY id
+	`)
+	if err != nil {
+		return XLSXSheet{}, err
+	}
+	var hospitals []Hospital
+	for rows.Next() {
+		h, err := scanStoredHospital(rows)
+		if err != nil {
+			rows.Close()
+			return XLSXSheet{}, err
+		}
+		// hospitals表没有城市列，按地址过滤
+		if f.matchCity(h.Address) {
+			hospitals = append(hospitals, h)
+		}
+	}
+	rows.Close()
+	for i := range hospitals {
+		hospitals[i].Rating, hospitals[i].Confidence = getHospitalRating(hospitals[i].ID)
+		hospitals[i].applyGrade()
+	}
+	return hospitalsSheet(hospitals, false), nil
+}
+
+// 医院列表转为表格，category/icon与合并POI的algo_hospital_category/algo_icon_type一致；
+// 搜索结果另带distance列（公里）
+func hospitalsSheet(hospitals []Hospital, withDistance bool) XLSXSheet {
+	sheet := XLSXSheet{Name: "hospitals", Rows: [][]string{append([]string{}, hospitalExportColumns...)},
+		Numeric: []bool{true, false, false, true, true, false, false, false, false, false, false, false, true, true, false, false, false, false}}
+	if withDistance {
+		sheet.Rows[0] = append(sheet.Rows[0], "distance")
+		sheet.Numeric = append(sheet.Numeric, true)
+	}
+	for _, h := range hospitals {
+		grade, source := "", ""
+		if h.Grade != nil {
+			grade, source = h.Grade.Grade, h.Grade.Source
+		}
+		// 与离线数据相同：三级甲等按090101分类，其余按090100
+		tc := "090100"
+		if grade == "三级甲等" {
+			tc = "090101"
+		}
+		category, icon := "", ""
+		if cat := taxonomy.Classify(tc, true); cat != nil {
+			category, icon = cat.Labels["zh"], cat.Icon
+		}
+		row := []string{
+			strconv.Itoa(h.ID), h.Name, h.Address, formatExportFloat(h.Latitude), formatExportFloat(h.Longitude), h.Phone,
+			h.HospitalType, h.MainDepartments, h.BusinessHours, h.Qualifications, grade, source,
+			formatExportFloat(h.Rating), formatExportFloat(h.Confidence), h.CreatedAt, h.UpdatedAt, category, icon,
+		}
+		if withDistance {
+			row = append(row, formatExportFloat(h.Distance))
+		}
+		sheet.Rows = append(sheet.Rows, row)
+	}
+	return sheet
+}
+
+// hospitals表的可空文本列
+func scanStoredHospital(row interface{ Scan(...interface{}) error }) (Hospital, error) {
+	var h Hospital
+	var phone, hospitalType, departments, hours, qualifications, createdAt, updatedAt sql.NullString
+	err := row.Scan(&h.ID, &h.Name, &h.Address, &h.Latitude, &h.Longitude, &phone, &hospitalType, &departments,
+		&hours, &qualifications, &createdAt, &updatedAt)
+	h.Phone, h.HospitalType, h.MainDepartments, h.BusinessHours = phone.String, hospitalType.String, departments.String, hours.String
+	h.Qualifications, h.CreatedAt, h.UpdatedAt = qualifications.String, createdAt.String, updatedAt.String
+	return h, err
+}
+
+func exportRatingsSheet(f exportFilter) (XLSXSheet, error) {
+	rows, err := db.Query(`
+		SELECT r.id, r.hospital_id, COALESCE(h.name, ''), COALESCE(h.address, ''), COALESCE(r.source, ''), r.rating_value,
+			COALESCE(r.confidence, 0), COALESCE(r.rating_date, ''), COALESCE(r.created_at, '')
+		FROM ratings r LEFT JOIN hospitals h ON h.id = r.hospital_id
+		ORDER BY r.hospital_id, r.id
+	`)
+	if err != nil {
+		return XLSXSheet{}, err
+	}
+	defer rows.Close()
+	sheet := XLSXSheet{Name: "ratings",
+		Rows:    [][]string{{"id", "hospital_id", "hospital_name", "source", "rating_value", "confidence", "rating_date", "created_at"}},
+		Numeric: []bool{true, true, false, false, true, true}}
+	for rows.Next() {
+		var r Rating
+		var hospitalName, address string
+		if err := rows.Scan(&r.ID, &r.HospitalID, &hospitalName, &address, &r.Source, &r.RatingValue, &r.Confidence, &r.RatingDate, &r.CreatedAt); err != nil {
+			return XLSXSheet{}, err
+		}
+		if !f.matchCity(address) {
+			continue
+		}
+		sheet.Rows = append(sheet.Rows, []string{
+			strconv.Itoa(r.ID), strconv.Itoa(r.HospitalID), hospitalName, r.Source,
+			formatExportFloat(r.RatingValue), formatExportFloat(r.Confidence), r.RatingDate, r.CreatedAt,
+		})
+	}
+	return sheet, rows.Err()
+}
+
+// 最近一次合并结果的快照，由writeMergedResult写入
+var mergedResultSnapshotPath = "backend/cache/merged_poi_result.json"
+
+// 合并POI优先排在前面的列，其余字段按名称排序
+var mergedPOIExportColumns = []string{
+	"id", "name", "typecode", "childtype", "parent", "hospital_category", "algo_hospital_category", "algo_icon_type",
+	"algo_display_order", "address", "pname", "cityname", "adname", "location", "tel", "type", "distance", "entrance_distance",
+}
+
+func exportMergedPOIsSheet(f exportFilter) (XLSXSheet, error) {
+	data, err := ioutil.ReadFile(mergedResultSnapshotPath)
+	if err != nil {
+		return XLSXSheet{}, fmt.Errorf("读取合并结果快照失败: %v", err)
+	}
+	var result struct {
+		POIs []map[string]interface{} `json:"pois"`
+	}
+	if err := json.Unmarshal(data, &result); err != nil {
+		return XLSXSheet{}, fmt.Errorf("解析合并结果快照失败: %v", err)
+	}
+	return poisSheet("merged_pois", filterPOIsByCity(result.POIs, f)), nil
+}
+
+// 后台抓取的全部POI（pois表，不含已消失的），按分类体系补充类别和图标
+func exportCrawledPOIsSheet(f exportFilter) (XLSXSheet, error) {
+	var pois []map[string]interface{}
+	for _, p := range loadCrawledPOIs() {
+		poi := p.POI
+		cat, icon, order := classifyHospital(poi)
+		poi["algo_hospital_category"] = cat
+		poi["algo_icon_type"] = icon
+		poi["algo_display_order"] = order
+		pois = append(pois, poi)
+	}
+	sort.Slice(pois, func(i, j int) bool { return exportCellValue(pois[i]["id"]) < exportCellValue(pois[j]["id"]) })
+	return poisSheet("pois", filterPOIsByCity(pois, f)), nil
+}
+
+func filterPOIsByCity(pois []map[string]interface{}, f exportFilter) []map[string]interface{} {
+	var res []map[string]interface{}
+	for _, poi := range pois {
+		if f.matchCity(exportCellValue(poi["pname"]), exportCellValue(poi["cityname"]), exportCellValue(poi["adname"])) {
+			res = append(res, poi)
+		}
+	}
+	return res
+}
+
+// 高德POI列表转为表格
+func poisSheet(name string, pois []map[string]interface{}) XLSXSheet {
+	columns := append([]string{}, mergedPOIExportColumns...)
+	known := map[string]bool{}
+	for _, col := range columns {
+		known[col] = true
+	}
+	var extra []string
+	for _, poi := range pois {
+		for k := range poi {
+			if !known[k] {
+				known[k] = true
+				extra = append(extra, k)
+			}
+		}
+	}
+	sort.Strings(extra)
+	columns = append(columns, extra...)
+
+	sheet := XLSXSheet{Name: name, Rows: [][]string{columns}, Numeric: make([]bool, len(columns))}
+	for i, col := range columns {
+		sheet.Numeric[i] = col == "distance" || col == "entrance_distance" || col == "algo_display_order"
+	}
+	for _, poi := range pois {
+		row := make([]string, len(columns))
+		for i, col := range columns {
+			row[i] = exportCellValue(poi[col])
+		}
+		sheet.Rows = append(sheet.Rows, row)
+	}
+	return sheet
+}
+
+// POI字段转为单元格文本：高德的空数组为空串，嵌套结构为JSON
+func exportCellValue(v interface{}) string {
+	switch val := v.(type) {
+	case nil:
+		return ""
+	case string:
+		return val
+	case float64:
+		return formatExportFloat(val)
+	case bool:
+		return strconv.FormatBool(val)
+	case []interface{}:
+		if len(val) == 0 {
+			return ""
+		}
+	}
+	b, _ := json.Marshal(v)
+	return string(b)
+}
+
+// 导出数据集：GET /api/export/:dataset?format=xlsx|csv|geojson|kml&city=，默认XLSX附件
+func exportDataset(c *gin.Context) {
+	dataset := c.Param("dataset")
+	build, ok := exportDatasets[dataset]
+	if !ok {
+		c.JSON(http.StatusNotFound, gin.H{"error": fmt.Sprintf("未知数据集，可选: %s", strings.Join(exportDatasetNames(), ", "))})
+		return
+	}
+	format, ok := exportFormatParam(c, ExportFormatXLSX)
+	if !ok {
+		return
+	}
+	crs, ok := crsParam(c)
+	if !ok {
+		return
+	}
+	sheet, err := build(exportFilter{City: c.Query("city")})
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+	writeExport(c, dataset, format, sheetToCRS(sheet, exportCRS(c, format, crs)))
+}
+
+// 医院导入字段及表头同义词（忽略大小写和"Column1."之类的前缀）
+var hospitalImportFields = map[string][]string{
+	"id":               {"id", "编号", "医院id"},
+	"name":             {"name", "医院名称", "名称", "医院"},
+	"address":          {"address", "地址"},
+	"latitude":         {"latitude", "lat", "纬度"},
+	"longitude":        {"longitude", "lng", "lon", "经度"},
+	"location":         {"location", "坐标", "经纬度"},
+	"phone":            {"phone", "tel", "电话", "联系电话"},
+	"hospital_type":    {"hospital_type", "hospital_category", "医院类型", "类型"},
+	"main_departments": {"main_departments", "主要科室", "科室"},
+	"business_hours":   {"business_hours", "营业时间", "门诊时间"},
+	"qualifications":   {"qualifications", "资质", "等级"},
+	"crs":              {"crs", "坐标系"},
+}
+
+func normalizeImportHeader(h string) string {
+	h = strings.ToLower(strings.TrimSpace(h))
+	if i := strings.LastIndex(h, "."); i >= 0 {
+		h = h[i+1:]
+	}
+	return h
+}
+
+// 表头到字段的映射：overrides为"表头:字段"，字段为"-"时忽略该列
+func hospitalImportMapping(header []string, overrides string) (map[int]string, []string, error) {
+	explicit := map[string]string{}
+	for _, pair := range strings.Split(overrides, ",") {
+		if pair = strings.TrimSpace(pair); pair == "" {
+			continue
+		}
+		i := strings.LastIndex(pair, ":")
+		if i <= 0 {
+			return nil, nil, fmt.Errorf("映射格式应为 表头:字段: %s", pair)
+		}
+		field := strings.TrimSpace(pair[i+1:])
+		if _, ok := hospitalImportFields[field]; !ok && field != "-" {
+			return nil, nil, fmt.Errorf("未知字段: %s", field)
+		}
+		explicit[strings.TrimSpace(pair[:i])] = field
+	}
+	synonyms := map[string]string{}
+	for field, names := range hospitalImportFields {
+		for _, n := range names {
+			synonyms[n] = field
+		}
+	}
+	mapping := map[int]string{}
+	used := map[string]bool{}
+	var unmapped []string
+	for i, h := range header {
+		field, ok := explicit[strings.TrimSpace(h)]
+		if !ok {
+			field, ok = synonyms[normalizeImportHeader(h)]
+		}
+		if !ok || field == "-" || used[field] {
+			if strings.TrimSpace(h) != "" {
+				unmapped = append(unmapped, h)
+			}
+			continue
+		}
+		mapping[i] = field
+		used[field] = true
+	}
+	if !used["name"] {
+		return nil, nil, fmt.Errorf("没有映射到name的列")
+	}
+	return mapping, unmapped, nil
+}
+
+// 导入计划中的一行
+type hospitalImportRow struct {
+	Row     int      `json:"row"`
+	Action  string   `json:"action"` // insert / update / unchanged / error
+	ID      int      `json:"id,omitempty"`
+	Name    string   `json:"name"`
+	Changes []string `json:"changes,omitempty"`
+	Error   string   `json:"error,omitempty"`
+	data    Hospital
+}
+
+// 导入计划与结果
+type hospitalImportPlan struct {
+	Sheet     string              `json:"sheet,omitempty"`
+	Mapping   map[string]string   `json:"mapping"`
+	Unmapped  []string            `json:"unmapped"`
+	Inserted  int                 `json:"inserted"`
+	Updated   int                 `json:"updated"`
+	Unchanged int                 `json:"unchanged"`
+	Errors    int                 `json:"errors"`
+	Rows      []hospitalImportRow `json:"rows"`
+}
+
+// 读取导入文件：XLSX取指定或第一个可见工作表，否则按CSV解析
+func importFileRows(data []byte, sheetName string) (string, [][]string, error) {
+	if !bytes.HasPrefix(data, []byte("PK")) {
+		rows, err := providerRowsFromFile(data, "")
+		return "", rows, err
+	}
+	sheets, err := readXLSX(data)
+	if err != nil {
+		return "", nil, err
+	}
+	for _, s := range sheets {
+		if (sheetName == "" && !s.Hidden) || s.Name == sheetName {
+			return s.Name, s.Rows, nil
+		}
+	}
+	return "", nil, fmt.Errorf("没有名为 %s 的工作表", sheetName)
+}
+
+// 与数据库比对，确定每行是新增、更新还是无变化；已有医院按id，其次按名称（和地址）查找
+// crs为文件坐标系，行中有crs列时以该列为准
+func planHospitalImport(rows [][]string, overrides, crs string) (*hospitalImportPlan, error) {
+	if len(rows) == 0 {
+		return nil, fmt.Errorf("文件为空")
+	}
+	mapping, unmapped, err := hospitalImportMapping(rows[0], overrides)
+	if err != nil {
+		return nil, err
+	}
+	plan := &hospitalImportPlan{Mapping: map[string]string{}, Unmapped: unmapped, Rows: []hospitalImportRow{}}
+	if plan.Unmapped == nil {
+		plan.Unmapped = []string{}
+	}
+	for i, field := range mapping {
+		plan.Mapping[rows[0][i]] = field
+	}
+
+	plannedNames := map[string]int{}
+	for r, row := range rows[1:] {
+		values := map[string]string{}
+		for i, field := range mapping {
+			if i < len(row) {
+				if v := strings.TrimSpace(row[i]); v != "" {
+					values[field] = v
+				}
+			}
+		}
+		if len(values) == 0 {
+			continue
+		}
+		res := planHospitalRow(values, crs)
+		res.Row = r + 2
+		if res.Action == "insert" {
+			if first, dup := plannedNames[res.Name]; dup {
+				res.Action, res.Error = "error", fmt.Sprintf("与第%d行重复", first)
+			}
+			plannedNames[res.Name] = res.Row
+		}
+		switch res.Action {
+		case "insert":
+			plan.Inserted++
+		case "update":
+			plan.Updated++
+		case "unchanged":
+			plan.Unchanged++
+		default:
+			plan.Errors++
+		}
+		plan.Rows = append(plan.Rows, res)
+	}
+	return plan, nil
+}
+
+func planHospitalRow(values map[string]string, crs string) hospitalImportRow {
+	res := hospitalImportRow{Name: values["name"]}
+	fail := func(format string, args ...interface{}) hospitalImportRow {
+		res.Action, res.Error = "error", fmt.Sprintf(format, args...)
+		return res
+	}
+	if res.Name == "" {
+		return fail("缺少医院名称")
+	}
+	if loc, ok := values["location"]; ok && (values["latitude"] == "" || values["longitude"] == "") {
+		lng, lat, ok := parseLngLat(loc)
+		if !ok {
+			return fail("坐标格式错误: %s", loc)
+		}
+		values["longitude"], values["latitude"] = formatExportFloat(lng), formatExportFloat(lat)
+	}
+	for _, field := range []string{"latitude", "longitude"} {
+		if v, ok := values[field]; ok {
+			if _, err := strconv.ParseFloat(v, 64); err != nil {
+				return fail("%s不是数字: %s", field, v)
+			}
+		}
+	}
+	// 坐标转为存储坐标系
+	if v, ok := values["crs"]; ok {
+		rowCRS, err := parseCRS(v)
+		if err != nil {
+			return fail("%v", err)
+		}
+		crs = rowCRS
+	}
+	if values["latitude"] != "" && values["longitude"] != "" && crs != storageCRS {
+		lng, _ := strconv.ParseFloat(values["longitude"], 64)
+		lat, _ := strconv.ParseFloat(values["latitude"], 64)
+		lng, lat = convertCoord(lng, lat, crs, storageCRS)
+		values["longitude"], values["latitude"] = formatExportFloat(lng), formatExportFloat(lat)
+	}
+
+	// 查找已有医院；id列非整数（如高德POI id）时忽略
+	var existing Hospital
+	err := sql.ErrNoRows
+	const sel = `SELECT id, name, address, latitude, longitude, phone, hospital_type, main_departments, business_hours, qualifications, created_at, updated_at FROM hospitals`
+	if id, convErr := strconv.Atoi(values["id"]); convErr == nil {
+		existing, err = scanStoredHospital(db.QueryRow(sel+` WHERE id = ?`, id))
+	}
+	if err == sql.ErrNoRows {
+		if addr, ok := values["address"]; ok {
+			existing, err = scanStoredHospital(db.QueryRow(sel+` WHERE name = ? AND address = ? ORDER BY id LIMIT 1`, res.Name, addr))
+		} else {
+			existing, err = scanStoredHospital(db.QueryRow(sel+` WHERE name = ? ORDER BY id LIMIT 1`, res.Name))
+		}
+	}
+	if err != nil && err != sql.ErrNoRows {
+		return fail("%v", err)
+	}
+
+	if err == sql.ErrNoRows {
+		if values["latitude"] == "" || values["longitude"] == "" {
+			return fail("新增医院需要经纬度")
+		}
+		res.Action = "insert"
+		res.data = hospitalFromImport(Hospital{}, values)
+		return res
+	}
+	res.ID = existing.ID
+	res.data = hospitalFromImport(existing, values)
+	for _, field := range hospitalVersionFields {
+		if hospitalFieldValue(existing, field) != hospitalFieldValue(res.data, field) {
+			res.Changes = append(res.Changes, field)
+		}
+	}
+	res.Action = "unchanged"
+	if len(res.Changes) > 0 {
+		res.Action = "update"
+	}
+	return res
+}
+
+// 以文件中的非空值覆盖医院字段
+func hospitalFromImport(h Hospital, values map[string]string) Hospital {
+	set := func(field string, dst *string) {
+		if v, ok := values[field]; ok {
+			*dst = v
+		}
+	}
+	set("name", &h.Name)
+	set("address", &h.Address)
+	set("phone", &h.Phone)
+	set("hospital_type", &h.HospitalType)
+	set("main_departments", &h.MainDepartments)
+	set("business_hours", &h.BusinessHours)
+	set("qualifications", &h.Qualifications)
+	if v, ok := values["latitude"]; ok {
+		h.Latitude, _ = strconv.ParseFloat(v, 64)
+	}
+	if v, ok := values["longitude"]; ok {
+		h.Longitude, _ = strconv.ParseFloat(v, 64)
+	}
+	return h
+}
+
+func hospitalFieldValue(h Hospital, field string) string {
+	switch field {
+	case "name":
+		return h.Name
+	case "address":
+		return h.Address
+	case "latitude":
+		return formatExportFloat(h.Latitude)
+	case "longitude":
+		return formatExportFloat(h.Longitude)
+	case "phone":
+		return h.Phone
+	case "hospital_type":
+		return h.HospitalType
+	case "main_departments":
+		return h.MainDepartments
+	case "business_hours":
+		return h.BusinessHours
+	case "qualifications":
+		return h.Qualifications
+	}
+	return ""
+}
+
+// 执行导入计划，新增和更新的医院记录历史版本
+func applyHospitalImport(plan *hospitalImportPlan, now time.Time) error {
+	tx, err := db.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+	updatedAt := now.Format("2006-01-02 15:04:05")
+	var touched []int
+	for i := range plan.Rows {
+		r := &plan.Rows[i]
+		h := r.data
+		switch r.Action {
+		case "insert":
+			res, err := tx.Exec(`
+				INSERT INTO hospitals (name, address, latitude, longitude, phone, hospital_type, main_departments, business_hours, qualifications)
+				VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?)
+			`, h.Name, h.Address, h.Latitude, h.Longitude, h.Phone, h.HospitalType, h.MainDepartments, h.BusinessHours, h.Qualifications)
+			if err != nil {
+				return fmt.Errorf("第%d行: %v", r.Row, err)
+			}
+			id, _ := res.LastInsertId()
+			r.ID = int(id)
+		case "update":
+			if _, err := tx.Exec(`
+				UPDATE hospitals SET name = ?, address = ?, latitude = ?, longitude = ?, phone = ?, hospital_type = ?,
+					main_departments = ?, business_hours = ?, qualifications = ?, updated_at = ?
+				WHERE id = ?
+			`, h.Name, h.Address, h.Latitude, h.Longitude, h.Phone, h.HospitalType, h.MainDepartments, h.BusinessHours,
+				h.Qualifications, updatedAt, r.ID); err != nil {
+				return fmt.Errorf("第%d行: %v", r.Row, err)
+			}
+		default:
+			continue
+		}
+		touched = append(touched, r.ID)
+	}
+	if err := tx.Commit(); err != nil {
+		return err
+	}
+	for _, id := range touched {
+		if err := recordHospitalVersion(id, "xlsx_import", now); err != nil {
+			log.Printf("[医院导入] 记录医院 %d 版本失败: %v", id, err)
+		}
+	}
+	relinkGrades()
+	invalidateMapPoints()
+	return nil
+}
+
+// 管理员：从XLSX/CSV导入医院。dry_run=true时只返回导入计划；sheet指定工作表；
+// map为"表头:字段"列表，覆盖自动映射
+func importHospitalsFile(c *gin.Context) {
+	data, err := ioutil.ReadAll(io.LimitReader(c.Request.Body, 32<<20))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	sheet, rows, err := importFileRows(data, c.Query("sheet"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	crs, ok := crsParam(c)
+	if !ok {
+		return
+	}
+	plan, err := planHospitalImport(rows, c.Query("map"), crs)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	plan.Sheet = sheet
+	dryRun := c.Query("dry_run") == "true"
+	if !dryRun {
+		if err := applyHospitalImport(plan, time.Now()); err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+			return
+		}
+		log.Printf("[医院导入] 新增 %d，更新 %d，无变化 %d，错误 %d", plan.Inserted, plan.Updated, plan.Unchanged, plan.Errors)
+	}
+	c.JSON(http.StatusOK, gin.H{"status": "success", "dry_run": dryRun, "data": plan})
+}
+
+// 子命令：export 导出数据集为XLSX、CSV、GeoJSON或KML
+func runExportCommand(args []string) int {
+	fs := flag.NewFlagSet("export", flag.ContinueOnError)
+	output := fs.String("o", "", "输出文件，默认 <数据集>.<格式>")
+	format := fs.String("format", ExportFormatXLSX, "导出格式: xlsx、csv、geojson、kml")
+	city := fs.String("city", "", "只导出该城市的数据")
+	crsName := fs.String("crs", "", "坐标系: wgs84、gcj02、bd09，默认geojson、kml为wgs84，其余为gcj02")
+	fs.Usage = func() {
+		fmt.Fprintf(fs.Output(), "用法: export [-format xlsx] [-city 北京] [-crs wgs84] [-o 文件] <%s>\n", strings.Join(exportDatasetNames(), "|"))
+		fs.PrintDefaults()
+	}
+	if err := fs.Parse(args); err != nil {
+		return 2
+	}
+	build, ok := exportDatasets[fs.Arg(0)]
+	if _, known := exportContentTypes[*format]; fs.NArg() != 1 || !ok || !known {
+		fs.Usage()
+		return 2
+	}
+	crs := storageCRS
+	if *format == ExportFormatGeoJSON || *format == ExportFormatKML {
+		crs = CRSWGS84
+	}
+	if *crsName != "" {
+		var err error
+		if crs, err = parseCRS(*crsName); err != nil {
+			fmt.Fprintln(os.Stderr, "[导出]", err)
+			return 2
+		}
+	}
+	if *output == "" {
+		*output = fs.Arg(0) + "." + *format
+	}
+	initDB()
+	sheet, err := build(exportFilter{City: *city})
+	if err != nil {
+		fmt.Fprintln(os.Stderr, "[导出] 失败:", err)
+		return 1
+	}
+	sheet = sheetToCRS(sheet, crs)
+	f, err := os.Create(*output)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, "[导出] 失败:", err)
+		return 1
+	}
+	defer f.Close()
+	if err := encodeExport(f, fs.Arg(0), *format, sheet); err != nil {
+		fmt.Fprintln(os.Stderr, "[导出] 失败:", err)
+		return 1
+	}
+	fmt.Fprintf(os.Stderr, "[导出] %s %d 行，已写入 %s\n", fs.Arg(0), len(sheet.Rows)-1, *output)
+	return 0
+}
+
+// 子命令：import 从XLSX/CSV导入医院
+func runImportCommand(args []string) int {
+	fs := flag.NewFlagSet("import", flag.ContinueOnError)
+	sheetName := fs.String("sheet", "", "XLSX工作表，默认第一个可见工作表")
+	overrides := fs.String("map", "", "列映射，如 医院:name,坐标:location；字段为 - 时忽略该列")
+	dryRun := fs.Bool("dry-run", false, "只输出导入计划，不写入数据库")
+	crsName := fs.String("crs", storageCRS, "文件坐标系: wgs84、gcj02、bd09；有crs列时以该列为准")
+	fs.Usage = func() {
+		fmt.Fprintln(fs.Output(), "用法: import [-sheet 名称] [-map 表头:字段,...] [-crs gcj02] [-dry-run] <医院.xlsx|医院.csv>")
+		fs.PrintDefaults()
+	}
+	if err := fs.Parse(args); err != nil {
+		return 2
+	}
+	crs, err := parseCRS(*crsName)
+	if fs.NArg() != 1 || err != nil {
+		fs.Usage()
+		return 2
+	}
+	data, err := ioutil.ReadFile(fs.Arg(0))
+	if err != nil {
+		fmt.Fprintln(os.Stderr, "[医院导入] 失败:", err)
+		return 1
+	}
+	initDB()
+	sheet, rows, err := importFileRows(data, *sheetName)
+	if err == nil {
+		var plan *hospitalImportPlan
+		if plan, err = planHospitalImport(rows, *overrides, crs); err == nil {
+			plan.Sheet = sheet
+			if !*dryRun {
+				err = applyHospitalImport(plan, time.Now())
+			}
+			out, _ := json.MarshalIndent(plan, "", "  ")
+			os.Stdout.Write(append(out, '\n'))
+		}
+	}
+	if err != nil {
+		fmt.Fprintln(os.Stderr, "[医院导入] 失败:", err)
+		return 1
+	}
+	return 0
+}
@@ -0,0 +1,205 @@
+package main
+
+import (
+	"archive/zip"
+	"bytes"
+	"io/ioutil"
+	"net/http"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestXLSXRoundTrip(t *testing.T) {
+	in := []XLSXSheet{
+		{Name: "医院/列表", Rows: [][]string{{"id", "name", "rating"}, {"1", "协和<东单> & \"西单\"", "4.5"}, {"2", "", "n/a"}},
+			Numeric: []bool{true, false, true}},
+		{Name: "", Rows: [][]string{{"a"}, {"第一行\n第二行"}}},
+	}
+	var buf bytes.Buffer
+	if err := writeXLSX(&buf, in); err != nil {
+		t.Fatal(err)
+	}
+	out, err := readXLSX(buf.Bytes())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(out) != 2 || out[0].Name != "医院_列表" || out[1].Name != "Sheet2" {
+		t.Fatalf("工作表: %+v", out)
+	}
+	if got := strings.Join(out[0].Rows[1], "|"); got != "1|协和<东单> & \"西单\"|4.5" {
+		t.Fatalf("第2行: %q", got)
+	}
+	// 空单元格不写入，非数字值按文本保存
+	if got := out[0].Rows[2]; len(got) != 3 || got[1] != "" || got[2] != "n/a" {
+		t.Fatalf("第3行: %q", got)
+	}
+	if out[1].Rows[1][0] != "第一行\n第二行" {
+		t.Fatalf("换行: %q", out[1].Rows[1][0])
+	}
+	if cellRef(27, 3) != "AB3" {
+		t.Fatalf("单元格引用: %s", cellRef(27, 3))
+	}
+}
+
+// 只有一个工作表的XLSX，sheetData为工作表内容
+func rawXLSX(t *testing.T, sheetData string) []byte {
+	t.Helper()
+	var buf bytes.Buffer
+	zw := zip.NewWriter(&buf)
+	for name, content := range map[string]string{
+		"xl/workbook.xml":            `<workbook xmlns:r="http://schemas.openxmlformats.org/officeDocument/2006/relationships"><sheets><sheet name="s" r:id="rId1"/></sheets></workbook>`,
+		"xl/_rels/workbook.xml.rels": `<Relationships><Relationship Id="rId1" Target="worksheets/sheet1.xml"/></Relationships>`,
+		"xl/worksheets/sheet1.xml":   `<worksheet><sheetData>` + sheetData + `</sheetData></worksheet>`,
+	} {
+		f, _ := zw.Create(name)
+		f.Write([]byte(content))
+	}
+	if err := zw.Close(); err != nil {
+		t.Fatal(err)
+	}
+	return buf.Bytes()
+}
+
+func TestXLSXBounds(t *testing.T) {
+	sheets, err := readXLSX(rawXLSX(t, `<row r="3"><c r="C3" t="inlineStr"><is><t>x</t></is></c></row>`))
+	if err != nil || len(sheets[0].Rows) != 3 || len(sheets[0].Rows[2]) != 3 || sheets[0].Rows[2][2] != "x" {
+		t.Fatalf("正常文件: %v %v", sheets, err)
+	}
+	for _, sheetData := range []string{
+		`<row r="1000000000"><c><v>1</v></c></row>`,
+		`<row r="-1"><c><v>1</v></c></row>`,
+		`<row r="1"><c r="ZZZZZZZ1"><v>1</v></c></row>`,
+		`<row r="1"><c r="XFE1"><v>1</v></c></row>`,
+		`<row r="1"><c r="A0"><v>1</v></c></row>`,
+	} {
+		if _, err := readXLSX(rawXLSX(t, sheetData)); err == nil || !strings.Contains(err.Error(), "超出范围") {
+			t.Errorf("%s: %v", sheetData, err)
+		}
+	}
+	// 解压后超过上限的部件
+	big := rawXLSX(t, `<row r="1"><c><v>`+strings.Repeat("1", xlsxMaxPartSize)+`</v></c></row>`)
+	if _, err := readXLSX(big); err == nil || !strings.Contains(err.Error(), "超过") {
+		t.Fatalf("超大部件: %v", err)
+	}
+}
+
+func TestHospitalImportAPI(t *testing.T) {
+	e := setupE2E(t, nil)
+	insertTestHospital(t, "北京协和医院", 116.417, 39.912, "address", "帅府园1号", "hospital_type", "综合医院")
+
+	// 与分析表相同的Power Query表头
+	var buf bytes.Buffer
+	writeXLSX(&buf, []XLSXSheet{{Name: "三元桥", Rows: [][]string{
+		{"Column1.id", "Column1.name", "Column1.address", "Column1.location", "Column1.tel", "备注"},
+		{"B000A5FD25", "北京协和医院", "帅府园1号", "116.417,39.912", "010-69156114", "x"},
+		{"B000A7BD6C", "北京测试新医院", "三元桥1号", "116.456,39.961", "", ""},
+		{"", "北京测试新医院", "三元桥2号", "116.457,39.962", "", ""},
+		{"", "无坐标医院", "", "", "", ""},
+	}}})
+	path := "/api/admin/import/hospitals?dry_run=true"
+	if w := doRequest(e.router, http.MethodPost, path, buf.Bytes(), nil); w.Code != http.StatusUnauthorized {
+		t.Fatalf("未认证导入 %d", w.Code)
+	}
+	w, body := e.admin(http.MethodPost, path, buf.String())
+	if w.Code != http.StatusOK {
+		t.Fatalf("预览 %d: %v", w.Code, body)
+	}
+	plan := body["data"].(map[string]interface{})
+	if plan["sheet"] != "三元桥" || plan["mapping"].(map[string]interface{})["Column1.location"] != "location" ||
+		plan["unmapped"].([]interface{})[0] != "备注" {
+		t.Fatalf("列映射: %v", plan)
+	}
+	if plan["inserted"].(float64) != 1 || plan["updated"].(float64) != 1 || plan["errors"].(float64) != 2 {
+		t.Fatalf("导入计划: %v", plan)
+	}
+	first := plan["rows"].([]interface{})[0].(map[string]interface{})
+	if first["action"] != "update" || first["changes"].([]interface{})[0] != "phone" {
+		t.Fatalf("第2行: %v", first)
+	}
+	var count int
+	db.QueryRow(`SELECT COUNT(*) FROM hospitals`).Scan(&count)
+	if count != 1 {
+		t.Fatalf("预览不应写入数据库: %d", count)
+	}
+
+	// CSV直接导入，显式映射覆盖自动识别
+	csvBody := "医院,坐标,电话\n北京测试新医院,\"116.456,39.961\",010-1\n北京协和医院,\"116.417,39.912\",010-69156114\n"
+	w, body = e.admin(http.MethodPost, "/api/admin/import/hospitals?map="+"医院:name", csvBody)
+	plan = body["data"].(map[string]interface{})
+	if w.Code != http.StatusOK || plan["inserted"].(float64) != 1 || plan["updated"].(float64) != 1 {
+		t.Fatalf("导入 %d: %v", w.Code, body)
+	}
+	var phone string
+	db.QueryRow(`SELECT phone FROM hospitals WHERE name = '北京协和医院'`).Scan(&phone)
+	if phone != "010-69156114" {
+		t.Fatalf("更新电话: %q", phone)
+	}
+	db.QueryRow(`SELECT COUNT(*) FROM hospital_versions WHERE source = 'xlsx_import'`).Scan(&count)
+	if count != 2 {
+		t.Fatalf("历史版本 %d", count)
+	}
+
+	// 再次导入无变化
+	if _, body = e.admin(http.MethodPost, "/api/admin/import/hospitals", csvBody); body["data"].(map[string]interface{})["unchanged"].(float64) != 2 {
+		t.Fatalf("重复导入: %v", body)
+	}
+	if w, _ := e.admin(http.MethodPost, "/api/admin/import/hospitals", "地址,电话\nx,y\n"); w.Code != http.StatusBadRequest {
+		t.Fatalf("缺少名称列 %d", w.Code)
+	}
+	if w, _ := e.admin(http.MethodPost, "/api/admin/import/hospitals?map=医院:nickname", csvBody); w.Code != http.StatusBadRequest {
+		t.Fatalf("未知字段 %d", w.Code)
+	}
+}
+
+func TestExportAPI(t *testing.T) {
+	e := setupE2E(t, nil)
+	id := insertTestHospital(t, "北京协和医院", 116.417, 39.912, "address", "帅府园1号", "hospital_type", "综合医院", "qualifications", "三级甲等")
+	db.Exec(`INSERT INTO ratings (hospital_id, source, rating_value, confidence) VALUES (?, 'dianping', 4.5, 0.8)`, id)
+
+	prevPath := mergedResultSnapshotPath
+	mergedResultSnapshotPath = filepath.Join(t.TempDir(), "merged.json")
+	t.Cleanup(func() { mergedResultSnapshotPath = prevPath })
+	ioutil.WriteFile(mergedResultSnapshotPath, []byte(`{"pois":[{"id":"B000A5FD25","name":"北京协和医院","tel":[],"distance":"120","biz_ext":{"rating":"4.8"}}]}`), 0644)
+
+	export := func(path string) (int, []XLSXSheet) {
+		w := doRequest(e.router, http.MethodGet, path, nil, map[string]string{"X-API-Key": e.adminKey})
+		if w.Code != http.StatusOK {
+			return w.Code, nil
+		}
+		sheets, err := readXLSX(w.Body.Bytes())
+		if err != nil {
+			t.Fatal(err)
+		}
+		return w.Code, sheets
+	}
+	if w := doRequest(e.router, http.MethodGet, "/api/export/hospitals", nil, nil); w.Code != http.StatusUnauthorized {
+		t.Fatalf("未认证导出 %d", w.Code)
+	}
+
+	_, sheets := export("/api/export/hospitals")
+	rows := sheets[0].Rows
+	if len(rows) != 2 || rows[1][1] != "北京协和医院" || rows[1][10] != "三级甲等" || rows[1][12] != "4.5" {
+		t.Fatalf("医院导出: %q", rows)
+	}
+	_, sheets = export("/api/export/ratings")
+	if rows := sheets[0].Rows; len(rows) != 2 || rows[1][2] != "北京协和医院" || rows[1][3] != "dianping" {
+		t.Fatalf("评分导出: %q", rows)
+	}
+	_, sheets = export("/api/export/merged-pois")
+	rows = sheets[0].Rows
+	col := map[string]int{}
+	for i, h := range rows[0] {
+		col[h] = i
+	}
+	if rows[1][col["id"]] != "B000A5FD25" || rows[1][col["tel"]] != "" || rows[1][col["biz_ext"]] != `{"rating":"4.8"}` {
+		t.Fatalf("合并POI导出: %q", rows)
+	}
+
+	if code, _ := export("/api/export/reviews"); code != http.StatusNotFound {
+		t.Fatalf("未知数据集 %d", code)
+	}
+	if code, _ := export("/api/export/hospitals?format=ods"); code != http.StatusBadRequest {
+		t.Fatalf("未知格式 %d", code)
+	}
+}
@@ -0,0 +1,445 @@
+package main
+
+import (
+	"encoding/json"
+	"flag"
+	"fmt"
+	"io/ioutil"
+	"log"
+	"math"
+	"net/http"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// fake服务可注入的故障
+const (
+	FakeFaultQuota      = "quota"     // 10003 DAILY_QUERY_OVER_LIMIT
+	FakeFaultQPS        = "qps"       // 10021 CUQPS_HAS_EXCEEDED_THE_LIMIT
+	FakeFaultSlow       = "slow"      // 延迟后正常返回
+	FakeFaultMalformed  = "malformed" // 非法JSON
+	FakeFaultServerDown = "http_500"  // HTTP 500
+)
+
+type fakeFault struct {
+	Mode      string
+	Delay     time.Duration
+	Remaining int // <=0 表示一直生效
+}
+
+type fakePOI struct {
+	codes []string // 可匹配的typecode：录制时的查询typecode及POI自身typecode
+	poi   map[string]interface{}
+}
+
+// FakeAmap 本地高德模拟服务：用录制的台账/POI文件响应 place/around、place/text、geocode/geo，
+// 可注入配额错误、慢响应和非法JSON，供集成测试及本地开发使用（AMAP_BASE_URL指向该服务）
+type FakeAmap struct {
+	Key string // 非空时校验key，不一致返回INVALID_USER_KEY
+
+	mu       sync.Mutex
+	pois     []*fakePOI
+	byID     map[string]*fakePOI
+	geocodes map[string]string // 地址 -> "lng,lat"
+	faults   []*fakeFault
+	requests map[string]int
+}
+
+func NewFakeAmap() *FakeAmap {
+	return &FakeAmap{
+		byID:     make(map[string]*fakePOI),
+		geocodes: make(map[string]string),
+		requests: make(map[string]int),
+	}
+}
+
+// 加载录制数据：台账数组、{pois}文件或 {"geocodes": {"地址": "lng,lat"}} 地理编码文件
+func (f *FakeAmap) LoadFixtures(paths ...string) error {
+	for _, path := range paths {
+		data, err := ioutil.ReadFile(path)
+		if err != nil {
+			return err
+		}
+		data = decodeReplayData(data)
+		var geo struct {
+			Geocodes map[string]string `json:"geocodes"`
+		}
+		if json.Unmarshal(data, &geo) == nil && len(geo.Geocodes) > 0 {
+			f.mu.Lock()
+			for addr, loc := range geo.Geocodes {
+				f.geocodes[addr] = loc
+			}
+			f.mu.Unlock()
+			continue
+		}
+		ledger, err := parseReplayInput(data, nil)
+		if err != nil {
+			return fmt.Errorf("%s: %w", path, err)
+		}
+		f.mu.Lock()
+		for _, rec := range ledger {
+			for _, raw := range rec.POIs {
+				poi, ok := raw.(map[string]interface{})
+				if !ok {
+					continue
+				}
+				f.addPOI(rec.Typecode, poi)
+			}
+		}
+		f.mu.Unlock()
+	}
+	return nil
+}
+
+func (f *FakeAmap) addPOI(queryTypecode string, poi map[string]interface{}) {
+	id, _ := poi["id"].(string)
+	tc, _ := poi["typecode"].(string)
+	codes := append([]string{queryTypecode}, strings.Split(tc, "|")...)
+	if p, ok := f.byID[id]; ok && id != "" {
+		p.codes = append(p.codes, codes...)
+		return
+	}
+	p := &fakePOI{codes: codes, poi: poi}
+	f.pois = append(f.pois, p)
+	if id != "" {
+		f.byID[id] = p
+	}
+}
+
+// 新增POI，模拟上游数据变化
+func (f *FakeAmap) AddPOI(queryTypecode string, poi map[string]interface{}) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.addPOI(queryTypecode, poi)
+}
+
+// 修改POI字段，POI不存在时返回false
+func (f *FakeAmap) UpdatePOI(id string, fields map[string]interface{}) bool {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	p, ok := f.byID[id]
+	if !ok {
+		return false
+	}
+	poi := copyPOI(p.poi)
+	for k, v := range fields {
+		poi[k] = v
+	}
+	p.poi = poi
+	return true
+}
+
+// 删除POI，POI不存在时返回false
+func (f *FakeAmap) RemovePOI(id string) bool {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	p, ok := f.byID[id]
+	if !ok {
+		return false
+	}
+	delete(f.byID, id)
+	for i, q := range f.pois {
+		if q == p {
+			f.pois = append(f.pois[:i], f.pois[i+1:]...)
+			break
+		}
+	}
+	return true
+}
+
+// 注入故障，times<=0表示一直生效直到ClearFaults
+func (f *FakeAmap) InjectFault(mode string, times int, delay time.Duration) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.faults = append(f.faults, &fakeFault{Mode: mode, Delay: delay, Remaining: times})
+}
+
+func (f *FakeAmap) ClearFaults() {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.faults = nil
+}
+
+// 各接口收到的请求数，如 "/v3/place/around"
+func (f *FakeAmap) Requests(path string) int {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	return f.requests[path]
+}
+
+func (f *FakeAmap) nextFault() *fakeFault {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	if len(f.faults) == 0 {
+		return nil
+	}
+	fault := f.faults[0]
+	if fault.Remaining > 0 {
+		fault.Remaining--
+		if fault.Remaining == 0 {
+			f.faults = f.faults[1:]
+		}
+	}
+	return fault
+}
+
+func (f *FakeAmap) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if strings.HasPrefix(r.URL.Path, "/__fake/") {
+		f.serveControl(w, r)
+		return
+	}
+	f.mu.Lock()
+	f.requests[r.URL.Path]++
+	f.mu.Unlock()
+
+	if fault := f.nextFault(); fault != nil {
+		switch fault.Mode {
+		case FakeFaultQuota:
+			writeFakeJSON(w, fakeAmapError("10003", "DAILY_QUERY_OVER_LIMIT"))
+			return
+		case FakeFaultQPS:
+			writeFakeJSON(w, fakeAmapError("10021", "CUQPS_HAS_EXCEEDED_THE_LIMIT"))
+			return
+		case FakeFaultMalformed:
+			w.Header().Set("Content-Type", "application/json")
+			w.Write([]byte(`{"status":"1","count":"3","pois":[{"id":`))
+			return
+		case FakeFaultServerDown:
+			http.Error(w, "internal error", http.StatusInternalServerError)
+			return
+		case FakeFaultSlow:
+			select {
+			case <-time.After(fault.Delay):
+			case <-r.Context().Done():
+				return
+			}
+		}
+	}
+
+	q := r.URL.Query()
+	if q.Get("key") == "" || (f.Key != "" && q.Get("key") != f.Key) {
+		writeFakeJSON(w, fakeAmapError("10001", "INVALID_USER_KEY"))
+		return
+	}
+	switch r.URL.Path {
+	case "/v3/place/around":
+		f.serveAround(w, q)
+	case "/v3/place/text":
+		f.serveText(w, q)
+	case "/v3/geocode/geo":
+		f.serveGeocode(w, q)
+	default:
+		http.NotFound(w, r)
+	}
+}
+
+func fakeAmapError(infocode, info string) map[string]interface{} {
+	return map[string]interface{}{"status": "0", "info": info, "infocode": infocode}
+}
+
+func writeFakeJSON(w http.ResponseWriter, v interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(v)
+}
+
+func (p *fakePOI) matchTypes(types string) bool {
+	if types == "" {
+		return true
+	}
+	for _, t := range strings.Split(types, "|") {
+		for _, code := range p.codes {
+			if code == t {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// 周边搜索：按typecode与半径筛选，距离升序，返回distance字段
+func (f *FakeAmap) serveAround(w http.ResponseWriter, q map[string][]string) {
+	get := func(k string) string { return firstValue(q, k) }
+	centerLng, centerLat, ok := parseLngLat(get("location"))
+	if !ok {
+		writeFakeJSON(w, fakeAmapError("20000", "INVALID_PARAMS"))
+		return
+	}
+	radius, err := strconv.ParseFloat(get("radius"), 64)
+	if err != nil || radius <= 0 {
+		radius = 3000
+	}
+	type hit struct {
+		poi  map[string]interface{}
+		dist float64
+	}
+	var hits []hit
+	f.mu.Lock()
+	for _, p := range f.pois {
+		if !p.matchTypes(get("types")) {
+			continue
+		}
+		loc, _ := p.poi["location"].(string)
+		lng, lat, ok := parseLngLat(loc)
+		if !ok {
+			continue
+		}
+		if d := haversine(centerLng, centerLat, lng, lat); d <= radius {
+			hits = append(hits, hit{p.poi, d})
+		}
+	}
+	f.mu.Unlock()
+	sort.SliceStable(hits, func(i, j int) bool { return hits[i].dist < hits[j].dist })
+
+	pois := make([]map[string]interface{}, len(hits))
+	for i, h := range hits {
+		poi := copyPOI(h.poi)
+		poi["distance"] = strconv.Itoa(int(math.Round(h.dist)))
+		pois[i] = poi
+	}
+	writeFakeJSON(w, fakePage(pois, get("offset"), get("page")))
+}
+
+// 关键字搜索：名称或地址包含任一关键字
+func (f *FakeAmap) serveText(w http.ResponseWriter, q map[string][]string) {
+	keywords := strings.Split(firstValue(q, "keywords"), "|")
+	var pois []map[string]interface{}
+	f.mu.Lock()
+	for _, p := range f.pois {
+		if !p.matchTypes(firstValue(q, "types")) {
+			continue
+		}
+		name, _ := p.poi["name"].(string)
+		addr, _ := p.poi["address"].(string)
+		for _, kw := range keywords {
+			if kw != "" && (strings.Contains(name, kw) || strings.Contains(addr, kw)) {
+				pois = append(pois, copyPOI(p.poi))
+				break
+			}
+		}
+	}
+	f.mu.Unlock()
+	writeFakeJSON(w, fakePage(pois, firstValue(q, "offset"), firstValue(q, "page")))
+}
+
+// 地理编码：优先使用地理编码文件，其次按POI名称/地址匹配
+func (f *FakeAmap) serveGeocode(w http.ResponseWriter, q map[string][]string) {
+	address := firstValue(q, "address")
+	f.mu.Lock()
+	location, ok := f.geocodes[address]
+	if !ok {
+		for _, p := range f.pois {
+			name, _ := p.poi["name"].(string)
+			addr, _ := p.poi["address"].(string)
+			if address != "" && (name == address || addr == address) {
+				location, _ = p.poi["location"].(string)
+				ok = location != ""
+				break
+			}
+		}
+	}
+	f.mu.Unlock()
+	geocodes := []map[string]interface{}{}
+	if ok {
+		geocodes = append(geocodes, map[string]interface{}{
+			"formatted_address": address,
+			"location":          location,
+			"level":             "兴趣点",
+		})
+	}
+	writeFakeJSON(w, map[string]interface{}{
+		"status":   "1",
+		"info":     "OK",
+		"infocode": "10000",
+		"count":    strconv.Itoa(len(geocodes)),
+		"geocodes": geocodes,
+	})
+}
+
+// 按高德规则分页：offset默认20、最大25，page从1开始，count为总数
+func fakePage(pois []map[string]interface{}, offsetParam, pageParam string) map[string]interface{} {
+	offset, err := strconv.Atoi(offsetParam)
+	if err != nil || offset <= 0 {
+		offset = 20
+	}
+	if offset > 25 {
+		offset = 25
+	}
+	page, err := strconv.Atoi(pageParam)
+	if err != nil || page <= 0 {
+		page = 1
+	}
+	start := (page - 1) * offset
+	if start > len(pois) {
+		start = len(pois)
+	}
+	end := start + offset
+	if end > len(pois) {
+		end = len(pois)
+	}
+	return map[string]interface{}{
+		"status":   "1",
+		"info":     "OK",
+		"infocode": "10000",
+		"count":    strconv.Itoa(len(pois)),
+		"pois":     pois[start:end],
+	}
+}
+
+func firstValue(q map[string][]string, key string) string {
+	if v := q[key]; len(v) > 0 {
+		return v[0]
+	}
+	return ""
+}
+
+// 控制接口：POST /__fake/fault?mode=quota&times=3&delay_ms=500 注入故障，DELETE 清除；GET /__fake/stats 查看请求数
+func (f *FakeAmap) serveControl(w http.ResponseWriter, r *http.Request) {
+	switch {
+	case r.URL.Path == "/__fake/fault" && r.Method == http.MethodPost:
+		q := r.URL.Query()
+		times, _ := strconv.Atoi(q.Get("times"))
+		delayMs, _ := strconv.Atoi(q.Get("delay_ms"))
+		f.InjectFault(q.Get("mode"), times, time.Duration(delayMs)*time.Millisecond)
+		writeFakeJSON(w, map[string]interface{}{"status": "ok"})
+	case r.URL.Path == "/__fake/fault" && r.Method == http.MethodDelete:
+		f.ClearFaults()
+		writeFakeJSON(w, map[string]interface{}{"status": "ok"})
+	case r.URL.Path == "/__fake/stats":
+		f.mu.Lock()
+		stats := map[string]interface{}{"pois": len(f.pois), "geocodes": len(f.geocodes), "requests": f.requests, "faults": len(f.faults)}
+		writeFakeJSON(w, stats)
+		f.mu.Unlock()
+	default:
+		http.NotFound(w, r)
+	}
+}
+
+// fake-amap子命令：go run ./backend fake-amap [-addr :9090] [-key k] [fixture...]
+func runFakeAmapCommand(args []string) int {
+	fs := flag.NewFlagSet("fake-amap", flag.ContinueOnError)
+	addr := fs.String("addr", ":9090", "监听地址")
+	key := fs.String("key", "", "只接受该key，默认接受任意非空key")
+	if err := fs.Parse(args); err != nil {
+		return 2
+	}
+	fixtures := fs.Args()
+	if len(fixtures) == 0 {
+		fixtures = []string{"backend/testdata/merge/ledger_beijing.json", "backend/testdata/fakeamap/geocode.json"}
+	}
+	fake := NewFakeAmap()
+	fake.Key = *key
+	if err := fake.LoadFixtures(fixtures...); err != nil {
+		fmt.Fprintln(os.Stderr, "[fake-amap] 加载数据失败:", err)
+		return 1
+	}
+	log.Printf("[fake-amap] 已加载 %d 条POI，监听 %s（设置 AMAP_BASE_URL=http://localhost%s）", len(fake.pois), *addr, *addr)
+	if err := http.ListenAndServe(*addr, fake); err != nil {
+		fmt.Fprintln(os.Stderr, "[fake-amap]", err)
+		return 1
+	}
+	return 0
+}
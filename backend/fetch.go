@@ -0,0 +1,240 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"log"
+	"sync"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+// 按首次出现顺序保存的POI集合，保证后续去重合并结果可复现
+type orderedPOIs struct {
+	ids  []string
+	byID map[string]map[string]interface{}
+}
+
+func newOrderedPOIs() *orderedPOIs {
+	return &orderedPOIs{byID: make(map[string]map[string]interface{})}
+}
+
+// 同一id重复出现时覆盖内容，保留首次出现的位置
+func (o *orderedPOIs) Set(id string, poi map[string]interface{}) {
+	if _, ok := o.byID[id]; !ok {
+		o.ids = append(o.ids, id)
+	}
+	o.byID[id] = poi
+}
+
+func (o *orderedPOIs) List() []map[string]interface{} {
+	list := make([]map[string]interface{}, 0, len(o.ids))
+	for _, id := range o.ids {
+		list = append(list, o.byID[id])
+	}
+	return list
+}
+
+// qpsLimiter 将请求均匀间隔到每秒n次，所有请求共享（高德QPS按Key计算）
+type qpsLimiter struct {
+	mu       sync.Mutex
+	interval time.Duration
+	next     time.Time
+}
+
+func newQPSLimiter(qps float64) *qpsLimiter {
+	if qps <= 0 {
+		return &qpsLimiter{}
+	}
+	return &qpsLimiter{interval: time.Duration(float64(time.Second) / qps)}
+}
+
+// 等待下一个可用时间片
+func (l *qpsLimiter) Wait(ctx context.Context) error {
+	if l.interval == 0 {
+		return ctx.Err()
+	}
+	l.mu.Lock()
+	now := time.Now()
+	if l.next.Before(now) {
+		l.next = now
+	}
+	slot := l.next
+	l.next = l.next.Add(l.interval)
+	l.mu.Unlock()
+	return sleepContext(ctx, slot.Sub(now))
+}
+
+var amapQPS *qpsLimiter
+var amapFetchWorkers int
+
+// 高德place/around单页条数上限为25
+var amapPageSize = 25
+var amapMaxPages = 5
+
+func initFetchLimits() {
+	amapQPS = newQPSLimiter(envFloat("AMAP_QPS", 3))
+	amapFetchWorkers = envInt("AMAP_FETCH_WORKERS", 4)
+	if amapFetchWorkers < 1 {
+		amapFetchWorkers = 1
+	}
+	amapPageSize = envInt("AMAP_PAGE_SIZE", 25)
+	if amapPageSize < 1 || amapPageSize > 25 {
+		amapPageSize = 25
+	}
+	amapMaxPages = envInt("AMAP_MAX_PAGES", 5)
+	if amapMaxPages < 1 {
+		amapMaxPages = 1
+	}
+}
+
+// 周边查询参数
+type aroundQuery struct {
+	Key         string
+	Location    string
+	Radius      string
+	Typecodes   []string
+	BypassCache bool // 跳过缓存读取（仍会写入）
+}
+
+// 并发查询各typecode的周边POI，结果按typecodes顺序返回
+// 任一typecode失败或客户端断开时取消其余查询
+func fetchAroundTypecodes(ctx context.Context, q aroundQuery, stats *cacheStats) ([]RawPOIRecord, error) {
+	typecodes := q.Typecodes
+	if amapQPS == nil {
+		initFetchLimits()
+	}
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+
+	results := make([][]RawPOIRecord, len(typecodes))
+	jobs := make(chan int)
+	var wg sync.WaitGroup
+	var once sync.Once
+	var firstErr error
+
+	workers := amapFetchWorkers
+	if workers > len(typecodes) {
+		workers = len(typecodes)
+	}
+	for w := 0; w < workers; w++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for i := range jobs {
+				recs, err := fetchAroundTypecode(ctx, q, typecodes[i], stats)
+				if err != nil {
+					once.Do(func() {
+						firstErr = fmt.Errorf("typecode %s: %w", typecodes[i], err)
+						cancel()
+					})
+					continue
+				}
+				results[i] = recs
+			}
+		}()
+	}
+	start := time.Now()
+dispatch:
+	for i := range typecodes {
+		select {
+		case jobs <- i:
+		case <-ctx.Done():
+			break dispatch
+		}
+	}
+	close(jobs)
+	wg.Wait()
+
+	if firstErr == nil && ctx.Err() != nil {
+		firstErr = ctx.Err()
+	}
+	if firstErr != nil {
+		return nil, firstErr
+	}
+	var ledger []RawPOIRecord
+	for _, recs := range results {
+		ledger = append(ledger, recs...)
+	}
+	log.Printf("[并发抓取] %d 个typecode完成，共 %d 页，耗时 %v (并发 %d)", len(typecodes), len(ledger), time.Since(start), workers)
+	return ledger, nil
+}
+
+// 分页查询单个typecode，每页生成一条台账记录
+// 以高德返回的count为总数，达到总数、出现不满页或超过最大页数时停止
+func fetchAroundTypecode(ctx context.Context, q aroundQuery, tc string, stats *cacheStats) ([]RawPOIRecord, error) {
+	var records []RawPOIRecord
+	fetched := 0
+	for page := 1; page <= amapMaxPages; page++ {
+		body, err := fetchAroundPage(ctx, q, tc, page, stats)
+		if err != nil {
+			return nil, err
+		}
+		var amapResp map[string]interface{}
+		json.Unmarshal(body, &amapResp)
+		pois, _ := amapResp["pois"].([]interface{})
+		count, _ := parseFloatFromAny(amapResp["count"])
+		records = append(records, RawPOIRecord{Typecode: tc, Page: page, Count: int(count), POIs: pois})
+
+		fetched += len(pois)
+		if len(pois) < amapPageSize || fetched >= int(count) {
+			return records, nil
+		}
+	}
+	log.Printf("[分页] typecode %s 达到最大页数 %d，已获取 %d/%d 条", tc, amapMaxPages, fetched, records[len(records)-1].Count)
+	records[len(records)-1].Truncated = true
+	return records, nil
+}
+
+// 被最大页数截断的typecode
+func truncatedTypecodes(ledger []RawPOIRecord) []string {
+	var res []string
+	for _, rec := range ledger {
+		if rec.Truncated {
+			res = append(res, rec.Typecode)
+		}
+	}
+	return res
+}
+
+// 结果不完整时在响应中标注 truncated 及被截断的typecode，导出文件通过响应头标注
+func markTruncated(c *gin.Context, result map[string]interface{}, ledger []RawPOIRecord) {
+	tcs := truncatedTypecodes(ledger)
+	if len(tcs) == 0 {
+		return
+	}
+	c.Header("X-Truncated", "true")
+	result["truncated"] = true
+	result["truncated_typecodes"] = tcs
+}
+
+// 查询单页：优先读缓存，未命中时扣减配额并请求高德
+func fetchAroundPage(ctx context.Context, q aroundQuery, tc string, page int, stats *cacheStats) ([]byte, error) {
+	ck := CacheKey{
+		Provider: ProviderAmap,
+		Endpoint: fmt.Sprintf("place/around/%d", amapPageSize),
+		Location: q.Location,
+		Radius:   q.Radius,
+		Typecode: tc,
+		Page:     page,
+	}
+	url := fmt.Sprintf("%s?key=%s&location=%s&radius=%s&types=%s&offset=%d&page=%d",
+		upstreamURL(ProviderAmap, "/v3/place/around"), q.Key, q.Location, q.Radius, tc, amapPageSize, page)
+	if !q.BypassCache {
+		if body, ok := responseCache.Get(ck); ok {
+			stats.record(true)
+			recordCacheHit(ctx, ProviderAmap, url, body)
+			return body, nil
+		}
+	}
+	stats.record(false)
+	// 配额与QPS由上游客户端在每次尝试前处理
+	body, err := upstreamClient(ProviderAmap).Get(ctx, url)
+	if err != nil {
+		return nil, err
+	}
+	responseCache.Set(ck, body)
+	return body, nil
+}
@@ -0,0 +1,214 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+// 按types参数定制响应的高德周边接口
+func setupFetchServer(t *testing.T, env map[string]string, handler func(w http.ResponseWriter, r *http.Request, types string)) {
+	t.Helper()
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		handler(w, r, r.URL.Query().Get("types"))
+	}))
+	t.Cleanup(srv.Close)
+	if env == nil {
+		env = map[string]string{}
+	}
+	env["AMAP_BASE_URL"] = srv.URL
+	setupE2E(t, env)
+}
+
+func fakeAroundBody(types string, n int) string {
+	var pois []string
+	for i := 0; i < n; i++ {
+		pois = append(pois, fmt.Sprintf(`{"id":"%s-%d","typecode":%q}`, types, i, types))
+	}
+	return fmt.Sprintf(`{"status":"1","count":"%d","pois":[%s]}`, n, strings.Join(pois, ","))
+}
+
+func TestFetchAroundTypecodesOrder(t *testing.T) {
+	typecodes := []string{"090100", "090101", "090102", "090200", "090300", "090400"}
+	var mu sync.Mutex
+	var completed []string
+	setupFetchServer(t, map[string]string{"AMAP_FETCH_WORKERS": "3"}, func(w http.ResponseWriter, r *http.Request, types string) {
+		// 越靠前的typecode响应越慢
+		for i, tc := range typecodes {
+			if tc == types {
+				time.Sleep(time.Duration(len(typecodes)-i) * 15 * time.Millisecond)
+			}
+		}
+		mu.Lock()
+		completed = append(completed, types)
+		mu.Unlock()
+		fmt.Fprint(w, fakeAroundBody(types, 2))
+	})
+
+	ledger, err := fetchAroundTypecodes(context.Background(), aroundQuery{
+		Key: e2eAmapKey, Location: e2eLocation, Radius: "3000", Typecodes: typecodes,
+	}, &cacheStats{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if completed[0] == typecodes[0] {
+		t.Fatalf("测试前提：请求应乱序完成 %v", completed)
+	}
+	var got []string
+	for _, rec := range ledger {
+		got = append(got, rec.Typecode)
+		if len(rec.POIs) != 2 || rec.POIs[0].(map[string]interface{})["typecode"] != rec.Typecode {
+			t.Fatalf("%s 的POI错位: %v", rec.Typecode, rec.POIs)
+		}
+	}
+	if fmt.Sprint(got) != fmt.Sprint(typecodes) {
+		t.Fatalf("台账顺序 %v，应为 %v", got, typecodes)
+	}
+}
+
+func TestFetchAroundTypecodesCancel(t *testing.T) {
+	typecodes := []string{"090100", "090101", "090102", "090200", "090300", "090400"}
+	var mu sync.Mutex
+	started, aborted := 0, 0
+	setupFetchServer(t, map[string]string{"AMAP_FETCH_WORKERS": "2", "AMAP_TIMEOUT_MS": "5000"}, func(w http.ResponseWriter, r *http.Request, types string) {
+		mu.Lock()
+		started++
+		mu.Unlock()
+		if types == "090101" {
+			fmt.Fprint(w, `{"status":"0","info":"INVALID_USER_KEY","infocode":"10001"}`)
+			return
+		}
+		// 其余请求挂起，直到调用方取消
+		select {
+		case <-r.Context().Done():
+			mu.Lock()
+			aborted++
+			mu.Unlock()
+		case <-time.After(3 * time.Second):
+			fmt.Fprint(w, fakeAroundBody(types, 1))
+		}
+	})
+	q := aroundQuery{Key: e2eAmapKey, Location: e2eLocation, Radius: "3000", Typecodes: typecodes}
+
+	// 任一typecode失败时取消其余查询，且不再派发
+	start := time.Now()
+	_, err := fetchAroundTypecodes(context.Background(), q, &cacheStats{})
+	var amapErr *AmapError
+	if !errors.As(err, &amapErr) || !strings.Contains(err.Error(), "typecode 090101") {
+		t.Fatalf("错误: %v", err)
+	}
+	if time.Since(start) > time.Second {
+		t.Fatalf("失败后未及时取消: %v", time.Since(start))
+	}
+	waitFor(t, func() bool { mu.Lock(); defer mu.Unlock(); return aborted == started-1 })
+	if started != 2 {
+		t.Fatalf("工作池为2时应只发出2个请求，实际 %d", started)
+	}
+
+	// 客户端断开
+	mu.Lock()
+	started, aborted = 0, 0
+	mu.Unlock()
+	q.Typecodes = []string{"090100", "090102", "090200"}
+	q.Location = "116.400000,39.900000" // 避开上一轮的缓存
+	ctx, cancel := context.WithCancel(context.Background())
+	time.AfterFunc(50*time.Millisecond, cancel)
+	start = time.Now()
+	if _, err := fetchAroundTypecodes(ctx, q, &cacheStats{}); !errors.Is(err, context.Canceled) {
+		t.Fatalf("取消后错误: %v", err)
+	}
+	if time.Since(start) > time.Second {
+		t.Fatalf("取消后未及时返回: %v", time.Since(start))
+	}
+	waitFor(t, func() bool { mu.Lock(); defer mu.Unlock(); return started == 2 && aborted == 2 })
+}
+
+func waitFor(t *testing.T, cond func() bool) {
+	t.Helper()
+	for deadline := time.Now().Add(2 * time.Second); time.Now().Before(deadline); time.Sleep(5 * time.Millisecond) {
+		if cond() {
+			return
+		}
+	}
+	t.Fatal("等待超时")
+}
+
+func TestFetchAroundTypecodePaging(t *testing.T) {
+	totals := map[string]int{"090100": 7, "090101": 10, "090102": 12, "090200": 0}
+	var mu sync.Mutex
+	pages := map[string][]string{}
+	setupFetchServer(t, map[string]string{"AMAP_PAGE_SIZE": "5", "AMAP_MAX_PAGES": "2"}, func(w http.ResponseWriter, r *http.Request, types string) {
+		q := r.URL.Query()
+		mu.Lock()
+		pages[types] = append(pages[types], q.Get("offset")+"/"+q.Get("page"))
+		mu.Unlock()
+		var page int
+		fmt.Sscan(q.Get("page"), &page)
+		n := totals[types] - (page-1)*5
+		if n > 5 {
+			n = 5
+		} else if n < 0 {
+			n = 0
+		}
+		var pois []string
+		for i := 0; i < n; i++ {
+			pois = append(pois, fmt.Sprintf(`{"id":"%s-%d"}`, types, (page-1)*5+i))
+		}
+		fmt.Fprintf(w, `{"status":"1","count":"%d","pois":[%s]}`, totals[types], strings.Join(pois, ","))
+	})
+
+	for _, c := range []struct {
+		typecode  string
+		sizes     []int
+		truncated bool
+	}{
+		{"090100", []int{5, 2}, false}, // 不满页停止
+		{"090101", []int{5, 5}, false}, // 达到count停止，不请求空页
+		{"090102", []int{5, 5}, true},  // 达到最大页数
+		{"090200", []int{0}, false},
+	} {
+		recs, err := fetchAroundTypecode(context.Background(), aroundQuery{
+			Key: e2eAmapKey, Location: e2eLocation, Radius: "3000",
+		}, c.typecode, &cacheStats{})
+		if err != nil {
+			t.Fatal(err)
+		}
+		var sizes []int
+		for i, rec := range recs {
+			sizes = append(sizes, len(rec.POIs))
+			if rec.Page != i+1 || rec.Count != totals[c.typecode] || rec.Typecode != c.typecode {
+				t.Fatalf("%s 第%d页: %+v", c.typecode, i+1, rec)
+			}
+			if rec.Truncated != (c.truncated && i == len(recs)-1) {
+				t.Fatalf("%s 第%d页truncated=%v", c.typecode, i+1, rec.Truncated)
+			}
+		}
+		if fmt.Sprint(sizes) != fmt.Sprint(c.sizes) {
+			t.Fatalf("%s 每页条数 %v，应为 %v", c.typecode, sizes, c.sizes)
+		}
+		if len(pages[c.typecode]) != len(c.sizes) || pages[c.typecode][0] != "5/1" {
+			t.Fatalf("%s 请求: %v", c.typecode, pages[c.typecode])
+		}
+	}
+	if got := truncatedTypecodes([]RawPOIRecord{{Typecode: "090100"}, {Typecode: "090102", Truncated: true}}); fmt.Sprint(got) != "[090102]" {
+		t.Fatalf("被截断的typecode: %v", got)
+	}
+}
+
+func TestE2ETruncatedFlag(t *testing.T) {
+	e := setupE2E(t, map[string]string{"AMAP_PAGE_SIZE": "1", "AMAP_MAX_PAGES": "1"})
+	w, body := e.get("/api/merged-pois?location=" + e2eLocation)
+	if w.Code != http.StatusOK || body["truncated"] != true || len(body["truncated_typecodes"].([]interface{})) == 0 || w.Header().Get("X-Truncated") != "true" {
+		t.Fatalf("截断未标注 %d: truncated=%v %v", w.Code, body["truncated"], body["truncated_typecodes"])
+	}
+	e = setupE2E(t, map[string]string{"AMAP_PAGE_SIZE": "25", "AMAP_MAX_PAGES": "5"})
+	if _, body = e.get("/api/amap/around?location=" + e2eLocation); body["truncated"] != nil {
+		t.Fatalf("未截断时不应标注: %v", body["truncated"])
+	}
+}
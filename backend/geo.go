@@ -0,0 +1,328 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"math"
+	"strconv"
+	"strings"
+)
+
+// 经纬度点
+type lngLat struct {
+	Lng float64 `json:"lng"`
+	Lat float64 `json:"lat"`
+}
+
+const metersPerDegreeLat = 111320.0
+
+// 解析多边形：支持 "lng,lat;lng,lat;..." 或 GeoJSON（Polygon / Feature / 坐标数组）
+func parsePolygon(s string) ([]lngLat, error) {
+	s = strings.TrimSpace(s)
+	if s == "" {
+		return nil, errors.New("多边形为空")
+	}
+	var poly []lngLat
+	if strings.HasPrefix(s, "{") || strings.HasPrefix(s, "[") {
+		coords, err := geoJSONRing([]byte(s))
+		if err != nil {
+			return nil, err
+		}
+		for _, c := range coords {
+			if len(c) < 2 {
+				return nil, errors.New("坐标格式错误")
+			}
+			poly = append(poly, lngLat{c[0], c[1]})
+		}
+	} else {
+		for _, part := range strings.Split(s, ";") {
+			lng, lat, ok := parseLngLat(part)
+			if !ok {
+				return nil, fmt.Errorf("坐标格式错误: %s", part)
+			}
+			poly = append(poly, lngLat{lng, lat})
+		}
+	}
+	// 去掉首尾重复的闭合点
+	if n := len(poly); n > 1 && poly[0] == poly[n-1] {
+		poly = poly[:n-1]
+	}
+	if len(poly) < 3 {
+		return nil, errors.New("多边形至少需要3个顶点")
+	}
+	return poly, nil
+}
+
+// 取GeoJSON多边形外环
+func geoJSONRing(data []byte) ([][]float64, error) {
+	var obj struct {
+		Type        string          `json:"type"`
+		Geometry    json.RawMessage `json:"geometry"`
+		Coordinates json.RawMessage `json:"coordinates"`
+	}
+	if data[0] == '[' {
+		obj.Coordinates = data
+	} else if err := json.Unmarshal(data, &obj); err != nil {
+		return nil, err
+	}
+	if obj.Type == "Feature" {
+		return geoJSONRing(obj.Geometry)
+	}
+	var rings [][][]float64
+	if err := json.Unmarshal(obj.Coordinates, &rings); err == nil && len(rings) > 0 {
+		return rings[0], nil
+	}
+	var ring [][]float64
+	if err := json.Unmarshal(obj.Coordinates, &ring); err != nil {
+		return nil, errors.New("不支持的GeoJSON多边形")
+	}
+	return ring, nil
+}
+
+func formatPolygon(poly []lngLat) string {
+	parts := make([]string, len(poly))
+	for i, p := range poly {
+		parts[i] = strconv.FormatFloat(p.Lng, 'f', 6, 64) + "," + strconv.FormatFloat(p.Lat, 'f', 6, 64)
+	}
+	return strings.Join(parts, ";")
+}
+
+// 射线法判断点是否在多边形内
+func pointInPolygon(p lngLat, poly []lngLat) bool {
+	inside := false
+	for i, j := 0, len(poly)-1; i < len(poly); j, i = i, i+1 {
+		a, b := poly[i], poly[j]
+		if (a.Lat > p.Lat) != (b.Lat > p.Lat) &&
+			p.Lng < (b.Lng-a.Lng)*(p.Lat-a.Lat)/(b.Lat-a.Lat)+a.Lng {
+			inside = !inside
+		}
+	}
+	return inside
+}
+
+// 点到多边形边界的最短距离（米），在点附近按平面近似
+func distanceToPolygonEdge(p lngLat, poly []lngLat) float64 {
+	kx := metersPerDegreeLat * math.Cos(p.Lat*math.Pi/180)
+	ky := metersPerDegreeLat
+	best := math.Inf(1)
+	for i, j := 0, len(poly)-1; i < len(poly); j, i = i, i+1 {
+		ax, ay := (poly[j].Lng-p.Lng)*kx, (poly[j].Lat-p.Lat)*ky
+		bx, by := (poly[i].Lng-p.Lng)*kx, (poly[i].Lat-p.Lat)*ky
+		dx, dy := bx-ax, by-ay
+		t := 0.0
+		if l := dx*dx + dy*dy; l > 0 {
+			t = math.Max(0, math.Min(1, -(ax*dx+ay*dy)/l))
+		}
+		if d := math.Hypot(ax+t*dx, ay+t*dy); d < best {
+			best = d
+		}
+	}
+	return best
+}
+
+// 解析矩形范围 "minLng,minLat,maxLng,maxLat"
+func parseBBox(s string) (min, max lngLat, err error) {
+	parts := strings.Split(s, ",")
+	if len(parts) != 4 {
+		return min, max, errors.New("bbox格式应为 minLng,minLat,maxLng,maxLat")
+	}
+	var v [4]float64
+	for i, p := range parts {
+		if v[i], err = strconv.ParseFloat(strings.TrimSpace(p), 64); err != nil {
+			return min, max, fmt.Errorf("bbox坐标格式错误: %s", p)
+		}
+	}
+	min, max = lngLat{v[0], v[1]}, lngLat{v[2], v[3]}
+	if min.Lng >= max.Lng || min.Lat >= max.Lat || min.Lat < -90 || max.Lat > 90 || min.Lng < -180 || max.Lng > 180 {
+		return min, max, errors.New("bbox范围无效")
+	}
+	return min, max, nil
+}
+
+func polygonBounds(poly []lngLat) (min, max lngLat) {
+	min, max = poly[0], poly[0]
+	for _, p := range poly[1:] {
+		min.Lng, min.Lat = math.Min(min.Lng, p.Lng), math.Min(min.Lat, p.Lat)
+		max.Lng, max.Lat = math.Max(max.Lng, p.Lng), math.Max(max.Lat, p.Lat)
+	}
+	return
+}
+
+// 用半径为radius米的圆覆盖多边形：圆心按六边形网格排列（行距1.5r、列距√3r），
+// 相邻圆互相重叠，保证区域内任一点至少被一个圆覆盖；只保留与多边形相交的圆
+func tileCircles(poly []lngLat, radius float64) []lngLat {
+	min, max := polygonBounds(poly)
+	midLat := (min.Lat + max.Lat) / 2
+	dLat := 1.5 * radius / metersPerDegreeLat
+	dLng := math.Sqrt(3) * radius / (metersPerDegreeLat * math.Cos(midLat*math.Pi/180))
+
+	var tiles []lngLat
+	row := 0
+	for lat := min.Lat - dLat; lat <= max.Lat+dLat; lat += dLat {
+		offset := 0.0
+		if row%2 == 1 {
+			offset = dLng / 2
+		}
+		for lng := min.Lng - dLng + offset; lng <= max.Lng+dLng; lng += dLng {
+			c := lngLat{lng, lat}
+			if pointInPolygon(c, poly) || distanceToPolygonEdge(c, poly) < radius {
+				tiles = append(tiles, c)
+			}
+		}
+		row++
+	}
+	return tiles
+}
+
+// 用半径为sub米的圆覆盖以center为圆心、半径radius米的圆：先覆盖外接正方形，再去掉与原圆不相交的圆
+func subdivideCircle(center lngLat, radius, sub float64) []lngLat {
+	dLat := radius / metersPerDegreeLat
+	dLng := radius / (metersPerDegreeLat * math.Cos(center.Lat*math.Pi/180))
+	square := []lngLat{
+		{center.Lng - dLng, center.Lat - dLat}, {center.Lng + dLng, center.Lat - dLat},
+		{center.Lng + dLng, center.Lat + dLat}, {center.Lng - dLng, center.Lat + dLat},
+	}
+	var res []lngLat
+	for _, c := range tileCircles(square, sub) {
+		if haversine(center.Lng, center.Lat, c.Lng, c.Lat) < radius+sub {
+			res = append(res, c)
+		}
+	}
+	return res
+}
+
+// 解析GeoJSON多边形：Polygon、MultiPolygon，或其Feature / FeatureCollection。
+// 返回 多边形 → 环 → 顶点，每个多边形第一个环为外环，其余为洞
+func geoJSONPolygons(data []byte) ([][][]lngLat, error) {
+	var obj struct {
+		Type        string            `json:"type"`
+		Geometry    json.RawMessage   `json:"geometry"`
+		Features    []json.RawMessage `json:"features"`
+		Coordinates json.RawMessage   `json:"coordinates"`
+	}
+	if err := json.Unmarshal(data, &obj); err != nil {
+		return nil, err
+	}
+	toRings := func(rings [][][]float64) ([][]lngLat, error) {
+		var res [][]lngLat
+		for _, ring := range rings {
+			var r []lngLat
+			for _, c := range ring {
+				if len(c) < 2 {
+					return nil, errors.New("坐标格式错误")
+				}
+				r = append(r, lngLat{c[0], c[1]})
+			}
+			if n := len(r); n > 1 && r[0] == r[n-1] {
+				r = r[:n-1]
+			}
+			if len(r) < 3 {
+				return nil, errors.New("多边形至少需要3个顶点")
+			}
+			res = append(res, r)
+		}
+		if len(res) == 0 {
+			return nil, errors.New("多边形为空")
+		}
+		return res, nil
+	}
+	switch obj.Type {
+	case "Feature":
+		return geoJSONPolygons(obj.Geometry)
+	case "FeatureCollection":
+		var polys [][][]lngLat
+		for _, f := range obj.Features {
+			p, err := geoJSONPolygons(f)
+			if err != nil {
+				return nil, err
+			}
+			polys = append(polys, p...)
+		}
+		if len(polys) == 0 {
+			return nil, errors.New("FeatureCollection中没有多边形")
+		}
+		return polys, nil
+	case "Polygon":
+		var coords [][][]float64
+		if err := json.Unmarshal(obj.Coordinates, &coords); err != nil {
+			return nil, err
+		}
+		rings, err := toRings(coords)
+		if err != nil {
+			return nil, err
+		}
+		return [][][]lngLat{rings}, nil
+	case "MultiPolygon":
+		var coords [][][][]float64
+		if err := json.Unmarshal(obj.Coordinates, &coords); err != nil {
+			return nil, err
+		}
+		var polys [][][]lngLat
+		for _, c := range coords {
+			rings, err := toRings(c)
+			if err != nil {
+				return nil, err
+			}
+			polys = append(polys, rings)
+		}
+		return polys, nil
+	}
+	return nil, fmt.Errorf("不支持的GeoJSON类型: %s", obj.Type)
+}
+
+// 搜索范围：矩形或多边形，多边形可有多个部分和洞
+type searchArea struct {
+	Polygons [][][]lngLat
+	Min, Max lngLat
+}
+
+func bboxArea(min, max lngLat) *searchArea {
+	ring := []lngLat{min, {max.Lng, min.Lat}, max, {min.Lng, max.Lat}}
+	return &searchArea{Polygons: [][][]lngLat{{ring}}, Min: min, Max: max}
+}
+
+func polygonArea(polys [][][]lngLat) *searchArea {
+	var all []lngLat
+	for _, poly := range polys {
+		all = append(all, poly[0]...)
+	}
+	min, max := polygonBounds(all)
+	return &searchArea{Polygons: polys, Min: min, Max: max}
+}
+
+// 奇偶规则：点落在奇数个环内即在范围内，洞和多个部分都能正确处理
+func (a *searchArea) Contains(p lngLat) bool {
+	if p.Lng < a.Min.Lng || p.Lng > a.Max.Lng || p.Lat < a.Min.Lat || p.Lat > a.Max.Lat {
+		return false
+	}
+	inside := false
+	for _, poly := range a.Polygons {
+		for _, ring := range poly {
+			if pointInPolygon(p, ring) {
+				inside = !inside
+			}
+		}
+	}
+	return inside
+}
+
+func (a *searchArea) Center() lngLat {
+	return lngLat{(a.Min.Lng + a.Max.Lng) / 2, (a.Min.Lat + a.Max.Lat) / 2}
+}
+
+// 转为GeoJSON MultiPolygon坐标（环首尾闭合）
+func (a *searchArea) multiPolygonCoordinates() [][][][]float64 {
+	coords := make([][][][]float64, len(a.Polygons))
+	for i, poly := range a.Polygons {
+		for _, ring := range poly {
+			r := make([][]float64, 0, len(ring)+1)
+			for _, p := range ring {
+				r = append(r, []float64{p.Lng, p.Lat})
+			}
+			r = append(r, []float64{ring[0].Lng, ring[0].Lat})
+			coords[i] = append(coords[i], r)
+		}
+	}
+	return coords
+}
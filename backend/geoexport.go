@@ -0,0 +1,266 @@
+package main
+
+import (
+	"bytes"
+	"encoding/csv"
+	"encoding/json"
+	"fmt"
+	"io"
+	"mime"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+// 导出格式：同一张表格（XLSXSheet，首行为表头）可输出为XLSX、CSV、GeoJSON或KML。
+// 坐标取自longitude/latitude列或"经度,纬度"格式的location列
+
+const (
+	ExportFormatJSON    = "json"
+	ExportFormatXLSX    = "xlsx"
+	ExportFormatCSV     = "csv"
+	ExportFormatGeoJSON = "geojson"
+	ExportFormatKML     = "kml"
+)
+
+var exportContentTypes = map[string]string{
+	ExportFormatXLSX:    "application/vnd.openxmlformats-officedocument.spreadsheetml.sheet",
+	ExportFormatCSV:     "text/csv; charset=utf-8",
+	ExportFormatGeoJSON: "application/geo+json",
+	ExportFormatKML:     "application/vnd.google-earth.kml+xml",
+}
+
+// 导出格式：format参数优先，其次Accept头中第一个可识别的类型，都没有时为def。
+// def为json时json也是合法的format
+func exportFormatParam(c *gin.Context, def string) (string, bool) {
+	if format := strings.ToLower(c.Query("format")); format != "" {
+		if _, ok := exportContentTypes[format]; ok || (format == ExportFormatJSON && def == ExportFormatJSON) {
+			return format, true
+		}
+		formats := []string{}
+		if def == ExportFormatJSON {
+			formats = append(formats, ExportFormatJSON)
+		}
+		for f := range exportContentTypes {
+			formats = append(formats, f)
+		}
+		sort.Strings(formats)
+		c.JSON(http.StatusBadRequest, gin.H{"error": fmt.Sprintf("format应为 %s 之一", strings.Join(formats, "、"))})
+		return "", false
+	}
+	for _, accept := range strings.Split(c.GetHeader("Accept"), ",") {
+		mediaType, _, err := mime.ParseMediaType(strings.TrimSpace(accept))
+		if err != nil {
+			continue
+		}
+		if mediaType == "application/json" {
+			return def, true
+		}
+		for format, contentType := range exportContentTypes {
+			if ct, _, _ := mime.ParseMediaType(contentType); ct == mediaType {
+				return format, true
+			}
+		}
+	}
+	return def, true
+}
+
+// 表格中的坐标列；没有坐标时ok为false
+type sheetCoordinates struct {
+	lng, lat, location int
+}
+
+func findSheetCoordinates(header []string) (sheetCoordinates, bool) {
+	coords := sheetCoordinates{-1, -1, -1}
+	for i, h := range header {
+		switch h {
+		case "longitude":
+			coords.lng = i
+		case "latitude":
+			coords.lat = i
+		case "location":
+			coords.location = i
+		}
+	}
+	return coords, (coords.lng >= 0 && coords.lat >= 0) || coords.location >= 0
+}
+
+func (sc sheetCoordinates) point(row []string) (float64, float64, bool) {
+	cell := func(i int) string {
+		if i >= 0 && i < len(row) {
+			return row[i]
+		}
+		return ""
+	}
+	if sc.lng >= 0 && sc.lat >= 0 {
+		lng, errLng := strconv.ParseFloat(cell(sc.lng), 64)
+		lat, errLat := strconv.ParseFloat(cell(sc.lat), 64)
+		if errLng == nil && errLat == nil && (lng != 0 || lat != 0) {
+			return lng, lat, true
+		}
+	}
+	return parseLngLat(cell(sc.location))
+}
+
+func (sc sheetCoordinates) isCoordinate(col int) bool {
+	return col == sc.lng || col == sc.lat || col == sc.location
+}
+
+// 将表格按格式写入w
+func encodeExport(w io.Writer, name, format string, sheet XLSXSheet) error {
+	switch format {
+	case ExportFormatXLSX:
+		return writeXLSX(w, []XLSXSheet{sheet})
+	case ExportFormatCSV:
+		return writeExportCSV(w, sheet)
+	case ExportFormatGeoJSON:
+		return writeGeoJSON(w, sheet)
+	case ExportFormatKML:
+		return writeKML(w, name, sheet)
+	}
+	return fmt.Errorf("不支持的导出格式: %s", format)
+}
+
+// 以附件形式返回导出文件
+func writeExport(c *gin.Context, name, format string, sheet XLSXSheet) {
+	if format == ExportFormatGeoJSON || format == ExportFormatKML {
+		if len(sheet.Rows) == 0 {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "没有可导出的数据"})
+			return
+		}
+		if _, ok := findSheetCoordinates(sheet.Rows[0]); !ok {
+			c.JSON(http.StatusBadRequest, gin.H{"error": fmt.Sprintf("%s 没有坐标，不能导出为%s", name, format)})
+			return
+		}
+	}
+	var buf bytes.Buffer
+	if err := encodeExport(&buf, name, format, sheet); err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+	filename := fmt.Sprintf("%s-%s.%s", name, time.Now().Format("20060102"), format)
+	c.Header("Content-Disposition", fmt.Sprintf(`attachment; filename="%s"`, filename))
+	c.Data(http.StatusOK, exportContentTypes[format], buf.Bytes())
+}
+
+// CSV带UTF-8 BOM，Excel打开中文不乱码
+func writeExportCSV(w io.Writer, sheet XLSXSheet) error {
+	if _, err := io.WriteString(w, "\ufeff"); err != nil {
+		return err
+	}
+	cw := csv.NewWriter(w)
+	for _, row := range sheet.Rows {
+		cells := make([]string, len(row))
+		for i, v := range row {
+			cells[i] = csvSafeCell(v)
+		}
+		if err := cw.Write(cells); err != nil {
+			return err
+		}
+	}
+	cw.Flush()
+	return cw.Error()
+}
+
+// 名称、地址等来自高德和用户导入，以=、+、-、@开头时Excel会当作公式执行，前面加'作为文本；数字（如负坐标）不变
+func csvSafeCell(v string) string {
+	if v == "" || !strings.ContainsRune("=+-@", rune(v[0])) {
+		return v
+	}
+	if _, err := strconv.ParseFloat(v, 64); err == nil {
+		return v
+	}
+	return "'" + v
+}
+
+// GeoJSON FeatureCollection：每行一个Point要素，其余列为属性（数字列为数字，空值为null），
+// 无坐标的行geometry为null
+func writeGeoJSON(w io.Writer, sheet XLSXSheet) error {
+	type feature struct {
+		Type       string                 `json:"type"`
+		Geometry   interface{}            `json:"geometry"`
+		Properties map[string]interface{} `json:"properties"`
+	}
+	collection := struct {
+		Type     string    `json:"type"`
+		Features []feature `json:"features"`
+	}{Type: "FeatureCollection", Features: []feature{}}
+	if len(sheet.Rows) > 0 {
+		header := sheet.Rows[0]
+		coords, _ := findSheetCoordinates(header)
+		for _, row := range sheet.Rows[1:] {
+			f := feature{Type: "Feature", Properties: map[string]interface{}{}}
+			if lng, lat, ok := coords.point(row); ok {
+				f.Geometry = map[string]interface{}{"type": "Point", "coordinates": []float64{lng, lat}}
+			}
+			for i, col := range header {
+				if coords.isCoordinate(i) {
+					continue
+				}
+				var v interface{}
+				if i < len(row) && row[i] != "" {
+					v = row[i]
+					if i < len(sheet.Numeric) && sheet.Numeric[i] {
+						if n, err := strconv.ParseFloat(row[i], 64); err == nil {
+							v = n
+						}
+					}
+				}
+				f.Properties[col] = v
+			}
+			collection.Features = append(collection.Features, f)
+		}
+	}
+	enc := json.NewEncoder(w)
+	enc.SetEscapeHTML(false)
+	return enc.Encode(collection)
+}
+
+// KML：每个有坐标的行一个Placemark，全部列写入ExtendedData，供Google Earth按属性查看和筛选
+func writeKML(w io.Writer, name string, sheet XLSXSheet) error {
+	var b strings.Builder
+	b.WriteString(`<?xml version="1.0" encoding="UTF-8"?>` + "\n")
+	b.WriteString(`<kml xmlns="http://www.opengis.net/kml/2.2"><Document>`)
+	fmt.Fprintf(&b, `<name>%s</name>`, xlsxEscape(name))
+	if len(sheet.Rows) > 0 {
+		header := sheet.Rows[0]
+		coords, _ := findSheetCoordinates(header)
+		col := map[string]int{}
+		for i, h := range header {
+			col[h] = i
+		}
+		cell := func(row []string, h string) string {
+			if i, ok := col[h]; ok && i < len(row) {
+				return row[i]
+			}
+			return ""
+		}
+		for _, row := range sheet.Rows[1:] {
+			lng, lat, ok := coords.point(row)
+			if !ok {
+				continue
+			}
+			b.WriteString(`<Placemark>`)
+			fmt.Fprintf(&b, `<name>%s</name>`, xlsxEscape(cell(row, "name")))
+			if addr := cell(row, "address"); addr != "" {
+				fmt.Fprintf(&b, `<description>%s</description>`, xlsxEscape(addr))
+			}
+			b.WriteString(`<ExtendedData>`)
+			for i, h := range header {
+				if i < len(row) && row[i] != "" && !coords.isCoordinate(i) {
+					fmt.Fprintf(&b, `<Data name="%s"><value>%s</value></Data>`, xlsxEscape(h), xlsxEscape(row[i]))
+				}
+			}
+			b.WriteString(`</ExtendedData>`)
+			fmt.Fprintf(&b, `<Point><coordinates>%s,%s</coordinates></Point>`, formatExportFloat(lng), formatExportFloat(lat))
+			b.WriteString(`</Placemark>`)
+		}
+	}
+	b.WriteString(`</Document></kml>` + "\n")
+	_, err := io.WriteString(w, b.String())
+	return err
+}
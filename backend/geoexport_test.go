@@ -0,0 +1,191 @@
+package main
+
+import (
+	"bytes"
+	"encoding/csv"
+	"encoding/json"
+	"encoding/xml"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func TestExportFormats(t *testing.T) {
+	sheet := XLSXSheet{
+		Rows: [][]string{
+			{"id", "name", "address", "longitude", "latitude", "rating", "tel"},
+			{"1", "北京协和医院", "帅府园1号 <东院>", "116.417", "39.912", "4.5", ""},
+			{"2", "无坐标医院", "", "", "", "n/a", "010-1"},
+		},
+		Numeric: []bool{true, false, false, true, true, true},
+	}
+
+	var buf bytes.Buffer
+	if err := writeGeoJSON(&buf, sheet); err != nil {
+		t.Fatal(err)
+	}
+	var fc struct {
+		Type     string
+		Features []struct {
+			Geometry *struct {
+				Type        string
+				Coordinates []float64
+			}
+			Properties map[string]interface{}
+		}
+	}
+	if err := json.Unmarshal(buf.Bytes(), &fc); err != nil || fc.Type != "FeatureCollection" || len(fc.Features) != 2 {
+		t.Fatalf("GeoJSON: %v %s", err, buf.String())
+	}
+	first := fc.Features[0]
+	if first.Geometry.Coordinates[0] != 116.417 || first.Geometry.Coordinates[1] != 39.912 {
+		t.Fatalf("坐标: %+v", first.Geometry)
+	}
+	if first.Properties["rating"] != 4.5 || first.Properties["tel"] != nil || first.Properties["longitude"] != nil {
+		t.Fatalf("属性: %v", first.Properties)
+	}
+	// 无坐标的行geometry为null，非数字值保留为文本
+	if fc.Features[1].Geometry != nil || fc.Features[1].Properties["rating"] != "n/a" {
+		t.Fatalf("第2个要素: %+v", fc.Features[1])
+	}
+
+	buf.Reset()
+	if err := writeKML(&buf, "hospitals", sheet); err != nil {
+		t.Fatal(err)
+	}
+	var kml struct {
+		Placemarks []struct {
+			Name        string `xml:"name"`
+			Description string `xml:"description"`
+			Data        []struct {
+				Name  string `xml:"name,attr"`
+				Value string `xml:"value"`
+			} `xml:"ExtendedData>Data"`
+			Coordinates string `xml:"Point>coordinates"`
+		} `xml:"Document>Placemark"`
+	}
+	if err := xml.Unmarshal(buf.Bytes(), &kml); err != nil || len(kml.Placemarks) != 1 {
+		t.Fatalf("KML: %v %s", err, buf.String())
+	}
+	pm := kml.Placemarks[0]
+	if pm.Name != "北京协和医院" || pm.Description != "帅府园1号 <东院>" || pm.Coordinates != "116.417,39.912" || len(pm.Data) != 4 {
+		t.Fatalf("Placemark: %+v", pm)
+	}
+
+	// location列为"经度,纬度"
+	coords, ok := findSheetCoordinates([]string{"id", "location"})
+	if lng, lat, found := coords.point([]string{"B0", "116.4,39.9"}); !ok || !found || lng != 116.4 || lat != 39.9 {
+		t.Fatalf("location列: %v %v %v %v", ok, found, lng, lat)
+	}
+	if _, ok := findSheetCoordinates([]string{"id", "rating_value"}); ok {
+		t.Fatal("评分表不应有坐标")
+	}
+}
+
+func TestExportCSVFormulaCells(t *testing.T) {
+	sheet := XLSXSheet{Rows: [][]string{
+		{"name", "address", "longitude", "tel"},
+		{"=HYPERLINK(\"http://example.com\",\"协和\")", "@SUM(1+1)", "-73.98", "+86-10-69156114"},
+		{"-2+3", "北京市东城区", "116.417", "010-69156114"},
+	}}
+	var buf bytes.Buffer
+	if err := writeExportCSV(&buf, sheet); err != nil {
+		t.Fatal(err)
+	}
+	rows, err := csv.NewReader(strings.NewReader(strings.TrimPrefix(buf.String(), "\ufeff"))).ReadAll()
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := [][]string{
+		{"name", "address", "longitude", "tel"},
+		{"'=HYPERLINK(\"http://example.com\",\"协和\")", "'@SUM(1+1)", "-73.98", "'+86-10-69156114"},
+		{"'-2+3", "北京市东城区", "116.417", "010-69156114"},
+	}
+	for i := range want {
+		for j := range want[i] {
+			if rows[i][j] != want[i][j] {
+				t.Errorf("第%d行第%d列: %q, 期望 %q", i, j, rows[i][j], want[i][j])
+			}
+		}
+	}
+}
+
+func TestSearchExportNegotiation(t *testing.T) {
+	e := setupE2E(t, nil)
+	insertTestHospital(t, "北京协和医院", 116.417, 39.912, "address", "北京市东城区帅府园1号", "hospital_type", "综合医院", "qualifications", "三级甲等")
+	db.Exec(`
+		INSERT INTO pois (id, name, typecode, query_typecode, longitude, latitude, raw, first_seen, last_seen)
+		VALUES ('B0TEST1', '测试社区医院', '090102', '090102', 116.45, 39.95,
+			'{"id":"B0TEST1","name":"测试社区医院","typecode":"090102","childtype":"","location":"116.45,39.95","cityname":"北京市","adname":"朝阳区"}',
+			'2025-07-01T00:00:00Z', '2025-07-01T00:00:00Z')
+	`)
+
+	get := func(path string, headers map[string]string) *httptest.ResponseRecorder {
+		return doRequest(e.router, http.MethodGet, path, nil, headers)
+	}
+	_, body := e.get("/api/hospitals/search?radius=100000&limit=50")
+	count := int(body["count"].(float64))
+
+	w := get("/api/hospitals/search?radius=100000&limit=50&format=geojson", nil)
+	if w.Code != http.StatusOK || w.Header().Get("Content-Type") != "application/geo+json" {
+		t.Fatalf("GeoJSON %d: %s", w.Code, w.Header().Get("Content-Type"))
+	}
+	var fc struct {
+		Features []struct {
+			Properties map[string]interface{}
+		}
+	}
+	json.Unmarshal(w.Body.Bytes(), &fc)
+	if len(fc.Features) != count || count == 0 {
+		t.Fatalf("要素数 %d，JSON结果 %d", len(fc.Features), count)
+	}
+	if p := fc.Features[0].Properties; p["category"] == nil || p["icon"] == nil || p["distance"] == nil {
+		t.Fatalf("分类属性: %v", p)
+	}
+	if !strings.Contains(w.Header().Get("Content-Disposition"), "hospitals-search-") {
+		t.Fatalf("附件名: %s", w.Header().Get("Content-Disposition"))
+	}
+
+	// Accept头协商
+	w = get("/api/hospitals/search?radius=100000", map[string]string{"Accept": "application/vnd.google-earth.kml+xml"})
+	if w.Code != http.StatusOK || !strings.HasPrefix(w.Body.String(), "<?xml") {
+		t.Fatalf("KML %d: %.80s", w.Code, w.Body.String())
+	}
+	w = get("/api/hospitals/search?radius=100000", map[string]string{"Accept": "application/json, text/plain, */*"})
+	if !strings.HasPrefix(w.Header().Get("Content-Type"), "application/json") {
+		t.Fatalf("默认JSON: %s", w.Header().Get("Content-Type"))
+	}
+	if w, _ := e.get("/api/hospitals/search?format=shp"); w.Code != http.StatusBadRequest {
+		t.Fatalf("未知格式 %d", w.Code)
+	}
+
+	w = get("/api/merged-pois?location="+e2eLocation+"&format=csv", nil)
+	if w.Code != http.StatusOK || !strings.HasPrefix(w.Body.String(), "\ufeff") {
+		t.Fatalf("合并POI CSV %d", w.Code)
+	}
+	records, err := csv.NewReader(strings.NewReader(strings.TrimPrefix(w.Body.String(), "\ufeff"))).ReadAll()
+	if err != nil || len(records) < 2 || records[0][0] != "id" || records[0][7] != "algo_icon_type" {
+		t.Fatalf("CSV: %v %v", err, records[:1])
+	}
+
+	// 城市数据集
+	admin := map[string]string{"X-API-Key": e.adminKey}
+	w = get("/api/export/pois?format=geojson&city=北京", admin)
+	json.Unmarshal(w.Body.Bytes(), &fc)
+	if w.Code != http.StatusOK || len(fc.Features) != 1 || fc.Features[0].Properties["algo_hospital_category"] == nil {
+		t.Fatalf("城市POI %d: %s", w.Code, w.Body.String())
+	}
+	if w = get("/api/export/pois?format=geojson&city=上海", admin); strings.Contains(w.Body.String(), "B0TEST1") {
+		t.Fatal("城市过滤无效")
+	}
+	if w = get("/api/export/hospitals?format=kml&city=北京", admin); !strings.Contains(w.Body.String(), "北京协和医院") {
+		t.Fatalf("医院KML: %s", w.Body.String())
+	}
+	if w = get("/api/export/ratings?format=geojson", admin); w.Code != http.StatusBadRequest {
+		t.Fatalf("评分无坐标 %d", w.Code)
+	}
+	if w = get("/api/export/ratings?format=csv", admin); w.Code != http.StatusOK {
+		t.Fatalf("评分CSV %d", w.Code)
+	}
+}
@@ -0,0 +1,783 @@
+package main
+
+import (
+	"bytes"
+	"database/sql"
+	"encoding/csv"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"log"
+	"math"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+// 医院等级登记：卫健委等官方公布的医院等级（三级甲等等）导入hospital_grades表，
+// 按名称/别名模糊匹配到医院和POI。等级来源优先级：
+// 登记表(registry) > 高德类型码090101(amap_typecode) > 医院资质文本(qualifications) > 静态三甲名单(static_list)
+
+const (
+	GradeSourceRegistry       = "registry"
+	GradeSourceTypecode       = "amap_typecode"
+	GradeSourceQualifications = "qualifications"
+	GradeSourceStatic         = "static_list"
+)
+
+// 名称匹配得分达到该值才视为同一医院
+const gradeMatchThreshold = 0.85
+
+var gradeLevels = []string{"三级", "二级", "一级"}
+
+// 等次，空串为未定等
+var gradeClasses = []string{"特等", "甲等", "乙等", "丙等", "合格", ""}
+
+// 常见简写
+var gradeAbbreviations = map[string]string{
+	"三特": "三级特等", "三甲": "三级甲等", "三乙": "三级乙等", "三丙": "三级丙等",
+	"二甲": "二级甲等", "二乙": "二级乙等", "二丙": "二级丙等",
+	"一甲": "一级甲等", "一乙": "一级乙等", "一丙": "一级丙等",
+}
+
+// 高德类型码对应的等级
+var typecodeGrades = map[string]string{"090101": "三级甲等"}
+
+// 登记表中的一条医院等级
+type GradeEntry struct {
+	ID             int      `json:"id"`
+	Name           string   `json:"name"`
+	Aliases        []string `json:"aliases"`
+	Grade          string   `json:"grade"`
+	Level          string   `json:"level"`
+	Province       string   `json:"province,omitempty"`
+	RegistrationID string   `json:"registration_id,omitempty"`
+	Source         string   `json:"source,omitempty"`
+	ImportedAt     string   `json:"imported_at,omitempty"`
+}
+
+// 医院/POI上的结构化等级及其来源
+type HospitalGrade struct {
+	Grade          string  `json:"grade"`
+	Level          string  `json:"level"`
+	Source         string  `json:"source"`
+	RegistryID     int     `json:"registry_id,omitempty"`
+	RegistrationID string  `json:"registration_id,omitempty"`
+	MatchedName    string  `json:"matched_name,omitempty"`
+	Score          float64 `json:"score,omitempty"`
+}
+
+// 解析等级文本，返回规范等级（如"三级甲等"）和级别（如"三级"）
+func parseHospitalGrade(s string) (grade, level string, ok bool) {
+	s = strings.Join(strings.Fields(s), "")
+	s = strings.TrimSuffix(s, "医院")
+	if full, ok := gradeAbbreviations[s]; ok {
+		s = full
+	}
+	for _, lv := range gradeLevels {
+		for _, cls := range gradeClasses {
+			if s == lv+cls {
+				return s, lv, true
+			}
+		}
+		if s == lv+"未定等" {
+			return lv, lv, true
+		}
+	}
+	return "", "", false
+}
+
+// 从资质等自由文本中找出等级，如"三级甲等, 北京大学直属"
+func gradeFromText(text string) (grade, level string, ok bool) {
+	for _, lv := range gradeLevels {
+		for _, cls := range gradeClasses {
+			if cls != "" && strings.Contains(text, lv+cls) {
+				return lv + cls, lv, true
+			}
+		}
+	}
+	return "", "", false
+}
+
+// 登记表及其名称索引的内存缓存，随db切换或导入后重新加载
+type gradeRegistryData struct {
+	entries []GradeEntry
+	index   map[rune][]int
+}
+
+var gradeRegistry dbCache[gradeRegistryData]
+
+func gradeEntries() []GradeEntry {
+	entries, _ := gradeRegistrySnapshot()
+	return entries
+}
+
+func gradeRegistrySnapshot() ([]GradeEntry, map[rune][]int) {
+	if db == nil {
+		return nil, nil
+	}
+	data, err := gradeRegistry.get(func() (gradeRegistryData, error) {
+		entries, err := queryGradeEntries("", "")
+		if err != nil {
+			return gradeRegistryData{}, err
+		}
+		return gradeRegistryData{entries, buildGradeIndex(entries)}, nil
+	})
+	if err != nil {
+		log.Printf("[医院等级] 加载登记表失败: %v", err)
+		return nil, nil
+	}
+	return data.entries, data.index
+}
+
+func buildGradeIndex(entries []GradeEntry) map[rune][]int {
+	names := make([][]string, len(entries))
+	for i, e := range entries {
+		names[i] = append([]string{e.Name}, e.Aliases...)
+	}
+	return buildNameIndex(names, gradeMatchThreshold)
+}
+
+// 名称的字符倒排索引（前缀过滤），names[i]为第i条记录的全部名称：每个名称只按其中最罕见的
+// 几个字符建索引，个数取到能保证——与其得分达到threshold的名称至少与其共有其中一个字符
+func buildNameIndex(names [][]string, threshold float64) map[rune][]int {
+	var stripped [][]rune
+	var owners []int
+	freq := map[rune]int{}
+	for i, ns := range names {
+		for _, n := range ns {
+			rs := []rune(stripPOIName(n))
+			if len(rs) == 0 {
+				continue
+			}
+			seen := map[rune]bool{}
+			for _, r := range rs {
+				if !seen[r] {
+					seen[r] = true
+					freq[r]++
+				}
+			}
+			stripped, owners = append(stripped, rs), append(owners, i)
+		}
+	}
+	index := map[rune][]int{}
+	for k, rs := range stripped {
+		counts := map[rune]int{}
+		for _, r := range rs {
+			counts[r]++
+		}
+		distinct := make([]rune, 0, len(counts))
+		for r := range counts {
+			distinct = append(distinct, r)
+		}
+		sort.Slice(distinct, func(i, j int) bool {
+			if freq[distinct[i]] != freq[distinct[j]] {
+				return freq[distinct[i]] < freq[distinct[j]]
+			}
+			return distinct[i] < distinct[j]
+		})
+		// 得分达到阈值时最长公共子序列 L >= t·len/(2-t)，未匹配的字符不超过 len-L；
+		// 所选字符覆盖的位置多于 len-L 时，其中必有一个出现在查询名中
+		unmatched := len(rs) - int(math.Ceil(threshold*float64(len(rs))/(2-threshold)-1e-9))
+		covered := 0
+		for _, r := range distinct {
+			if covered > unmatched {
+				break
+			}
+			covered += counts[r]
+			if posting := index[r]; len(posting) == 0 || posting[len(posting)-1] != owners[k] {
+				index[r] = append(posting, owners[k])
+			}
+		}
+	}
+	return index
+}
+
+// 索引中与名称共有字符的记录，按下标升序
+func nameIndexLookup(index map[rune][]int, name string) []int {
+	seen := map[int]bool{}
+	var ids []int
+	for _, r := range stripPOIName(name) {
+		for _, i := range index[r] {
+			if !seen[i] {
+				seen[i] = true
+				ids = append(ids, i)
+			}
+		}
+	}
+	sort.Ints(ids)
+	return ids
+}
+
+func invalidateGradeRegistry() {
+	gradeRegistry.invalidate()
+}
+
+const gradeEntryColumns = `id, name, COALESCE(aliases, ''), grade, COALESCE(level, ''), COALESCE(province, ''),
+	COALESCE(registration_id, ''), COALESCE(source, ''), COALESCE(imported_at, '')`
+
+func scanGradeEntry(scanner interface{ Scan(...interface{}) error }) (GradeEntry, error) {
+	var e GradeEntry
+	var aliases string
+	err := scanner.Scan(&e.ID, &e.Name, &aliases, &e.Grade, &e.Level, &e.Province, &e.RegistrationID, &e.Source, &e.ImportedAt)
+	e.Aliases = splitGradeAliases(aliases)
+	return e, err
+}
+
+// 登记表查询，q按名称/别名模糊过滤，grade按等级过滤
+func queryGradeEntries(q, grade string) ([]GradeEntry, error) {
+	query := `SELECT ` + gradeEntryColumns + ` FROM hospital_grades WHERE 1=1`
+	var args []interface{}
+	if q != "" {
+		query += ` AND (name LIKE ? OR aliases LIKE ?)`
+		args = append(args, "%"+q+"%", "%"+q+"%")
+	}
+	if grade != "" {
+		query += ` AND grade = ?`
+		args = append(args, grade)
+	}
+	rows, err := db.Query(query+` ORDER BY id`, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	entries := []GradeEntry{}
+	for rows.Next() {
+		e, err := scanGradeEntry(rows)
+		if err != nil {
+			return nil, err
+		}
+		entries = append(entries, e)
+	}
+	return entries, rows.Err()
+}
+
+// 别名以"|"、"；"或"，"分隔
+func splitGradeAliases(s string) []string {
+	aliases := []string{}
+	for _, a := range strings.FieldsFunc(s, func(r rune) bool { return r == '|' || r == '；' || r == ';' || r == '，' }) {
+		if a = strings.TrimSpace(a); a != "" {
+			aliases = append(aliases, a)
+		}
+	}
+	return aliases
+}
+
+// 名称匹配得分：去掉括号和标点后相同为1；POI名为登记名加院区/本部后缀为0.9；否则按最长公共子序列
+func gradeNameScore(poiName, registered string) float64 {
+	a, b := stripPOIName(poiName), stripPOIName(registered)
+	if a == "" || b == "" {
+		return 0
+	}
+	if a == b {
+		return 1
+	}
+	if rest := strings.TrimPrefix(a, b); rest != a && (strings.HasSuffix(rest, "院区") || strings.HasSuffix(rest, "本部")) {
+		return 0.9
+	}
+	return lcsDice([]rune(a), []rune(b))
+}
+
+// 省份比较时忽略"省"、"市"等后缀
+func sameProvince(a, b string) bool {
+	trim := func(s string) string {
+		for _, suffix := range []string{"维吾尔自治区", "壮族自治区", "回族自治区", "自治区", "特别行政区", "省", "市"} {
+			s = strings.TrimSuffix(s, suffix)
+		}
+		return s
+	}
+	return a == "" || b == "" || trim(a) == trim(b)
+}
+
+// 一个匹配候选
+type gradeCandidate struct {
+	Entry       GradeEntry `json:"entry"`
+	MatchedName string     `json:"matched_name"`
+	Score       float64    `json:"score"`
+}
+
+// 按得分从高到低的候选（每条登记取名称/别名中的最高分）
+func gradeCandidates(name, province string, limit int) []gradeCandidate {
+	var res []gradeCandidate
+	for _, e := range gradeEntries() {
+		if !sameProvince(province, e.Province) {
+			continue
+		}
+		best := gradeCandidate{Entry: e}
+		for _, n := range append([]string{e.Name}, e.Aliases...) {
+			if s := gradeNameScore(name, n); s > best.Score {
+				best.MatchedName, best.Score = n, s
+			}
+		}
+		if best.Score > 0 {
+			res = append(res, best)
+		}
+	}
+	sort.SliceStable(res, func(i, j int) bool { return res[i].Score > res[j].Score })
+	if limit > 0 && len(res) > limit {
+		res = res[:limit]
+	}
+	return res
+}
+
+// 名称在登记表中的匹配；得分不足或最高分并列且等级不同时视为无匹配。
+// 只比较与名称共有索引字符的登记，得分不可能达到阈值的登记不参与
+func matchGrade(name, province string) *gradeCandidate {
+	entries, index := gradeRegistrySnapshot()
+	var cands []gradeCandidate
+	for _, i := range nameIndexLookup(index, name) {
+		if !sameProvince(province, entries[i].Province) {
+			continue
+		}
+		best := gradeCandidate{Entry: entries[i]}
+		for _, n := range append([]string{entries[i].Name}, entries[i].Aliases...) {
+			if s := gradeNameScore(name, n); s > best.Score {
+				best.MatchedName, best.Score = n, s
+			}
+		}
+		if best.Score >= gradeMatchThreshold {
+			cands = append(cands, best)
+		}
+	}
+	if len(cands) == 0 {
+		return nil
+	}
+	// 与全量比较一致：同分时取登记表中靠前的
+	sort.SliceStable(cands, func(i, j int) bool {
+		if cands[i].Score != cands[j].Score {
+			return cands[i].Score > cands[j].Score
+		}
+		return cands[i].Entry.ID < cands[j].Entry.ID
+	})
+	if len(cands) > 1 && cands[1].Score == cands[0].Score && cands[1].Entry.Grade != cands[0].Entry.Grade {
+		return nil
+	}
+	return &cands[0]
+}
+
+// 确定等级所需的其他线索
+type gradeHints struct {
+	Province       string
+	Typecode       string
+	Qualifications string
+	StaticList     bool // 来自静态三甲名单
+}
+
+// 按来源优先级确定医院等级，无从判断时返回nil
+func resolveHospitalGrade(name string, hints gradeHints) *HospitalGrade {
+	if m := matchGrade(name, hints.Province); m != nil {
+		return &HospitalGrade{
+			Grade: m.Entry.Grade, Level: m.Entry.Level, Source: GradeSourceRegistry, RegistryID: m.Entry.ID,
+			RegistrationID: m.Entry.RegistrationID, MatchedName: m.MatchedName, Score: m.Score,
+		}
+	}
+	tc := hints.Typecode
+	if len(tc) > 6 {
+		tc = tc[:6]
+	}
+	if grade, ok := typecodeGrades[tc]; ok {
+		_, level, _ := parseHospitalGrade(grade)
+		return &HospitalGrade{Grade: grade, Level: level, Source: GradeSourceTypecode}
+	}
+	if grade, level, ok := gradeFromText(hints.Qualifications); ok {
+		return &HospitalGrade{Grade: grade, Level: level, Source: GradeSourceQualifications}
+	}
+	if hints.StaticList {
+		return &HospitalGrade{Grade: "三级甲等", Level: "三级", Source: GradeSourceStatic}
+	}
+	return nil
+}
+
+// 数据库医院的等级
+func (h *Hospital) applyGrade() {
+	h.Grade = resolveHospitalGrade(h.Name, gradeHints{Qualifications: h.Qualifications})
+}
+
+// 合并结果中的医院POI加上grade字段（子POI除外）
+func applyPOIGrades(pois []map[string]interface{}) {
+	for _, poi := range pois {
+		if _, _, ok := childPOIFromMap(poi); ok {
+			continue
+		}
+		name, _ := poi["name"].(string)
+		tc, _ := poi["typecode"].(string)
+		province, _ := poi["pname"].(string)
+		if grade := resolveHospitalGrade(name, gradeHints{Province: province, Typecode: tc}); grade != nil {
+			poi["grade"] = grade
+		}
+	}
+}
+
+// 导入时的一行错误
+type gradeImportError struct {
+	Row   int    `json:"row"`
+	Error string `json:"error"`
+}
+
+// 校验并规范化一条导入记录
+func normalizeGradeEntry(e *GradeEntry) error {
+	e.Name = strings.TrimSpace(e.Name)
+	if e.Name == "" {
+		return fmt.Errorf("缺少医院名称")
+	}
+	grade, level, ok := parseHospitalGrade(e.Grade)
+	if !ok {
+		return fmt.Errorf("无法识别的等级: %s", e.Grade)
+	}
+	if e.Level = strings.TrimSpace(e.Level); e.Level != "" && e.Level != level {
+		return fmt.Errorf("级别%s与等级%s不一致", e.Level, grade)
+	}
+	e.Grade, e.Level = grade, level
+	e.Province = strings.TrimSpace(e.Province)
+	e.RegistrationID = strings.TrimSpace(e.RegistrationID)
+	if e.Aliases == nil {
+		e.Aliases = []string{}
+	}
+	return nil
+}
+
+// CSV表头，中英文均可
+var gradeCSVHeaders = map[string]string{
+	"name": "name", "医院名称": "name", "名称": "name",
+	"aliases": "aliases", "别名": "aliases",
+	"grade": "grade", "等级": "grade",
+	"level": "level", "级别": "level",
+	"province": "province", "省份": "province",
+	"registration_id": "registration_id", "登记号": "registration_id", "登记号码": "registration_id",
+}
+
+// 解析CSV登记表，首行为表头；返回记录及其所在行号
+func parseGradeCSV(data []byte) ([]GradeEntry, []int, error) {
+	r := csv.NewReader(bytes.NewReader(bytes.TrimPrefix(data, []byte("\xef\xbb\xbf"))))
+	r.FieldsPerRecord = -1
+	header, err := r.Read()
+	if err != nil {
+		return nil, nil, fmt.Errorf("读取表头失败: %v", err)
+	}
+	cols := map[string]int{}
+	for i, h := range header {
+		if field, ok := gradeCSVHeaders[strings.ToLower(strings.TrimSpace(h))]; ok {
+			cols[field] = i
+		}
+	}
+	if _, ok := cols["name"]; !ok {
+		return nil, nil, fmt.Errorf("表头缺少name列")
+	}
+	if _, ok := cols["grade"]; !ok {
+		return nil, nil, fmt.Errorf("表头缺少grade列")
+	}
+	var entries []GradeEntry
+	var lines []int
+	for line := 2; ; line++ {
+		record, err := r.Read()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return nil, nil, fmt.Errorf("第%d行: %v", line, err)
+		}
+		get := func(field string) string {
+			if i, ok := cols[field]; ok && i < len(record) {
+				return strings.TrimSpace(record[i])
+			}
+			return ""
+		}
+		entries = append(entries, GradeEntry{
+			Name: get("name"), Aliases: splitGradeAliases(get("aliases")), Grade: get("grade"),
+			Level: get("level"), Province: get("province"), RegistrationID: get("registration_id"),
+		})
+		lines = append(lines, line)
+	}
+	return entries, lines, nil
+}
+
+// 写入登记表：有登记号的按登记号更新，否则按名称+省份更新；replace时先清空
+func importGradeEntries(entries []GradeEntry, source string, replace bool, now time.Time) (inserted, updated int, err error) {
+	tx, err := db.Begin()
+	if err != nil {
+		return 0, 0, err
+	}
+	defer tx.Rollback()
+	if replace {
+		if _, err := tx.Exec(`DELETE FROM hospital_grades`); err != nil {
+			return 0, 0, err
+		}
+	}
+	importedAt := now.UTC().Format(time.RFC3339)
+	for _, e := range entries {
+		var id int
+		if e.RegistrationID != "" {
+			err = tx.QueryRow(`SELECT id FROM hospital_grades WHERE registration_id = ?`, e.RegistrationID).Scan(&id)
+		} else {
+			err = tx.QueryRow(`SELECT id FROM hospital_grades WHERE name = ? AND COALESCE(province, '') = ?`, e.Name, e.Province).Scan(&id)
+		}
+		if err != nil && err != sql.ErrNoRows {
+			return 0, 0, err
+		}
+		aliases := strings.Join(e.Aliases, "|")
+		if id > 0 {
+			_, err = tx.Exec(`
+				UPDATE hospital_grades SET name = ?, aliases = ?, grade = ?, level = ?, province = ?, registration_id = ?,
+					source = ?, imported_at = ?
+				WHERE id = ?
+			`, e.Name, aliases, e.Grade, e.Level, e.Province, e.RegistrationID, source, importedAt, id)
+			updated++
+		} else {
+			_, err = tx.Exec(`
+				INSERT INTO hospital_grades (name, aliases, grade, level, province, registration_id, source, imported_at)
+				VALUES (?, ?, ?, ?, ?, ?, ?, ?)
+			`, e.Name, aliases, e.Grade, e.Level, e.Province, e.RegistrationID, source, importedAt)
+			inserted++
+		}
+		if err != nil {
+			return 0, 0, err
+		}
+	}
+	// 登记表变化后全部重新匹配
+	if _, err := tx.Exec(`DELETE FROM grade_links`); err != nil {
+		return 0, 0, err
+	}
+	if err := tx.Commit(); err != nil {
+		return 0, 0, err
+	}
+	invalidateGradeRegistry()
+	relinkGrades()
+	invalidateMapPoints()
+	return inserted, updated, nil
+}
+
+// 管理员：导入医院等级登记表。请求体为JSON数组或CSV（Content-Type: text/csv 或 format=csv），
+// source为来源说明，replace=true时替换整个登记表。无效行跳过并在errors中列出
+func importHospitalGrades(c *gin.Context) {
+	data, err := ioutil.ReadAll(c.Request.Body)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	var entries []GradeEntry
+	var lines []int
+	if c.Query("format") == "csv" || strings.HasPrefix(c.ContentType(), "text/csv") {
+		entries, lines, err = parseGradeCSV(data)
+	} else if err = json.Unmarshal(data, &entries); err == nil {
+		for i := range entries {
+			lines = append(lines, i+1)
+		}
+	}
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	valid := make([]GradeEntry, 0, len(entries))
+	importErrors := []gradeImportError{}
+	for i := range entries {
+		if err := normalizeGradeEntry(&entries[i]); err != nil {
+			importErrors = append(importErrors, gradeImportError{Row: lines[i], Error: err.Error()})
+			continue
+		}
+		valid = append(valid, entries[i])
+	}
+	if len(valid) == 0 {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "没有有效的等级记录", "errors": importErrors})
+		return
+	}
+
+	source := c.DefaultQuery("source", "import")
+	inserted, updated, err := importGradeEntries(valid, source, c.Query("replace") == "true", time.Now())
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+	log.Printf("[医院等级] 导入 %s: 新增 %d 条，更新 %d 条，跳过 %d 条", source, inserted, updated, len(importErrors))
+	c.JSON(http.StatusOK, gin.H{
+		"status": "success", "inserted": inserted, "updated": updated, "skipped": len(importErrors), "errors": importErrors,
+	})
+}
+
+// 登记表列表，支持q（名称/别名）和grade过滤
+func listHospitalGrades(c *gin.Context) {
+	entries, err := queryGradeEntries(c.Query("q"), c.Query("grade"))
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"status": "success", "count": len(entries), "data": entries})
+}
+
+// 试匹配：给定名称返回匹配到的等级及得分最高的候选，便于核对别名
+func matchHospitalGrade(c *gin.Context) {
+	name := c.Query("name")
+	if name == "" {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "name参数不能为空"})
+		return
+	}
+	province := c.Query("province")
+	candidates := gradeCandidates(name, province, 5)
+	if candidates == nil {
+		candidates = []gradeCandidate{}
+	}
+	c.JSON(http.StatusOK, gin.H{
+		"status": "success", "name": name, "threshold": gradeMatchThreshold,
+		"grade":      resolveHospitalGrade(name, gradeHints{Province: province}),
+		"candidates": candidates,
+	})
+}
+
+// 登记详情及关联到的医院和已抓取POI
+func getHospitalGradeEntry(c *gin.Context) {
+	id, err := strconv.Atoi(c.Param("id"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid grade ID"})
+		return
+	}
+	entry, err := scanGradeEntry(db.QueryRow(`SELECT `+gradeEntryColumns+` FROM hospital_grades WHERE id = ?`, id))
+	if err == sql.ErrNoRows {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Grade entry not found"})
+		return
+	}
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+	hospitals, err := linkedGradeRows(`
+		SELECT l.ref_id, h.name, l.matched_name, l.score FROM grade_links l
+		JOIN hospitals h ON h.id = CAST(l.ref_id AS INTEGER)
+		WHERE l.kind = 'hospital' AND l.grade_id = ? ORDER BY h.id`, entry.ID)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+	pois, err := linkedGradeRows(`
+		SELECT l.ref_id, p.name, l.matched_name, l.score FROM grade_links l
+		JOIN pois p ON p.id = l.ref_id
+		WHERE l.kind = 'poi' AND l.grade_id = ? AND p.removed_at IS NULL AND COALESCE(p.parent_id, '') = '' ORDER BY p.id`, entry.ID)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"status": "success", "data": entry, "hospitals": hospitals, "pois": pois})
+}
+
+// 关联到指定登记的医院或POI。query需返回 id, name, matched_name, score
+func linkedGradeRows(query string, entryID int) ([]gin.H, error) {
+	rows, err := db.Query(query, entryID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	linked := []gin.H{}
+	for rows.Next() {
+		var id, name, matched string
+		var score float64
+		if err := rows.Scan(&id, &name, &matched, &score); err != nil {
+			return nil, err
+		}
+		linked = append(linked, gin.H{"id": id, "name": name, "matched_name": matched, "score": score})
+	}
+	return linked, rows.Err()
+}
+
+// 登记与医院、已抓取POI的关联保存在grade_links表：写入医院/POI时匹配，导入登记表后全部重新匹配，
+// 名称变化的行在下次刷新时重新匹配。无匹配的行grade_id为0
+const (
+	gradeLinkHospital = "hospital"
+	gradeLinkPOI      = "poi"
+)
+
+// 待匹配的医院或POI
+type gradeLinkRow struct {
+	Kind, ID, Name, Province string
+}
+
+// 匹配并保存关联；调用前须已加载登记表，事务中不再查询数据库
+func saveGradeLinks(tx *sql.Tx, rows []gradeLinkRow) error {
+	if len(rows) == 0 {
+		return nil
+	}
+	stmt, err := tx.Prepare(`
+		INSERT INTO grade_links (kind, ref_id, name, grade_id, matched_name, score) VALUES (?, ?, ?, ?, ?, ?)
+		ON CONFLICT(kind, ref_id) DO UPDATE SET
+			name = excluded.name, grade_id = excluded.grade_id, matched_name = excluded.matched_name, score = excluded.score
+	`)
+	if err != nil {
+		return err
+	}
+	defer stmt.Close()
+	for _, r := range rows {
+		gradeID, matched, score := 0, "", 0.0
+		if m := matchGrade(r.Name, r.Province); m != nil {
+			gradeID, matched, score = m.Entry.ID, m.MatchedName, m.Score
+		}
+		if _, err := stmt.Exec(r.Kind, r.ID, r.Name, gradeID, matched, score); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// 匹配尚无关联或改名后的医院和POI
+func refreshGradeLinks() error {
+	gradeEntries()
+	var pending []gradeLinkRow
+	for _, q := range []struct {
+		kind, query string
+	}{
+		{gradeLinkHospital, `
+			SELECT CAST(h.id AS TEXT), h.name, '' FROM hospitals h
+			LEFT JOIN grade_links l ON l.kind = 'hospital' AND l.ref_id = CAST(h.id AS TEXT)
+			WHERE l.ref_id IS NULL OR l.name != h.name`},
+		{gradeLinkPOI, `
+			SELECT p.id, p.name, COALESCE(p.raw, '') FROM pois p
+			LEFT JOIN grade_links l ON l.kind = 'poi' AND l.ref_id = p.id
+			WHERE COALESCE(p.parent_id, '') = '' AND (l.ref_id IS NULL OR l.name != p.name)`},
+	} {
+		rows, err := db.Query(q.query)
+		if err != nil {
+			return err
+		}
+		for rows.Next() {
+			r := gradeLinkRow{Kind: q.kind}
+			var raw string
+			if err := rows.Scan(&r.ID, &r.Name, &raw); err != nil {
+				rows.Close()
+				return err
+			}
+			var poi struct {
+				Pname string `json:"pname"`
+			}
+			if raw != "" && json.Unmarshal([]byte(raw), &poi) == nil {
+				r.Province = poi.Pname
+			}
+			pending = append(pending, r)
+		}
+		rows.Close()
+	}
+	if len(pending) == 0 {
+		return nil
+	}
+	tx, err := db.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+	if err := saveGradeLinks(tx, pending); err != nil {
+		return err
+	}
+	if err := tx.Commit(); err != nil {
+		return err
+	}
+	log.Printf("[医院等级] 匹配 %d 家医院/POI", len(pending))
+	return nil
+}
+
+// 刷新关联，失败只记录日志
+func relinkGrades() {
+	if err := refreshGradeLinks(); err != nil {
+		log.Printf("[医院等级] 刷新关联失败: %v", err)
+	}
+}
@@ -0,0 +1,253 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"testing"
+	"time"
+)
+
+func TestParseHospitalGrade(t *testing.T) {
+	cases := []struct {
+		in, grade, level string
+	}{
+		{"三级甲等", "三级甲等", "三级"},
+		{"三甲", "三级甲等", "三级"},
+		{" 二级 乙等 ", "二级乙等", "二级"},
+		{"三级甲等医院", "三级甲等", "三级"},
+		{"一级", "一级", "一级"},
+		{"三级未定等", "三级", "三级"},
+		{"四级甲等", "", ""},
+		{"", "", ""},
+	}
+	for _, tc := range cases {
+		grade, level, ok := parseHospitalGrade(tc.in)
+		if grade != tc.grade || level != tc.level || ok != (tc.grade != "") {
+			t.Errorf("%q: %q %q %v", tc.in, grade, level, ok)
+		}
+	}
+	if grade, _, ok := gradeFromText("三级甲等, 北京大学直属, 综合医院"); !ok || grade != "三级甲等" {
+		t.Fatalf("资质文本: %q %v", grade, ok)
+	}
+}
+
+func TestMatchGrade(t *testing.T) {
+	setupTestDB(t)
+	entries := []GradeEntry{
+		{Name: "首都医科大学附属北京同仁医院", Aliases: []string{"北京同仁医院", "同仁医院"}, Grade: "三级甲等", Province: "北京市"},
+		{Name: "北京大学第三医院", Aliases: []string{"北医三院"}, Grade: "三级甲等", Province: "北京"},
+		{Name: "北京市第一测试医院", Grade: "二级甲等", Province: "北京市"},
+		{Name: "北京市第一测试医院", Grade: "二级乙等", Province: "河北省"},
+		{Name: "上海市第一测试医院", Grade: "三级乙等", Province: "上海市"},
+	}
+	for i := range entries {
+		if err := normalizeGradeEntry(&entries[i]); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if _, _, err := importGradeEntries(entries, "test", false, time.Now()); err != nil {
+		t.Fatal(err)
+	}
+
+	cases := []struct {
+		name, province, matched string
+		score                   float64
+	}{
+		{"北京同仁医院", "北京市", "北京同仁医院", 1},
+		{"首都医科大学附属北京同仁医院(东区)", "", "首都医科大学附属北京同仁医院", 1},
+		{"北京大学第三医院首都国际机场院区", "北京市", "北京大学第三医院", 0.9},
+		{"北京市第一测试医院", "北京市", "北京市第一测试医院", 1},
+		{"北京市第一测试医院", "河北省", "北京市第一测试医院", 1},
+		// 不同省份同名且等级不同：无法区分
+		{"北京市第一测试医院", "", "", 0},
+		{"上海市第一测试医院", "北京市", "", 0},
+		{"北京协和医院", "", "", 0},
+	}
+	for _, tc := range cases {
+		m := matchGrade(tc.name, tc.province)
+		if tc.matched == "" {
+			if m != nil {
+				t.Errorf("%s/%s 不应匹配: %+v", tc.name, tc.province, m)
+			}
+			continue
+		}
+		if m == nil || m.MatchedName != tc.matched || m.Score != tc.score {
+			t.Errorf("%s/%s: %+v, 期望 %s %.1f", tc.name, tc.province, m, tc.matched, tc.score)
+		}
+	}
+	if m := matchGrade("北京市第一测试医院", "河北"); m == nil || m.Entry.Grade != "二级乙等" {
+		t.Fatalf("省份过滤: %+v", m)
+	}
+
+	// 来源优先级：登记表 > 类型码 > 资质文本 > 静态名单
+	if g := resolveHospitalGrade("北医三院", gradeHints{Typecode: "090100"}); g == nil || g.Source != GradeSourceRegistry {
+		t.Fatalf("登记表来源: %+v", g)
+	}
+	if g := resolveHospitalGrade("北京协和医院", gradeHints{Typecode: "090101|090100", StaticList: true}); g == nil || g.Source != GradeSourceTypecode {
+		t.Fatalf("类型码来源: %+v", g)
+	}
+	if g := resolveHospitalGrade("北京协和医院", gradeHints{Qualifications: "二级甲等"}); g == nil || g.Source != GradeSourceQualifications || g.Level != "二级" {
+		t.Fatalf("资质来源: %+v", g)
+	}
+	if g := resolveHospitalGrade("北京协和医院", gradeHints{StaticList: true}); g == nil || g.Source != GradeSourceStatic {
+		t.Fatalf("静态名单来源: %+v", g)
+	}
+	if g := resolveHospitalGrade("北京协和医院", gradeHints{Typecode: "090100"}); g != nil {
+		t.Fatalf("无等级: %+v", g)
+	}
+}
+
+func TestGradeImportAPI(t *testing.T) {
+	e := setupE2E(t, nil)
+	csvBody := "医院名称,别名,等级,级别,省份,登记号\n" +
+		"首都医科大学附属北京同仁医院,北京同仁医院|同仁医院,三级甲等,三级,北京市,PDY0001\n" +
+		"北京测试第二医院,,二甲,,北京市,PDY0002\n" +
+		"无效等级医院,,五级,,北京市,\n" +
+		",,三级甲等,,北京市,\n"
+	if w := doRequest(e.router, http.MethodPost, "/api/admin/grades?format=csv", []byte(csvBody), nil); w.Code != http.StatusUnauthorized {
+		t.Fatalf("未认证导入 %d", w.Code)
+	}
+	w, body := e.admin(http.MethodPost, "/api/admin/grades?format=csv&source=nhc-2025", csvBody)
+	if w.Code != http.StatusOK || body["inserted"].(float64) != 2 || body["skipped"].(float64) != 2 {
+		t.Fatalf("CSV导入 %d: %v", w.Code, body)
+	}
+	if errs := body["errors"].([]interface{}); errs[0].(map[string]interface{})["row"].(float64) != 4 {
+		t.Fatalf("错误行号: %v", errs)
+	}
+
+	// 按登记号更新
+	w, body = e.admin(http.MethodPost, "/api/admin/grades?source=nhc-2025",
+		`[{"name":"北京测试第二医院","grade":"三级乙等","province":"北京市","registration_id":"PDY0002"}]`)
+	if w.Code != http.StatusOK || body["updated"].(float64) != 1 || body["inserted"].(float64) != 0 {
+		t.Fatalf("JSON导入 %d: %v", w.Code, body)
+	}
+	if w, _ := e.admin(http.MethodPost, "/api/admin/grades", `[{"name":"x","grade":"三级","level":"二级"}]`); w.Code != http.StatusBadRequest {
+		t.Fatalf("全部无效 %d", w.Code)
+	}
+
+	_, body = e.get("/api/grades?grade=三级乙等")
+	if body["count"].(float64) != 1 {
+		t.Fatalf("等级过滤: %v", body)
+	}
+	entry := body["data"].([]interface{})[0].(map[string]interface{})
+	if entry["source"] != "nhc-2025" || entry["level"] != "三级" {
+		t.Fatalf("登记记录: %v", entry)
+	}
+
+	_, body = e.get("/api/grades/match?name=同仁医院")
+	grade := body["grade"].(map[string]interface{})
+	if grade["source"] != GradeSourceRegistry || grade["registration_id"] != "PDY0001" {
+		t.Fatalf("试匹配: %v", body)
+	}
+	if w, _ := e.get("/api/grades/match"); w.Code != http.StatusBadRequest {
+		t.Fatalf("缺少name %d", w.Code)
+	}
+
+	// 数据库医院的等级取自登记表，写入医院时保存关联，能从登记反查到医院
+	(&HospitalSpider{}).SaveHospitals([]Hospital{{Name: "北京同仁医院", Address: "东交民巷1号", Latitude: 39.90, Longitude: 116.41, Qualifications: "二级甲等"}})
+	var id int
+	db.QueryRow(`SELECT id FROM hospitals WHERE name = '北京同仁医院'`).Scan(&id)
+	_, body = e.get(fmt.Sprintf("/api/hospitals/%d", id))
+	grade = body["data"].(map[string]interface{})["grade"].(map[string]interface{})
+	if grade["grade"] != "三级甲等" || grade["source"] != GradeSourceRegistry || grade["matched_name"] != "北京同仁医院" {
+		t.Fatalf("医院等级: %v", grade)
+	}
+	_, body = e.get(fmt.Sprintf("/api/grades/%v", grade["registry_id"]))
+	if hospitals := body["hospitals"].([]interface{}); len(hospitals) != 1 {
+		t.Fatalf("关联医院: %v", body)
+	}
+	if w, _ := e.get("/api/grades/999"); w.Code != http.StatusNotFound {
+		t.Fatalf("不存在的登记 %d", w.Code)
+	}
+}
+
+// 倒排索引只是剪枝，结果须与逐条比较全部登记一致
+func TestMatchGradeIndexAgreesWithFullScan(t *testing.T) {
+	setupTestDB(t)
+	data, err := ioutil.ReadFile("testdata/merge/ledger_beijing.json")
+	if err != nil {
+		t.Fatal(err)
+	}
+	var ledger []RawPOIRecord
+	if err := json.Unmarshal(data, &ledger); err != nil {
+		t.Fatal(err)
+	}
+	var names []string
+	var entries []GradeEntry
+	for _, rec := range ledger {
+		for i, raw := range rec.POIs {
+			name, _ := raw.(map[string]interface{})["name"].(string)
+			names = append(names, name)
+			grade := []string{"三级甲等", "二级甲等"}[i%2]
+			entries = append(entries, GradeEntry{Name: name, Grade: grade, Province: "北京市"})
+		}
+	}
+	if _, _, err := importGradeEntries(entries, "test", false, time.Now()); err != nil {
+		t.Fatal(err)
+	}
+
+	fullScan := func(name string) *gradeCandidate {
+		cands := gradeCandidates(name, "", 2)
+		if len(cands) == 0 || cands[0].Score < gradeMatchThreshold {
+			return nil
+		}
+		if len(cands) > 1 && cands[1].Score == cands[0].Score && cands[1].Entry.Grade != cands[0].Entry.Grade {
+			return nil
+		}
+		return &cands[0]
+	}
+	matched := 0
+	for _, name := range names {
+		rs := []rune(name)
+		for _, q := range []string{name, name + "西院区", string(rs[1:]), string(rs[:len(rs)-1]), "北京" + string(rs[len(rs)/2:])} {
+			got, want := matchGrade(q, ""), fullScan(q)
+			if (got == nil) != (want == nil) || got != nil && (got.Entry.ID != want.Entry.ID || got.Score != want.Score) {
+				t.Fatalf("%s: 索引 %+v，全量 %+v", q, got, want)
+			}
+			if got != nil {
+				matched++
+			}
+		}
+	}
+	if matched < len(names) {
+		t.Fatalf("匹配数 %d 过少", matched)
+	}
+}
+
+func TestGradeLinksFollowCrawlAndImport(t *testing.T) {
+	e := setupCrawlAPI(t, nil)
+	runTestCrawl(t, crawler)
+	var total, links int
+	db.QueryRow(`SELECT COUNT(*) FROM pois WHERE COALESCE(parent_id, '') = ''`).Scan(&total)
+	db.QueryRow(`SELECT COUNT(*) FROM grade_links WHERE kind = 'poi'`).Scan(&links)
+	if total == 0 || links != total {
+		t.Fatalf("抓取时应保存关联: POI %d，关联 %d", total, links)
+	}
+	var poi crawledPOI
+	db.QueryRow(`SELECT id, name FROM pois WHERE COALESCE(parent_id, '') = '' ORDER BY id LIMIT 1`).Scan(&poi.ID, &poi.Name)
+
+	// 导入登记表后重新匹配已抓取的POI
+	entry := GradeEntry{Name: poi.Name, Grade: "三级甲等", Province: "北京市"}
+	normalizeGradeEntry(&entry)
+	if _, _, err := importGradeEntries([]GradeEntry{entry}, "test", false, time.Now()); err != nil {
+		t.Fatal(err)
+	}
+	var gradeID int
+	db.QueryRow(`SELECT id FROM hospital_grades`).Scan(&gradeID)
+	_, body := e.get(fmt.Sprintf("/api/grades/%d", gradeID))
+	pois := body["pois"].([]interface{})
+	if len(pois) == 0 || pois[0].(map[string]interface{})["id"] != poi.ID {
+		t.Fatalf("关联POI: %v", body["pois"])
+	}
+
+	// 消失的POI不再列出
+	db.Exec(`UPDATE pois SET removed_at = '2025-07-01T00:00:00Z' WHERE id = ?`, poi.ID)
+	_, body = e.get(fmt.Sprintf("/api/grades/%d", gradeID))
+	for _, v := range body["pois"].([]interface{}) {
+		if v.(map[string]interface{})["id"] == poi.ID {
+			t.Fatal("已消失的POI仍被列出")
+		}
+	}
+}
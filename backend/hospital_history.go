@@ -0,0 +1,238 @@
+package main
+
+import (
+	"database/sql"
+	"log"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+// 医院历史版本（SCD type 2）：hospitals表保存当前状态，hospital_versions保存每个版本及其有效区间
+// [valid_from, valid_to)，valid_to为空表示当前版本。时间统一为UTC RFC3339
+
+// 参与版本比较的字段
+var hospitalVersionFields = []string{
+	"name", "address", "latitude", "longitude", "phone",
+	"hospital_type", "main_departments", "business_hours", "qualifications",
+}
+
+// 医院的一个历史版本
+type HospitalVersion struct {
+	Version       int      `json:"version"`
+	ValidFrom     string   `json:"valid_from"`
+	ValidTo       string   `json:"valid_to,omitempty"`
+	Source        string   `json:"source"`
+	ChangedFields []string `json:"changed_fields"`
+	Data          Hospital `json:"data"`
+}
+
+// 为尚无版本记录的医院补建初始版本，生效时间取created_at
+func backfillHospitalVersions() {
+	res, err := db.Exec(`
+		INSERT INTO hospital_versions (hospital_id, version, ` + strings.Join(hospitalVersionFields, ", ") + `,
+			valid_from, source, changed_fields)
+		SELECT id, 1, ` + strings.Join(hospitalVersionFields, ", ") + `,
+			strftime('%Y-%m-%dT%H:%M:%SZ', COALESCE(created_at, CURRENT_TIMESTAMP)), 'backfill', ''
+		FROM hospitals
+		WHERE id NOT IN (SELECT hospital_id FROM hospital_versions)
+	`)
+	if err != nil {
+		log.Printf("[医院历史] 补建初始版本失败: %v", err)
+		return
+	}
+	if n, _ := res.RowsAffected(); n > 0 {
+		log.Printf("[医院历史] 已为 %d 家医院补建初始版本", n)
+	}
+}
+
+// hospitals表中的医院发生变化后记录新版本：关闭当前版本并插入新版本，内容未变化时不记录
+func recordHospitalVersion(hospitalID int, source string, now time.Time) error {
+	tx, err := db.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	cur := make([]interface{}, len(hospitalVersionFields))
+	curPtrs := make([]interface{}, len(cur))
+	for i := range cur {
+		curPtrs[i] = &cur[i]
+	}
+	err = tx.QueryRow(`SELECT `+strings.Join(hospitalVersionFields, ", ")+` FROM hospitals WHERE id = ?`, hospitalID).Scan(curPtrs...)
+	if err != nil {
+		return err
+	}
+
+	prev := make([]interface{}, len(hospitalVersionFields))
+	prevPtrs := make([]interface{}, len(prev)+1)
+	for i := range prev {
+		prevPtrs[i] = &prev[i]
+	}
+	var version int
+	prevPtrs[len(prev)] = &version
+	err = tx.QueryRow(`
+		SELECT `+strings.Join(hospitalVersionFields, ", ")+`, version FROM hospital_versions
+		WHERE hospital_id = ? AND valid_to IS NULL
+	`, hospitalID).Scan(prevPtrs...)
+	var changed []string
+	switch {
+	case err == sql.ErrNoRows:
+		version = 0
+	case err != nil:
+		return err
+	default:
+		for i, field := range hospitalVersionFields {
+			if !sameColumnValue(prev[i], cur[i]) {
+				changed = append(changed, field)
+			}
+		}
+		if len(changed) == 0 {
+			return nil
+		}
+	}
+
+	ts := now.UTC().Format(time.RFC3339)
+	if version > 0 {
+		if _, err := tx.Exec(`UPDATE hospital_versions SET valid_to = ? WHERE hospital_id = ? AND valid_to IS NULL`, ts, hospitalID); err != nil {
+			return err
+		}
+	}
+	args := append([]interface{}{hospitalID, version + 1}, cur...)
+	args = append(args, ts, source, strings.Join(changed, ","))
+	_, err = tx.Exec(`
+		INSERT INTO hospital_versions (hospital_id, version, `+strings.Join(hospitalVersionFields, ", ")+`,
+			valid_from, source, changed_fields)
+		VALUES (?, ?, `+strings.TrimSuffix(strings.Repeat("?, ", len(hospitalVersionFields)), ", ")+`, ?, ?, ?)
+	`, args...)
+	if err != nil {
+		return err
+	}
+	return tx.Commit()
+}
+
+// 比较SQLite返回的列值（可能是[]byte、string、数值或nil）
+func sameColumnValue(a, b interface{}) bool {
+	if ab, ok := a.([]byte); ok {
+		a = string(ab)
+	}
+	if bb, ok := b.([]byte); ok {
+		b = string(bb)
+	}
+	return a == b
+}
+
+// 解析as_of：RFC3339时间点，或日期（取当天结束时的状态，按北京时间）。
+// 返回截止时间cutoff，版本在该时间点有效当且仅当 valid_from < cutoff <= valid_to（valid_to为空视为无穷）
+func parseAsOf(v string) (string, bool) {
+	if t, err := time.Parse(time.RFC3339, v); err == nil {
+		return t.UTC().Truncate(time.Second).Add(time.Second).Format(time.RFC3339), true
+	}
+	if t, err := time.ParseInLocation("2006-01-02", v, quotaZone); err == nil {
+		return t.AddDate(0, 0, 1).UTC().Format(time.RFC3339), true
+	}
+	return "", false
+}
+
+// as_of查询参数，未传时ok为true且cutoff为空；格式错误时已写入400响应
+func asOfParam(c *gin.Context) (cutoff string, ok bool) {
+	v := c.Query("as_of")
+	if v == "" {
+		return "", true
+	}
+	cutoff, ok = parseAsOf(v)
+	if !ok {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "as_of格式错误，应为RFC3339时间或YYYY-MM-DD日期"})
+	}
+	return cutoff, ok
+}
+
+// 与hospitals表字段顺序一致的历史版本查询，created_at取首个版本的生效时间，updated_at取该版本生效时间
+const hospitalAsOfSelect = `
+	SELECT v.hospital_id, v.name, v.address, v.latitude, v.longitude, COALESCE(v.phone, ''), COALESCE(v.hospital_type, ''),
+		COALESCE(v.main_departments, ''), COALESCE(v.business_hours, ''), COALESCE(v.qualifications, ''),
+		(SELECT MIN(f.valid_from) FROM hospital_versions f WHERE f.hospital_id = v.hospital_id), v.valid_from
+	FROM hospital_versions v
+	WHERE v.valid_from < ? AND (v.valid_to IS NULL OR v.valid_to >= ?)`
+
+func scanHospital(row interface{ Scan(...interface{}) error }) (Hospital, error) {
+	var h Hospital
+	err := row.Scan(&h.ID, &h.Name, &h.Address, &h.Latitude, &h.Longitude, &h.Phone, &h.HospitalType,
+		&h.MainDepartments, &h.BusinessHours, &h.Qualifications, &h.CreatedAt, &h.UpdatedAt)
+	return h, err
+}
+
+// 某时间点的医院状态，当时不存在返回sql.ErrNoRows
+func getHospitalAsOf(id int, cutoff string) (Hospital, error) {
+	return scanHospital(db.QueryRow(hospitalAsOfSelect+` AND v.hospital_id = ?`, cutoff, cutoff, id))
+}
+
+// 某时间点的医院列表
+func listHospitalsAsOf(cutoff string, limit, offset int) ([]Hospital, error) {
+	rows, err := db.Query(hospitalAsOfSelect+` ORDER BY v.hospital_id LIMIT ? OFFSET ?`, cutoff, cutoff, limit, offset)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	var hospitals []Hospital
+	for rows.Next() {
+		h, err := scanHospital(rows)
+		if err != nil {
+			log.Printf("Error scanning hospital: %v", err)
+			continue
+		}
+		hospitals = append(hospitals, h)
+	}
+	return hospitals, rows.Err()
+}
+
+// 医院全部历史版本，按版本号升序
+func getHospitalHistory(c *gin.Context) {
+	id, err := strconv.Atoi(c.Param("id"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid hospital ID"})
+		return
+	}
+	rows, err := db.Query(`
+		SELECT version, valid_from, COALESCE(valid_to, ''), COALESCE(source, ''), COALESCE(changed_fields, ''),
+			hospital_id, name, address, latitude, longitude, COALESCE(phone, ''), COALESCE(hospital_type, ''),
+			COALESCE(main_departments, ''), COALESCE(business_hours, ''), COALESCE(qualifications, '')
+		FROM hospital_versions WHERE hospital_id = ? ORDER BY version
+	`, id)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+	defer rows.Close()
+	versions := []HospitalVersion{}
+	for rows.Next() {
+		var v HospitalVersion
+		var changed string
+		h := &v.Data
+		if err := rows.Scan(&v.Version, &v.ValidFrom, &v.ValidTo, &v.Source, &changed,
+			&h.ID, &h.Name, &h.Address, &h.Latitude, &h.Longitude, &h.Phone, &h.HospitalType,
+			&h.MainDepartments, &h.BusinessHours, &h.Qualifications); err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+			return
+		}
+		v.ChangedFields = []string{}
+		if changed != "" {
+			v.ChangedFields = strings.Split(changed, ",")
+		}
+		if len(versions) > 0 {
+			h.CreatedAt = versions[0].ValidFrom
+		} else {
+			h.CreatedAt = v.ValidFrom
+		}
+		h.UpdatedAt = v.ValidFrom
+		versions = append(versions, v)
+	}
+	if len(versions) == 0 {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Hospital not found"})
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"status": "success", "count": len(versions), "data": versions})
+}
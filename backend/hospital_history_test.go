@@ -0,0 +1,135 @@
+package main
+
+import (
+	"fmt"
+	"net/http"
+	"net/url"
+	"testing"
+	"time"
+)
+
+func TestSaveHospitalsRecordsVersions(t *testing.T) {
+	e := setupE2E(t, nil)
+	spider := &HospitalSpider{}
+	h := Hospital{Name: "测试医院", Address: "北京市东城区测试路1号", Latitude: 39.9, Longitude: 116.4, Phone: "010-1", HospitalType: "综合医院"}
+	spider.SaveHospitals([]Hospital{h})
+	spider.SaveHospitals([]Hospital{h}) // 内容未变化，不产生新版本
+	h.Phone = "010-2"
+	h.Qualifications = "三级甲等"
+	spider.SaveHospitals([]Hospital{h})
+
+	var id int
+	db.QueryRow(`SELECT id FROM hospitals WHERE name = ?`, h.Name).Scan(&id)
+	w, body := e.get(fmt.Sprintf("/api/hospitals/%d/history", id))
+	if w.Code != http.StatusOK || body["count"].(float64) != 2 {
+		t.Fatalf("历史版本: %s", w.Body.String())
+	}
+	versions := body["data"].([]interface{})
+	v1, v2 := versions[0].(map[string]interface{}), versions[1].(map[string]interface{})
+	if v1["valid_to"] != v2["valid_from"] || v2["valid_to"] != nil {
+		t.Fatalf("版本区间不连续: %v / %v", v1, v2)
+	}
+	changed := v2["changed_fields"].([]interface{})
+	if len(changed) != 2 || changed[0] != "phone" || changed[1] != "qualifications" {
+		t.Fatalf("变化字段: %v", changed)
+	}
+	if v1["data"].(map[string]interface{})["phone"] != "010-1" {
+		t.Fatalf("旧版本内容: %v", v1["data"])
+	}
+	if w, _ := e.get("/api/hospitals/9999/history"); w.Code != http.StatusNotFound {
+		t.Fatalf("不存在的医院 %d", w.Code)
+	}
+}
+
+func TestHospitalAsOf(t *testing.T) {
+	e := setupE2E(t, nil)
+	id := int(insertTestHospital(t, "旧名称医院", 116.45, 39.95, "address", "北京市朝阳区测试路2号", "phone", "010-100",
+		"hospital_type", "综合医院", "created_at", "2024-01-01 02:00:00"))
+	backfillHospitalVersions()
+
+	// 2024-03-10 10:00（北京时间）改名，2024-06-01 改电话
+	db.Exec(`UPDATE hospitals SET name = '新名称医院' WHERE id = ?`, id)
+	recordHospitalVersion(id, "test", time.Date(2024, 3, 10, 2, 0, 0, 0, time.UTC))
+	db.Exec(`UPDATE hospitals SET phone = '010-200' WHERE id = ?`, id)
+	recordHospitalVersion(id, "test", time.Date(2024, 6, 1, 0, 0, 0, 0, time.UTC))
+
+	cases := []struct {
+		asOf, name, phone string
+	}{
+		{"2024-02-01", "旧名称医院", "010-100"},
+		{"2024-03-09", "旧名称医院", "010-100"},
+		{"2024-03-10", "新名称医院", "010-100"}, // 当天结束时的状态
+		{"2024-03-10T09:59:59+08:00", "旧名称医院", "010-100"},
+		{"2024-03-10T10:00:00+08:00", "新名称医院", "010-100"},
+		{"2024-06-01T00:00:00Z", "新名称医院", "010-200"},
+		{"", "新名称医院", "010-200"},
+	}
+	for _, tc := range cases {
+		w, body := e.get(fmt.Sprintf("/api/hospitals/%d?as_of=%s", id, url.QueryEscape(tc.asOf)))
+		if w.Code != http.StatusOK {
+			t.Fatalf("as_of=%s: %d %s", tc.asOf, w.Code, w.Body.String())
+		}
+		data := body["data"].(map[string]interface{})
+		if data["name"] != tc.name || data["phone"] != tc.phone {
+			t.Errorf("as_of=%s: %v %v", tc.asOf, data["name"], data["phone"])
+		}
+	}
+
+	// 创建之前不存在
+	if w, _ := e.get(fmt.Sprintf("/api/hospitals/%d?as_of=2023-12-31", id)); w.Code != http.StatusNotFound {
+		t.Fatalf("创建前查询 %d", w.Code)
+	}
+	if w, _ := e.get(fmt.Sprintf("/api/hospitals/%d?as_of=last-week", id)); w.Code != http.StatusBadRequest {
+		t.Fatalf("非法as_of %d", w.Code)
+	}
+
+	for _, path := range []string{"/api/hospitals?as_of=2024-02-01", "/api/hospitals/search?as_of=2024-02-01"} {
+		w, body := e.get(path)
+		data, _ := body["data"].([]interface{})
+		if w.Code != http.StatusOK || len(data) != 1 || data[0].(map[string]interface{})["name"] != "旧名称医院" ||
+			body["as_of"] != "2024-02-01" {
+			t.Fatalf("%s: %s", path, w.Body.String())
+		}
+	}
+	if _, body := e.get("/api/hospitals?as_of=2023-06-01"); body["count"].(float64) != 0 {
+		t.Fatalf("创建前的列表: %v", body["data"])
+	}
+}
+
+func TestHospitalSearchAsOfRadius(t *testing.T) {
+	e := setupE2E(t, nil)
+	// 按id顺序：远、近、中；离查询点分别约11km、0.5km、2km
+	for _, h := range []struct {
+		name     string
+		lat, lng float64
+	}{
+		{"远处医院", 40.0, 116.45},
+		{"近处医院", 39.9045, 116.4074},
+		{"中间医院", 39.918, 116.4074},
+	} {
+		insertTestHospital(t, h.name, h.lng, h.lat, "hospital_type", "综合医院", "created_at", "2024-01-01 02:00:00")
+	}
+	backfillHospitalVersions()
+
+	search := func(query string) []string {
+		w, body := e.get("/api/hospitals/search?as_of=2024-02-01&lat=39.9" + query)
+		if w.Code != http.StatusOK {
+			t.Fatalf("%s: %d %s", query, w.Code, w.Body.String())
+		}
+		var names []string
+		data, _ := body["data"].([]interface{})
+		for _, v := range data {
+			names = append(names, v.(map[string]interface{})["name"].(string))
+		}
+		return names
+	}
+	if got := search("&lng=116.4074&radius=5"); fmt.Sprint(got) != "[近处医院 中间医院]" {
+		t.Fatalf("半径5km: %v", got)
+	}
+	if got := search("&lng=116.4074&radius=20&limit=2"); fmt.Sprint(got) != "[近处医院 中间医院]" {
+		t.Fatalf("按距离取前2条: %v", got)
+	}
+	if got := search("&lng=116.4074&radius=1"); fmt.Sprint(got) != "[近处医院]" {
+		t.Fatalf("半径1km: %v", got)
+	}
+}
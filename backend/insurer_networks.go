@@ -0,0 +1,635 @@
+package main
+
+import (
+	"bytes"
+	"encoding/csv"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"log"
+	"net/http"
+	"sort"
+	"strings"
+	"time"
+	"unicode"
+	"unicode/utf8"
+
+	"github.com/gin-gonic/gin"
+)
+
+// 保险直付网络：按保险公司、网络和生效日期导入直付医疗机构名单（XLSX/CSV），
+// 查询时按名称匹配到医院。同一保险公司和网络以生效日期不晚于查询日的最新一版为准
+
+// 名单中的机构名常带"国际医疗部"等后缀，匹配要求比等级登记更严格
+const networkMatchThreshold = 0.9
+
+// 直付服务类型
+const (
+	ServiceOutpatient = "outpatient"
+	ServiceInpatient  = "inpatient"
+	ServiceCheckup    = "checkup"
+)
+
+// 一版直付网络名单
+type InsurerNetwork struct {
+	ID            int    `json:"id"`
+	Insurer       string `json:"insurer"`
+	Network       string `json:"network"`
+	EffectiveDate string `json:"effective_date"`
+	SourceFile    string `json:"source_file,omitempty"`
+	ImportedAt    string `json:"imported_at"`
+	ProviderCount int    `json:"provider_count"`
+}
+
+// 名单中的一家医疗机构
+type NetworkProvider struct {
+	ID           int      `json:"id"`
+	NetworkID    int      `json:"network_id"`
+	Name         string   `json:"name"`
+	NameEn       string   `json:"name_en,omitempty"`
+	Province     string   `json:"province,omitempty"`
+	City         string   `json:"city,omitempty"`
+	District     string   `json:"district,omitempty"`
+	Address      string   `json:"address,omitempty"`
+	Services     []string `json:"services"`
+	Phone        string   `json:"phone,omitempty"`
+	ProviderType string   `json:"provider_type,omitempty"`
+	Note         string   `json:"note,omitempty"`
+}
+
+// 医院上的一条直付网络
+type DirectBilling struct {
+	Insurer        string   `json:"insurer"`
+	Network        string   `json:"network"`
+	EffectiveDate  string   `json:"effective_date"`
+	ProviderID     int      `json:"provider_id"`
+	ProviderName   string   `json:"provider_name"`
+	ProviderNameEn string   `json:"provider_name_en,omitempty"`
+	Services       []string `json:"services"`
+	Phone          string   `json:"phone,omitempty"`
+	Note           string   `json:"note,omitempty"`
+	Score          float64  `json:"score"`
+}
+
+// 保险公司标识统一为小写
+func normalizeInsurer(s string) string {
+	return strings.ToLower(strings.TrimSpace(s))
+}
+
+// 名单表头关键字（小写包含即可），按列顺序每列只对应第一个匹配的字段
+var providerColumnKeywords = []struct {
+	field    string
+	keywords []string
+}{
+	{"name", []string{"医疗机构", "provider name", "医院名称", "name"}},
+	{"province", []string{"省", "province"}},
+	{"city", []string{"城市", "city"}},
+	{"district", []string{"行政区", "district"}},
+	{"address", []string{"地址", "address"}},
+	{"services", []string{"门诊/住院", "op/ip", "services", "服务类型"}},
+	{"phone", []string{"预约电话", "电话", "tel", "phone"}},
+	{"provider_type", []string{"医院性质", "type of provider"}},
+	{"note", []string{"备注", "note"}},
+}
+
+// 表头行各字段所在列，没有名称列时返回nil
+func providerColumns(header []string) map[string]int {
+	cols := map[string]int{}
+	for i, h := range header {
+		h = strings.ToLower(h)
+		for _, pc := range providerColumnKeywords {
+			if _, taken := cols[pc.field]; taken {
+				continue
+			}
+			matched := false
+			for _, kw := range pc.keywords {
+				if strings.Contains(h, kw) {
+					matched = true
+					break
+				}
+			}
+			if matched {
+				cols[pc.field] = i
+				break
+			}
+		}
+	}
+	if _, ok := cols["name"]; !ok {
+		return nil
+	}
+	return cols
+}
+
+// 中英双语单元格（"北京协和医院…\nPeking Union…"）拆为中文和英文
+func splitBilingual(s string) (zh, en string) {
+	var zhLines, enLines []string
+	for _, line := range strings.Split(s, "\n") {
+		if line = strings.TrimSpace(line); line == "" {
+			continue
+		}
+		if strings.IndexFunc(line, func(r rune) bool { return unicode.Is(unicode.Han, r) }) >= 0 {
+			zhLines = append(zhLines, line)
+		} else {
+			enLines = append(enLines, line)
+		}
+	}
+	return strings.Join(zhLines, " "), strings.Join(enLines, " ")
+}
+
+// 服务类型："门诊 OP/住院 IP/体检Check up"
+func parseProviderServices(s string) []string {
+	services := []string{}
+	if strings.Contains(s, "门诊") || strings.Contains(s, "OP") {
+		services = append(services, ServiceOutpatient)
+	}
+	if strings.Contains(s, "住院") || strings.Contains(s, "IP") {
+		services = append(services, ServiceInpatient)
+	}
+	if strings.Contains(s, "体检") || strings.Contains(strings.ToLower(s), "check") {
+		services = append(services, ServiceCheckup)
+	}
+	return services
+}
+
+// 由表格行解析名单：在前10行中找表头，之后每行一家机构，名称为空的行跳过
+func parseProviderRows(rows [][]string) ([]NetworkProvider, error) {
+	headerRow, cols := -1, map[string]int(nil)
+	for i := 0; i < len(rows) && i < 10; i++ {
+		if cols = providerColumns(rows[i]); cols != nil {
+			headerRow = i
+			break
+		}
+	}
+	if headerRow < 0 {
+		return nil, fmt.Errorf("前10行中没有找到机构名称列")
+	}
+	var providers []NetworkProvider
+	for _, row := range rows[headerRow+1:] {
+		get := func(field string) string {
+			if i, ok := cols[field]; ok && i < len(row) {
+				return strings.TrimSpace(row[i])
+			}
+			return ""
+		}
+		p := NetworkProvider{Address: get("address"), Phone: get("phone"), Note: get("note")}
+		p.Name, p.NameEn = splitBilingual(get("name"))
+		if p.Name == "" {
+			// 只有英文名的机构
+			p.Name, p.NameEn = p.NameEn, ""
+		}
+		if p.Name = strings.TrimSpace(strings.TrimRight(p.Name, "*＊ ")); p.Name == "" {
+			continue
+		}
+		p.Province, _ = splitBilingual(get("province"))
+		p.City, _ = splitBilingual(get("city"))
+		p.District, _ = splitBilingual(get("district"))
+		p.ProviderType, _ = splitBilingual(get("provider_type"))
+		p.Services = parseProviderServices(get("services"))
+		providers = append(providers, p)
+	}
+	return providers, nil
+}
+
+// 导入文件转为表格行：XLSX（按sheet名或第一个含名称列的可见工作表）或CSV
+func providerRowsFromFile(data []byte, sheetName string) ([][]string, error) {
+	if !bytes.HasPrefix(data, []byte("PK")) {
+		r := csv.NewReader(bytes.NewReader(bytes.TrimPrefix(data, []byte("\xef\xbb\xbf"))))
+		r.FieldsPerRecord = -1
+		return r.ReadAll()
+	}
+	sheets, err := readXLSX(data)
+	if err != nil {
+		return nil, err
+	}
+	for _, s := range sheets {
+		if sheetName != "" {
+			if s.Name == sheetName {
+				return s.Rows, nil
+			}
+			continue
+		}
+		if s.Hidden {
+			continue
+		}
+		for i := 0; i < len(s.Rows) && i < 10; i++ {
+			if providerColumns(s.Rows[i]) != nil {
+				return s.Rows, nil
+			}
+		}
+	}
+	if sheetName != "" {
+		return nil, fmt.Errorf("没有名为 %s 的工作表", sheetName)
+	}
+	return nil, fmt.Errorf("没有包含机构名称列的工作表")
+}
+
+// 写入一版名单，同一保险公司、网络和生效日期的旧名单被替换
+func importNetworkProviders(network InsurerNetwork, providers []NetworkProvider, now time.Time) (int, error) {
+	tx, err := db.Begin()
+	if err != nil {
+		return 0, err
+	}
+	defer tx.Rollback()
+	importedAt := now.UTC().Format(time.RFC3339)
+	if _, err := tx.Exec(`
+		INSERT INTO insurer_networks (insurer, network, effective_date, source_file, imported_at) VALUES (?, ?, ?, ?, ?)
+		ON CONFLICT (insurer, network, effective_date) DO UPDATE SET source_file = excluded.source_file, imported_at = excluded.imported_at
+	`, network.Insurer, network.Network, network.EffectiveDate, network.SourceFile, importedAt); err != nil {
+		return 0, err
+	}
+	var id int
+	if err := tx.QueryRow(`SELECT id FROM insurer_networks WHERE insurer = ? AND network = ? AND effective_date = ?`,
+		network.Insurer, network.Network, network.EffectiveDate).Scan(&id); err != nil {
+		return 0, err
+	}
+	if _, err := tx.Exec(`DELETE FROM network_providers WHERE network_id = ?`, id); err != nil {
+		return 0, err
+	}
+	for _, p := range providers {
+		if _, err := tx.Exec(`
+			INSERT INTO network_providers (network_id, name, name_en, province, city, district, address, services, phone, provider_type, note)
+			VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?)
+		`, id, p.Name, p.NameEn, p.Province, p.City, p.District, p.Address, strings.Join(p.Services, ","), p.Phone, p.ProviderType, p.Note); err != nil {
+			return 0, err
+		}
+	}
+	if err := tx.Commit(); err != nil {
+		return 0, err
+	}
+	invalidateNetworkProviders()
+	return id, nil
+}
+
+// 名单中的机构及其所属网络
+type networkProviderRow struct {
+	NetworkProvider
+	Insurer       string
+	Network       string
+	EffectiveDate string
+}
+
+// 全部名单及其名称索引的内存缓存，随db切换或导入后重新加载
+type networkProviderData struct {
+	rows  []networkProviderRow
+	index map[rune][]int
+}
+
+var networkProviderCache dbCache[networkProviderData]
+
+func invalidateNetworkProviders() {
+	networkProviderCache.invalidate()
+}
+
+func allNetworkProviders() []networkProviderRow {
+	rows, _ := networkProviderSnapshot()
+	return rows
+}
+
+func networkProviderSnapshot() ([]networkProviderRow, map[rune][]int) {
+	if db == nil {
+		return nil, nil
+	}
+	data, err := networkProviderCache.get(func() (networkProviderData, error) {
+		rows, err := queryNetworkProviders(`1=1`)
+		if err != nil {
+			return networkProviderData{}, err
+		}
+		providers := make([]NetworkProvider, len(rows))
+		for i, r := range rows {
+			providers[i] = r.NetworkProvider
+		}
+		return networkProviderData{rows, buildProviderIndex(providers)}, nil
+	})
+	if err != nil {
+		log.Printf("[直付网络] 加载名单失败: %v", err)
+		return nil, nil
+	}
+	return data.rows, data.index
+}
+
+func queryNetworkProviders(where string, args ...interface{}) ([]networkProviderRow, error) {
+	rows, err := db.Query(`
+		SELECT p.id, p.network_id, p.name, COALESCE(p.name_en, ''), COALESCE(p.province, ''), COALESCE(p.city, ''),
+			COALESCE(p.district, ''), COALESCE(p.address, ''), COALESCE(p.services, ''), COALESCE(p.phone, ''),
+			COALESCE(p.provider_type, ''), COALESCE(p.note, ''), n.insurer, n.network, n.effective_date
+		FROM network_providers p JOIN insurer_networks n ON n.id = p.network_id
+		WHERE `+where+` ORDER BY p.id
+	`, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	var res []networkProviderRow
+	for rows.Next() {
+		var r networkProviderRow
+		var services string
+		if err := rows.Scan(&r.ID, &r.NetworkID, &r.Name, &r.NameEn, &r.Province, &r.City, &r.District, &r.Address,
+			&services, &r.Phone, &r.ProviderType, &r.Note, &r.Insurer, &r.Network, &r.EffectiveDate); err != nil {
+			return nil, err
+		}
+		r.Services = []string{}
+		if services != "" {
+			r.Services = strings.Split(services, ",")
+		}
+		res = append(res, r)
+	}
+	return res, rows.Err()
+}
+
+// 各保险公司、网络在指定日期有效的名单版本（network_id集合）
+func effectiveNetworkIDs(rows []networkProviderRow, date string) map[int]bool {
+	latest := map[string]networkProviderRow{}
+	for _, r := range rows {
+		if r.EffectiveDate > date {
+			continue
+		}
+		key := r.Insurer + "\x00" + r.Network
+		if cur, ok := latest[key]; !ok || r.EffectiveDate > cur.EffectiveDate {
+			latest[key] = r
+		}
+	}
+	ids := map[int]bool{}
+	for _, r := range latest {
+		ids[r.NetworkID] = true
+	}
+	return ids
+}
+
+// 机构名的匹配候选：去掉"国际医疗部"等科室后缀，保留到最后一个"医院"（及其后的院区）
+func providerNameVariants(name string) []string {
+	variants := []string{name}
+	if i := strings.LastIndex(name, "医院"); i > 0 {
+		base := name[:i+len("医院")]
+		rest := name[len(base):]
+		if j := strings.Index(rest, "院区"); j >= 0 {
+			variants = append(variants, base+rest[:j+len("院区")])
+		}
+		if base != name {
+			variants = append(variants, base)
+		}
+	}
+	return variants
+}
+
+// 机构名称（含各匹配候选）的字符倒排索引
+func buildProviderIndex(providers []NetworkProvider) map[rune][]int {
+	names := make([][]string, len(providers))
+	for i, p := range providers {
+		names[i] = providerNameVariants(p.Name)
+	}
+	return buildNameIndex(names, networkMatchThreshold)
+}
+
+// 地址开头的城市（"浙江省杭州市上城区…"为"杭州市"），地址不以省市开头时为空
+func addressCity(address string) string {
+	s := strings.TrimSpace(address)
+	for _, suffix := range []string{"特别行政区", "自治区", "省"} {
+		if i := strings.Index(s, suffix); i >= 0 {
+			if n := utf8.RuneCountInString(s[:i]); n >= 2 && n <= 6 {
+				s = s[i+len(suffix):]
+				break
+			}
+		}
+	}
+	rs := []rune(s)
+	for i := 2; i < len(rs) && i <= 6; i++ {
+		if rs[i] == '市' {
+			return string(rs[:i+1])
+		}
+	}
+	return ""
+}
+
+// 城市比较时忽略"市"后缀，任一方没有城市时不作限制
+func sameCity(a, b string) bool {
+	a, b = strings.TrimSuffix(strings.TrimSpace(a), "市"), strings.TrimSuffix(strings.TrimSpace(b), "市")
+	return a == "" || b == "" || a == b
+}
+
+func providerNameScore(hospitalName string, p NetworkProvider) float64 {
+	best := 0.0
+	for _, v := range providerNameVariants(p.Name) {
+		if s := gradeNameScore(hospitalName, v); s > best {
+			best = s
+		}
+	}
+	return best
+}
+
+// 医院在指定日期（YYYY-MM-DD）有效的直付网络，insurer非空时只看该保险公司。
+// 只比较与名称共有索引字符的机构，医院和机构都有城市时要求城市相同
+func directBillingFor(hospitalName, city, insurer, date string) []DirectBilling {
+	rows, index := networkProviderSnapshot()
+	candidates := nameIndexLookup(index, hospitalName)
+	if len(candidates) == 0 {
+		return nil
+	}
+	effective := effectiveNetworkIDs(rows, date)
+	var res []DirectBilling
+	seen := map[int]int{}
+	for _, i := range candidates {
+		r := rows[i]
+		if !effective[r.NetworkID] || (insurer != "" && r.Insurer != insurer) || !sameCity(r.City, city) {
+			continue
+		}
+		score := providerNameScore(hospitalName, r.NetworkProvider)
+		if score < networkMatchThreshold {
+			continue
+		}
+		entry := DirectBilling{
+			Insurer: r.Insurer, Network: r.Network, EffectiveDate: r.EffectiveDate, ProviderID: r.ID,
+			ProviderName: r.Name, ProviderNameEn: r.NameEn, Services: r.Services, Phone: r.Phone, Note: r.Note, Score: score,
+		}
+		// 同一网络有多个机构（如国际医疗部和特需部）匹配时保留得分最高者
+		if i, ok := seen[r.NetworkID]; ok {
+			if score > res[i].Score {
+				res[i] = entry
+			}
+			continue
+		}
+		seen[r.NetworkID] = len(res)
+		res = append(res, entry)
+	}
+	return res
+}
+
+// 比较名单生效日期所用的日期：当前日期，或as_of截止时间所在的日期（按配额时区）
+func billingDate(asOfCutoff string) string {
+	t := time.Now()
+	if cutoff, err := time.Parse(time.RFC3339, asOfCutoff); err == nil {
+		t = cutoff.Add(-time.Second)
+	}
+	return t.In(quotaZone).Format("2006-01-02")
+}
+
+func insurerKnown(insurer string) bool {
+	for _, r := range allNetworkProviders() {
+		if r.Insurer == insurer {
+			return true
+		}
+	}
+	return false
+}
+
+// insurer查询参数，未导入过该保险公司名单时已写入400响应
+func insurerParam(c *gin.Context) (string, bool) {
+	insurer := normalizeInsurer(c.Query("insurer"))
+	if insurer != "" && !insurerKnown(insurer) {
+		c.JSON(http.StatusBadRequest, gin.H{"error": fmt.Sprintf("未知保险公司: %s", insurer)})
+		return "", false
+	}
+	return insurer, true
+}
+
+// 名单中能匹配到已知医院或POI的机构，用于导入时报告未匹配的机构。
+// 按名单建名称索引，每家医院只与共有索引字符的机构比较；城市取医院地址或POI的cityname
+func matchedProviders(providers []NetworkProvider) ([]bool, error) {
+	index := buildProviderIndex(providers)
+	matched := make([]bool, len(providers))
+	for _, query := range []string{
+		`SELECT name, COALESCE(address, ''), '' FROM hospitals`,
+		`SELECT name, '', COALESCE(raw, '') FROM pois WHERE removed_at IS NULL AND COALESCE(parent_id, '') = ''`,
+	} {
+		rows, err := db.Query(query)
+		if err != nil {
+			return nil, err
+		}
+		for rows.Next() {
+			var name, address, raw string
+			if err := rows.Scan(&name, &address, &raw); err != nil {
+				rows.Close()
+				return nil, err
+			}
+			candidates := nameIndexLookup(index, name)
+			if len(candidates) == 0 {
+				continue
+			}
+			city := addressCity(address)
+			if raw != "" {
+				var poi struct {
+					Cityname string `json:"cityname"`
+				}
+				if json.Unmarshal([]byte(raw), &poi) == nil {
+					city = poi.Cityname
+				}
+			}
+			for _, i := range candidates {
+				if !matched[i] && sameCity(providers[i].City, city) && providerNameScore(name, providers[i]) >= networkMatchThreshold {
+					matched[i] = true
+				}
+			}
+		}
+		err = rows.Err()
+		rows.Close()
+		if err != nil {
+			return nil, err
+		}
+	}
+	return matched, nil
+}
+
+// 管理员：导入直付网络名单。请求体为XLSX或CSV文件，
+// network默认default，effective_date（YYYY-MM-DD）必填，sheet指定XLSX工作表
+func importInsurerNetwork(c *gin.Context) {
+	insurer := normalizeInsurer(c.Param("insurer"))
+	network := InsurerNetwork{
+		Insurer: insurer, Network: c.DefaultQuery("network", "default"),
+		EffectiveDate: c.Query("effective_date"), SourceFile: c.Query("source_file"),
+	}
+	if _, err := time.Parse("2006-01-02", network.EffectiveDate); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "effective_date应为YYYY-MM-DD"})
+		return
+	}
+	data, err := ioutil.ReadAll(io.LimitReader(c.Request.Body, 32<<20))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	rows, err := providerRowsFromFile(data, c.Query("sheet"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	providers, err := parseProviderRows(rows)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	if len(providers) == 0 {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "名单中没有医疗机构"})
+		return
+	}
+
+	id, err := importNetworkProviders(network, providers, time.Now())
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+	matched, err := matchedProviders(providers)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+	unmatched := []string{}
+	for i, p := range providers {
+		if !matched[i] {
+			unmatched = append(unmatched, p.Name)
+		}
+	}
+	log.Printf("[直付网络] 导入 %s/%s (%s): %d 家机构，%d 家未匹配到医院",
+		network.Insurer, network.Network, network.EffectiveDate, len(providers), len(unmatched))
+	c.JSON(http.StatusOK, gin.H{
+		"status": "success", "network_id": id, "insurer": network.Insurer, "network": network.Network,
+		"effective_date": network.EffectiveDate, "providers": len(providers),
+		"matched": len(providers) - len(unmatched), "unmatched": unmatched,
+	})
+}
+
+// 已导入的直付网络名单版本
+func listInsurerNetworks(c *gin.Context) {
+	rows, err := db.Query(`
+		SELECT n.id, n.insurer, n.network, n.effective_date, COALESCE(n.source_file, ''), n.imported_at, COUNT(p.id)
+		FROM insurer_networks n LEFT JOIN network_providers p ON p.network_id = n.id
+		GROUP BY n.id ORDER BY n.insurer, n.network, n.effective_date DESC
+	`)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+	defer rows.Close()
+	networks := []InsurerNetwork{}
+	for rows.Next() {
+		var n InsurerNetwork
+		if err := rows.Scan(&n.ID, &n.Insurer, &n.Network, &n.EffectiveDate, &n.SourceFile, &n.ImportedAt, &n.ProviderCount); err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+			return
+		}
+		networks = append(networks, n)
+	}
+	c.JSON(http.StatusOK, gin.H{"status": "success", "count": len(networks), "data": networks})
+}
+
+// 保险公司当前有效名单中的机构，支持city（中文）过滤
+func listInsurerProviders(c *gin.Context) {
+	insurer := normalizeInsurer(c.Param("insurer"))
+	if !insurerKnown(insurer) {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Insurer not found"})
+		return
+	}
+	date := c.DefaultQuery("date", billingDate(""))
+	all := allNetworkProviders()
+	effective := effectiveNetworkIDs(all, date)
+	providers := []networkProviderRow{}
+	for _, r := range all {
+		if r.Insurer == insurer && effective[r.NetworkID] && (c.Query("city") == "" || r.City == c.Query("city")) {
+			providers = append(providers, r)
+		}
+	}
+	sort.SliceStable(providers, func(i, j int) bool { return providers[i].Network < providers[j].Network })
+	data := make([]gin.H, 0, len(providers))
+	for _, p := range providers {
+		data = append(data, gin.H{"network": p.Network, "effective_date": p.EffectiveDate, "provider": p.NetworkProvider})
+	}
+	c.JSON(http.StatusOK, gin.H{"status": "success", "insurer": insurer, "date": date, "count": len(data), "data": data})
+}
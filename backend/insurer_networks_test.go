@@ -0,0 +1,183 @@
+package main
+
+import (
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"strings"
+	"testing"
+)
+
+const cghbDirectBillingFile = "CGHB Public Hospitals Only Direct Billing List 20250701.xlsx"
+
+func TestParseDirectBillingXLSX(t *testing.T) {
+	data, err := ioutil.ReadFile(cghbDirectBillingFile)
+	if err != nil {
+		t.Fatal(err)
+	}
+	sheets, err := readXLSX(data)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(sheets) != 3 || !sheets[1].Hidden || sheets[2].Name != "直付医疗网络列表 Direct Billing List" {
+		t.Fatalf("工作表: %d", len(sheets))
+	}
+
+	// 自动选中含机构名称列的可见工作表
+	rows, err := providerRowsFromFile(data, "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	providers, err := parseProviderRows(rows)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(providers) != 343 {
+		t.Fatalf("机构数 %d", len(providers))
+	}
+	for _, p := range providers {
+		if p.Name == "北京协和医院东单院区国际医疗部" {
+			if !strings.HasPrefix(p.NameEn, "Peking Union Medical College Hospital") || p.City != "北京" ||
+				p.District != "东城区" || strings.Join(p.Services, ",") != "outpatient,inpatient" {
+				t.Fatalf("协和东单院区: %+v", p)
+			}
+			return
+		}
+	}
+	t.Fatal("名单中没有北京协和医院东单院区国际医疗部")
+}
+
+func TestProviderNameScore(t *testing.T) {
+	cases := []struct {
+		hospital, provider string
+		match              bool
+	}{
+		{"北京协和医院", "北京协和医院东单院区国际医疗部", true},
+		{"北京协和医院东单院区", "北京协和医院东单院区国际医疗部", true},
+		{"航空总医院", "航空总医院特需部", true},
+		{"中国中医科学院广安门医院", "中国中医科学院广安门医院国际医疗部", true},
+		{"浙江大学医学院附属第一医院", "浙江大学医学院附属第一医院国际医疗门诊部（庆春院区）", true},
+		{"第二人民医院", "成都市第二人民医院龙潭院区国际医疗部", false},
+		{"北京医院", "北京协和医院东单院区国际医疗部", false},
+	}
+	for _, tc := range cases {
+		score := providerNameScore(tc.hospital, NetworkProvider{Name: tc.provider})
+		if (score >= networkMatchThreshold) != tc.match {
+			t.Errorf("%s / %s: %.2f", tc.hospital, tc.provider, score)
+		}
+	}
+}
+
+func TestInsurerNetworkAPI(t *testing.T) {
+	e := setupE2E(t, nil)
+	for _, name := range []string{"北京协和医院", "北京测试第三医院"} {
+		insertTestHospital(t, name, 116.41, 39.91)
+	}
+	data, err := ioutil.ReadFile(cghbDirectBillingFile)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	path := "/api/admin/insurers/CGHB/networks?network=public&effective_date=2025-07-01&source_file=" + url.QueryEscape(cghbDirectBillingFile)
+	if w := doRequest(e.router, http.MethodPost, path, data, nil); w.Code != http.StatusUnauthorized {
+		t.Fatalf("未认证导入 %d", w.Code)
+	}
+	w, body := e.admin(http.MethodPost, path, string(data))
+	if w.Code != http.StatusOK || body["providers"].(float64) != 343 || body["matched"].(float64) < 1 {
+		t.Fatalf("导入 %d: %v", w.Code, body)
+	}
+	if w, _ := e.admin(http.MethodPost, "/api/admin/insurers/cghb/networks?effective_date=2025-13-01", string(data)); w.Code != http.StatusBadRequest {
+		t.Fatalf("非法生效日期 %d", w.Code)
+	}
+	if w, _ := e.admin(http.MethodPost, "/api/admin/insurers/cghb/networks?effective_date=2025-07-01", "城市,地址\n北京,x\n"); w.Code != http.StatusBadRequest {
+		t.Fatalf("缺少名称列 %d", w.Code)
+	}
+
+	// 未来生效的新版名单不影响当前结果
+	csvBody := "医疗机构,城市,门诊/住院\n北京测试第三医院国际部,北京,门诊 OP\n"
+	if w, body := e.admin(http.MethodPost, "/api/admin/insurers/cghb/networks?network=public&effective_date=2099-01-01", csvBody); w.Code != http.StatusOK ||
+		body["matched"].(float64) != 1 {
+		t.Fatalf("CSV导入 %d: %v", w.Code, body)
+	}
+	_, body = e.get("/api/insurers")
+	if body["count"].(float64) != 2 {
+		t.Fatalf("名单版本: %v", body)
+	}
+	_, body = e.get("/api/insurers/cghb/providers?city=北京")
+	if n := body["count"].(float64); n < 10 || n > 100 {
+		t.Fatalf("北京机构数 %v", n)
+	}
+	if _, body = e.get("/api/insurers/cghb/providers?date=2099-06-01"); body["count"].(float64) != 1 {
+		t.Fatalf("未来名单: %v", body)
+	}
+
+	_, body = e.get("/api/hospitals/1")
+	billing := body["data"].(map[string]interface{})["direct_billing"].([]interface{})
+	entry := billing[0].(map[string]interface{})
+	if len(billing) != 1 || entry["insurer"] != "cghb" || entry["effective_date"] != "2025-07-01" {
+		t.Fatalf("直付网络: %v", billing)
+	}
+
+	w, body = e.get("/api/hospitals/search?insurer=CGHB&radius=100000")
+	if w.Code != http.StatusOK || body["count"].(float64) != 1 {
+		t.Fatalf("按保险公司搜索 %d: %v", w.Code, body)
+	}
+	if name := body["data"].([]interface{})[0].(map[string]interface{})["name"]; name != "北京协和医院" {
+		t.Fatalf("搜索结果: %v", name)
+	}
+	if w, _ := e.get("/api/hospitals/search?insurer=nobody"); w.Code != http.StatusBadRequest {
+		t.Fatalf("未知保险公司 %d", w.Code)
+	}
+	if w, _ := e.get(fmt.Sprintf("/api/insurers/%s/providers", "nobody")); w.Code != http.StatusNotFound {
+		t.Fatalf("未知保险公司名单 %d", w.Code)
+	}
+}
+
+func TestInsurerFilterBeforeLimit(t *testing.T) {
+	e := setupE2E(t, nil)
+	// 离中心更近的医院都不在直付网络中
+	for i := 0; i < 5; i++ {
+		insertTestHospital(t, fmt.Sprintf("东城社区卫生服务站%d", i), 116.4074, 39.9042+float64(i)*0.001, "address", "北京市东城区")
+	}
+	(&HospitalSpider{}).SaveHospitals([]Hospital{
+		{Name: "北京协和医院", Address: "北京市东城区帅府园1号", Latitude: 39.9130, Longitude: 116.4170},
+		{Name: "上海测试医院", Address: "北京市朝阳区", Latitude: 39.9100, Longitude: 116.4100},
+	})
+	csvBody := "医疗机构,城市\n北京协和医院国际医疗部,北京\n上海测试医院,上海\n"
+	w, body := e.admin(http.MethodPost, "/api/admin/insurers/test/networks?effective_date=2025-01-01", csvBody)
+	if w.Code != http.StatusOK || fmt.Sprint(body["unmatched"]) != "[上海测试医院]" {
+		t.Fatalf("导入 %d: %v", w.Code, body)
+	}
+
+	for _, path := range []string{
+		"/api/hospitals/search?insurer=test&limit=3",
+		"/api/hospitals/search?insurer=test&limit=3&bbox=116.3,39.8,116.5,40.0",
+		"/api/hospitals/search?insurer=test&limit=3&as_of=2099-01-01",
+	} {
+		w, body := e.get(path)
+		if w.Code != http.StatusOK || body["count"].(float64) != 1 {
+			t.Fatalf("%s %d: %v", path, w.Code, body)
+		}
+		if name := body["data"].([]interface{})[0].(map[string]interface{})["name"]; name != "北京协和医院" {
+			t.Fatalf("%s: %v", path, name)
+		}
+	}
+	// 名称相同但城市不同的机构不匹配
+	if billing := directBillingFor("上海测试医院", "北京市", "test", "2025-07-01"); len(billing) != 0 {
+		t.Fatalf("城市不同: %v", billing)
+	}
+	if billing := directBillingFor("上海测试医院", "", "test", "2025-07-01"); len(billing) != 1 {
+		t.Fatalf("医院没有城市: %v", billing)
+	}
+	for addr, want := range map[string]string{
+		"北京市东城区帅府园1号":     "北京市",
+		"浙江省杭州市上城区解放路88号": "杭州市",
+		"广西壮族自治区南宁市青秀区":   "南宁市",
+		"帅府园1号": "",
+	} {
+		if got := addressCity(addr); got != want {
+			t.Errorf("%s: %q，应为 %q", addr, got, want)
+		}
+	}
+}
@@ -0,0 +1,452 @@
+package main
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/sha256"
+	"database/sql"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"log"
+	"net/http"
+	"net/url"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+// 台账状态
+const (
+	LedgerStatusOK           = "ok"
+	LedgerStatusCacheHit     = "cache_hit" // 命中响应缓存，未请求上游，记录以便完整重放
+	LedgerStatusHTTPError    = "http_error"
+	LedgerStatusAPIError     = "api_error"
+	LedgerStatusMalformed    = "malformed"
+	LedgerStatusNetworkError = "network_error"
+	LedgerStatusCanceled     = "canceled"
+)
+
+// 上游调用台账记录（upstream_ledger表，只追加）
+type LedgerEntry struct {
+	ID            int64             `json:"id"`
+	RequestID     string            `json:"request_id"`
+	CreatedAt     time.Time         `json:"created_at"`
+	Provider      string            `json:"provider"`
+	Endpoint      string            `json:"endpoint"`
+	Params        map[string]string `json:"params"`
+	Typecode      string            `json:"typecode,omitempty"`
+	Page          int               `json:"page,omitempty"`
+	Status        string            `json:"status"`
+	HTTPStatus    int               `json:"http_status,omitempty"`
+	Infocode      string            `json:"infocode,omitempty"`
+	POICount      int               `json:"poi_count"`
+	LatencyMs     int64             `json:"latency_ms"`
+	Attempts      int               `json:"attempts"`
+	Error         string            `json:"error,omitempty"`
+	PayloadSHA256 string            `json:"payload_sha256,omitempty"`
+	Payload       json.RawMessage   `json:"payload,omitempty"`
+}
+
+type ledgerRequestKey struct{}
+
+// 同一次接口调用产生的上游请求共享一个台账请求ID
+func withLedgerRequest(ctx context.Context, id string) context.Context {
+	return context.WithValue(ctx, ledgerRequestKey{}, id)
+}
+
+func ledgerRequestID(ctx context.Context) string {
+	id, _ := ctx.Value(ledgerRequestKey{}).(string)
+	return id
+}
+
+func newLedgerRequestID() string {
+	b := make([]byte, 8)
+	rand.Read(b)
+	return "req_" + hex.EncodeToString(b)
+}
+
+// 为当前接口请求生成台账请求ID，并通过X-Ledger-Request-ID返回给调用方
+func ledgerContext(c *gin.Context) context.Context {
+	id := newLedgerRequestID()
+	c.Header("X-Ledger-Request-ID", id)
+	return withLedgerRequest(c.Request.Context(), id)
+}
+
+// 根据错误类型确定台账状态
+func ledgerStatus(err error) (string, int) {
+	var httpErr *UpstreamHTTPError
+	var amapErr *AmapError
+	var googleErr *GoogleError
+	var malformed *MalformedResponseError
+	switch {
+	case err == nil:
+		return LedgerStatusOK, http.StatusOK
+	case errors.Is(err, context.Canceled):
+		return LedgerStatusCanceled, 0
+	case errors.As(err, &httpErr):
+		return LedgerStatusHTTPError, httpErr.StatusCode
+	case errors.As(err, &amapErr), errors.As(err, &googleErr):
+		return LedgerStatusAPIError, http.StatusOK
+	case errors.As(err, &malformed):
+		return LedgerStatusMalformed, http.StatusOK
+	}
+	return LedgerStatusNetworkError, 0
+}
+
+// 从请求URL与响应体构建台账记录，参数中的key已隐藏
+func buildLedgerEntry(ctx context.Context, provider, rawURL string, payload []byte, err error) LedgerEntry {
+	entry := LedgerEntry{
+		RequestID: ledgerRequestID(ctx),
+		CreatedAt: time.Now(),
+		Provider:  provider,
+		Params:    map[string]string{},
+	}
+	entry.Status, entry.HTTPStatus = ledgerStatus(err)
+	if err != nil {
+		entry.Error = redactKey(err.Error())
+	}
+	if u, perr := url.Parse(rawURL); perr == nil {
+		entry.Endpoint = strings.TrimPrefix(strings.TrimPrefix(u.Path, "/v3/"), "/maps/api/")
+		for k, v := range u.Query() {
+			if k == "key" {
+				entry.Params[k] = "***"
+				continue
+			}
+			entry.Params[k] = strings.Join(v, ",")
+		}
+		entry.Typecode = entry.Params["types"]
+		entry.Page, _ = strconv.Atoi(entry.Params["page"])
+	}
+	if len(payload) > 0 {
+		sum := sha256.Sum256(payload)
+		entry.PayloadSHA256 = hex.EncodeToString(sum[:])
+		var resp struct {
+			Infocode string        `json:"infocode"`
+			Status   string        `json:"status"`
+			POIs     []interface{} `json:"pois"`
+			Results  []interface{} `json:"results"`
+			Geocodes []interface{} `json:"geocodes"`
+		}
+		if json.Unmarshal(payload, &resp) == nil {
+			entry.Infocode = resp.Infocode
+			if provider == ProviderGoogle {
+				entry.Infocode = resp.Status
+			}
+			entry.POICount = len(resp.POIs) + len(resp.Results) + len(resp.Geocodes)
+		}
+	}
+	return entry
+}
+
+// 写入台账；数据库未初始化时跳过
+func recordLedger(entry LedgerEntry, payload []byte) {
+	if db == nil {
+		return
+	}
+	if entry.PayloadSHA256 != "" {
+		// 相同响应体只存一份
+		db.Exec(`INSERT OR IGNORE INTO ledger_payloads (sha256, body) VALUES (?, ?)`, entry.PayloadSHA256, payload)
+	}
+	params, _ := json.Marshal(entry.Params)
+	_, err := db.Exec(`
+		INSERT INTO upstream_ledger (request_id, created_at, provider, endpoint, params, typecode, page,
+			status, http_status, infocode, poi_count, latency_ms, attempts, error, payload_sha256)
+		VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?)
+	`, entry.RequestID, entry.CreatedAt.UTC().Format(time.RFC3339Nano), entry.Provider, entry.Endpoint, string(params),
+		entry.Typecode, entry.Page, entry.Status, entry.HTTPStatus, entry.Infocode, entry.POICount,
+		entry.LatencyMs, entry.Attempts, entry.Error, entry.PayloadSHA256)
+	if err != nil {
+		log.Printf("[台账] 写入upstream_ledger失败: %v", err)
+	}
+}
+
+// 记录一次上游调用
+func recordUpstreamCall(ctx context.Context, provider, rawURL string, payload []byte, err error, attempts int, latency time.Duration) {
+	entry := buildLedgerEntry(ctx, provider, rawURL, payload, err)
+	entry.Attempts = attempts
+	entry.LatencyMs = latency.Milliseconds()
+	recordLedger(entry, payload)
+}
+
+// 记录一次缓存命中，重放时与上游响应同等对待
+func recordCacheHit(ctx context.Context, provider, rawURL string, payload []byte) {
+	entry := buildLedgerEntry(ctx, provider, rawURL, payload, nil)
+	entry.Status = LedgerStatusCacheHit
+	entry.HTTPStatus = 0
+	recordLedger(entry, payload)
+}
+
+const ledgerColumns = `l.id, l.request_id, l.created_at, l.provider, l.endpoint, l.params, l.typecode, l.page,
+	l.status, l.http_status, l.infocode, l.poi_count, l.latency_ms, l.attempts, l.error, l.payload_sha256`
+
+func scanLedgerEntry(rows interface{ Scan(...interface{}) error }, withPayload bool) (LedgerEntry, error) {
+	var e LedgerEntry
+	var requestID, params, typecode, infocode, errText, sha sql.NullString
+	var createdAt string
+	var page, httpStatus, poiCount, attempts sql.NullInt64
+	var latency sql.NullInt64
+	var payload []byte
+	dest := []interface{}{&e.ID, &requestID, &createdAt, &e.Provider, &e.Endpoint, &params, &typecode, &page,
+		&e.Status, &httpStatus, &infocode, &poiCount, &latency, &attempts, &errText, &sha}
+	if withPayload {
+		dest = append(dest, &payload)
+	}
+	if err := rows.Scan(dest...); err != nil {
+		return e, err
+	}
+	e.RequestID = requestID.String
+	e.CreatedAt, _ = time.Parse(time.RFC3339Nano, createdAt)
+	json.Unmarshal([]byte(params.String), &e.Params)
+	e.Typecode = typecode.String
+	e.Page = int(page.Int64)
+	e.HTTPStatus = int(httpStatus.Int64)
+	e.Infocode = infocode.String
+	e.POICount = int(poiCount.Int64)
+	e.LatencyMs = latency.Int64
+	e.Attempts = int(attempts.Int64)
+	e.Error = errText.String
+	e.PayloadSHA256 = sha.String
+	if len(payload) > 0 {
+		e.Payload = json.RawMessage(payload)
+	}
+	return e, nil
+}
+
+// 台账查询条件
+type ledgerFilter struct {
+	IDs       []int64
+	RequestID string
+	Provider  string
+	Endpoint  string
+	Status    string
+	Typecode  string
+	Since     string
+	Until     string
+	Limit     int
+	Offset    int
+}
+
+func (f ledgerFilter) where() (string, []interface{}) {
+	var conds []string
+	var args []interface{}
+	add := func(cond string, arg interface{}) {
+		conds = append(conds, cond)
+		args = append(args, arg)
+	}
+	if len(f.IDs) > 0 {
+		placeholders := make([]string, len(f.IDs))
+		for i, id := range f.IDs {
+			placeholders[i] = "?"
+			args = append(args, id)
+		}
+		conds = append(conds, "l.id IN ("+strings.Join(placeholders, ",")+")")
+	}
+	if f.RequestID != "" {
+		add("l.request_id = ?", f.RequestID)
+	}
+	if f.Provider != "" {
+		add("l.provider = ?", f.Provider)
+	}
+	if f.Endpoint != "" {
+		add("l.endpoint = ?", f.Endpoint)
+	}
+	if f.Status != "" {
+		add("l.status = ?", f.Status)
+	}
+	if f.Typecode != "" {
+		add("l.typecode = ?", f.Typecode)
+	}
+	if f.Since != "" {
+		add("l.created_at >= ?", f.Since)
+	}
+	if f.Until != "" {
+		add("l.created_at < ?", f.Until)
+	}
+	if len(conds) == 0 {
+		return "", args
+	}
+	return " WHERE " + strings.Join(conds, " AND "), args
+}
+
+// 查询台账，按id倒序；withPayload时附带原始响应体
+func queryLedger(f ledgerFilter, withPayload bool) ([]LedgerEntry, int, error) {
+	where, args := f.where()
+	var total int
+	if err := db.QueryRow(`SELECT COUNT(*) FROM upstream_ledger l`+where, args...).Scan(&total); err != nil {
+		return nil, 0, err
+	}
+	query := `SELECT ` + ledgerColumns
+	if withPayload {
+		query += `, p.body FROM upstream_ledger l LEFT JOIN ledger_payloads p ON p.sha256 = l.payload_sha256`
+	} else {
+		query += ` FROM upstream_ledger l`
+	}
+	query += where + ` ORDER BY l.id DESC`
+	if f.Limit > 0 {
+		query += ` LIMIT ? OFFSET ?`
+		args = append(args, f.Limit, f.Offset)
+	}
+	rows, err := db.Query(query, args...)
+	if err != nil {
+		return nil, 0, err
+	}
+	defer rows.Close()
+	entries := []LedgerEntry{}
+	for rows.Next() {
+		e, err := scanLedgerEntry(rows, withPayload)
+		if err != nil {
+			return nil, 0, err
+		}
+		entries = append(entries, e)
+	}
+	return entries, total, rows.Err()
+}
+
+// 将周边查询台账记录还原为合并流程的输入，按配置的typecode顺序、页码排列
+func ledgerEntriesToRecords(entries []LedgerEntry, typecodes []string) []RawPOIRecord {
+	order := make(map[string]int, len(typecodes))
+	for i, tc := range typecodes {
+		order[tc] = i
+	}
+	rank := func(tc string) int {
+		if i, ok := order[tc]; ok {
+			return i
+		}
+		return len(typecodes)
+	}
+	var usable []LedgerEntry
+	for _, e := range entries {
+		if strings.HasPrefix(e.Endpoint, "place/around") && (e.Status == LedgerStatusOK || e.Status == LedgerStatusCacheHit) && len(e.Payload) > 0 {
+			usable = append(usable, e)
+		}
+	}
+	sort.SliceStable(usable, func(i, j int) bool {
+		a, b := usable[i], usable[j]
+		if rank(a.Typecode) != rank(b.Typecode) {
+			return rank(a.Typecode) < rank(b.Typecode)
+		}
+		if a.Typecode != b.Typecode {
+			return a.Typecode < b.Typecode
+		}
+		if a.Page != b.Page {
+			return a.Page < b.Page
+		}
+		return a.ID < b.ID
+	})
+	var records []RawPOIRecord
+	for _, e := range usable {
+		var resp map[string]interface{}
+		json.Unmarshal(e.Payload, &resp)
+		pois, _ := resp["pois"].([]interface{})
+		count, _ := parseFloatFromAny(resp["count"])
+		records = append(records, RawPOIRecord{Typecode: e.Typecode, Page: e.Page, Count: int(count), POIs: pois})
+	}
+	return records
+}
+
+func parseLedgerFilter(c *gin.Context) ledgerFilter {
+	f := ledgerFilter{
+		RequestID: c.Query("request_id"),
+		Provider:  c.Query("provider"),
+		Endpoint:  c.Query("endpoint"),
+		Status:    c.Query("status"),
+		Typecode:  c.Query("typecode"),
+		Since:     parseLedgerTime(c.Query("since")),
+		Until:     parseLedgerTime(c.Query("until")),
+	}
+	f.Limit, _ = strconv.Atoi(c.DefaultQuery("limit", "50"))
+	if f.Limit <= 0 || f.Limit > 500 {
+		f.Limit = 50
+	}
+	f.Offset, _ = strconv.Atoi(c.DefaultQuery("offset", "0"))
+	return f
+}
+
+// 时间参数支持RFC3339与日期，统一转为UTC以便与created_at比较
+func parseLedgerTime(v string) string {
+	if v == "" {
+		return ""
+	}
+	if t, err := time.Parse(time.RFC3339, v); err == nil {
+		return t.UTC().Format(time.RFC3339Nano)
+	}
+	if t, err := time.ParseInLocation("2006-01-02", v, quotaZone); err == nil {
+		return t.UTC().Format(time.RFC3339Nano)
+	}
+	return v
+}
+
+// 管理员：浏览台账
+func listLedger(c *gin.Context) {
+	f := parseLedgerFilter(c)
+	entries, total, err := queryLedger(f, false)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"status": "success", "data": entries, "total": total, "limit": f.Limit, "offset": f.Offset})
+}
+
+// 管理员：查看单条台账及原始响应体
+func getLedgerEntry(c *gin.Context) {
+	id, err := strconv.ParseInt(c.Param("id"), 10, 64)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "无效的台账ID"})
+		return
+	}
+	entries, _, err := queryLedger(ledgerFilter{IDs: []int64{id}}, true)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+	if len(entries) == 0 {
+		c.JSON(http.StatusNotFound, gin.H{"error": "台账记录不存在"})
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"status": "success", "data": entries[0]})
+}
+
+// 管理员：将台账记录重放到合并流程
+func replayLedger(c *gin.Context) {
+	var req struct {
+		RequestID string  `json:"request_id"`
+		IDs       []int64 `json:"ids"`
+		Profile   string  `json:"profile"`
+	}
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	if req.RequestID == "" && len(req.IDs) == 0 {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "request_id或ids必填"})
+		return
+	}
+	profile, ok := mergeProfileByName(req.Profile)
+	if !ok {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "未知的合并配置: " + req.Profile})
+		return
+	}
+	entries, _, err := queryLedger(ledgerFilter{RequestID: req.RequestID, IDs: req.IDs}, true)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+	records := ledgerEntriesToRecords(entries, profile.Typecodes)
+	if len(records) == 0 {
+		c.JSON(http.StatusNotFound, gin.H{"error": "没有可重放的周边查询台账"})
+		return
+	}
+	result := mergeLedger(records, profile)
+	classifyMergedPOIs(result)
+	result["replay"] = gin.H{
+		"request_id": req.RequestID,
+		"entries":    len(entries),
+		"pages":      len(records),
+		"profile":    profile.Name,
+	}
+	c.JSON(http.StatusOK, result)
+}
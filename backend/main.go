@@ -1,8 +1,9 @@
 package main
 
 import (
-	"bytes" // 用于将body重新包装为io.Reader
+	"context"
 	"database/sql"
+	"errors"
 	"fmt"
 	"io"  // 用于读取HTTP响应体
 	"log" // 用于输出日志
@@ -42,6 +43,14 @@ type Hospital struct {
 	Distance        float64 `json:"distance,omitempty"`
 	Rating          float64 `json:"rating,omitempty"`
 	Confidence      float64 `json:"confidence,omitempty"`
+	// 按搜索用途计算距离时的最近出入口
+	NearestEntrance *Entrance `json:"nearest_entrance,omitempty"`
+	// 医院等级及其来源
+	Grade *HospitalGrade `json:"grade,omitempty"`
+	// 保险直付网络
+	DirectBilling []DirectBilling `json:"direct_billing,omitempty"`
+	// 坐标所用的坐标系
+	CRS string `json:"crs,omitempty"`
 }
 
 type Rating struct {
@@ -79,11 +88,17 @@ type SearchResponse struct {
 	Status string     `json:"status"`
 	Count  int        `json:"count"`
 	Data   []Hospital `json:"data"`
+	AsOf   string     `json:"as_of,omitempty"`
+	CRS    string     `json:"crs,omitempty"`
 }
 
 type DetailResponse struct {
-	Status string   `json:"status"`
-	Data   Hospital `json:"data"`
+	Status   string       `json:"status"`
+	Data     Hospital     `json:"data"`
+	AsOf     string       `json:"as_of,omitempty"`
+	POIID    string       `json:"poi_id,omitempty"`
+	Children *POIChildren `json:"children,omitempty"`
+	Campus   *Campus      `json:"campus,omitempty"`
 }
 
 type FeedbackRequest struct {
@@ -198,26 +213,68 @@ func isInRadius(poiLocation string, centerLng, centerLat, radius float64) bool {
 }
 
 func main() {
+	// 子命令：replay 离线重放合并流程
+	if len(os.Args) > 1 && os.Args[1] == "replay" {
+		os.Exit(runReplayCommand(os.Args[2:]))
+	}
+	// 子命令：fake-amap 本地高德模拟服务
+	if len(os.Args) > 1 && os.Args[1] == "fake-amap" {
+		os.Exit(runFakeAmapCommand(os.Args[2:]))
+	}
+	// 子命令：export / import XLSX导出与医院导入
+	if len(os.Args) > 1 && os.Args[1] == "export" {
+		os.Exit(runExportCommand(os.Args[2:]))
+	}
+	if len(os.Args) > 1 && os.Args[1] == "import" {
+		os.Exit(runImportCommand(os.Args[2:]))
+	}
+
 	// 自动加载.env文件
 	_ = godotenv.Load(".env")
 	fmt.Println("AMAP_KEY from env:", os.Getenv("AMAP_KEY"))
 
-	// 启动时健康检查AMAP_KEY
-	checkAmapKeyHealth()
+	// 初始化限流与上游配额
+	initRateLimits()
 
-	// 初始化本地缓存
+	// 初始化上游HTTP客户端
+	initUpstreamClients()
+	initFetchLimits()
+	initGoogleCRS()
+	initResponseCache()
+
+	// 初始化分类体系与本地缓存
+	initTaxonomy()
 	initLocalGeocodeCache()
 	loadStaticTier3POIs()
 
 	// 初始化数据库
 	initDB()
 	defer db.Close()
+	initAuth()
+
+	// 离线模式依赖数据库与缓存；启动时健康检查AMAP_KEY（记入台账）
+	initOfflineMode()
+	initCrawler()
+	checkAmapKeyHealth()
 
 	fmt.Println("Database initialized successfully")
 	fmt.Println("Local cache initialized successfully")
 
-	// 创建 Gin 路由
+	r := setupRouter()
+
+	// 启动服务器
+	fmt.Println("Server starting on http://localhost:8080")
+	fmt.Println("Press Ctrl+C to stop the server")
+	r.Run(":8080")
+}
+
+// 创建路由：中间件与全部API，测试中可直接使用
+func setupRouter() *gin.Engine {
 	r := gin.Default()
+	// 匿名请求按客户端IP限流，只信任配置的代理转发的X-Forwarded-For
+	if err := r.SetTrustedProxies(trustedProxies()); err != nil {
+		log.Fatalf("[限流] TRUSTED_PROXIES无效: %v", err)
+	}
 
 	// 全局recover，捕获所有panic
 	r.Use(func(c *gin.Context) {
@@ -232,11 +289,19 @@ func main() {
 
 	// 配置 CORS
 	config := cors.DefaultConfig()
-	config.AllowAllOrigins = true
+	if origins := os.Getenv("CORS_ORIGIN"); origins != "" && origins != "*" {
+		config.AllowOrigins = strings.Split(origins, ",")
+	} else {
+		config.AllowAllOrigins = true
+	}
 	config.AllowMethods = []string{"GET", "POST", "PUT", "DELETE", "OPTIONS"}
-	config.AllowHeaders = []string{"Origin", "Content-Type", "Accept", "Authorization"}
+	config.AllowHeaders = []string{"Origin", "Content-Type", "Accept", "Authorization", "X-API-Key"}
 	r.Use(cors.New(config))
 
+	// 鉴权：解析API Key/JWT，具体权限由各路由声明
+	r.Use(authMiddleware())
+	r.Use(rateLimitMiddleware(clientLimiter))
+
 	// API 路由
 	api := r.Group("/api")
 	{
@@ -244,6 +309,7 @@ func main() {
 		api.GET("/hospitals", getHospitals)
 		api.GET("/hospitals/search", searchHospitals)
 		api.GET("/hospitals/:id", getHospitalDetail)
+		api.GET("/hospitals/:id/history", getHospitalHistory)
 
 		// 医院评级 API
 		api.GET("/hospitals/:id/ratings", getHospitalRatingsAPI)
@@ -251,20 +317,81 @@ func main() {
 		api.GET("/hospitals/:id/feedback", getHospitalFeedback)
 
 		// 用户反馈 API
-		api.POST("/hospitals/:id/feedback", submitFeedback)
+		api.POST("/hospitals/:id/feedback", requireRole(RoleContributor), submitFeedback)
 		api.GET("/places/hospitals", getNearbyHospitals)
+
+		// 已抓取POI详情（含科室、楼宇、出入口等子POI）
+		api.GET("/pois/:id", getPOIDetail)
+		// 按矩形、多边形或行政区范围搜索已抓取POI
+		api.GET("/pois/search", searchStoredPOIs)
+
+		// 抓取间POI变化记录
+		api.GET("/changes", getPOIChanges)
+
+		// 医院分类体系（图例、筛选）
+		api.GET("/taxonomy", getTaxonomy)
+
+		// 医院等级登记
+		api.GET("/grades", listHospitalGrades)
+		api.GET("/grades/match", matchHospitalGrade)
+		api.GET("/grades/:id", getHospitalGradeEntry)
+
+		// 保险直付网络
+		api.GET("/insurers", listInsurerNetworks)
+		api.GET("/insurers/:insurer/providers", listInsurerProviders)
+
+		// 数据导出（XLSX、CSV、GeoJSON、KML）
+		api.GET("/export/:dataset", requireRole(RoleViewer), exportDataset)
+
+		// 地图聚合与矢量瓦片
+		api.GET("/map/clusters", getMapClusters)
+		api.GET("/map/tiles/:z/:x/:y", getMapTile)
+
+		// 行政区划边界
+		api.GET("/districts", listDistricts)
+		api.GET("/districts/:adcode", getDistrict)
+
+		// 健康检查 API（含上游熔断状态）
+		api.GET("/health", getHealth)
+
+		// 鉴权 API
+		api.POST("/auth/token", issueToken)
+		api.GET("/auth/me", requireRole(RoleViewer), getCurrentUser)
+	}
+
+	// 管理员 API
+	admin := r.Group("/api/admin", requireRole(RoleAdmin))
+	{
+		admin.GET("/users", listUsers)
+		admin.POST("/users", createUser)
+		admin.PUT("/users/:id", updateUser)
+		admin.POST("/users/:id/rotate-key", rotateUserKey)
+		admin.GET("/quotas", getUpstreamQuotas)
+		admin.GET("/cache", getCacheStats)
+		admin.DELETE("/cache", purgeCache)
+		admin.POST("/offline/reload", reloadOfflineData)
+		admin.GET("/ledger", listLedger)
+		admin.GET("/ledger/:id", getLedgerEntry)
+		admin.POST("/ledger/replay", replayLedger)
+		admin.GET("/crawls", listCrawlJobs)
+		admin.POST("/crawls", createCrawlJob)
+		admin.GET("/crawls/:id", getCrawlJobHandler)
+		admin.GET("/crawls/:id/events", streamCrawlJob)
+		admin.POST("/crawls/:id/cancel", cancelCrawlJob)
+		admin.POST("/crawls/:id/resume", resumeCrawlJob)
+		admin.POST("/grades", importHospitalGrades)
+		admin.POST("/insurers/:insurer/networks", importInsurerNetwork)
+		admin.POST("/import/hospitals", importHospitalsFile)
+		admin.POST("/districts", importDistrictsHandler)
 	}
 
-	// 启动服务器
-	fmt.Println("Server starting on http://localhost:8080")
-	fmt.Println("Press Ctrl+C to stop the server")
 	r.GET("/api/amap/geo", AmapGeoProxy)
 	r.GET("/api/amap/around", AmapAroundProxy)
 
 	// 新增：合并POI结果API
 	r.GET("/api/merged-pois", getMergedPois)
 
-	r.Run(":8080")
+	return r
 }
 
 // 初始化数据库
@@ -280,6 +407,12 @@ func initDB() {
 
 	// 插入示例数据
 	insertSampleData()
+
+	// 补建医院历史初始版本
+	backfillHospitalVersions()
+
+	// 匹配尚无等级关联的医院和POI
+	relinkGrades()
 }
 
 // 创建数据库表
@@ -297,7 +430,8 @@ func createTables() {
 			business_hours TEXT,
 			qualifications TEXT,
 			created_at DATETIME DEFAULT CURRENT_TIMESTAMP,
-			updated_at DATETIME DEFAULT CURRENT_TIMESTAMP
+			updated_at DATETIME DEFAULT CURRENT_TIMESTAMP,
+			crs TEXT NOT NULL DEFAULT 'gcj02'
 		)`,
 		`CREATE TABLE IF NOT EXISTS ratings (
 			id INTEGER PRIMARY KEY AUTOINCREMENT,
@@ -330,6 +464,202 @@ func createTables() {
 			created_at DATETIME DEFAULT CURRENT_TIMESTAMP,
 			FOREIGN KEY (hospital_id) REFERENCES hospitals(id)
 		)`,
+		`CREATE TABLE IF NOT EXISTS users (
+			id INTEGER PRIMARY KEY AUTOINCREMENT,
+			username TEXT NOT NULL UNIQUE,
+			role TEXT NOT NULL DEFAULT 'viewer',
+			api_key_hash TEXT NOT NULL UNIQUE,
+			disabled INTEGER DEFAULT 0,
+			created_at DATETIME DEFAULT CURRENT_TIMESTAMP
+		)`,
+		`CREATE TABLE IF NOT EXISTS upstream_ledger (
+			id INTEGER PRIMARY KEY AUTOINCREMENT,
+			request_id TEXT,
+			created_at DATETIME NOT NULL,
+			provider TEXT NOT NULL,
+			endpoint TEXT NOT NULL,
+			params TEXT,
+			typecode TEXT,
+			page INTEGER,
+			status TEXT NOT NULL,
+			http_status INTEGER,
+			infocode TEXT,
+			poi_count INTEGER,
+			latency_ms INTEGER,
+			attempts INTEGER,
+			error TEXT,
+			payload_sha256 TEXT
+		)`,
+		`CREATE INDEX IF NOT EXISTS idx_upstream_ledger_request ON upstream_ledger(request_id)`,
+		`CREATE INDEX IF NOT EXISTS idx_upstream_ledger_created ON upstream_ledger(created_at)`,
+		`CREATE TABLE IF NOT EXISTS ledger_payloads (
+			sha256 TEXT PRIMARY KEY,
+			body BLOB NOT NULL
+		)`,
+		// 台账只允许追加
+		`CREATE TRIGGER IF NOT EXISTS upstream_ledger_no_update BEFORE UPDATE ON upstream_ledger
+		BEGIN SELECT RAISE(ABORT, 'upstream_ledger is append-only'); END`,
+		`CREATE TRIGGER IF NOT EXISTS upstream_ledger_no_delete BEFORE DELETE ON upstream_ledger
+		BEGIN SELECT RAISE(ABORT, 'upstream_ledger is append-only'); END`,
+		// 后台抓取任务
+		`CREATE TABLE IF NOT EXISTS crawl_jobs (
+			id INTEGER PRIMARY KEY AUTOINCREMENT,
+			area TEXT NOT NULL,
+			polygon TEXT NOT NULL,
+			typecodes TEXT NOT NULL,
+			provider TEXT NOT NULL,
+			tile_radius INTEGER NOT NULL,
+			trigger TEXT NOT NULL,
+			status TEXT NOT NULL,
+			tiles_total INTEGER NOT NULL DEFAULT 0,
+			tiles_done INTEGER NOT NULL DEFAULT 0,
+			tiles_truncated INTEGER NOT NULL DEFAULT 0,
+			pois_found INTEGER NOT NULL DEFAULT 0,
+			errors INTEGER NOT NULL DEFAULT 0,
+			last_error TEXT,
+			created_at TEXT NOT NULL,
+			started_at TEXT,
+			finished_at TEXT
+		)`,
+		`CREATE TABLE IF NOT EXISTS crawl_tiles (
+			job_id INTEGER NOT NULL,
+			idx INTEGER NOT NULL,
+			longitude REAL NOT NULL,
+			latitude REAL NOT NULL,
+			status TEXT NOT NULL,
+			pois INTEGER NOT NULL DEFAULT 0,
+			attempts INTEGER NOT NULL DEFAULT 0,
+			error TEXT,
+			radius INTEGER NOT NULL DEFAULT 0,
+			typecodes TEXT,
+			truncated INTEGER NOT NULL DEFAULT 0,
+			updated_at TEXT,
+			PRIMARY KEY (job_id, idx)
+		)`,
+		// 抓取到的POI，按高德POI id去重
+		`CREATE TABLE IF NOT EXISTS pois (
+			id TEXT PRIMARY KEY,
+			name TEXT NOT NULL,
+			address TEXT,
+			typecode TEXT,
+			query_typecode TEXT,
+			longitude REAL,
+			latitude REAL,
+			tel TEXT,
+			raw TEXT,
+			first_seen TEXT NOT NULL,
+			last_seen TEXT NOT NULL,
+			last_job_id INTEGER,
+			first_job_id INTEGER,
+			removed_at TEXT,
+			parent_id TEXT,
+			childtype TEXT,
+			crs TEXT NOT NULL DEFAULT 'gcj02'
+		)`,
+		`CREATE INDEX IF NOT EXISTS idx_pois_last_job ON pois(last_job_id)`,
+		// 两次抓取之间的POI变化
+		`CREATE TABLE IF NOT EXISTS poi_changes (
+			id INTEGER PRIMARY KEY AUTOINCREMENT,
+			job_id INTEGER,
+			poi_id TEXT NOT NULL,
+			change_type TEXT NOT NULL,
+			name TEXT,
+			old_value TEXT,
+			new_value TEXT,
+			distance_m REAL,
+			matched_poi_id TEXT,
+			detected_at TEXT NOT NULL
+		)`,
+		`CREATE INDEX IF NOT EXISTS idx_poi_changes_poi ON poi_changes(poi_id)`,
+		`CREATE INDEX IF NOT EXISTS idx_poi_changes_detected ON poi_changes(detected_at)`,
+		// 医院历史版本
+		`CREATE TABLE IF NOT EXISTS hospital_versions (
+			id INTEGER PRIMARY KEY AUTOINCREMENT,
+			hospital_id INTEGER NOT NULL,
+			version INTEGER NOT NULL,
+			name TEXT NOT NULL,
+			address TEXT NOT NULL,
+			latitude REAL NOT NULL,
+			longitude REAL NOT NULL,
+			phone TEXT,
+			hospital_type TEXT,
+			main_departments TEXT,
+			business_hours TEXT,
+			qualifications TEXT,
+			valid_from TEXT NOT NULL,
+			valid_to TEXT,
+			source TEXT,
+			changed_fields TEXT,
+			UNIQUE (hospital_id, version)
+		)`,
+		`CREATE INDEX IF NOT EXISTS idx_hospital_versions_valid ON hospital_versions(hospital_id, valid_from)`,
+		// 医院等级登记
+		`CREATE TABLE IF NOT EXISTS hospital_grades (
+			id INTEGER PRIMARY KEY AUTOINCREMENT,
+			name TEXT NOT NULL,
+			aliases TEXT,
+			grade TEXT NOT NULL,
+			level TEXT,
+			province TEXT,
+			registration_id TEXT,
+			source TEXT,
+			imported_at TEXT
+		)`,
+		`CREATE INDEX IF NOT EXISTS idx_hospital_grades_registration ON hospital_grades(registration_id)`,
+		`CREATE INDEX IF NOT EXISTS idx_hospital_grades_name ON hospital_grades(name)`,
+		// 登记与医院/已抓取POI的匹配结果，kind为hospital或poi，grade_id为0表示无匹配
+		`CREATE TABLE IF NOT EXISTS grade_links (
+			kind TEXT NOT NULL,
+			ref_id TEXT NOT NULL,
+			name TEXT NOT NULL,
+			grade_id INTEGER NOT NULL DEFAULT 0,
+			matched_name TEXT,
+			score REAL,
+			PRIMARY KEY (kind, ref_id)
+		)`,
+		`CREATE INDEX IF NOT EXISTS idx_grade_links_grade ON grade_links(grade_id)`,
+		// 保险直付网络名单
+		`CREATE TABLE IF NOT EXISTS insurer_networks (
+			id INTEGER PRIMARY KEY AUTOINCREMENT,
+			insurer TEXT NOT NULL,
+			network TEXT NOT NULL,
+			effective_date TEXT NOT NULL,
+			source_file TEXT,
+			imported_at TEXT NOT NULL,
+			UNIQUE (insurer, network, effective_date)
+		)`,
+		`CREATE TABLE IF NOT EXISTS network_providers (
+			id INTEGER PRIMARY KEY AUTOINCREMENT,
+			network_id INTEGER NOT NULL,
+			name TEXT NOT NULL,
+			name_en TEXT,
+			province TEXT,
+			city TEXT,
+			district TEXT,
+			address TEXT,
+			services TEXT,
+			phone TEXT,
+			provider_type TEXT,
+			note TEXT
+		)`,
+		`CREATE INDEX IF NOT EXISTS idx_network_providers_network ON network_providers(network_id)`,
+		// 行政区划边界，geometry为GeoJSON MultiPolygon坐标，min/max为外接矩形
+		`CREATE TABLE IF NOT EXISTS districts (
+			adcode TEXT PRIMARY KEY,
+			name TEXT NOT NULL,
+			level TEXT,
+			parent_adcode TEXT,
+			center TEXT,
+			min_lng REAL NOT NULL,
+			min_lat REAL NOT NULL,
+			max_lng REAL NOT NULL,
+			max_lat REAL NOT NULL,
+			geometry TEXT NOT NULL,
+			source TEXT,
+			imported_at TEXT NOT NULL,
+			crs TEXT NOT NULL DEFAULT 'gcj02'
+		)`,
+		`CREATE INDEX IF NOT EXISTS idx_districts_name ON districts(name)`,
 	}
 
 	for _, query := range queries {
@@ -338,6 +668,51 @@ func createTables() {
 			log.Printf("Error creating table: %v", err)
 		}
 	}
+
+	// 旧库补充新增列及其索引
+	addMissingColumns("pois", [][2]string{{"first_job_id", "INTEGER"}, {"removed_at", "TEXT"}, {"parent_id", "TEXT"}, {"childtype", "TEXT"}, {"crs", "TEXT NOT NULL DEFAULT 'gcj02'"}})
+	addMissingColumns("hospitals", [][2]string{{"crs", "TEXT NOT NULL DEFAULT 'gcj02'"}})
+	addMissingColumns("districts", [][2]string{{"crs", "TEXT NOT NULL DEFAULT 'gcj02'"}})
+	addMissingColumns("crawl_jobs", [][2]string{{"tiles_truncated", "INTEGER NOT NULL DEFAULT 0"}})
+	addMissingColumns("crawl_tiles", [][2]string{{"radius", "INTEGER NOT NULL DEFAULT 0"}, {"typecodes", "TEXT"}, {"truncated", "INTEGER NOT NULL DEFAULT 0"}})
+	for _, query := range []string{
+		`CREATE INDEX IF NOT EXISTS idx_pois_first_job ON pois(first_job_id)`,
+		`CREATE INDEX IF NOT EXISTS idx_pois_parent ON pois(parent_id)`,
+		`CREATE INDEX IF NOT EXISTS idx_pois_location ON pois(longitude, latitude)`,
+	} {
+		if _, err := db.Exec(query); err != nil {
+			log.Printf("Error creating table: %v", err)
+		}
+	}
+	// 旧数据未标注坐标系，按高德的GCJ-02处理；标注为其他坐标系的转为存储坐标系
+	normalizeStoredCRS()
+}
+
+// CREATE TABLE IF NOT EXISTS不会修改已存在的表，缺少的列用ALTER TABLE补充
+func addMissingColumns(table string, columns [][2]string) {
+	rows, err := db.Query(`PRAGMA table_info(` + table + `)`)
+	if err != nil {
+		log.Printf("Error reading columns of %s: %v", table, err)
+		return
+	}
+	existing := map[string]bool{}
+	for rows.Next() {
+		var cid, notNull, pk int
+		var name, colType string
+		var dflt sql.NullString
+		if rows.Scan(&cid, &name, &colType, &notNull, &dflt, &pk) == nil {
+			existing[name] = true
+		}
+	}
+	rows.Close()
+	for _, col := range columns {
+		if existing[col[0]] {
+			continue
+		}
+		if _, err := db.Exec(`ALTER TABLE ` + table + ` ADD COLUMN ` + col[0] + ` ` + col[1]); err != nil {
+			log.Printf("Error adding column %s.%s: %v", table, col[0], err)
+		}
+	}
 }
 
 // 插入示例数据
@@ -398,6 +773,30 @@ func getHospitals(c *gin.Context) {
 		}
 	}
 
+	crs, ok := crsParam(c)
+	if !ok {
+		return
+	}
+
+	// 历史时间点查询
+	asOf, ok := asOfParam(c)
+	if !ok {
+		return
+	}
+	if asOf != "" {
+		hospitals, err := listHospitalsAsOf(asOf, limit, skip)
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+			return
+		}
+		for i := range hospitals {
+			hospitals[i].applyGrade()
+		}
+		hospitalsToCRS(hospitals, crs)
+		c.JSON(http.StatusOK, SearchResponse{Status: "success", Count: len(hospitals), Data: hospitals, AsOf: c.Query("as_of"), CRS: crs})
+		return
+	}
+
 	rows, err := db.Query(`
 		SELECT id, name, address, latitude, longitude, phone, hospital_type, main_departments, business_hours, qualifications, created_at, updated_at
 		FROM hospitals
@@ -420,11 +819,17 @@ func getHospitals(c *gin.Context) {
 		}
 		hospitals = append(hospitals, h)
 	}
+	// 等级匹配可能查询登记表，须在遍历结果集之后进行
+	for i := range hospitals {
+		hospitals[i].applyGrade()
+	}
+	hospitalsToCRS(hospitals, crs)
 
 	response := SearchResponse{
 		Status: "success",
 		Count:  len(hospitals),
 		Data:   hospitals,
+		CRS:    crs,
 	}
 
 	c.JSON(http.StatusOK, response)
@@ -437,6 +842,22 @@ func searchHospitals(c *gin.Context) {
 	radiusStr := c.Query("radius")
 	limitStr := c.Query("limit")
 	_ = c.Query("landmark") // 暂时未使用
+	purpose, ok := purposeParam(c)
+	if !ok {
+		return
+	}
+	insurer, ok := insurerParam(c)
+	if !ok {
+		return
+	}
+	format, ok := exportFormatParam(c, ExportFormatJSON)
+	if !ok {
+		return
+	}
+	crs, ok := crsParam(c)
+	if !ok {
+		return
+	}
 
 	// 默认参数
 	lat := 39.9042 // 北京默认坐标
@@ -465,25 +886,101 @@ func searchHospitals(c *gin.Context) {
 			limit = l
 		}
 	}
+	// 请求坐标转为存储坐标系，与库中数据在同一坐标系下计算距离
+	if latStr != "" && lngStr != "" {
+		lng, lat = convertCoord(lng, lat, crs, storageCRS)
+	}
+
+	// 历史时间点查询只使用有版本记录的数据库数据
+	asOf, ok := asOfParam(c)
+	if !ok {
+		return
+	}
+	// 按保险公司过滤：只保留该保险公司直付网络中的医院，须在截取前筛选
+	billingOn := billingDate(asOf)
+	billed := func(h *Hospital, city string) bool {
+		if insurer == "" {
+			return true
+		}
+		h.DirectBilling = directBillingFor(h.Name, city, insurer, billingOn)
+		return len(h.DirectBilling) > 0
+	}
+	billedOnly := func(hospitals []Hospital) []Hospital {
+		filtered := hospitals[:0]
+		for _, h := range hospitals {
+			if billed(&h, addressCity(h.Address)) {
+				filtered = append(filtered, h)
+			}
+		}
+		return filtered
+	}
+	// 范围搜索（bbox / polygon / district）不使用半径，只搜数据库数据
+	area, ok := searchAreaParam(c, crs)
+	if !ok {
+		return
+	}
+	if asOf != "" && area == nil {
+		stored, err := listHospitalsAsOf(asOf, -1, 0)
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+			return
+		}
+		// 与当前状态搜索一样按出入口距离筛选半径、排序后再截取
+		center := lngLat{lng, lat}
+		hospitals := hospitalsInRadius(billedOnly(stored), center, radius+campusMatchRadius/1000)
+		hospitals = nearestEntranceDistances(hospitals, center, purpose, radius, limit)
+		for i := range hospitals {
+			hospitals[i].Rating, hospitals[i].Confidence = getHospitalRating(hospitals[i].ID)
+			hospitals[i].applyGrade()
+		}
+		respondHospitals(c, format, crs, SearchResponse{Status: "success", Count: len(hospitals), Data: hospitals, AsOf: c.Query("as_of")})
+		return
+	}
 
-	// 优先使用本地JSON数据
-	loadStaticTier3POIs()
-	
 	var hospitals []Hospital
+
+	if area != nil {
+		var stored []Hospital
+		var err error
+		if asOf != "" {
+			stored, err = listHospitalsAsOf(asOf, -1, 0)
+		} else {
+			stored, err = storedHospitalsInArea(area)
+		}
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+			return
+		}
+		// 距离相对lat/lng，未给出时相对范围中心；出入口距离也按同一点计算
+		center := areaSearchCenter(c, area, crs)
+		lat, lng = center.Lat, center.Lng
+		hospitals = nearestEntranceDistances(hospitalsInArea(billedOnly(stored), area, center), center, purpose, -1, limit)
+		for i := range hospitals {
+			hospitals[i].Rating, hospitals[i].Confidence = getHospitalRating(hospitals[i].ID)
+			hospitals[i].applyGrade()
+		}
+		log.Printf("[范围搜索] 找到 %d 家医院", len(hospitals))
+	}
+
+	// 半径搜索的候选范围：出入口可能在医院规范坐标campusMatchRadius米之外
+	candidateRadius := radius + campusMatchRadius/1000
+
+	// 优先使用本地JSON数据
+	if area == nil {
+		loadStaticTier3POIs()
+	}
 	
 	// 从本地JSON数据中搜索医院
-	if len(staticTier3POIs) > 0 {
+	if area == nil && len(staticTier3POIs) > 0 {
 		log.Printf("[本地搜索] 使用本地JSON数据，共 %d 条记录", len(staticTier3POIs))
 		
 		for i, poi := range staticTier3POIs {
-			if i >= limit {
-				break
-			}
-			
 			// 提取医院信息
 			name, _ := poi["name"].(string)
 			address, _ := poi["address"].(string)
 			location, _ := poi["location"].(string)
+			typecode, _ := poi["typecode"].(string)
+			cityname, _ := poi["cityname"].(string)
 			
 			// 解析经纬度
 			var poiLat, poiLng float64
@@ -495,11 +992,13 @@ func searchHospitals(c *gin.Context) {
 				}
 			}
 			
-			// 计算距离
-			distance := calculateDistance(lat, lng, poiLat, poiLng)
+			// 计算距离（公里）
+			distance := haversine(lng, lat, poiLng, poiLat) / 1000
 			
-			// 如果在搜索半径内
-			if distance <= radius*1000 { // 转换为米
+			// 如果在搜索半径内（出入口可能在规范坐标之外，先放宽筛选）
+			if distance <= candidateRadius {
+				// 等级优先取登记表，其次高德类型码，最后才按静态三甲名单
+				grade := resolveHospitalGrade(name, gradeHints{Typecode: typecode, StaticList: true})
 				hospital := Hospital{
 					ID:              i + 1,
 					Name:            name,
@@ -510,7 +1009,8 @@ func searchHospitals(c *gin.Context) {
 					HospitalType:    "综合医院",
 					MainDepartments: "内科,外科,妇产科,儿科",
 					BusinessHours:   "24小时",
-					Qualifications:  "三级甲等",
+					Qualifications:  grade.Grade,
+					Grade:           grade,
 					CreatedAt:       time.Now().Format("2006-01-02 15:04:05"),
 					UpdatedAt:       time.Now().Format("2006-01-02 15:04:05"),
 				}
@@ -519,25 +1019,28 @@ func searchHospitals(c *gin.Context) {
 				hospital.Rating = 4.5
 				hospital.Confidence = 0.8
 				
-				hospitals = append(hospitals, hospital)
+				if billed(&hospital, cityname) {
+					hospitals = append(hospitals, hospital)
+				}
 			}
 		}
 		
-		// 按距离排序
-		// 这里可以添加更复杂的排序算法
-		
 		log.Printf("[本地搜索] 找到 %d 家医院", len(hospitals))
 	}
 	
 	// 如果本地数据不足，从数据库补充
-	if len(hospitals) < limit {
+	if area == nil && len(hospitals) < limit {
 		log.Printf("[数据库补充] 从数据库补充数据")
+		dbStart := len(hospitals)
 		
+		// 先按半径的外接矩形筛选，再按实际距离筛选
+		dLat := candidateRadius * 1000 / metersPerDegreeLat
+		dLng := candidateRadius * 1000 / (metersPerDegreeLat * math.Cos(lat*math.Pi/180))
 		rows, err := db.Query(`
 			SELECT id, name, address, latitude, longitude, phone, hospital_type, main_departments, business_hours, qualifications, created_at, updated_at
 			FROM hospitals
-			LIMIT ?
-		`, limit-len(hospitals))
+			WHERE latitude BETWEEN ? AND ? AND longitude BETWEEN ? AND ?
+		`, lat-dLat, lat+dLat, lng-dLng, lng+dLng)
 
 		if err == nil {
 			defer rows.Close()
@@ -550,24 +1053,47 @@ func searchHospitals(c *gin.Context) {
 					continue
 				}
 
-				// 计算距离
-				h.Distance = calculateDistance(lat, lng, h.Latitude, h.Longitude)
-
-				// 获取平均评分
-				h.Rating, h.Confidence = getHospitalRating(h.ID)
+				// 计算距离（公里）
+				h.Distance = haversine(lng, lat, h.Longitude, h.Latitude) / 1000
+				if h.Distance > candidateRadius {
+					continue
+				}
 
 				hospitals = append(hospitals, h)
 			}
+			// 直付网络、评分和等级需要再查询数据库，须在遍历结果集之后进行
+			hospitals = append(hospitals[:dbStart], billedOnly(hospitals[dbStart:])...)
+			for i := dbStart; i < len(hospitals); i++ {
+				hospitals[i].Rating, hospitals[i].Confidence = getHospitalRating(hospitals[i].ID)
+				hospitals[i].applyGrade()
+			}
 		}
 	}
 
+	// 有院区出入口的医院，距离按用途取最近的相关出入口；按最终距离筛选半径、排序后截取
+	if area == nil {
+		hospitals = nearestEntranceDistances(hospitals, lngLat{lng, lat}, purpose, radius, limit)
+	}
+
 	response := SearchResponse{
 		Status: "success",
 		Count:  len(hospitals),
 		Data:   hospitals,
+		AsOf:   c.Query("as_of"),
 	}
 
-	c.JSON(http.StatusOK, response)
+	respondHospitals(c, format, crs, response)
+}
+
+// 按协商的格式返回医院搜索结果，坐标转为crs；json以外的格式为附件
+func respondHospitals(c *gin.Context, format, crs string, response SearchResponse) {
+	if format == ExportFormatJSON {
+		hospitalsToCRS(response.Data, crs)
+		response.CRS = crs
+		c.JSON(http.StatusOK, response)
+		return
+	}
+	writeExport(c, "hospitals-search", format, sheetToCRS(hospitalsSheet(response.Data, true), exportCRS(c, format, crs)))
 }
 
 // 获取医院详情
@@ -579,12 +1105,26 @@ func getHospitalDetail(c *gin.Context) {
 		return
 	}
 
+	asOf, ok := asOfParam(c)
+	if !ok {
+		return
+	}
+	crs, ok := crsParam(c)
+	if !ok {
+		return
+	}
+
 	var hospital Hospital
-	err = db.QueryRow(`
-		SELECT id, name, address, latitude, longitude, phone, hospital_type, main_departments, business_hours, qualifications, created_at, updated_at
-		FROM hospitals
-		WHERE id = ?
-	`, id).Scan(&hospital.ID, &hospital.Name, &hospital.Address, &hospital.Latitude, &hospital.Longitude, &hospital.Phone, &hospital.HospitalType, &hospital.MainDepartments, &hospital.BusinessHours, &hospital.Qualifications, &hospital.CreatedAt, &hospital.UpdatedAt)
+	if asOf != "" {
+		// 历史时间点：返回当时有效的版本
+		hospital, err = getHospitalAsOf(id, asOf)
+	} else {
+		err = db.QueryRow(`
+			SELECT id, name, address, latitude, longitude, phone, hospital_type, main_departments, business_hours, qualifications, created_at, updated_at
+			FROM hospitals
+			WHERE id = ?
+		`, id).Scan(&hospital.ID, &hospital.Name, &hospital.Address, &hospital.Latitude, &hospital.Longitude, &hospital.Phone, &hospital.HospitalType, &hospital.MainDepartments, &hospital.BusinessHours, &hospital.Qualifications, &hospital.CreatedAt, &hospital.UpdatedAt)
+	}
 
 	if err != nil {
 		if err == sql.ErrNoRows {
@@ -597,11 +1137,22 @@ func getHospitalDetail(c *gin.Context) {
 
 	// 获取评分信息
 	hospital.Rating, hospital.Confidence = getHospitalRating(hospital.ID)
+	hospital.applyGrade()
+	hospital.DirectBilling = directBillingFor(hospital.Name, addressCity(hospital.Address), "", billingDate(asOf))
 
 	response := DetailResponse{
 		Status: "success",
 		Data:   hospital,
+		AsOf:   c.Query("as_of"),
+	}
+
+	// 对应的已抓取POI及其科室、楼宇、出入口和院区（仅当前状态）
+	if asOf == "" {
+		response.POIID, response.Children, response.Campus = hospitalCampus(hospital)
 	}
+	response.Data.toCRS(crs)
+	response.Children.toCRS(crs)
+	response.Campus.toCRS(crs)
 
 	c.JSON(http.StatusOK, response)
 }
@@ -662,107 +1213,40 @@ func getNearbyHospitals(c *gin.Context) {
 		c.JSON(http.StatusBadRequest, gin.H{"error": "lat/lng required"})
 		return
 	}
-	apiKey := os.Getenv("GOOGLE_MAPS_API_KEY")
-	url := "https://maps.googleapis.com/maps/api/place/nearbysearch/json?location=" + lat + "," + lng + "&radius=5000&type=hospital&key=" + apiKey
-	log.Printf("[GoogleAPI] 请求URL: %s", url)
-	resp, err := http.Get(url)
-	if err != nil {
-		log.Printf("[GoogleAPI] http.Get错误: %v", err)
-		// 兜底SAMPLE
-		c.JSON(http.StatusOK, gin.H{
-			"results": []gin.H{
-				{
-					"id":        "sample1",
-					"name":      "SAMPLE兜底医院",
-					"latitude":  lat,
-					"longitude": lng,
-					"address":   "SAMPLE地址",
-					"_sample":   true,
-				},
-			},
-			"isSample":     true,
-			"sampleReason": "Google API请求失败，返回兜底SAMPLE",
-		})
+	latF, err1 := strconv.ParseFloat(lat, 64)
+	lngF, err2 := strconv.ParseFloat(lng, 64)
+	if err1 != nil || err2 != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "lat/lng格式错误"})
 		return
 	}
-	defer resp.Body.Close()
-	body, err := ioutil.ReadAll(resp.Body)
-	if err != nil {
-		log.Printf("[GoogleAPI] 读取响应体失败: %v", err)
-		c.JSON(http.StatusOK, gin.H{
-			"results": []gin.H{
-				{
-					"id":        "sample1",
-					"name":      "SAMPLE兜底医院",
-					"latitude":  lat,
-					"longitude": lng,
-					"address":   "SAMPLE地址",
-					"_sample":   true,
-				},
-			},
-			"isSample":     true,
-			"sampleReason": "Google API响应体读取失败，返回兜底SAMPLE",
-		})
+	crs, ok := crsParam(c)
+	if !ok {
 		return
 	}
-	if resp.StatusCode != 200 {
-		log.Printf("[GoogleAPI] HTTP状态码: %d, 响应体: %s", resp.StatusCode, string(body))
-		c.JSON(http.StatusOK, gin.H{
-			"results": []gin.H{
-				{
-					"id":        "sample1",
-					"name":      "SAMPLE兜底医院",
-					"latitude":  lat,
-					"longitude": lng,
-					"address":   "SAMPLE地址",
-					"_sample":   true,
-				},
-			},
-			"isSample":     true,
-			"sampleReason": "Google API返回非200，返回兜底SAMPLE",
-		})
+	// 本地数据为GCJ-02，Google在中国大陆使用GCJ-02、其他地区使用WGS-84
+	gLng, gLat := convertCoord(lngF, latF, crs, googleSourceCRS(lngF, latF))
+	lngF, latF = convertCoord(lngF, latF, crs, storageCRS)
+	apiKey := os.Getenv("GOOGLE_MAPS_API_KEY")
+	if offlineMode || apiKey == "" {
+		offlineNearbyHospitals(c, latF, lngF, 5000, crs, "离线模式")
 		return
 	}
-	var result map[string]interface{}
-	err = json.Unmarshal(body, &result)
+	url := upstreamURL(ProviderGoogle, "/maps/api/place/nearbysearch/json") + "?location=" + formatExportFloat(gLat) + "," + formatExportFloat(gLng) + "&radius=5000&type=hospital&key=" + apiKey
+	log.Printf("[GoogleAPI] 请求URL: %s", redactKey(url))
+	body, err := upstreamClient(ProviderGoogle).Get(ledgerContext(c), url)
 	if err != nil {
-		log.Printf("[GoogleAPI] JSON解析失败: %v, body: %s", err, string(body))
-		c.JSON(http.StatusOK, gin.H{
-			"results": []gin.H{
-				{
-					"id":        "sample1",
-					"name":      "SAMPLE兜底医院",
-					"latitude":  lat,
-					"longitude": lng,
-					"address":   "SAMPLE地址",
-					"_sample":   true,
-				},
-			},
-			"isSample":     true,
-			"sampleReason": "Google API响应JSON解析失败，返回兜底SAMPLE",
-		})
-		return
-	}
-	if status, ok := result["status"]; ok && status != "OK" && status != "ZERO_RESULTS" {
-		log.Printf("[GoogleAPI] status: %v, error_message: %v, body: %s", status, result["error_message"], string(body))
-		c.JSON(http.StatusOK, gin.H{
-			"results": []gin.H{
-				{
-					"id":        "sample1",
-					"name":      "SAMPLE兜底医院",
-					"latitude":  lat,
-					"longitude": lng,
-					"address":   "SAMPLE地址",
-					"_sample":   true,
-				},
-			},
-			"isSample":     true,
-			"sampleReason": "Google API返回错误，返回兜底SAMPLE",
-		})
+		log.Printf("[GoogleAPI] 请求失败: %v", err)
+		var quotaErr *QuotaExceededError
+		if errors.As(err, &quotaErr) {
+			respondUpstreamError(c, err)
+			return
+		}
+		// 上游失败时返回本地数据，并标注为离线结果
+		offlineNearbyHospitals(c, latF, lngF, 5000, crs, "Google API请求失败: "+redactKey(err.Error()))
 		return
 	}
-	// 正常返回Google API原始数据
-	c.Data(http.StatusOK, "application/json", body)
+	// 返回Google API原始数据，结果坐标由WGS-84转为crs
+	c.Data(http.StatusOK, "application/json", googleResultsToCRS(body, crs))
 }
 
 func PlacesSearchHandler(w http.ResponseWriter, r *http.Request) {
@@ -772,7 +1256,7 @@ func PlacesSearchHandler(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 	apiKey := os.Getenv("GOOGLE_MAPS_API_KEY")
-	url := fmt.Sprintf("https://maps.googleapis.com/maps/api/place/textsearch/json?query=%%s&key=%%s", query, apiKey)
+	url := fmt.Sprintf("%s/maps/api/place/textsearch/json?query=%s&key=%s", upstreamURL(ProviderGoogle, ""), query, apiKey)
 	resp, err := http.Get(url)
 	if err != nil {
 		http.Error(w, "请求Google Places API失败", http.StatusInternalServerError)
@@ -793,7 +1277,7 @@ func StaticMapHandler(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 	apiKey := os.Getenv("GOOGLE_MAPS_API_KEY")
-	url := fmt.Sprintf("https://maps.googleapis.com/maps/api/staticmap?center=%%s&zoom=%%s&size=%%s&key=%%s", center, zoom, size, apiKey)
+	url := fmt.Sprintf("%s/maps/api/staticmap?center=%s&zoom=%s&size=%s&key=%s", upstreamURL(ProviderGoogle, ""), center, zoom, size, apiKey)
 	resp, err := http.Get(url)
 	if err != nil {
 		http.Error(w, "请求Google Static Maps API失败", http.StatusInternalServerError)
@@ -839,6 +1323,11 @@ func AmapGeoProxy(c *gin.Context) {
 		c.JSON(400, gin.H{"error": "address参数缺失"})
 		return
 	}
+	// 高德返回GCJ-02，按crs转换后输出；缓存中保存原始响应
+	crs, ok := crsParam(c)
+	if !ok {
+		return
+	}
 	
 	// 优先使用本地地理编码缓存
 	if geocode, found := localGeocode(address); found {
@@ -864,47 +1353,36 @@ func AmapGeoProxy(c *gin.Context) {
 		}
 		
 		responseJSON, _ := json.Marshal(response)
-		c.Data(http.StatusOK, "application/json", responseJSON)
+		c.Data(http.StatusOK, "application/json", amapGeocodesToCRS(responseJSON, crs))
 		return
 	}
 	
 	// 如果本地缓存没有，才调用高德API
+	ck := CacheKey{Provider: ProviderAmap, Endpoint: "geocode/geo", Location: address}
+	if body, ok := responseCache.Get(ck); ok {
+		c.Header("X-Cache", "HIT")
+		c.Data(http.StatusOK, "application/json", amapGeocodesToCRS(body, crs))
+		return
+	}
 	key := os.Getenv("AMAP_KEY")
-	if key == "" {
-		log.Println("[AmapGeoProxy] AMAP_KEY not set in backend env")
-		c.JSON(500, gin.H{"error": "AMAP_KEY not set in backend env"})
+	if offlineMode || key == "" {
+		log.Println("[AmapGeoProxy] 离线模式，本地无该地址缓存:", address)
+		c.JSON(http.StatusServiceUnavailable, gin.H{"error": "离线模式：本地无该地址的地理编码缓存", "offline": true})
 		return
 	}
-	
+	c.Header("X-Cache", "MISS")
 	log.Printf("[AmapGeoProxy] 本地缓存未找到，调用高德API: %s", address)
-	amapUrl := "https://restapi.amap.com/v3/geocode/geo?address=" + url.QueryEscape(address) + "&key=" + key
-	log.Println("[AmapGeoProxy] 请求URL:", amapUrl)
-	resp, err := http.Get(amapUrl)
+	amapUrl := upstreamURL(ProviderAmap, "/v3/geocode/geo") + "?address=" + url.QueryEscape(address) + "&key=" + key
+	log.Println("[AmapGeoProxy] 请求URL:", redactKey(amapUrl))
+	body, err := upstreamClient(ProviderAmap).Get(ledgerContext(c), amapUrl)
 	if err != nil {
 		log.Println("[AmapGeoProxy] amap request failed:", err)
-		c.JSON(500, gin.H{"error": "amap request failed", "detail": err.Error()})
-		return
-	}
-	defer resp.Body.Close()
-	body, err := io.ReadAll(resp.Body)
-	if err != nil {
-		log.Printf("[AmapGeoProxy] 读取响应体失败: %v", err)
-		c.JSON(500, gin.H{"error": "amap response body read failed", "detail": err.Error()})
-		return
-	}
-	log.Printf("[AmapGeoProxy] HTTP状态码: %d, 响应体: %s", resp.StatusCode, string(body))
-	// 自动解析高德API响应码
-	var amapResp map[string]interface{}
-	json.Unmarshal(body, &amapResp)
-	if status, ok := amapResp["status"]; ok && status != "1" {
-		log.Printf("[AmapGeoProxy][高德API异常] status: %v, info: %v, infocode: %v, body: %s", status, amapResp["info"], amapResp["infocode"], string(body))
-	}
-	if resp.StatusCode != 200 {
-		c.JSON(500, gin.H{"error": "amap api status not 200", "status": resp.StatusCode, "body": string(body)})
+		respondUpstreamError(c, err)
 		return
 	}
+	responseCache.Set(ck, body)
 	log.Println("[AmapGeoProxy] 高德原始响应:", string(body))
-	c.DataFromReader(resp.StatusCode, int64(len(body)), resp.Header.Get("Content-Type"), io.NopCloser(bytes.NewReader(body)), nil)
+	c.Data(http.StatusOK, "application/json", amapGeocodesToCRS(body, crs))
 }
 
 // 高德周边医院搜索代理接口
@@ -916,367 +1394,46 @@ func AmapAroundProxy(c *gin.Context) {
 		return
 	}
 	radius := c.DefaultQuery("radius", "5000")
-	key := os.Getenv("AMAP_KEY")
-	if key == "" {
-		c.JSON(500, gin.H{"error": "AMAP_KEY not set in backend env"})
+	purpose, ok := purposeParam(c)
+	if !ok {
 		return
 	}
-
-	typecodes := []string{"090100", "090101", "090102", "090200", "090300", "090400", "090202"}
-	typecodeCategory := map[string]string{
-		"090100": "综合医院",
-		"090101": "三级甲等医院",
-		"090102": "社区医院",
-		"090200": "专科医院",
-		"090202": "牙科医院",
-		"090203": "icon_small_red_cross_normal",
-		"090204": "icon_small_red_cross_normal",
-		"090205": "icon_small_red_cross_normal",
-		"090206": "icon_small_red_cross_normal",
-		"090207": "icon_small_red_cross_normal",
-		"090208": "icon_small_red_cross_normal",
-		"090209": "icon_small_red_cross_normal",
-		"090210": "icon_small_red_cross_normal",
-		"090211": "icon_small_red_cross_normal",
-		"090300": "icon_clinic",
-		"090400": "icon_emergency",
-	}
-	poiMap := make(map[string]map[string]interface{})
-	var ledger []RawPOIRecord
-	for _, tc := range typecodes {
-		url := fmt.Sprintf("https://restapi.amap.com/v3/place/around?key=%s&location=%s&radius=%s&types=%s", key, location, radius, tc)
-		resp, err := http.Get(url)
-		if err != nil {
-			continue
-		}
-		body, _ := ioutil.ReadAll(resp.Body)
-		resp.Body.Close()
-		var amapResp map[string]interface{}
-		json.Unmarshal(body, &amapResp)
-		pois, _ := amapResp["pois"].([]interface{})
-		// 追加到台账
-		ledger = append(ledger, RawPOIRecord{Typecode: tc, POIs: pois})
-		for _, poi := range pois {
-			m, ok := poi.(map[string]interface{})
-			if !ok {
-				continue
-			}
-			id, _ := m["id"].(string)
-			if cat, ok := typecodeCategory[tc]; ok {
-				m["hospital_category"] = cat
-			}
-			poiMap[id] = m
-		}
-	}
-	// ====== 新增：090100/090101合并预处理 ======
-	merge0901xxHospitals(poiMap)
-	// 写入amap_query_ledger.json到backend目录，增强健壮性
-	log.Println("[台账] 准备写入台账文件 backend/amap_query_ledger.json ...")
-	if _, err := os.Stat("backend"); os.IsNotExist(err) {
-		errMk := os.Mkdir("backend", 0755)
-		if errMk != nil {
-			log.Println("[台账] 创建backend目录失败:", errMk)
-		}
-	}
-	ledgerBytes, _ := json.MarshalIndent(ledger, "", "  ")
-	errWrite := ioutil.WriteFile("backend/amap_query_ledger.json", ledgerBytes, 0644)
-	if errWrite != nil {
-		log.Println("[台账] 写台账失败:", errWrite)
-	} else {
-		log.Println("[台账] 台账写入成功，记录数：", len(ledger))
-	}
-	// 构建标准化POI缓冲JSON，增加icon_type字段
-	var mergedPois []map[string]interface{}
-	iconTypeMap := map[string]string{
-		"090100": "icon_general_hospital",
-		"090101": "icon_tier3_hospital",
-		"090102": "icon_health_center",
-		"090200": "icon_special_hospital",
-		"090202": "icon_tooth",
-		"090203": "icon_small_red_cross_normal",
-		"090204": "icon_small_red_cross_normal",
-		"090205": "icon_small_red_cross_normal",
-		"090206": "icon_small_red_cross_normal",
-		"090207": "icon_small_red_cross_normal",
-		"090208": "icon_small_red_cross_normal",
-		"090209": "icon_small_red_cross_normal",
-		"090210": "icon_small_red_cross_normal",
-		"090211": "icon_small_red_cross_normal",
-		"090300": "icon_clinic",
-		"090400": "icon_emergency",
-	}
-	// POI去重合并逻辑
-	for _, v := range poiMap {
-		m := v
-		tc, _ := m["typecode"].(string)
-		if icon, ok := iconTypeMap[tc]; ok {
-			m["icon_type"] = icon
-		} else {
-			m["icon_type"] = "icon_default"
-		}
-		// 牙科医院不去重，直接加入
-		if tc == "090202" {
-			mergedPois = append(mergedPois, m)
-			continue
-		}
-		// 其余POI去重
-		lat1, _ := parseFloatFromAny(m["location_lat"])
-		lng1, _ := parseFloatFromAny(m["location_lng"])
-		isDuplicate := false
-		for _, exist := range mergedPois {
-			tcExist, _ := exist["typecode"].(string)
-			if tcExist == "090202" {
-				continue // 不与牙科比对
-			}
-			lat2, _ := parseFloatFromAny(exist["location_lat"])
-			lng2, _ := parseFloatFromAny(exist["location_lng"])
-			if lat1 != 0 && lng1 != 0 && lat2 != 0 && lng2 != 0 {
-				dist := calculateDistance(lat1, lng1, lat2, lng2) * 1000 // km->m
-				if dist < duplicateDistanceThreshold {
-					isDuplicate = true
-					break
-				}
-			}
-		}
-		if !isDuplicate {
-			mergedPois = append(mergedPois, m)
-		}
-	}
-	// POI合并优化：名称重叠>=4字且距离<350米的合并为一个
-	var finalPois []map[string]interface{}
-	optOutIds := make(map[string]bool)
-	for i, poi1 := range mergedPois {
-		if optOutIds[poi1["id"].(string)] {
-			continue
-		}
-		tc1, _ := poi1["typecode"].(string)
-		childtype1, _ := poi1["childtype"]
-		// 改进：更准确的childtype空值判断
-		isChildtype1Empty := false
-		if childtype1 == nil || childtype1 == "" {
-			isChildtype1Empty = true
-		} else if arr, ok := childtype1.([]interface{}); ok && len(arr) == 0 {
-			isChildtype1Empty = true
-		} else if arr, ok := childtype1.([]string); ok && len(arr) == 0 {
-			isChildtype1Empty = true
-		}
-
-		// 只对满足如下条件的POI参与合并
-		if (tc1 == "090101" && isChildtype1Empty) ||
-			strings.HasPrefix(tc1, "0901") ||
-			strings.HasPrefix(tc1, "0902") ||
-			strings.HasPrefix(tc1, "0903") ||
-			strings.HasPrefix(tc1, "0904") {
-			// 参与合并
-		} else {
-			finalPois = append(finalPois, poi1)
-			continue
-		}
-		for j := i + 1; j < len(mergedPois); j++ {
-			poi2 := mergedPois[j]
-			if optOutIds[poi2["id"].(string)] {
-				continue
-			}
-			tc2, _ := poi2["typecode"].(string)
-			childtype2, _ := poi2["childtype"]
-
-			// 改进：更准确的childtype空值判断
-			isChildtype2Empty := false
-			if childtype2 == nil || childtype2 == "" {
-				isChildtype2Empty = true
-			} else if arr, ok := childtype2.([]interface{}); ok && len(arr) == 0 {
-				isChildtype2Empty = true
-			} else if arr, ok := childtype2.([]string); ok && len(arr) == 0 {
-				isChildtype2Empty = true
-			}
-
-			if tc2 == "090101" && !isChildtype2Empty {
-				continue // 090101且childtype不为空的，不参与合并
-			}
-
-			// 改进名称重叠判定：优先检查完全相同的名称
-			name1, _ := poi1["name"].(string)
-			name2, _ := poi2["name"].(string)
-
-			// 如果名称完全相同，直接合并
-			if name1 == name2 {
-				// 距离判定
-				lat1, _ := parseFloatFromAny(poi1["location_lat"])
-				lng1, _ := parseFloatFromAny(poi1["location_lng"])
-				lat2, _ := parseFloatFromAny(poi2["location_lat"])
-				lng2, _ := parseFloatFromAny(poi2["location_lng"])
-				if lat1 != 0 && lng1 != 0 && lat2 != 0 && lng2 != 0 {
-					dist := calculateDistance(lat1, lng1, lat2, lng2) * 1000
-					if dist < duplicateDistanceThreshold {
-						// 合并为一个新POI，名称为原名称
-						merged := make(map[string]interface{})
-						for k, v := range poi1 {
-							merged[k] = v
-						}
-						// 修正：typecode/childtype优先保留主POI的值，如无则补全
-						if merged["typecode"] == nil || merged["typecode"] == "" {
-							merged["typecode"] = poi2["typecode"]
-						}
-						if merged["childtype"] == nil || merged["childtype"] == "" {
-							merged["childtype"] = poi2["childtype"]
-						}
-						finalPois = append(finalPois, merged)
-						optOutIds[poi2["id"].(string)] = true
-						goto NextPoi
-					}
-				}
-			} else {
-				// 改进的字符重叠判定逻辑：更智能的医院名称匹配
-				name1, _ := poi1["name"].(string)
-				name2, _ := poi2["name"].(string)
-
-				// 方法1：检查是否包含相同的医院核心名称（如"东直门医院"）
-				coreNames := []string{"医院", "门诊", "诊所", "中心", "院区", "分院"}
-				hasCoreOverlap := false
-				for _, core := range coreNames {
-					if strings.Contains(name1, core) && strings.Contains(name2, core) {
-						// 提取核心名称前的部分进行比较
-						idx1 := strings.Index(name1, core)
-						idx2 := strings.Index(name2, core)
-						if idx1 > 0 && idx2 > 0 {
-							prefix1 := name1[:idx1]
-							prefix2 := name2[:idx2]
-							// 检查前缀是否有重叠
-							if len(prefix1) >= 2 && len(prefix2) >= 2 {
-								// 计算前缀的重叠字符数
-								overlapCount := 0
-								for _, c := range prefix1 {
-									if strings.ContainsRune(prefix2, c) {
-										overlapCount++
-									}
-								}
-								if overlapCount >= 2 { // 至少2个字符重叠
-									hasCoreOverlap = true
-									log.Printf("[合并算法] 核心名称匹配: %s 和 %s 通过 %s 匹配 (重叠字符数: %d)", name1, name2, core, overlapCount)
-									break
-								}
-							}
-						}
-					}
-				}
-
-				// 方法2：检查是否包含相同的医院名称片段（如"东直门"）
-				if !hasCoreOverlap {
-					hospitalNameFragments := []string{"东直门", "协和", "同仁", "天坛", "安贞", "积水潭", "友谊", "宣武", "朝阳", "海淀", "丰台", "石景山", "门头沟", "房山", "通州", "顺义", "昌平", "大兴", "怀柔", "平谷", "密云", "延庆"}
-					for _, fragment := range hospitalNameFragments {
-						if strings.Contains(name1, fragment) && strings.Contains(name2, fragment) {
-							hasCoreOverlap = true
-							log.Printf("[合并算法] 医院名称片段匹配: %s 和 %s 通过 %s 匹配", name1, name2, fragment)
-							break
-						}
-					}
-				}
-
-				// 方法3：原有的字符重叠判定逻辑（作为兜底）
-				n1 := []rune(name1)
-				n2 := []rune(name2)
-				overlap := ""
-				for _, c := range n1 {
-					if strings.ContainsRune(string(n2), c) && !strings.ContainsRune(overlap, c) {
-						overlap += string(c)
-					}
-				}
-				charOverlapCount := len([]rune(overlap))
-
-				// 合并条件：核心名称重叠 或 字符重叠>=4
-				if hasCoreOverlap || charOverlapCount >= 4 {
-					log.Printf("[合并算法] 尝试合并: %s 和 %s (核心重叠: %v, 字符重叠: %d)", name1, name2, hasCoreOverlap, charOverlapCount)
-					// 距离判定
-					lat1, _ := parseFloatFromAny(poi1["location_lat"])
-					lng1, _ := parseFloatFromAny(poi1["location_lng"])
-					lat2, _ := parseFloatFromAny(poi2["location_lat"])
-					lng2, _ := parseFloatFromAny(poi2["location_lng"])
-					if lat1 != 0 && lng1 != 0 && lat2 != 0 && lng2 != 0 {
-						dist := calculateDistance(lat1, lng1, lat2, lng2) * 1000
-						if dist < duplicateDistanceThreshold {
-							// 合并为一个新POI，选择更简洁的名称
-							merged := make(map[string]interface{})
-							for k, v := range poi1 {
-								merged[k] = v
-							}
-
-							// 选择更简洁的名称作为合并后的名称
-							if len(name1) <= len(name2) {
-								merged["name"] = name1
-							} else {
-								merged["name"] = name2
-							}
-
-							// 修正：typecode/childtype优先保留主POI的值，如无则补全
-							if merged["typecode"] == nil || merged["typecode"] == "" {
-								merged["typecode"] = poi2["typecode"]
-							}
-							if merged["childtype"] == nil || merged["childtype"] == "" {
-								merged["childtype"] = poi2["childtype"]
-							}
-
-							// 添加合并日志
-							log.Printf("[合并算法] 合并医院: %s + %s -> %s (距离: %.1fm)", name1, name2, merged["name"], dist)
-
-							finalPois = append(finalPois, merged)
-							optOutIds[poi2["id"].(string)] = true
-							goto NextPoi
-						} else {
-							log.Printf("[合并算法] 距离过远，不合并: %s 和 %s (距离: %.1fm > %.1fm)", name1, name2, dist, duplicateDistanceThreshold)
-						}
-					} else {
-						log.Printf("[合并算法] 坐标无效，不合并: %s 和 %s", name1, name2)
-					}
-				} else {
-					log.Printf("[合并算法] 名称不匹配，不合并: %s 和 %s (核心重叠: %v, 字符重叠: %d)", name1, name2, hasCoreOverlap, charOverlapCount)
-				}
-			}
-		}
-		finalPois = append(finalPois, poi1)
-	NextPoi:
-	}
-	// 其它被合并的POI标记OptOut
-	for _, poi := range mergedPois {
-		if optOutIds[poi["id"].(string)] {
-			if poi["tags"] == nil {
-				poi["tags"] = []string{"OptOut"}
-			} else {
-				poi["tags"] = append(poi["tags"].([]string), "OptOut")
-			}
-		}
+	crs, ok := crsParam(c)
+	if !ok {
+		return
 	}
+	location = convertLocation(location, crs, storageCRS)
 
-	// 新增：将合并后POI及TAG写入JSON文件，便于前端查看
-	mergedResult := map[string]interface{}{
-		"status": "1",
-		"count":  len(finalPois),
-		"pois":   finalPois,
+	// 并发查询各typecode，台账按typecodes顺序排列；离线模式读取本地数据
+	ledger, offline, ok := loadAroundLedger(c, location, radius, aroundMergeProfile.Typecodes)
+	if !ok {
+		return
 	}
-	mergedBytes, _ := json.MarshalIndent(mergedResult, "", "  ")
-	errMerged := ioutil.WriteFile("backend/cache/merged_poi_result.json", mergedBytes, 0644)
-	if errMerged != nil {
-		log.Println("[合并结果] 写入合并POI结果JSON失败:", errMerged)
-	} else {
-		log.Println("[合并结果] 合并POI结果写入backend/cache/merged_poi_result.json成功")
+	if offline == nil {
+		writeLedgerSnapshot(ledger)
 	}
-
-	// 修正医院类别、ICON、排序判定逻辑
-	for _, poi := range finalPois {
-		cat, icon, order := classifyHospital(poi)
-		poi["algo_hospital_category"] = cat
-		poi["algo_icon_type"] = icon
-		poi["algo_display_order"] = order
+	mergedResult := mergeLedger(ledger, aroundMergeProfile)
+	markTruncated(c, mergedResult, ledger)
+	writeMergedResult(mergedResult)
+	classifyMergedPOIs(mergedResult)
+	if lng, lat, ok := parseLngLat(location); ok {
+		finalPois, _ := mergedResult["pois"].([]map[string]interface{})
+		applyEntranceDistances(finalPois, lng, lat, purpose)
 	}
 
+	finalPois, _ := mergedResult["pois"].([]map[string]interface{})
+	poisToCRS(finalPois, crs)
+	mergedResult["crs"] = crs
+	offline.apply(c, mergedResult)
 	c.JSON(http.StatusOK, mergedResult)
 	return
 }
 
 // 合并090100/090101医院POI，按名称最长公共子串≥4且距离<300米原则，生成新POI
-func merge0901xxHospitals(poiMap map[string]map[string]interface{}) {
+func merge0901xxHospitals(poiMap *orderedPOIs) {
 	// 1. 收集所有0901xx POI（typecode前4位为0901）
 	var poiList []map[string]interface{}
-	for _, poi := range poiMap {
+	for _, poi := range poiMap.List() {
 		tc, _ := poi["typecode"].(string)
 		if len(tc) >= 4 && tc[:4] == "0901" {
 			poiList = append(poiList, poi)
@@ -1343,7 +1500,7 @@ func merge0901xxHospitals(poiMap map[string]map[string]interface{}) {
 			for _, idx := range group {
 				poiList[idx]["category"] = nil // 原POI分类置为null
 			}
-			poiMap[commonName+commonAddr] = newPOI
+			poiMap.Set(commonName+commonAddr, newPOI)
 			log.Printf("[合并算法] 生成新合并POI: %s, 地址: %s, 类别: %s", commonName, commonAddr, newType)
 		}
 	}
@@ -1369,22 +1526,27 @@ func checkAmapKeyHealth() {
 		log.Println("[健康检查] AMAP_KEY未设置")
 		return
 	}
-	testUrl := "https://restapi.amap.com/v3/geocode/geo?address=北京&key=" + key
-	resp, err := http.Get(testUrl)
+	if offlineMode {
+		log.Println("[健康检查] 离线模式，跳过")
+		return
+	}
+	testUrl := upstreamURL(ProviderAmap, "/v3/geocode/geo") + "?address=北京&key=" + key
+	body, err := upstreamClient(ProviderAmap).Get(context.Background(), testUrl)
 	if err != nil {
 		log.Println("[健康检查] 高德API请求失败:", err)
 		return
 	}
-	defer resp.Body.Close()
-	body, _ := io.ReadAll(resp.Body)
 	log.Printf("[健康检查] 高德API响应: %s", string(body))
 }
 
 // 台账结构体和全局变量
 
 type RawPOIRecord struct {
-	Typecode string        `json:"typecode"`
-	POIs     []interface{} `json:"pois"`
+	Typecode  string        `json:"typecode"`
+	Page      int           `json:"page,omitempty"`
+	Count     int           `json:"count,omitempty"` // 高德返回的结果总数
+	POIs      []interface{} `json:"pois"`
+	Truncated bool          `json:"truncated,omitempty"` // 达到最大页数，后续结果未获取
 }
 
 var allRawPois []RawPOIRecord
@@ -1398,512 +1560,70 @@ func getMergedPois(c *gin.Context) {
 	// 默认北京中心点与半径（可根据前端传参扩展）
 	location := c.DefaultQuery("location", "116.407387,39.904179")
 	radius := c.DefaultQuery("radius", "5000")
-	key := os.Getenv("AMAP_KEY")
-	if key == "" {
-		c.JSON(500, gin.H{"error": "AMAP_KEY not set in backend env"})
+	purpose, ok := purposeParam(c)
+	if !ok {
 		return
 	}
-	typecodes := []string{
-		"090100", // 综合医院
-		"090101", // 三级甲等医院
-		"090102", // 社区医院
-		"090200", // 专科医院
-		"090202", // 牙科
-		"090203", // 眼科
-		"090204", // 耳鼻喉
-		"090205", // 胸科
-		"090206", // 骨科
-		"090207", // 肿瘤
-		"090208", // 脑科
-		"090209", // 妇科
-		"090210", // 精神
-		"090211", // 传染病
-		"090300", // 诊所
-		"090400", // 急救中心
-	}
-	typecodeCategory := map[string]string{
-		"090100": "综合医院",
-		"090101": "三级甲等医院",
-		"090102": "社区医院",
-		"090200": "专科医院",
-		"090202": "牙科医院",
-		"090203": "眼科医院",
-		"090204": "耳鼻喉医院",
-		"090205": "胸科医院",
-		"090206": "骨科医院",
-		"090207": "肿瘤医院",
-		"090208": "脑科医院",
-		"090209": "妇科医院",
-		"090210": "精神医院",
-		"090211": "传染病医院",
-		"090300": "诊所",
-		"090400": "急救中心",
-	}
-	poiMap := make(map[string]map[string]interface{})
-	var ledger []RawPOIRecord
-	for _, tc := range typecodes {
-		url := fmt.Sprintf("https://restapi.amap.com/v3/place/around?key=%s&location=%s&radius=%s&types=%s", key, location, radius, tc)
-		resp, err := http.Get(url)
-		if err != nil {
-			continue
-		}
-		body, _ := ioutil.ReadAll(resp.Body)
-		resp.Body.Close()
-		var amapResp map[string]interface{}
-		json.Unmarshal(body, &amapResp)
-		pois, _ := amapResp["pois"].([]interface{})
-		// 追加到台账
-		ledger = append(ledger, RawPOIRecord{Typecode: tc, POIs: pois})
-		for _, poi := range pois {
-			m, ok := poi.(map[string]interface{})
-			if !ok {
-				continue
-			}
-			id, _ := m["id"].(string)
-			if cat, ok := typecodeCategory[tc]; ok {
-				m["hospital_category"] = cat
-			}
-			poiMap[id] = m
-		}
-	}
-
-	// 写入amap_query_ledger.json到backend目录，增强健壮性
-	log.Println("[台账] 准备写入台账文件 backend/cache/amap_query_ledger.json ...")
-	if _, err := os.Stat("backend"); os.IsNotExist(err) {
-		errMk := os.Mkdir("backend", 0755)
-		if errMk != nil {
-			log.Println("[台账] 创建backend目录失败:", errMk)
-		}
+	format, ok := exportFormatParam(c, ExportFormatJSON)
+	if !ok {
+		return
 	}
-	ledgerBytes, _ := json.MarshalIndent(ledger, "", "  ")
-	errWrite := ioutil.WriteFile("backend/cache/amap_query_ledger.json", ledgerBytes, 0644)
-	if errWrite != nil {
-		log.Println("[台账] 写台账失败:", errWrite)
-	} else {
-		log.Println("[台账] 台账写入成功，记录数：", len(ledger))
-	}
-
-	// 构建标准化POI缓冲JSON，增加icon_type字段
-	var mergedPois []map[string]interface{}
-	iconTypeMap := map[string]string{
-		"090100": "icon_general_hospital",
-		"090101": "icon_tier3_hospital",
-		"090102": "icon_health_center",
-		"090200": "icon_special_hospital",
-		"090202": "icon_tooth",
-		"090203": "icon_small_red_cross_normal",
-		"090204": "icon_small_red_cross_normal",
-		"090205": "icon_small_red_cross_normal",
-		"090206": "icon_small_red_cross_normal",
-		"090207": "icon_small_red_cross_normal",
-		"090208": "icon_small_red_cross_normal",
-		"090209": "icon_small_red_cross_normal",
-		"090210": "icon_small_red_cross_normal",
-		"090211": "icon_small_red_cross_normal",
-		"090300": "icon_clinic",
-		"090400": "icon_emergency",
-	}
-	// POI去重合并逻辑
-	for _, v := range poiMap {
-		m := v
-		tc, _ := m["typecode"].(string)
-		if icon, ok := iconTypeMap[tc]; ok {
-			m["icon_type"] = icon
-		} else {
-			m["icon_type"] = "icon_default"
-		}
-		// 牙科医院不去重，直接加入
-		if tc == "090202" {
-			mergedPois = append(mergedPois, m)
-			continue
-		}
-		// 其余POI去重
-		lat1, _ := parseFloatFromAny(m["location_lat"])
-		lng1, _ := parseFloatFromAny(m["location_lng"])
-		isDuplicate := false
-		for _, exist := range mergedPois {
-			tcExist, _ := exist["typecode"].(string)
-			if tcExist == "090202" {
-				continue // 不与牙科比对
-			}
-			lat2, _ := parseFloatFromAny(exist["location_lat"])
-			lng2, _ := parseFloatFromAny(exist["location_lng"])
-			if lat1 != 0 && lng1 != 0 && lat2 != 0 && lng2 != 0 {
-				dist := calculateDistance(lat1, lng1, lat2, lng2) * 1000 // km->m
-				if dist < duplicateDistanceThreshold {
-					isDuplicate = true
-					break
-				}
-			}
-		}
-		if !isDuplicate {
-			mergedPois = append(mergedPois, m)
-		}
+	crs, ok := crsParam(c)
+	if !ok {
+		return
 	}
-
-	// POI合并优化：名称重叠>=4字且距离<350米的合并为一个
-	var finalPois []map[string]interface{}
-	optOutIds := make(map[string]bool)
-	for i, poi1 := range mergedPois {
-		if optOutIds[poi1["id"].(string)] {
-			continue
-		}
-		tc1, _ := poi1["typecode"].(string)
-		childtype1, _ := poi1["childtype"]
-		// 改进：更准确的childtype空值判断
-		isChildtype1Empty := false
-		if childtype1 == nil || childtype1 == "" {
-			isChildtype1Empty = true
-		} else if arr, ok := childtype1.([]interface{}); ok && len(arr) == 0 {
-			isChildtype1Empty = true
-		} else if arr, ok := childtype1.([]string); ok && len(arr) == 0 {
-			isChildtype1Empty = true
-		}
-
-		// 只对满足如下条件的POI参与合并
-		if (tc1 == "090101" && isChildtype1Empty) ||
-			strings.HasPrefix(tc1, "0901") ||
-			strings.HasPrefix(tc1, "0902") ||
-			strings.HasPrefix(tc1, "0903") ||
-			strings.HasPrefix(tc1, "0904") {
-			// 参与合并
-		} else {
-			finalPois = append(finalPois, poi1)
-			continue
-		}
-		for j := i + 1; j < len(mergedPois); j++ {
-			poi2 := mergedPois[j]
-			if optOutIds[poi2["id"].(string)] {
-				continue
-			}
-			tc2, _ := poi2["typecode"].(string)
-			childtype2, _ := poi2["childtype"]
-
-			// 改进：更准确的childtype空值判断
-			isChildtype2Empty := false
-			if childtype2 == nil || childtype2 == "" {
-				isChildtype2Empty = true
-			} else if arr, ok := childtype2.([]interface{}); ok && len(arr) == 0 {
-				isChildtype2Empty = true
-			} else if arr, ok := childtype2.([]string); ok && len(arr) == 0 {
-				isChildtype2Empty = true
-			}
-
-			if tc2 == "090101" && !isChildtype2Empty {
-				continue // 090101且childtype不为空的，不参与合并
-			}
-
-			// 改进名称重叠判定：优先检查完全相同的名称
-			name1, _ := poi1["name"].(string)
-			name2, _ := poi2["name"].(string)
-
-			// 如果名称完全相同，直接合并
-			if name1 == name2 {
-				// 距离判定
-				lat1, _ := parseFloatFromAny(poi1["location_lat"])
-				lng1, _ := parseFloatFromAny(poi1["location_lng"])
-				lat2, _ := parseFloatFromAny(poi2["location_lat"])
-				lng2, _ := parseFloatFromAny(poi2["location_lng"])
-				if lat1 != 0 && lng1 != 0 && lat2 != 0 && lng2 != 0 {
-					dist := calculateDistance(lat1, lng1, lat2, lng2) * 1000
-					if dist < duplicateDistanceThreshold {
-						// 合并为一个新POI，名称为原名称
-						merged := make(map[string]interface{})
-						for k, v := range poi1 {
-							merged[k] = v
-						}
-						// 修正：typecode/childtype优先保留主POI的值，如无则补全
-						if merged["typecode"] == nil || merged["typecode"] == "" {
-							merged["typecode"] = poi2["typecode"]
-						}
-						if merged["childtype"] == nil || merged["childtype"] == "" {
-							merged["childtype"] = poi2["childtype"]
-						}
-						finalPois = append(finalPois, merged)
-						optOutIds[poi2["id"].(string)] = true
-						goto NextPoi
-					}
-				}
-			} else {
-				// 改进的字符重叠判定逻辑：更智能的医院名称匹配
-				name1, _ := poi1["name"].(string)
-				name2, _ := poi2["name"].(string)
-
-				// 方法1：检查是否包含相同的医院核心名称（如"东直门医院"）
-				coreNames := []string{"医院", "门诊", "诊所", "中心", "院区", "分院"}
-				hasCoreOverlap := false
-				for _, core := range coreNames {
-					if strings.Contains(name1, core) && strings.Contains(name2, core) {
-						// 提取核心名称前的部分进行比较
-						idx1 := strings.Index(name1, core)
-						idx2 := strings.Index(name2, core)
-						if idx1 > 0 && idx2 > 0 {
-							prefix1 := name1[:idx1]
-							prefix2 := name2[:idx2]
-							// 检查前缀是否有重叠
-							if len(prefix1) >= 2 && len(prefix2) >= 2 {
-								// 计算前缀的重叠字符数
-								overlapCount := 0
-								for _, c := range prefix1 {
-									if strings.ContainsRune(prefix2, c) {
-										overlapCount++
-									}
-								}
-								if overlapCount >= 2 { // 至少2个字符重叠
-									hasCoreOverlap = true
-									log.Printf("[合并算法] 核心名称匹配: %s 和 %s 通过 %s 匹配 (重叠字符数: %d)", name1, name2, core, overlapCount)
-									break
-								}
-							}
-						}
-					}
-				}
-
-				// 方法2：检查是否包含相同的医院名称片段（如"东直门"）
-				if !hasCoreOverlap {
-					hospitalNameFragments := []string{"东直门", "协和", "同仁", "天坛", "安贞", "积水潭", "友谊", "宣武", "朝阳", "海淀", "丰台", "石景山", "门头沟", "房山", "通州", "顺义", "昌平", "大兴", "怀柔", "平谷", "密云", "延庆"}
-					for _, fragment := range hospitalNameFragments {
-						if strings.Contains(name1, fragment) && strings.Contains(name2, fragment) {
-							hasCoreOverlap = true
-							log.Printf("[合并算法] 医院名称片段匹配: %s 和 %s 通过 %s 匹配", name1, name2, fragment)
-							break
-						}
-					}
-				}
-
-				// 方法3：原有的字符重叠判定逻辑（作为兜底）
-				n1 := []rune(name1)
-				n2 := []rune(name2)
-				overlap := ""
-				for _, c := range n1 {
-					if strings.ContainsRune(string(n2), c) && !strings.ContainsRune(overlap, c) {
-						overlap += string(c)
-					}
-				}
-				charOverlapCount := len([]rune(overlap))
-
-				// 合并条件：核心名称重叠 或 字符重叠>=4
-				if hasCoreOverlap || charOverlapCount >= 4 {
-					log.Printf("[合并算法] 尝试合并: %s 和 %s (核心重叠: %v, 字符重叠: %d)", name1, name2, hasCoreOverlap, charOverlapCount)
-					// 距离判定
-					lat1, _ := parseFloatFromAny(poi1["location_lat"])
-					lng1, _ := parseFloatFromAny(poi1["location_lng"])
-					lat2, _ := parseFloatFromAny(poi2["location_lat"])
-					lng2, _ := parseFloatFromAny(poi2["location_lng"])
-					if lat1 != 0 && lng1 != 0 && lat2 != 0 && lng2 != 0 {
-						dist := calculateDistance(lat1, lng1, lat2, lng2) * 1000
-						if dist < duplicateDistanceThreshold {
-							// 合并为一个新POI，选择更简洁的名称
-							merged := make(map[string]interface{})
-							for k, v := range poi1 {
-								merged[k] = v
-							}
-
-							// 选择更简洁的名称作为合并后的名称
-							if len(name1) <= len(name2) {
-								merged["name"] = name1
-							} else {
-								merged["name"] = name2
-							}
-
-							// 修正：typecode/childtype优先保留主POI的值，如无则补全
-							if merged["typecode"] == nil || merged["typecode"] == "" {
-								merged["typecode"] = poi2["typecode"]
-							}
-							if merged["childtype"] == nil || merged["childtype"] == "" {
-								merged["childtype"] = poi2["childtype"]
-							}
-
-							// 添加合并日志
-							log.Printf("[合并算法] 合并医院: %s + %s -> %s (距离: %.1fm)", name1, name2, merged["name"], dist)
-
-							finalPois = append(finalPois, merged)
-							optOutIds[poi2["id"].(string)] = true
-							goto NextPoi
-						} else {
-							log.Printf("[合并算法] 距离过远，不合并: %s 和 %s (距离: %.1fm > %.1fm)", name1, name2, dist, duplicateDistanceThreshold)
-						}
-					} else {
-						log.Printf("[合并算法] 坐标无效，不合并: %s 和 %s", name1, name2)
-					}
-				} else {
-					log.Printf("[合并算法] 名称不匹配，不合并: %s 和 %s (核心重叠: %v, 字符重叠: %d)", name1, name2, hasCoreOverlap, charOverlapCount)
-				}
-			}
-		}
-		finalPois = append(finalPois, poi1)
-	NextPoi:
-	}
-	// 其它被合并的POI标记OptOut
-	for _, poi := range mergedPois {
-		if optOutIds[poi["id"].(string)] {
-			if poi["tags"] == nil {
-				poi["tags"] = []string{"OptOut"}
-			} else {
-				poi["tags"] = append(poi["tags"].([]string), "OptOut")
-			}
-		}
+	location = convertLocation(location, crs, storageCRS)
+	// 并发查询各typecode，台账按typecodes顺序排列；离线模式读取本地数据
+	ledger, offline, ok := loadAroundLedger(c, location, radius, mergedPoisMergeProfile.Typecodes)
+	if !ok {
+		return
 	}
-
-	// 新增：将合并后POI及TAG写入JSON文件，便于前端查看
-	mergedResult := map[string]interface{}{
-		"status": "1",
-		"count":  len(finalPois),
-		"pois":   finalPois,
+	if offline == nil {
+		writeLedgerSnapshot(ledger)
 	}
-	mergedBytes, _ := json.MarshalIndent(mergedResult, "", "  ")
-	errMerged := ioutil.WriteFile("backend/cache/merged_poi_result.json", mergedBytes, 0644)
-	if errMerged != nil {
-		log.Println("[合并结果] 写入合并POI结果JSON失败:", errMerged)
-	} else {
-		log.Println("[合并结果] 合并POI结果写入backend/cache/merged_poi_result.json成功")
+	mergedResult := mergeLedger(ledger, mergedPoisMergeProfile)
+	markTruncated(c, mergedResult, ledger)
+	writeMergedResult(mergedResult)
+	classifyMergedPOIs(mergedResult)
+	if lng, lat, ok := parseLngLat(location); ok {
+		finalPois, _ := mergedResult["pois"].([]map[string]interface{})
+		applyEntranceDistances(finalPois, lng, lat, purpose)
 	}
 
-	// 修正医院类别、ICON、排序判定逻辑
-	for _, poi := range finalPois {
-		cat, icon, order := classifyHospital(poi)
-		poi["algo_hospital_category"] = cat
-		poi["algo_icon_type"] = icon
-		poi["algo_display_order"] = order
+	offline.apply(c, mergedResult)
+	finalPois, _ := mergedResult["pois"].([]map[string]interface{})
+	if format != ExportFormatJSON {
+		writeExport(c, "merged-pois", format, sheetToCRS(poisSheet("merged_pois", finalPois), exportCRS(c, format, crs)))
+		return
 	}
-
+	poisToCRS(finalPois, crs)
+	mergedResult["crs"] = crs
 	c.JSON(http.StatusOK, mergedResult)
 	return
 }
 
-// 修正医院类别、ICON、排序判定逻辑
+// 修正医院类别、ICON、排序判定逻辑：规则见分类体系（taxonomy.json），不显示的返回 "null", "null", 0
 func classifyHospital(poi map[string]interface{}) (string, string, int) {
 	typecode, _ := poi["typecode"].(string)
-	childtype, childtypeExists := poi["childtype"]
-	childtypeStr := ""
-	if childtypeExists && childtype != nil {
-		switch t := childtype.(type) {
-		case string:
-			childtypeStr = t
-		case float64, int:
-			childtypeStr = fmt.Sprintf("%v", t)
-		default:
-			childtypeStr = fmt.Sprintf("%v", t)
-		}
-	}
-
-	// 处理多个typecode的情况（如：090202|090300），只取前6位作为主要typecode
-	primaryTypecode := typecode
-	if strings.Contains(typecode, "|") {
-		parts := strings.Split(typecode, "|")
-		if len(parts) > 0 && len(parts[0]) >= 6 {
-			primaryTypecode = parts[0][:6]
-			log.Printf("[分类算法] 多typecode处理: %s -> 取前6位: %s", typecode, primaryTypecode)
-		}
-	}
+	childtypeStr, isChildtypeEmpty := poiChildtype(poi)
 
-	// 添加调试日志
 	name, _ := poi["name"].(string)
-	log.Printf("[分类算法] 医院: %s, 原始typecode: %s, 主要typecode: %s, childtype: %s", name, typecode, primaryTypecode, childtypeStr)
-
-	// 判断childtype是否为空（包括空字符串、null、[]等）
-	isChildtypeEmpty := childtypeStr == "" || childtypeStr == "[]" || childtypeStr == "null" || childtypeStr == "0"
-	isChildtypeNotEmpty := childtypeStr != "" && childtypeStr != "[]" && childtypeStr != "null" && childtypeStr != "0"
-
-	// 严格按照规则表进行精细化判断，使用主要typecode
-	// 1. (POI=090101) and (childtype=[]) → 三甲，大红十字BOLD，排序1
-	if primaryTypecode == "090101" && isChildtypeEmpty {
-		log.Printf("[分类算法] %s → 三甲 (090101且childtype为空)", name)
-		return "三甲", "icon_tier3_hospital_bold", 1
-	}
-	// 2. (POI=090101) and (childtype<>[]) → null，不显示
-	if primaryTypecode == "090101" && isChildtypeNotEmpty {
-		log.Printf("[分类算法] %s → null (090101且childtype非空: %s)", name, childtypeStr)
-		return "null", "null", 0
-	}
-	// 3. (POI=090100) and (childtype=[]) → 综合医院，中红十字BOLD，排序2
-	if primaryTypecode == "090100" && isChildtypeEmpty {
-		log.Printf("[分类算法] %s → 综合医院 (090100且childtype为空)", name)
-		return "综合医院", "icon_general_hospital_bold", 2
-	}
-	// 4. (POI=090100) and (childtype<>[]) → null，不显示
-	if primaryTypecode == "090100" && isChildtypeNotEmpty {
-		log.Printf("[分类算法] %s → null (090100且childtype非空: %s)", name, childtypeStr)
+	cat := taxonomy.Classify(typecode, isChildtypeEmpty)
+	if cat == nil {
+		log.Printf("[分类算法] %s → null (typecode: %s, childtype: %s)", name, typecode, childtypeStr)
 		return "null", "null", 0
 	}
-	// 5. POI=090201 → null，不显示
-	if primaryTypecode == "090201" {
-		log.Printf("[分类算法] %s → null (090201)", name)
-		return "null", "null", 0
+	log.Printf("[分类算法] %s → %s (typecode: %s)", name, cat.Labels["zh"], typecode)
+	return cat.Labels["zh"], cat.Icon, cat.DisplayOrder
+}
+
+// 高德POI的childtype及其是否为空（包括空字符串、null、[]等）
+func poiChildtype(poi map[string]interface{}) (string, bool) {
+	childtypeStr := ""
+	if childtype, ok := poi["childtype"]; ok && childtype != nil {
+		childtypeStr = fmt.Sprintf("%v", childtype)
 	}
-	// 6. POI=090102 → 社区医院，小红十字BOLD，排序3
-	if primaryTypecode == "090102" {
-		log.Printf("[分类算法] %s → 社区医院", name)
-		return "社区医院", "icon_small_red_cross_bold", 3
-	}
-	// 7. POI=090200 → 专科，小红十字BOLD，排序4
-	if primaryTypecode == "090200" {
-		log.Printf("[分类算法] %s → 专科", name)
-		return "专科", "icon_small_red_cross_bold", 4
-	}
-	// 8. POI=090202 → 牙科，Tooth，排序5
-	if primaryTypecode == "090202" {
-		log.Printf("[分类算法] %s → 牙科", name)
-		return "牙科", "icon_tooth", 5
-	}
-	// 9. POI=090203 → 眼科，小红十字Normal，排序6
-	if primaryTypecode == "090203" {
-		log.Printf("[分类算法] %s → 眼科", name)
-		return "眼科", "icon_small_red_cross_normal", 6
-	}
-	// 10. POI=090204 → 耳鼻喉，小红十字Normal，排序7
-	if primaryTypecode == "090204" {
-		log.Printf("[分类算法] %s → 耳鼻喉", name)
-		return "耳鼻喉", "icon_small_red_cross_normal", 7
-	}
-	// 11. POI=090205 → 胸科，小红十字Normal，排序8
-	if primaryTypecode == "090205" {
-		log.Printf("[分类算法] %s → 胸科", name)
-		return "胸科", "icon_small_red_cross_normal", 8
-	}
-	// 12. POI=090206 → 骨科，小红十字Normal，排序9
-	if primaryTypecode == "090206" {
-		log.Printf("[分类算法] %s → 骨科", name)
-		return "骨科", "icon_small_red_cross_normal", 9
-	}
-	// 13. POI=090207 → 肿瘤，小红十字Normal，排序10
-	if primaryTypecode == "090207" {
-		log.Printf("[分类算法] %s → 肿瘤", name)
-		return "肿瘤", "icon_small_red_cross_normal", 10
-	}
-	// 14. POI=090208 → 脑科，小红十字Normal，排序11
-	if primaryTypecode == "090208" {
-		log.Printf("[分类算法] %s → 脑科", name)
-		return "脑科", "icon_small_red_cross_normal", 11
-	}
-	// 15. POI=090209 → 妇科，小红十字Normal，排序12
-	if primaryTypecode == "090209" {
-		log.Printf("[分类算法] %s → 妇科", name)
-		return "妇科", "icon_small_red_cross_normal", 12
-	}
-	// 16. POI=090210 → 精神，小红十字Normal，排序13
-	if primaryTypecode == "090210" {
-		log.Printf("[分类算法] %s → 精神", name)
-		return "精神", "icon_small_red_cross_normal", 13
-	}
-	// 17. POI=090211 → 传染病，小红十字Normal，排序14
-	if primaryTypecode == "090211" {
-		log.Printf("[分类算法] %s → 传染病", name)
-		return "传染病", "icon_small_red_cross_normal", 14
-	}
-	// 18. POI=090300 → 诊所，小红十字Normal，排序15
-	if primaryTypecode == "090300" {
-		log.Printf("[分类算法] %s → 诊所", name)
-		return "诊所", "icon_small_red_cross_normal", 15
-	}
-	// 19. POI=090400 → 急救中心，ER.png，排序16
-	if primaryTypecode == "090400" {
-		log.Printf("[分类算法] %s → 急救中心", name)
-		return "急救中心", "icon_er", 16
-	}
-	// 其它默认
-	log.Printf("[分类算法] %s → 其他 (主要typecode: %s)", name, primaryTypecode)
-	return "其他", "icon_default", 99
+	return childtypeStr, childtypeStr == "" || childtypeStr == "[]" || childtypeStr == "null" || childtypeStr == "0"
 }
 
 // 地址最大交集
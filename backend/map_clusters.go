@@ -0,0 +1,364 @@
+package main
+
+import (
+	"database/sql"
+	"encoding/json"
+	"fmt"
+	"math"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+
+	"github.com/gin-gonic/gin"
+)
+
+// 地图聚合：按缩放级别把医院点归入Web墨卡托像素网格，每格一个聚合点并按类别计数。
+// 点来自SQLite：后台抓取的POI（不含子POI和不显示的类别）及hospitals表中未被抓取到的医院。
+// /api/map/clusters 返回JSON，/api/map/tiles/{z}/{x}/{y}.mvt 返回同一网格的矢量瓦片
+
+const (
+	clusterRadiusPx = 60 // 网格边长（像素，按256像素瓦片）
+	clusterTileSize = 256
+	maxClusterZoom  = 17 // 超过该级别不再聚合
+	maxMapZoom      = 22
+	mvtLayerName    = "hospitals"
+	mvtBufferPx     = 64 // 瓦片缓冲区（瓦片坐标），避免边缘图标被裁切
+)
+
+// 地图上的一个医院点
+type mapPoint struct {
+	ID       string
+	Name     string
+	Lng, Lat float64
+	Category string
+	Icon     string
+	Source   string  // crawl / sqlite
+	x, y     float64 // Web墨卡托归一化坐标，[0,1)
+}
+
+// 单个医院点的信息
+type MapPOI struct {
+	ID       string `json:"id"`
+	Name     string `json:"name"`
+	Category string `json:"category"`
+	Icon     string `json:"icon"`
+	Source   string `json:"source"`
+}
+
+// 聚合点；只含一个医院时带poi
+type MapCluster struct {
+	ID         string         `json:"id"`
+	Lng        float64        `json:"lng"`
+	Lat        float64        `json:"lat"`
+	Count      int            `json:"count"`
+	Categories map[string]int `json:"categories"`
+	// 点击展开到的缩放级别：该级别下聚合点开始拆分
+	ExpansionZoom int     `json:"expansion_zoom,omitempty"`
+	POI           *MapPOI `json:"poi,omitempty"`
+	x, y          float64
+}
+
+// 经纬度转Web墨卡托归一化坐标
+func mercatorXY(lng, lat float64) (float64, float64) {
+	lat = math.Max(-85.05112878, math.Min(85.05112878, lat))
+	sin := math.Sin(lat * math.Pi / 180)
+	return lng/360 + 0.5, 0.5 - math.Log((1+sin)/(1-sin))/(4*math.Pi)
+}
+
+func mercatorLngLat(x, y float64) (float64, float64) {
+	return (x - 0.5) * 360, math.Atan(math.Sinh((0.5-y)*2*math.Pi)) * 180 / math.Pi
+}
+
+// 点集缓存，按坐标系分别缓存；写入pois、hospitals或等级登记表后由invalidateMapPoints清空，
+// 随db切换重新加载
+var mapPointCache struct {
+	sync.Mutex
+	db     *sql.DB
+	loaded bool
+	points map[string][]mapPoint
+}
+
+func invalidateMapPoints() {
+	mapPointCache.Lock()
+	mapPointCache.loaded = false
+	mapPointCache.Unlock()
+}
+
+func mapPoints(crs string) ([]mapPoint, error) {
+	mapPointCache.Lock()
+	defer mapPointCache.Unlock()
+	if mapPointCache.db != db || !mapPointCache.loaded {
+		points, err := loadMapPoints()
+		if err != nil {
+			return nil, err
+		}
+		mapPointCache.db, mapPointCache.loaded = db, true
+		mapPointCache.points = map[string][]mapPoint{storageCRS: points}
+	}
+	if points, ok := mapPointCache.points[crs]; ok {
+		return points, nil
+	}
+	// 底图不是GCJ-02时按其坐标系重新投影，网格也在该坐标系下划分
+	base := mapPointCache.points[storageCRS]
+	points := make([]mapPoint, len(base))
+	for i, p := range base {
+		p.Lng, p.Lat = convertCoord(p.Lng, p.Lat, storageCRS, crs)
+		p.x, p.y = mercatorXY(p.Lng, p.Lat)
+		points[i] = p
+	}
+	mapPointCache.points[crs] = points
+	return points, nil
+}
+
+func loadMapPoints() ([]mapPoint, error) {
+	var points []mapPoint
+	crawledNames := map[string]bool{}
+	rows, err := db.Query(`
+		SELECT id, name, COALESCE(typecode, ''), COALESCE(raw, ''), COALESCE(longitude, 0), COALESCE(latitude, 0)
+		FROM pois WHERE removed_at IS NULL AND COALESCE(parent_id, '') = ''
+		ORDER BY id
+	`)
+	if err != nil {
+		return nil, err
+	}
+	for rows.Next() {
+		var p mapPoint
+		var typecode, raw string
+		if err := rows.Scan(&p.ID, &p.Name, &typecode, &raw, &p.Lng, &p.Lat); err != nil {
+			rows.Close()
+			return nil, err
+		}
+		var poi map[string]interface{}
+		json.Unmarshal([]byte(raw), &poi)
+		_, childtypeEmpty := poiChildtype(poi)
+		cat := taxonomy.Classify(typecode, childtypeEmpty)
+		if cat == nil || (p.Lng == 0 && p.Lat == 0) {
+			continue
+		}
+		p.Category, p.Icon, p.Source = cat.Labels["zh"], cat.Icon, "crawl"
+		crawledNames[normalizePOIName(p.Name)] = true
+		points = append(points, p)
+	}
+	rows.Close()
+
+	// hospitals表中的医院按等级取090101/090100分类，与离线数据一致
+	gradeEntries()
+	var hospitals []Hospital
+	rows, err = db.Query(`SELECT id, name, latitude, longitude, COALESCE(qualifications, '') FROM hospitals ORDER BY id`)
+	if err != nil {
+		return nil, err
+	}
+	for rows.Next() {
+		var h Hospital
+		if err := rows.Scan(&h.ID, &h.Name, &h.Latitude, &h.Longitude, &h.Qualifications); err != nil {
+			rows.Close()
+			return nil, err
+		}
+		if !crawledNames[normalizePOIName(h.Name)] {
+			hospitals = append(hospitals, h)
+		}
+	}
+	rows.Close()
+	for _, h := range hospitals {
+		tc := "090100"
+		if grade := resolveHospitalGrade(h.Name, gradeHints{Qualifications: h.Qualifications}); grade != nil && grade.Grade == "三级甲等" {
+			tc = "090101"
+		}
+		cat := taxonomy.Classify(tc, true)
+		if cat == nil {
+			continue
+		}
+		points = append(points, mapPoint{
+			ID: "db_" + strconv.Itoa(h.ID), Name: h.Name, Lng: h.Longitude, Lat: h.Latitude,
+			Category: cat.Labels["zh"], Icon: cat.Icon, Source: "sqlite",
+		})
+	}
+	for i := range points {
+		points[i].x, points[i].y = mercatorXY(points[i].Lng, points[i].Lat)
+	}
+	return points, nil
+}
+
+// 缩放级别z下的网格边长（归一化坐标）
+func clusterCellSize(z int) float64 {
+	return clusterRadiusPx / (clusterTileSize * math.Exp2(float64(z)))
+}
+
+type clusterCell struct{ cx, cy int }
+
+func cellOf(p mapPoint, size float64) clusterCell {
+	return clusterCell{int(math.Floor(p.x / size)), int(math.Floor(p.y / size))}
+}
+
+// 按网格聚合；z超过maxClusterZoom时每个点单独成组
+func clusterPoints(points []mapPoint, z int) []MapCluster {
+	size := clusterCellSize(z)
+	groups := map[clusterCell][]mapPoint{}
+	var cells []clusterCell
+	for i, p := range points {
+		cell := cellOf(p, size)
+		if z > maxClusterZoom {
+			cell = clusterCell{i, -1}
+		}
+		if _, ok := groups[cell]; !ok {
+			cells = append(cells, cell)
+		}
+		groups[cell] = append(groups[cell], p)
+	}
+
+	clusters := make([]MapCluster, 0, len(cells))
+	for _, cell := range cells {
+		members := groups[cell]
+		cl := MapCluster{Count: len(members), Categories: map[string]int{}}
+		for _, p := range members {
+			cl.x += p.x / float64(len(members))
+			cl.y += p.y / float64(len(members))
+			cl.Categories[p.Category]++
+		}
+		if len(members) == 1 {
+			p := members[0]
+			cl.ID, cl.Lng, cl.Lat = p.ID, p.Lng, p.Lat
+			cl.POI = &MapPOI{ID: p.ID, Name: p.Name, Category: p.Category, Icon: p.Icon, Source: p.Source}
+		} else {
+			cl.ID = fmt.Sprintf("%d/%d/%d", z, cell.cx, cell.cy)
+			cl.Lng, cl.Lat = mercatorLngLat(cl.x, cl.y)
+			cl.ExpansionZoom = clusterExpansionZoom(members, z)
+		}
+		clusters = append(clusters, cl)
+	}
+	// 大的聚合点在前，便于前端按顺序绘制
+	sort.SliceStable(clusters, func(i, j int) bool {
+		if clusters[i].Count != clusters[j].Count {
+			return clusters[i].Count > clusters[j].Count
+		}
+		return clusters[i].ID < clusters[j].ID
+	})
+	return clusters
+}
+
+// 聚合点开始拆分的最小缩放级别；同一位置的点始终不拆分时为maxClusterZoom+1
+func clusterExpansionZoom(members []mapPoint, z int) int {
+	for zz := z + 1; zz <= maxClusterZoom; zz++ {
+		size := clusterCellSize(zz)
+		first := cellOf(members[0], size)
+		for _, p := range members[1:] {
+			if cellOf(p, size) != first {
+				return zz
+			}
+		}
+	}
+	return maxClusterZoom + 1
+}
+
+// 归一化坐标矩形内的点
+func pointsInRange(points []mapPoint, minX, minY, maxX, maxY float64) []mapPoint {
+	var res []mapPoint
+	for _, p := range points {
+		if p.x >= minX && p.x < maxX && p.y >= minY && p.y < maxY {
+			res = append(res, p)
+		}
+	}
+	return res
+}
+
+func zoomParam(c *gin.Context, s string) (int, bool) {
+	z, err := strconv.Atoi(s)
+	if err != nil || z < 0 || z > maxMapZoom {
+		c.JSON(http.StatusBadRequest, gin.H{"error": fmt.Sprintf("zoom应为0-%d的整数", maxMapZoom)})
+		return 0, false
+	}
+	return z, true
+}
+
+// 地图聚合：GET /api/map/clusters?bbox=minLng,minLat,maxLng,maxLat&zoom=12，bbox和返回坐标为crs坐标系
+func getMapClusters(c *gin.Context) {
+	crs, ok := crsParam(c)
+	if !ok {
+		return
+	}
+	min, max, err := parseBBox(c.Query("bbox"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	z, ok := zoomParam(c, c.Query("zoom"))
+	if !ok {
+		return
+	}
+	points, err := mapPoints(crs)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+	minX, maxY := mercatorXY(min.Lng, min.Lat)
+	maxX, minY := mercatorXY(max.Lng, max.Lat)
+	inView := pointsInRange(points, minX, minY, maxX, maxY)
+	clusters := clusterPoints(inView, z)
+	c.JSON(http.StatusOK, gin.H{
+		"status":   "success",
+		"crs":      crs,
+		"zoom":     z,
+		"total":    len(inView),
+		"count":    len(clusters),
+		"clusters": clusters,
+	})
+}
+
+// 矢量瓦片：GET /api/map/tiles/{z}/{x}/{y}.mvt，图层hospitals；crs为底图坐标系（如OSM为wgs84）。
+// 聚合点属性：count、category（数量最多的类别）、expansion_zoom；单个医院另带id、name、icon
+func getMapTile(c *gin.Context) {
+	crs, ok := crsParam(c)
+	if !ok {
+		return
+	}
+	z, ok := zoomParam(c, c.Param("z"))
+	if !ok {
+		return
+	}
+	n := 1 << uint(z)
+	x, errX := strconv.Atoi(c.Param("x"))
+	y, errY := strconv.Atoi(strings.TrimSuffix(c.Param("y"), ".mvt"))
+	if errX != nil || errY != nil || !strings.HasSuffix(c.Param("y"), ".mvt") || x < 0 || y < 0 || x >= n || y >= n {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "瓦片坐标无效"})
+		return
+	}
+	points, err := mapPoints(crs)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+
+	// 与瓦片相交的网格中的点都参与聚合，聚合点中心落在瓦片（含缓冲区）内的才写入
+	tile := 1 / float64(n)
+	minX, minY := float64(x)*tile, float64(y)*tile
+	margin := clusterCellSize(z) + tile*mvtBufferPx/mvtExtent
+	clusters := clusterPoints(pointsInRange(points, minX-margin, minY-margin, minX+tile+margin, minY+tile+margin), z)
+
+	var features []mvtFeature
+	for _, cl := range clusters {
+		tx, ty := mvtTileCoord(z, x, y, cl.x, cl.y)
+		if tx < -mvtBufferPx || ty < -mvtBufferPx || tx > mvtExtent+mvtBufferPx || ty > mvtExtent+mvtBufferPx {
+			continue
+		}
+		props := map[string]interface{}{"count": cl.Count, "category": dominantCategory(cl.Categories)}
+		if cl.POI != nil {
+			props["id"], props["name"], props["icon"] = cl.POI.ID, cl.POI.Name, cl.POI.Icon
+		} else {
+			props["expansion_zoom"] = cl.ExpansionZoom
+		}
+		features = append(features, mvtFeature{ID: uint64(len(features) + 1), X: tx, Y: ty, Properties: props})
+	}
+	c.Data(http.StatusOK, "application/vnd.mapbox-vector-tile", encodeMVT(mvtLayerName, features))
+}
+
+func dominantCategory(categories map[string]int) string {
+	best, bestN := "", 0
+	for cat, n := range categories {
+		if n > bestN || (n == bestN && cat < best) {
+			best, bestN = cat, n
+		}
+	}
+	return best
+}
@@ -0,0 +1,273 @@
+package main
+
+import (
+	"fmt"
+	"math"
+	"net/http"
+	"testing"
+	"time"
+)
+
+func TestClusterPoints(t *testing.T) {
+	if x, y := mercatorXY(0, 0); x != 0.5 || y != 0.5 {
+		t.Fatalf("墨卡托原点: %v %v", x, y)
+	}
+	if lng, lat := mercatorLngLat(mercatorXY(116.417, 39.912)); math.Abs(lng-116.417) > 1e-9 || math.Abs(lat-39.912) > 1e-9 {
+		t.Fatalf("墨卡托往返: %v %v", lng, lat)
+	}
+
+	var points []mapPoint
+	add := func(id string, lng, lat float64, cat string) {
+		p := mapPoint{ID: id, Name: id, Lng: lng, Lat: lat, Category: cat}
+		p.x, p.y = mercatorXY(lng, lat)
+		points = append(points, p)
+	}
+	// 协和附近三家（相距约100米），另一家在20公里外
+	add("a", 116.4170, 39.9120, "三甲")
+	add("b", 116.4180, 39.9125, "三甲")
+	add("c", 116.4175, 39.9115, "社区")
+	add("d", 116.2000, 39.9000, "社区")
+
+	clusters := clusterPoints(points, 10)
+	if len(clusters) != 2 || clusters[0].Count != 3 || clusters[0].Categories["三甲"] != 2 || clusters[0].POI != nil {
+		t.Fatalf("zoom 10: %+v", clusters)
+	}
+	if clusters[1].POI == nil || clusters[1].POI.ID != "d" || clusters[1].Lng != 116.2 {
+		t.Fatalf("单点: %+v", clusters[1])
+	}
+	// 展开级别下这三家不再全部落在同一格
+	ez := clusters[0].ExpansionZoom
+	if ez <= 10 || ez > maxClusterZoom {
+		t.Fatalf("展开级别 %d", ez)
+	}
+	if split := clusterPoints(points[:3], ez); len(split) < 2 {
+		t.Fatalf("zoom %d 仍为 %d 个聚合点", ez, len(split))
+	}
+	if cl := clusterPoints(points, maxClusterZoom+1); len(cl) != 4 {
+		t.Fatalf("最大级别不聚合: %d", len(cl))
+	}
+	// 同一位置的点始终不拆分
+	add("e", 116.2000, 39.9000, "社区")
+	if cl := clusterPoints(points[3:], 12); len(cl) != 1 || cl[0].ExpansionZoom != maxClusterZoom+1 {
+		t.Fatalf("同一位置: %+v", cl)
+	}
+}
+
+func TestMapClusterAPI(t *testing.T) {
+	e := setupE2E(t, nil)
+	for i, p := range []struct {
+		id, name, typecode string
+		lng, lat           float64
+	}{
+		{"B0MAP1", "北京协和医院", "090101", 116.4170, 39.9120},
+		{"B0MAP2", "测试东单医院", "090100", 116.4180, 39.9125},
+		{"B0MAP3", "测试东单诊所", "090102", 116.4175, 39.9115},
+		{"B0MAP4", "测试专科门诊", "090201", 116.4176, 39.9116}, // 分类体系不显示
+	} {
+		db.Exec(`
+			INSERT INTO pois (id, name, typecode, query_typecode, longitude, latitude, raw, first_seen, last_seen)
+			VALUES (?, ?, ?, ?, ?, ?, ?, '2025-07-01T00:00:00Z', ?)
+		`, p.id, p.name, p.typecode, p.typecode, p.lng, p.lat,
+			fmt.Sprintf(`{"id":%q,"name":%q,"typecode":%q,"childtype":""}`, p.id, p.name, p.typecode),
+			fmt.Sprintf("2025-07-01T00:00:0%dZ", i))
+	}
+	db.Exec(`INSERT INTO pois (id, name, typecode, longitude, latitude, raw, first_seen, last_seen, parent_id, childtype)
+		VALUES ('B0MAP1C', '北京协和医院-急诊', '090101', 116.4171, 39.9121, '{}', '2025-07-01T00:00:00Z', '2025-07-01T00:00:00Z', 'B0MAP1', 'entrance')`)
+	// hospitals表中已被抓取到的医院不重复计入
+	for _, h := range []struct {
+		name     string
+		lng, lat float64
+	}{{"北京协和医院", 116.417, 39.912}, {"测试西山医院", 116.20, 39.90}} {
+		insertTestHospital(t, h.name, h.lng, h.lat)
+	}
+
+	w, body := e.get("/api/map/clusters?bbox=116.0,39.8,116.6,40.0&zoom=10")
+	if w.Code != http.StatusOK || body["total"].(float64) != 4 || body["count"].(float64) != 2 {
+		t.Fatalf("聚合 %d: %v", w.Code, body)
+	}
+	big := body["clusters"].([]interface{})[0].(map[string]interface{})
+	if big["count"].(float64) != 3 || len(big["categories"].(map[string]interface{})) != 3 || big["expansion_zoom"] == nil {
+		t.Fatalf("聚合点: %v", big)
+	}
+	single := body["clusters"].([]interface{})[1].(map[string]interface{})
+	if poi := single["poi"].(map[string]interface{}); poi["name"] != "测试西山医院" || poi["source"] != "sqlite" {
+		t.Fatalf("单点: %v", single)
+	}
+	if _, body = e.get("/api/map/clusters?bbox=116.41,39.91,116.42,39.92&zoom=18"); body["count"].(float64) != 3 {
+		t.Fatalf("最大级别: %v", body)
+	}
+	for _, path := range []string{
+		"/api/map/clusters?zoom=10",
+		"/api/map/clusters?bbox=116.6,39.8,116.0,40.0&zoom=10",
+		"/api/map/clusters?bbox=116.0,39.8,116.6,40.0&zoom=30",
+		"/api/map/tiles/10/843/388.png",
+		"/api/map/tiles/2/4/1.mvt",
+	} {
+		if w, _ := e.get(path); w.Code != http.StatusBadRequest {
+			t.Fatalf("%s: %d", path, w.Code)
+		}
+	}
+
+	// 请求时不再查库：绕过写入路径直接写库时仍用缓存
+	db.Exec(`INSERT INTO pois (id, name, typecode, longitude, latitude, raw, first_seen, last_seen)
+		VALUES ('B0MAP5', '测试朝阳医院', '090100', 116.45, 39.95, '{}', '2025-07-02T00:00:00Z', '2025-07-02T00:00:00Z')`)
+	if _, body = e.get("/api/map/clusters?bbox=116.0,39.8,116.6,40.0&zoom=10"); body["total"].(float64) != 4 {
+		t.Fatalf("应使用缓存: %v", body)
+	}
+	// 抓取写入POI、写入医院后缓存失效
+	if _, err := upsertCrawledPOIs(1, []RawPOIRecord{{Typecode: "090100", POIs: []interface{}{map[string]interface{}{
+		"id": "B0MAP6", "name": "测试通州医院", "typecode": "090100", "location": "116.50,39.85",
+	}}}}, time.Now()); err != nil {
+		t.Fatal(err)
+	}
+	if _, body = e.get("/api/map/clusters?bbox=116.0,39.8,116.6,40.0&zoom=10"); body["total"].(float64) != 6 {
+		t.Fatalf("抓取后缓存未失效: %v", body)
+	}
+	(&HospitalSpider{}).SaveHospitals([]Hospital{{Name: "测试门头沟医院", Latitude: 39.94, Longitude: 116.10}})
+	if _, body = e.get("/api/map/clusters?bbox=116.0,39.8,116.6,40.0&zoom=10"); body["total"].(float64) != 7 {
+		t.Fatalf("写入医院后缓存未失效: %v", body)
+	}
+
+	// 协和所在的z14瓦片
+	x, y := mercatorXY(116.4175, 39.912)
+	tx, ty := int(x*(1<<14)), int(y*(1<<14))
+	w, _ = e.get(fmt.Sprintf("/api/map/tiles/14/%d/%d.mvt", tx, ty))
+	if w.Code != http.StatusOK || w.Header().Get("Content-Type") != "application/vnd.mapbox-vector-tile" {
+		t.Fatalf("瓦片 %d", w.Code)
+	}
+	layers := decodeTestMVT(t, w.Body.Bytes())
+	// 网格可能把三家分到相邻两格，总数不变
+	var total uint64
+	for _, f := range layers[mvtLayerName] {
+		total += f.props["count"].(uint64)
+		if f.x < 0 || f.x > mvtExtent || f.y < 0 || f.y > mvtExtent {
+			t.Fatalf("瓦片坐标 %d,%d", f.x, f.y)
+		}
+		if (f.props["count"] == uint64(1)) != (f.props["name"] != nil) || (f.props["name"] == nil) != (f.props["expansion_zoom"] != nil) {
+			t.Fatalf("要素属性: %v", f.props)
+		}
+	}
+	if total != 3 {
+		t.Fatalf("瓦片要素: %+v", layers)
+	}
+	w, _ = e.get(fmt.Sprintf("/api/map/tiles/14/%d/%d.mvt", tx+5, ty))
+	if layers := decodeTestMVT(t, w.Body.Bytes()); len(layers[mvtLayerName]) != 0 {
+		t.Fatalf("空瓦片: %+v", layers)
+	}
+}
+
+type testMVTFeature struct {
+	x, y  int
+	props map[string]interface{}
+}
+
+// 按MVT规范解码瓦片，只支持encodeMVT写入的字段
+func decodeTestMVT(t *testing.T, data []byte) map[string][]testMVTFeature {
+	t.Helper()
+	type field struct {
+		num   int
+		value uint64
+		data  []byte
+	}
+	parse := func(b []byte) []field {
+		var fields []field
+		varint := func() uint64 {
+			var v uint64
+			for shift := uint(0); ; shift += 7 {
+				if len(b) == 0 {
+					t.Fatal("protobuf截断")
+				}
+				c := b[0]
+				b = b[1:]
+				v |= uint64(c&0x7f) << shift
+				if c < 0x80 {
+					return v
+				}
+			}
+		}
+		for len(b) > 0 {
+			key := varint()
+			f := field{num: int(key >> 3)}
+			switch key & 7 {
+			case 0:
+				f.value = varint()
+			case 2:
+				n := varint()
+				f.data, b = b[:n], b[n:]
+			default:
+				t.Fatalf("不支持的wire type %d", key&7)
+			}
+			fields = append(fields, f)
+		}
+		return fields
+	}
+	packed := func(b []byte) []uint64 {
+		var vs []uint64
+		for _, f := range parse(append([]byte{0x08}, joinVarints(b)...)) {
+			vs = append(vs, f.value)
+		}
+		return vs
+	}
+
+	layers := map[string][]testMVTFeature{}
+	for _, lf := range parse(data) {
+		if lf.num != 3 {
+			continue
+		}
+		var name string
+		var keys []string
+		var values []interface{}
+		var rawFeatures [][]byte
+		for _, f := range parse(lf.data) {
+			switch f.num {
+			case 1:
+				name = string(f.data)
+			case 2:
+				rawFeatures = append(rawFeatures, f.data)
+			case 3:
+				keys = append(keys, string(f.data))
+			case 4:
+				v := parse(f.data)[0]
+				if v.num == 1 {
+					values = append(values, string(v.data))
+				} else {
+					values = append(values, v.value)
+				}
+			}
+		}
+		layers[name] = []testMVTFeature{}
+		for _, raw := range rawFeatures {
+			feat := testMVTFeature{props: map[string]interface{}{}}
+			for _, f := range parse(raw) {
+				switch f.num {
+				case 2:
+					tags := packed(f.data)
+					for i := 0; i+1 < len(tags); i += 2 {
+						feat.props[keys[tags[i]]] = values[tags[i+1]]
+					}
+				case 4:
+					geom := packed(f.data)
+					if len(geom) != 3 || geom[0] != 9 {
+						t.Fatalf("点几何: %v", geom)
+					}
+					unzig := func(v uint64) int { return int(v>>1) ^ -int(v&1) }
+					feat.x, feat.y = unzig(geom[1]), unzig(geom[2])
+				}
+			}
+			layers[name] = append(layers[name], feat)
+		}
+	}
+	return layers
+}
+
+// 把packed varint序列改写为重复的字段1，便于用同一个解析器读取
+func joinVarints(b []byte) []byte {
+	var out []byte
+	for i, c := range b {
+		out = append(out, c)
+		if c < 0x80 && i < len(b)-1 {
+			out = append(out, 0x08)
+		}
+	}
+	return out
+}
@@ -0,0 +1,371 @@
+package main
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"log"
+	"strings"
+)
+
+// 合并流程配置：查询的typecode及是否执行0901xx合并预处理；类别名称来自分类体系
+type mergeProfile struct {
+	Name        string
+	Typecodes   []string
+	Merge0901xx bool
+}
+
+// /api/amap/around 使用的配置
+var aroundMergeProfile = mergeProfile{
+	Name:        "around",
+	Typecodes:   []string{"090100", "090101", "090102", "090200", "090300", "090400", "090202"},
+	Merge0901xx: true,
+}
+
+// /api/merged-pois 使用的配置
+var mergedPoisMergeProfile = mergeProfile{
+	Name: "merged",
+	Typecodes: []string{
+		"090100", // 综合医院
+		"090101", // 三级甲等医院
+		"090102", // 社区医院
+		"090200", // 专科医院
+		"090202", // 牙科
+		"090203", // 眼科
+		"090204", // 耳鼻喉
+		"090205", // 胸科
+		"090206", // 骨科
+		"090207", // 肿瘤
+		"090208", // 脑科
+		"090209", // 妇科
+		"090210", // 精神
+		"090211", // 传染病
+		"090300", // 诊所
+		"090400", // 急救中心
+	},
+}
+
+func mergeProfileByName(name string) (mergeProfile, bool) {
+	switch name {
+	case "", mergedPoisMergeProfile.Name:
+		return mergedPoisMergeProfile, true
+	case aroundMergeProfile.Name:
+		return aroundMergeProfile, true
+	}
+	return mergeProfile{}, false
+}
+
+// 合并流程：台账 -> 标注类别 -> 去重 -> 名称/距离合并 -> OptOut标记
+// 返回与接口一致的合并结果 {status, count, pois}，不做分类
+func mergeLedger(ledger []RawPOIRecord, profile mergeProfile) map[string]interface{} {
+	queried := make(map[string]bool, len(profile.Typecodes))
+	for _, tc := range profile.Typecodes {
+		queried[tc] = true
+	}
+	poiMap := newOrderedPOIs()
+	for _, rec := range ledger {
+		for _, poi := range rec.POIs {
+			m, ok := poi.(map[string]interface{})
+			if !ok {
+				continue
+			}
+			id, _ := m["id"].(string)
+			// 仅标注本配置查询的typecode，其余保留原有类别
+			if cat, ok := taxonomy.TypeLabel(rec.Typecode, "zh"); ok && queried[rec.Typecode] {
+				m["hospital_category"] = cat
+			}
+			poiMap.Set(id, m)
+		}
+	}
+	if profile.Merge0901xx {
+		// 090100/090101合并预处理
+		merge0901xxHospitals(poiMap)
+	}
+	// 构建标准化POI缓冲JSON，增加icon_type字段
+	var mergedPois []map[string]interface{}
+	// POI去重合并逻辑
+	for _, v := range poiMap.List() {
+		m := v
+		tc, _ := m["typecode"].(string)
+		m["icon_type"] = taxonomy.LegacyIcon(tc)
+		// 牙科医院不去重，直接加入
+		if tc == "090202" {
+			mergedPois = append(mergedPois, m)
+			continue
+		}
+		// 其余POI去重
+		lat1, _ := parseFloatFromAny(m["location_lat"])
+		lng1, _ := parseFloatFromAny(m["location_lng"])
+		isDuplicate := false
+		for _, exist := range mergedPois {
+			tcExist, _ := exist["typecode"].(string)
+			if tcExist == "090202" {
+				continue // 不与牙科比对
+			}
+			lat2, _ := parseFloatFromAny(exist["location_lat"])
+			lng2, _ := parseFloatFromAny(exist["location_lng"])
+			if lat1 != 0 && lng1 != 0 && lat2 != 0 && lng2 != 0 {
+				dist := calculateDistance(lat1, lng1, lat2, lng2) * 1000 // km->m
+				if dist < duplicateDistanceThreshold {
+					isDuplicate = true
+					// 同一医院的其他POI（急诊、门诊等）保留为院区出入口
+					name1, _ := m["name"].(string)
+					name2, _ := exist["name"].(string)
+					if nameSimilarity(name1, name2) >= 0.6 {
+						absorbCampusPoint(exist, exist, m)
+					}
+					break
+				}
+			}
+		}
+		if !isDuplicate {
+			mergedPois = append(mergedPois, m)
+		}
+	}
+	// POI合并优化：名称重叠>=4字且距离<350米的合并为一个
+	var finalPois []map[string]interface{}
+	optOutIds := make(map[string]bool)
+	for i, poi1 := range mergedPois {
+		if optOutIds[poi1["id"].(string)] {
+			continue
+		}
+		tc1, _ := poi1["typecode"].(string)
+		childtype1, _ := poi1["childtype"]
+		// 改进：更准确的childtype空值判断
+		isChildtype1Empty := false
+		if childtype1 == nil || childtype1 == "" {
+			isChildtype1Empty = true
+		} else if arr, ok := childtype1.([]interface{}); ok && len(arr) == 0 {
+			isChildtype1Empty = true
+		} else if arr, ok := childtype1.([]string); ok && len(arr) == 0 {
+			isChildtype1Empty = true
+		}
+
+		// 只对满足如下条件的POI参与合并
+		if (tc1 == "090101" && isChildtype1Empty) ||
+			strings.HasPrefix(tc1, "0901") ||
+			strings.HasPrefix(tc1, "0902") ||
+			strings.HasPrefix(tc1, "0903") ||
+			strings.HasPrefix(tc1, "0904") {
+			// 参与合并
+		} else {
+			finalPois = append(finalPois, poi1)
+			continue
+		}
+		for j := i + 1; j < len(mergedPois); j++ {
+			poi2 := mergedPois[j]
+			if optOutIds[poi2["id"].(string)] {
+				continue
+			}
+			tc2, _ := poi2["typecode"].(string)
+			childtype2, _ := poi2["childtype"]
+
+			// 改进：更准确的childtype空值判断
+			isChildtype2Empty := false
+			if childtype2 == nil || childtype2 == "" {
+				isChildtype2Empty = true
+			} else if arr, ok := childtype2.([]interface{}); ok && len(arr) == 0 {
+				isChildtype2Empty = true
+			} else if arr, ok := childtype2.([]string); ok && len(arr) == 0 {
+				isChildtype2Empty = true
+			}
+
+			if tc2 == "090101" && !isChildtype2Empty {
+				continue // 090101且childtype不为空的，不参与合并
+			}
+
+			// 改进名称重叠判定：优先检查完全相同的名称
+			name1, _ := poi1["name"].(string)
+			name2, _ := poi2["name"].(string)
+
+			// 如果名称完全相同，直接合并
+			if name1 == name2 {
+				// 距离判定
+				lat1, _ := parseFloatFromAny(poi1["location_lat"])
+				lng1, _ := parseFloatFromAny(poi1["location_lng"])
+				lat2, _ := parseFloatFromAny(poi2["location_lat"])
+				lng2, _ := parseFloatFromAny(poi2["location_lng"])
+				if lat1 != 0 && lng1 != 0 && lat2 != 0 && lng2 != 0 {
+					dist := calculateDistance(lat1, lng1, lat2, lng2) * 1000
+					if dist < duplicateDistanceThreshold {
+						// 合并为一个新POI，名称为原名称
+						merged := make(map[string]interface{})
+						for k, v := range poi1 {
+							merged[k] = v
+						}
+						// 修正：typecode/childtype优先保留主POI的值，如无则补全
+						if merged["typecode"] == nil || merged["typecode"] == "" {
+							merged["typecode"] = poi2["typecode"]
+						}
+						if merged["childtype"] == nil || merged["childtype"] == "" {
+							merged["childtype"] = poi2["childtype"]
+						}
+						absorbCampusPoint(merged, poi1, poi2)
+						finalPois = append(finalPois, merged)
+						optOutIds[poi2["id"].(string)] = true
+						goto NextPoi
+					}
+				}
+			} else {
+				// 改进的字符重叠判定逻辑：更智能的医院名称匹配
+				name1, _ := poi1["name"].(string)
+				name2, _ := poi2["name"].(string)
+
+				// 方法1：检查是否包含相同的医院核心名称（如"东直门医院"）
+				coreNames := []string{"医院", "门诊", "诊所", "中心", "院区", "分院"}
+				hasCoreOverlap := false
+				for _, core := range coreNames {
+					if strings.Contains(name1, core) && strings.Contains(name2, core) {
+						// 提取核心名称前的部分进行比较
+						idx1 := strings.Index(name1, core)
+						idx2 := strings.Index(name2, core)
+						if idx1 > 0 && idx2 > 0 {
+							prefix1 := name1[:idx1]
+							prefix2 := name2[:idx2]
+							// 检查前缀是否有重叠
+							if len(prefix1) >= 2 && len(prefix2) >= 2 {
+								// 计算前缀的重叠字符数
+								overlapCount := 0
+								for _, c := range prefix1 {
+									if strings.ContainsRune(prefix2, c) {
+										overlapCount++
+									}
+								}
+								if overlapCount >= 2 { // 至少2个字符重叠
+									hasCoreOverlap = true
+									log.Printf("[合并算法] 核心名称匹配: %s 和 %s 通过 %s 匹配 (重叠字符数: %d)", name1, name2, core, overlapCount)
+									break
+								}
+							}
+						}
+					}
+				}
+
+				// 方法2：检查是否包含相同的医院名称片段（如"东直门"）
+				if !hasCoreOverlap {
+					hospitalNameFragments := []string{"东直门", "协和", "同仁", "天坛", "安贞", "积水潭", "友谊", "宣武", "朝阳", "海淀", "丰台", "石景山", "门头沟", "房山", "通州", "顺义", "昌平", "大兴", "怀柔", "平谷", "密云", "延庆"}
+					for _, fragment := range hospitalNameFragments {
+						if strings.Contains(name1, fragment) && strings.Contains(name2, fragment) {
+							hasCoreOverlap = true
+							log.Printf("[合并算法] 医院名称片段匹配: %s 和 %s 通过 %s 匹配", name1, name2, fragment)
+							break
+						}
+					}
+				}
+
+				// 方法3：原有的字符重叠判定逻辑（作为兜底）
+				n1 := []rune(name1)
+				n2 := []rune(name2)
+				overlap := ""
+				for _, c := range n1 {
+					if strings.ContainsRune(string(n2), c) && !strings.ContainsRune(overlap, c) {
+						overlap += string(c)
+					}
+				}
+				charOverlapCount := len([]rune(overlap))
+
+				// 合并条件：核心名称重叠 或 字符重叠>=4
+				if hasCoreOverlap || charOverlapCount >= 4 {
+					log.Printf("[合并算法] 尝试合并: %s 和 %s (核心重叠: %v, 字符重叠: %d)", name1, name2, hasCoreOverlap, charOverlapCount)
+					// 距离判定
+					lat1, _ := parseFloatFromAny(poi1["location_lat"])
+					lng1, _ := parseFloatFromAny(poi1["location_lng"])
+					lat2, _ := parseFloatFromAny(poi2["location_lat"])
+					lng2, _ := parseFloatFromAny(poi2["location_lng"])
+					if lat1 != 0 && lng1 != 0 && lat2 != 0 && lng2 != 0 {
+						dist := calculateDistance(lat1, lng1, lat2, lng2) * 1000
+						if dist < duplicateDistanceThreshold {
+							// 合并为一个新POI，选择更简洁的名称
+							merged := make(map[string]interface{})
+							for k, v := range poi1 {
+								merged[k] = v
+							}
+
+							// 选择更简洁的名称作为合并后的名称
+							if len(name1) <= len(name2) {
+								merged["name"] = name1
+							} else {
+								merged["name"] = name2
+							}
+
+							// 修正：typecode/childtype优先保留主POI的值，如无则补全
+							if merged["typecode"] == nil || merged["typecode"] == "" {
+								merged["typecode"] = poi2["typecode"]
+							}
+							if merged["childtype"] == nil || merged["childtype"] == "" {
+								merged["childtype"] = poi2["childtype"]
+							}
+
+							// 添加合并日志
+							log.Printf("[合并算法] 合并医院: %s + %s -> %s (距离: %.1fm)", name1, name2, merged["name"], dist)
+
+							absorbCampusPoint(merged, poi1, poi2)
+							finalPois = append(finalPois, merged)
+							optOutIds[poi2["id"].(string)] = true
+							goto NextPoi
+						} else {
+							log.Printf("[合并算法] 距离过远，不合并: %s 和 %s (距离: %.1fm > %.1fm)", name1, name2, dist, duplicateDistanceThreshold)
+						}
+					} else {
+						log.Printf("[合并算法] 坐标无效，不合并: %s 和 %s", name1, name2)
+					}
+				} else {
+					log.Printf("[合并算法] 名称不匹配，不合并: %s 和 %s (核心重叠: %v, 字符重叠: %d)", name1, name2, hasCoreOverlap, charOverlapCount)
+				}
+			}
+		}
+		finalPois = append(finalPois, poi1)
+	NextPoi:
+	}
+	// 其它被合并的POI标记OptOut
+	for _, poi := range mergedPois {
+		if optOutIds[poi["id"].(string)] {
+			if poi["tags"] == nil {
+				poi["tags"] = []string{"OptOut"}
+			} else {
+				poi["tags"] = append(poi["tags"].([]string), "OptOut")
+			}
+		}
+	}
+
+	return map[string]interface{}{
+		"status": "1",
+		"count":  len(finalPois),
+		"pois":   finalPois,
+	}
+}
+
+// 修正医院类别、ICON、排序判定逻辑；子POI挂到父医院的children下并构建院区
+func classifyMergedPOIs(result map[string]interface{}) {
+	finalPois, _ := result["pois"].([]map[string]interface{})
+	for _, poi := range finalPois {
+		cat, icon, order := classifyHospital(poi)
+		poi["algo_hospital_category"] = cat
+		poi["algo_icon_type"] = icon
+		poi["algo_display_order"] = order
+	}
+	attachChildPOIs(finalPois)
+	attachCampuses(finalPois)
+	applyPOIGrades(finalPois)
+}
+
+// 最近一次在线查询的台账快照，完整历史见upstream_ledger表
+const ledgerSnapshotPath = "backend/cache/amap_query_ledger.json"
+
+func writeLedgerSnapshot(ledger []RawPOIRecord) {
+	ledgerBytes, _ := json.MarshalIndent(ledger, "", "  ")
+	if err := ioutil.WriteFile(ledgerSnapshotPath, ledgerBytes, 0644); err != nil {
+		log.Println("[台账] 写台账快照失败:", err)
+		return
+	}
+	log.Println("[台账] 台账快照写入成功，记录数：", len(ledger))
+}
+
+// 将合并后POI及TAG写入JSON文件，便于前端查看
+func writeMergedResult(result map[string]interface{}) {
+	mergedBytes, _ := json.MarshalIndent(result, "", "  ")
+	if err := ioutil.WriteFile(mergedResultSnapshotPath, mergedBytes, 0644); err != nil {
+		log.Println("[合并结果] 写入合并POI结果JSON失败:", err)
+		return
+	}
+	log.Println("[合并结果] 合并POI结果写入" + mergedResultSnapshotPath + "成功")
+}
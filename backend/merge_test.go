@@ -0,0 +1,184 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"io/ioutil"
+	"log"
+	"os"
+	"path/filepath"
+	"reflect"
+	"sort"
+	"strings"
+	"testing"
+)
+
+// go test ./backend -run TestMergeGolden -update 重新生成golden文件
+var updateGolden = flag.Bool("update", false, "重新生成testdata/merge下的golden文件")
+
+// 录制的高德place/around原始响应（台账格式）：输入文件 -> 合并配置
+var goldenCases = []struct {
+	input   string
+	profile string
+}{
+	{"ledger_beijing.json", "merged"},
+	{"ledger_beijing.json", "around"},
+}
+
+func TestMergeGolden(t *testing.T) {
+	// 合并算法日志量很大，测试期间关闭
+	log.SetOutput(ioutil.Discard)
+	defer log.SetOutput(os.Stderr)
+
+	for _, tc := range goldenCases {
+		name := strings.TrimSuffix(tc.input, ".json") + "." + tc.profile
+		t.Run(name, func(t *testing.T) {
+			result, err := ReplayFile(filepath.Join("testdata", "merge", tc.input), tc.profile)
+			if err != nil {
+				t.Fatalf("重放失败: %v", err)
+			}
+			got, _ := json.MarshalIndent(result, "", "  ")
+			got = append(got, '\n')
+
+			golden := filepath.Join("testdata", "merge", name+".golden.json")
+			if *updateGolden {
+				if err := ioutil.WriteFile(golden, got, 0644); err != nil {
+					t.Fatal(err)
+				}
+				return
+			}
+			want, err := ioutil.ReadFile(golden)
+			if err != nil {
+				t.Fatalf("读取golden文件失败（使用 -update 生成）: %v", err)
+			}
+			if !bytes.Equal(got, want) {
+				t.Errorf("合并结果与 %s 不一致（确认变更后使用 -update 更新）:\n%s", golden, diffMergedResults(want, got))
+			}
+		})
+	}
+}
+
+// 合并与分类生成的字段，不应出现在输入中
+var derivedPOIFields = []string{"hospital_category", "icon_type", "algo_display_order", "algo_hospital_category", "algo_icon_type"}
+
+// 输入必须是高德place/around的原始响应，否则golden无法发现这些字段的回归
+func TestMergeGoldenInputsRaw(t *testing.T) {
+	seen := map[string]bool{}
+	for _, tc := range goldenCases {
+		if seen[tc.input] {
+			continue
+		}
+		seen[tc.input] = true
+		data, err := ioutil.ReadFile(filepath.Join("testdata", "merge", tc.input))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if data = bytes.TrimSpace(decodeReplayData(data)); len(data) == 0 || data[0] != '[' {
+			t.Errorf("%s 应为台账格式 [{typecode, page, count, pois}]", tc.input)
+			continue
+		}
+		ledger, err := parseReplayInput(data, nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		for _, rec := range ledger {
+			for _, raw := range rec.POIs {
+				poi := raw.(map[string]interface{})
+				for _, f := range derivedPOIFields {
+					if _, ok := poi[f]; ok {
+						t.Fatalf("%s 中 %v 含合并生成的字段 %s", tc.input, poi["id"], f)
+					}
+				}
+			}
+		}
+	}
+}
+
+// 相同输入重复重放结果必须一致
+func TestReplayDeterministic(t *testing.T) {
+	log.SetOutput(ioutil.Discard)
+	defer log.SetOutput(os.Stderr)
+
+	var outputs [][]byte
+	for i := 0; i < 3; i++ {
+		result, err := ReplayFile(filepath.Join("testdata", "merge", "ledger_beijing.json"), "around")
+		if err != nil {
+			t.Fatal(err)
+		}
+		out, _ := json.Marshal(result)
+		outputs = append(outputs, out)
+	}
+	for i := 1; i < len(outputs); i++ {
+		if !bytes.Equal(outputs[0], outputs[i]) {
+			t.Fatalf("第%d次重放结果与第1次不同", i+1)
+		}
+	}
+}
+
+// 按POI对比合并结果，输出新增、消失及字段变化
+func diffMergedResults(want, got []byte) string {
+	var w, g struct {
+		Count int                      `json:"count"`
+		POIs  []map[string]interface{} `json:"pois"`
+	}
+	if err := json.Unmarshal(want, &w); err != nil {
+		return "golden文件无法解析: " + err.Error()
+	}
+	json.Unmarshal(got, &g)
+
+	key := func(poi map[string]interface{}) string {
+		id, _ := poi["id"].(string)
+		name, _ := poi["name"].(string)
+		return id + " " + name
+	}
+	index := func(pois []map[string]interface{}) map[string]map[string]interface{} {
+		m := make(map[string]map[string]interface{}, len(pois))
+		for _, poi := range pois {
+			m[key(poi)] = poi
+		}
+		return m
+	}
+	wm, gm := index(w.POIs), index(g.POIs)
+
+	var lines []string
+	if w.Count != g.Count {
+		lines = append(lines, fmt.Sprintf("count: %d -> %d", w.Count, g.Count))
+	}
+	for _, poi := range w.POIs {
+		if _, ok := gm[key(poi)]; !ok {
+			lines = append(lines, "- "+key(poi))
+		}
+	}
+	for _, poi := range g.POIs {
+		k := key(poi)
+		old, ok := wm[k]
+		if !ok {
+			lines = append(lines, "+ "+k)
+			continue
+		}
+		var fields []string
+		for f := range old {
+			fields = append(fields, f)
+		}
+		for f := range poi {
+			if _, ok := old[f]; !ok {
+				fields = append(fields, f)
+			}
+		}
+		sort.Strings(fields)
+		for _, f := range fields {
+			if !reflect.DeepEqual(old[f], poi[f]) {
+				lines = append(lines, fmt.Sprintf("~ %s %s: %v -> %v", k, f, old[f], poi[f]))
+			}
+		}
+	}
+	if len(lines) == 0 {
+		lines = append(lines, "POI内容相同，顺序或格式不同")
+	}
+	if len(lines) > 40 {
+		lines = append(lines[:40], fmt.Sprintf("... 另有 %d 处差异", len(lines)-40))
+	}
+	return strings.Join(lines, "\n")
+}
@@ -0,0 +1,128 @@
+package main
+
+import (
+	"math"
+	"sort"
+)
+
+// 极简Mapbox Vector Tile（MVT 2.1）编码：只支持点要素，属性值为字符串或无符号整数。
+// protobuf按规范手工编码，不依赖生成代码
+
+const mvtExtent = 4096
+
+// 瓦片中的一个点要素，X/Y为瓦片内坐标（0..mvtExtent，缓冲区内可略超出）
+type mvtFeature struct {
+	ID         uint64
+	X, Y       int
+	Properties map[string]interface{} // string / int / uint64
+}
+
+// protobuf写入器
+type pbWriter []byte
+
+func (b *pbWriter) varint(v uint64) {
+	for v >= 0x80 {
+		*b = append(*b, byte(v)|0x80)
+		v >>= 7
+	}
+	*b = append(*b, byte(v))
+}
+
+func (b *pbWriter) key(field, wire int) {
+	b.varint(uint64(field<<3 | wire))
+}
+
+func (b *pbWriter) uintField(field int, v uint64) {
+	b.key(field, 0)
+	b.varint(v)
+}
+
+func (b *pbWriter) bytesField(field int, data []byte) {
+	b.key(field, 2)
+	b.varint(uint64(len(data)))
+	*b = append(*b, data...)
+}
+
+func (b *pbWriter) packedField(field int, vs []uint32) {
+	var inner pbWriter
+	for _, v := range vs {
+		inner.varint(uint64(v))
+	}
+	b.bytesField(field, inner)
+}
+
+func zigzag(v int) uint32 {
+	return uint32((v << 1) ^ (v >> 31))
+}
+
+// 编码只含一个图层的瓦片；属性键和值在图层内去重
+func encodeMVT(layer string, features []mvtFeature) []byte {
+	var keys []string
+	keyIndex := map[string]uint32{}
+	var values [][]byte
+	valueIndex := map[string]uint32{}
+
+	var layerBuf pbWriter
+	layerBuf.uintField(15, 2) // version
+	layerBuf.bytesField(1, []byte(layer))
+	for _, f := range features {
+		var tags []uint32
+		props := make([]string, 0, len(f.Properties))
+		for k := range f.Properties {
+			props = append(props, k)
+		}
+		sort.Strings(props)
+		for _, k := range props {
+			ki, ok := keyIndex[k]
+			if !ok {
+				ki = uint32(len(keys))
+				keyIndex[k] = ki
+				keys = append(keys, k)
+			}
+			var val pbWriter
+			switch v := f.Properties[k].(type) {
+			case string:
+				val.bytesField(1, []byte(v))
+			case int:
+				val.uintField(5, uint64(v))
+			case uint64:
+				val.uintField(5, v)
+			default:
+				continue
+			}
+			vi, ok := valueIndex[string(val)]
+			if !ok {
+				vi = uint32(len(values))
+				valueIndex[string(val)] = vi
+				values = append(values, val)
+			}
+			tags = append(tags, ki, vi)
+		}
+		var feat pbWriter
+		feat.uintField(1, f.ID)
+		if len(tags) > 0 {
+			feat.packedField(2, tags)
+		}
+		feat.uintField(3, 1) // POINT
+		// MoveTo(1)，坐标为相对光标原点(0,0)的zigzag编码
+		feat.packedField(4, []uint32{1&0x7 | 1<<3, zigzag(f.X), zigzag(f.Y)})
+		layerBuf.bytesField(2, feat)
+	}
+	for _, k := range keys {
+		layerBuf.bytesField(3, []byte(k))
+	}
+	for _, v := range values {
+		layerBuf.bytesField(4, v)
+	}
+	layerBuf.uintField(5, mvtExtent)
+
+	var tile pbWriter
+	tile.bytesField(3, layerBuf)
+	return tile
+}
+
+// 瓦片(z,x,y)内Web墨卡托归一化坐标转为瓦片坐标
+func mvtTileCoord(z, x, y int, wx, wy float64) (int, int) {
+	n := math.Exp2(float64(z))
+	return int(math.Round((wx*n - float64(x)) * mvtExtent)), int(math.Round((wy*n - float64(y)) * mvtExtent))
+}
@@ -0,0 +1,450 @@
+package main
+
+import (
+	"database/sql"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"log"
+	"net/http"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+// 离线模式：不调用上游，搜索/合并/分类基于SQLite与本地缓存台账
+var offlineMode bool
+
+// 离线数据来源（逗号分隔的glob），兼容从仓库根目录或backend目录启动
+var defaultOfflineSources = []string{
+	"backend/amap_query_ledger.json",
+	"backend/cache/amap_query_ledger.json",
+	"backend/cache/cache_*_hospitals.json",
+	"cache/amap_query_ledger.json",
+	"cache/cache_*_hospitals.json",
+}
+
+func initOfflineMode() {
+	offlineMode = os.Getenv("OFFLINE_MODE") == "true"
+	if os.Getenv("AMAP_KEY") == "" {
+		offlineMode = true
+		log.Println("[离线模式] AMAP_KEY未设置，自动启用离线模式")
+	}
+	if offlineMode {
+		n := offlineStore.Reload()
+		log.Printf("[离线模式] 已启用，本地缓存POI %d 条", n)
+	}
+}
+
+// 离线POI及其抓取时间
+type offlinePOI struct {
+	Typecode  string // 查询时使用的typecode
+	POI       map[string]interface{}
+	FetchedAt time.Time
+}
+
+// offlinePOIStore 汇总台账文件、合并结果缓存、响应缓存与上游调用台账中的POI
+type offlinePOIStore struct {
+	mu       sync.Mutex
+	pois     map[string]offlinePOI
+	loadedAt time.Time
+}
+
+var offlineStore = &offlinePOIStore{}
+
+// 重新扫描本地数据源，同一POI保留最新抓取的版本
+func (s *offlinePOIStore) Reload() int {
+	pois := make(map[string]offlinePOI)
+	add := func(tc string, raw interface{}, fetchedAt time.Time) {
+		m, ok := raw.(map[string]interface{})
+		if !ok {
+			return
+		}
+		id, _ := m["id"].(string)
+		if id == "" {
+			return
+		}
+		if tc == "" {
+			tc, _ = m["typecode"].(string)
+		}
+		if len(tc) > 6 {
+			tc = tc[:6]
+		}
+		if old, ok := pois[id]; ok && old.FetchedAt.After(fetchedAt) {
+			return
+		}
+		pois[id] = offlinePOI{Typecode: tc, POI: m, FetchedAt: fetchedAt}
+	}
+
+	sources := defaultOfflineSources
+	if v := os.Getenv("OFFLINE_SOURCES"); v != "" {
+		sources = strings.Split(v, ",")
+	}
+	for _, pattern := range sources {
+		files, _ := filepath.Glob(strings.TrimSpace(pattern))
+		for _, file := range files {
+			info, err := os.Stat(file)
+			if err != nil {
+				continue
+			}
+			data, err := ioutil.ReadFile(file)
+			if err != nil {
+				continue
+			}
+			// 台账：[{typecode, pois}]
+			var ledger []RawPOIRecord
+			if json.Unmarshal(data, &ledger) == nil {
+				for _, rec := range ledger {
+					for _, poi := range rec.POIs {
+						add(rec.Typecode, poi, info.ModTime())
+					}
+				}
+				continue
+			}
+			// 合并结果：{status, count, pois}
+			var merged struct {
+				POIs []interface{} `json:"pois"`
+			}
+			if json.Unmarshal(data, &merged) == nil {
+				for _, poi := range merged.POIs {
+					add("", poi, info.ModTime())
+				}
+			}
+		}
+	}
+
+	// 响应缓存：键中包含查询typecode
+	if responseCache != nil && responseCache.dir != "" {
+		for _, f := range responseCache.listDisk() {
+			data, err := ioutil.ReadFile(f.path)
+			if err != nil {
+				continue
+			}
+			var entry diskCacheFile
+			if json.Unmarshal(data, &entry) != nil || !strings.Contains(entry.Key, "|place/around") {
+				continue
+			}
+			parts := strings.Split(entry.Key, "|")
+			tc := ""
+			if len(parts) == 6 {
+				tc = parts[4]
+			}
+			var resp struct {
+				POIs []interface{} `json:"pois"`
+			}
+			json.Unmarshal(entry.Body, &resp)
+			for _, poi := range resp.POIs {
+				add(tc, poi, entry.StoredAt)
+			}
+		}
+	}
+
+	// 上游调用台账：每个查询位置只取最新的成功记录，分批读取响应体
+	if db != nil {
+		ids := latestAroundLedgerIDs()
+		for start := 0; start < len(ids); start += offlineLedgerBatch {
+			end := start + offlineLedgerBatch
+			if end > len(ids) {
+				end = len(ids)
+			}
+			entries, _, _ := queryLedger(ledgerFilter{IDs: ids[start:end]}, true)
+			for _, e := range entries {
+				var resp struct {
+					POIs []interface{} `json:"pois"`
+				}
+				json.Unmarshal(e.Payload, &resp)
+				for _, poi := range resp.POIs {
+					add(e.Typecode, poi, e.CreatedAt)
+				}
+			}
+		}
+		// 后台抓取入库的POI
+		for _, p := range loadCrawledPOIs() {
+			add(p.Typecode, p.POI, p.FetchedAt)
+		}
+	}
+
+	s.mu.Lock()
+	s.pois = pois
+	s.loadedAt = time.Now()
+	s.mu.Unlock()
+	return len(pois)
+}
+
+const offlineLedgerBatch = 500
+
+// 周边查询台账中每个 (typecode, 页码, 半径, 位置格) 最新一次成功调用的id。
+// 台账只追加，同一区块反复抓取会积累大量重复记录，只读取元数据筛选后再取响应体
+func latestAroundLedgerIDs() []int64 {
+	rows, err := db.Query(`SELECT id, params, typecode, page FROM upstream_ledger WHERE endpoint = ? AND status = ? ORDER BY id`,
+		"place/around", LedgerStatusOK)
+	if err != nil {
+		return nil
+	}
+	defer rows.Close()
+	latest := make(map[string]int64)
+	for rows.Next() {
+		var id int64
+		var params, tc sql.NullString
+		var page sql.NullInt64
+		if rows.Scan(&id, &params, &tc, &page) != nil {
+			continue
+		}
+		var p map[string]string
+		json.Unmarshal([]byte(params.String), &p)
+		cell := p["location"]
+		if lng, lat, ok := parseLngLat(cell); ok {
+			cell = encodeGeohash(lat, lng, cacheGeohashPrecision)
+		}
+		latest[strings.Join([]string{tc.String, strconv.FormatInt(page.Int64, 10), p["radius"], cell}, "|")] = id
+	}
+	ids := make([]int64, 0, len(latest))
+	for _, id := range latest {
+		ids = append(ids, id)
+	}
+	sort.Slice(ids, func(i, j int) bool { return ids[i] < ids[j] })
+	return ids
+}
+
+// 按位置、半径和typecode筛选本地POI，返回与在线查询相同结构的台账
+// 只在取数据快照时持有锁，查询SQLite不阻塞其他请求
+func (s *offlinePOIStore) Around(location string, radius float64, typecodes []string) ([]RawPOIRecord, time.Time) {
+	s.mu.Lock()
+	loaded := s.pois != nil
+	s.mu.Unlock()
+	if !loaded {
+		s.Reload()
+	}
+	// Reload整体替换map而不修改，取得引用后即可在锁外遍历
+	s.mu.Lock()
+	stored := s.pois
+	s.mu.Unlock()
+
+	centerLng, centerLat, _ := parseLngLat(location)
+	byTypecode := make(map[string][]interface{})
+	var oldest time.Time
+	include := func(tc string, poi map[string]interface{}, fetchedAt time.Time) {
+		loc, _ := poi["location"].(string)
+		if !isInRadius(loc, centerLng, centerLat, radius) {
+			return
+		}
+		byTypecode[tc] = append(byTypecode[tc], poi)
+		if oldest.IsZero() || fetchedAt.Before(oldest) {
+			oldest = fetchedAt
+		}
+	}
+	// 按id排序遍历，保证输出稳定
+	ids := make([]string, 0, len(stored))
+	for id := range stored {
+		ids = append(ids, id)
+	}
+	sort.Strings(ids)
+	for _, id := range ids {
+		p := stored[id]
+		include(p.Typecode, copyPOI(p.POI), p.FetchedAt)
+	}
+	for _, h := range loadStoredHospitalPOIs() {
+		include(h.Typecode, h.POI, h.FetchedAt)
+	}
+
+	var ledger []RawPOIRecord
+	for _, tc := range typecodes {
+		ledger = append(ledger, RawPOIRecord{Typecode: tc, Page: 1, Count: len(byTypecode[tc]), POIs: byTypecode[tc]})
+	}
+	return ledger, oldest
+}
+
+// 浅拷贝POI，避免合并流程修改缓存中的原始数据
+func copyPOI(poi map[string]interface{}) map[string]interface{} {
+	cp := make(map[string]interface{}, len(poi))
+	for k, v := range poi {
+		cp[k] = v
+	}
+	return cp
+}
+
+// 读取后台抓取的POI（pois表），不含已消失的
+func loadCrawledPOIs() []offlinePOI {
+	var res []offlinePOI
+	rows, err := db.Query(`SELECT query_typecode, raw, last_seen FROM pois WHERE removed_at IS NULL`)
+	if err != nil {
+		return res
+	}
+	defer rows.Close()
+	for rows.Next() {
+		var tc, raw, lastSeen sql.NullString
+		if err := rows.Scan(&tc, &raw, &lastSeen); err != nil {
+			continue
+		}
+		var poi map[string]interface{}
+		if json.Unmarshal([]byte(raw.String), &poi) != nil {
+			continue
+		}
+		fetchedAt, _ := time.Parse(time.RFC3339, lastSeen.String)
+		res = append(res, offlinePOI{Typecode: tc.String, POI: poi, FetchedAt: fetchedAt})
+	}
+	return res
+}
+
+// 将SQLite中的医院转换为高德POI结构
+func loadStoredHospitalPOIs() []offlinePOI {
+	var res []offlinePOI
+	// 先加载等级登记表，遍历结果集时不再查询数据库
+	gradeEntries()
+	rows, err := db.Query(`
+		SELECT id, name, address, latitude, longitude, phone, qualifications, updated_at
+		FROM hospitals
+	`)
+	if err != nil {
+		return res
+	}
+	defer rows.Close()
+	for rows.Next() {
+		var id int
+		var name, address string
+		var lat, lng float64
+		var phone, qualifications, updatedAt sql.NullString
+		if err := rows.Scan(&id, &name, &address, &lat, &lng, &phone, &qualifications, &updatedAt); err != nil {
+			continue
+		}
+		tc := "090100"
+		if grade := resolveHospitalGrade(name, gradeHints{Qualifications: qualifications.String}); grade != nil && grade.Grade == "三级甲等" {
+			tc = "090101"
+		}
+		fetchedAt, _ := time.ParseInLocation("2006-01-02 15:04:05", updatedAt.String, time.Local)
+		res = append(res, offlinePOI{
+			Typecode: tc,
+			POI: map[string]interface{}{
+				"id":        "db_" + strconv.Itoa(id),
+				"name":      name,
+				"address":   address,
+				"location":  fmt.Sprintf("%f,%f", lng, lat),
+				"typecode":  tc,
+				"childtype": "",
+				"tel":       phone.String,
+				"source":    "sqlite",
+			},
+			FetchedAt: fetchedAt,
+		})
+	}
+	return res
+}
+
+// 离线响应说明
+type offlineInfo struct {
+	Reason   string
+	DataAsOf time.Time
+}
+
+// 在响应中标注离线/过期信息及数据年龄
+func (o *offlineInfo) apply(c *gin.Context, result map[string]interface{}) {
+	if o == nil {
+		return
+	}
+	c.Header("X-Data-Source", "offline")
+	c.Header("Warning", `110 - "Response is Stale"`)
+	result["offline"] = true
+	result["stale"] = true
+	result["offline_reason"] = o.Reason
+	if !o.DataAsOf.IsZero() {
+		result["data_as_of"] = o.DataAsOf.Format(time.RFC3339)
+		result["data_age_seconds"] = int(time.Since(o.DataAsOf).Seconds())
+	}
+}
+
+// 获取周边台账：离线模式直接读取本地数据；在线查询失败时回退到本地数据
+// 返回false表示已写入错误响应
+func loadAroundLedger(c *gin.Context, location, radius string, typecodes []string) ([]RawPOIRecord, *offlineInfo, bool) {
+	radiusM, _ := strconv.ParseFloat(radius, 64)
+	key := os.Getenv("AMAP_KEY")
+	if offlineMode || key == "" {
+		ledger, asOf := offlineStore.Around(location, radiusM, typecodes)
+		return ledger, &offlineInfo{Reason: "离线模式", DataAsOf: asOf}, true
+	}
+
+	// 跳过缓存会消耗上游配额，仅限管理员
+	force := c.Query("force") == "true"
+	if user, ok := currentUser(c); force && (!ok || roleLevels[user.Role] < roleLevels[RoleAdmin]) {
+		c.JSON(http.StatusForbidden, gin.H{"error": "force=true 需要管理员权限", "required_role": RoleAdmin})
+		return nil, nil, false
+	}
+
+	ctx := ledgerContext(c)
+	stats := &cacheStats{}
+	ledger, err := fetchAroundTypecodes(ctx, aroundQuery{
+		Key:         key,
+		Location:    location,
+		Radius:      radius,
+		Typecodes:   typecodes,
+		BypassCache: force,
+	}, stats)
+	stats.setHeaders(c)
+	if err == nil {
+		return ledger, nil, true
+	}
+	log.Printf("[台账] 周边查询失败: %v", err)
+	if c.Request.Context().Err() == nil {
+		if offline, asOf := offlineStore.Around(location, radiusM, typecodes); countLedgerPOIs(offline) > 0 {
+			log.Printf("[离线模式] 上游失败，回退本地数据")
+			return offline, &offlineInfo{Reason: "上游查询失败: " + redactKey(err.Error()), DataAsOf: asOf}, true
+		}
+	}
+	respondUpstreamError(c, err)
+	return nil, nil, false
+}
+
+func countLedgerPOIs(ledger []RawPOIRecord) int {
+	n := 0
+	for _, rec := range ledger {
+		n += len(rec.POIs)
+	}
+	return n
+}
+
+// 离线版附近医院：SQLite与本地缓存中半径内的医院
+func offlineNearbyHospitals(c *gin.Context, lat, lng float64, radius float64, crs, reason string) {
+	location := fmt.Sprintf("%f,%f", lng, lat)
+	ledger, asOf := offlineStore.Around(location, radius, []string{"090100", "090101"})
+	results := []gin.H{}
+	seen := make(map[string]bool)
+	for _, rec := range ledger {
+		for _, raw := range rec.POIs {
+			poi := raw.(map[string]interface{})
+			id, _ := poi["id"].(string)
+			if seen[id] {
+				continue
+			}
+			seen[id] = true
+			loc, _ := poi["location"].(string)
+			poiLng, poiLat, _ := parseLngLat(loc)
+			distance := haversine(lng, lat, poiLng, poiLat)
+			poiLng, poiLat = convertCoord(poiLng, poiLat, storageCRS, crs)
+			results = append(results, gin.H{
+				"id":        id,
+				"name":      poi["name"],
+				"address":   poi["address"],
+				"latitude":  poiLat,
+				"longitude": poiLng,
+				"distance":  distance,
+			})
+		}
+	}
+	sort.Slice(results, func(i, j int) bool {
+		return results[i]["distance"].(float64) < results[j]["distance"].(float64)
+	})
+	resp := map[string]interface{}{"results": results, "crs": crs}
+	(&offlineInfo{Reason: reason, DataAsOf: asOf}).apply(c, resp)
+	c.JSON(http.StatusOK, resp)
+}
+
+// 管理员：重新加载离线数据
+func reloadOfflineData(c *gin.Context) {
+	n := offlineStore.Reload()
+	c.JSON(http.StatusOK, gin.H{"status": "success", "offline_mode": offlineMode, "count": n})
+}
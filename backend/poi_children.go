@@ -0,0 +1,220 @@
+package main
+
+import (
+	"database/sql"
+	"math"
+	"net/http"
+	"strconv"
+	"strings"
+
+	"github.com/gin-gonic/gin"
+)
+
+// 子POI：高德以parent字段指向所属医院的科室、楼宇、出入口、下属机构等（childtype非空）。
+// 这些POI不作为独立医院显示，而是挂到父医院下
+
+// 一个子POI
+type ChildPOI struct {
+	ID        string  `json:"id"`
+	Name      string  `json:"name"`
+	Kind      string  `json:"kind"`
+	Childtype string  `json:"childtype,omitempty"`
+	Typecode  string  `json:"typecode,omitempty"`
+	Longitude float64 `json:"longitude"`
+	Latitude  float64 `json:"latitude"`
+	Tel       string  `json:"tel,omitempty"`
+}
+
+// 医院的子POI，按种类分组
+type POIChildren struct {
+	Departments []ChildPOI `json:"departments"`
+	Buildings   []ChildPOI `json:"buildings"`
+	Entrances   []ChildPOI `json:"entrances"`
+	Branches    []ChildPOI `json:"branches"`
+	Others      []ChildPOI `json:"others"`
+}
+
+func newPOIChildren() *POIChildren {
+	return &POIChildren{
+		Departments: []ChildPOI{}, Buildings: []ChildPOI{}, Entrances: []ChildPOI{},
+		Branches: []ChildPOI{}, Others: []ChildPOI{},
+	}
+}
+
+func (pc *POIChildren) add(child ChildPOI) {
+	switch child.Kind {
+	case ChildDepartment:
+		pc.Departments = append(pc.Departments, child)
+	case ChildBuilding:
+		pc.Buildings = append(pc.Buildings, child)
+	case ChildEntrance:
+		pc.Entrances = append(pc.Entrances, child)
+	case ChildBranch:
+		pc.Branches = append(pc.Branches, child)
+	default:
+		pc.Others = append(pc.Others, child)
+	}
+}
+
+func (pc *POIChildren) Count() int {
+	return len(pc.Departments) + len(pc.Buildings) + len(pc.Entrances) + len(pc.Branches) + len(pc.Others)
+}
+
+// 名称是否为出入口，如"XX医院(东门)"、"XX医院-急诊入口"
+func isEntranceName(name string) bool {
+	name = strings.TrimRight(strings.TrimSpace(name), ")）")
+	return strings.HasSuffix(name, "门") || strings.HasSuffix(name, "入口") || strings.HasSuffix(name, "出口")
+}
+
+// 高德POI的parent与childtype，无值时高德返回[]或空串
+func poiParentInfo(poi map[string]interface{}) (parent, childtype string) {
+	parent, _ = poi["parent"].(string)
+	switch v := poi["childtype"].(type) {
+	case string:
+		childtype = v
+	case float64:
+		if v == math.Trunc(v) {
+			childtype = strconv.FormatFloat(v, 'f', -1, 64)
+		}
+	}
+	if childtype == "0" || childtype == "null" {
+		childtype = ""
+	}
+	return parent, childtype
+}
+
+// 高德POI转为子POI，非子POI（无parent或childtype）返回false
+func childPOIFromMap(poi map[string]interface{}) (ChildPOI, string, bool) {
+	parent, childtype := poiParentInfo(poi)
+	if parent == "" || childtype == "" {
+		return ChildPOI{}, "", false
+	}
+	child := ChildPOI{Childtype: childtype}
+	child.ID, _ = poi["id"].(string)
+	child.Name, _ = poi["name"].(string)
+	child.Typecode, _ = poi["typecode"].(string)
+	child.Tel, _ = poi["tel"].(string)
+	location, _ := poi["location"].(string)
+	child.Longitude, child.Latitude, _ = parseLngLat(location)
+	child.Kind = taxonomy.ChildKind(childtype, child.Name)
+	return child, parent, child.ID != "" && parent != child.ID
+}
+
+// 合并结果中的子POI挂到同一结果中的父医院下（children字段），子POI本身仍保留在列表中
+func attachChildPOIs(pois []map[string]interface{}) {
+	byID := make(map[string]map[string]interface{}, len(pois))
+	for _, poi := range pois {
+		if id, _ := poi["id"].(string); id != "" {
+			byID[id] = poi
+		}
+	}
+	for _, poi := range pois {
+		child, parentID, ok := childPOIFromMap(poi)
+		if !ok {
+			continue
+		}
+		parent := byID[parentID]
+		if parent == nil {
+			continue
+		}
+		children, _ := parent["children"].(*POIChildren)
+		if children == nil {
+			children = newPOIChildren()
+			parent["children"] = children
+		}
+		children.add(child)
+	}
+}
+
+// 已抓取POI的子POI（不含已消失的）
+func queryPOIChildren(parentID string) (*POIChildren, error) {
+	rows, err := db.Query(`
+		SELECT id, name, COALESCE(childtype, ''), COALESCE(typecode, ''), COALESCE(longitude, 0), COALESCE(latitude, 0), COALESCE(tel, '')
+		FROM pois WHERE parent_id = ? AND removed_at IS NULL ORDER BY id
+	`, parentID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	children := newPOIChildren()
+	for rows.Next() {
+		var ch ChildPOI
+		if err := rows.Scan(&ch.ID, &ch.Name, &ch.Childtype, &ch.Typecode, &ch.Longitude, &ch.Latitude, &ch.Tel); err != nil {
+			return nil, err
+		}
+		ch.Kind = taxonomy.ChildKind(ch.Childtype, ch.Name)
+		children.add(ch)
+	}
+	return children, rows.Err()
+}
+
+// 医院对应的已抓取POI：附近（campusMatchRadius米内）名称最相似的非子POI
+func findHospitalPOI(name string, lng, lat float64) (string, bool) {
+	const radius = campusMatchRadius
+	dLat := radius / 111000
+	dLng := dLat / math.Max(math.Cos(lat*math.Pi/180), 0.1)
+	rows, err := db.Query(`
+		SELECT id, name, longitude, latitude FROM pois
+		WHERE removed_at IS NULL AND COALESCE(parent_id, '') = ''
+			AND longitude BETWEEN ? AND ? AND latitude BETWEEN ? AND ?
+	`, lng-dLng, lng+dLng, lat-dLat, lat+dLat)
+	if err != nil {
+		return "", false
+	}
+	defer rows.Close()
+	bestID, bestScore := "", 0.0
+	for rows.Next() {
+		var id, poiName string
+		var pLng, pLat float64
+		if rows.Scan(&id, &poiName, &pLng, &pLat) != nil || haversine(lng, lat, pLng, pLat) > radius {
+			continue
+		}
+		if score := nameSimilarity(name, poiName); score >= 0.8 && score > bestScore {
+			bestID, bestScore = id, score
+		}
+	}
+	return bestID, bestID != ""
+}
+
+// 已抓取POI详情，含子POI；子POI本身返回其父POI的id；坐标按crs输出
+func getPOIDetail(c *gin.Context) {
+	id := c.Param("id")
+	crs, ok := crsParam(c)
+	if !ok {
+		return
+	}
+	p, err := scanCrawledPOI(db.QueryRow(`SELECT `+crawledPOIColumns+` FROM pois WHERE id = ?`, id))
+	if err == sql.ErrNoRows {
+		c.JSON(http.StatusNotFound, gin.H{"error": "POI not found"})
+		return
+	}
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+	var parentID, childtype string
+	db.QueryRow(`SELECT COALESCE(parent_id, ''), COALESCE(childtype, '') FROM pois WHERE id = ?`, id).Scan(&parentID, &childtype)
+	children, err := queryPOIChildren(id)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+	lng, lat := convertCoord(p.Lng, p.Lat, storageCRS, crs)
+	data := gin.H{
+		"id": p.ID, "name": p.Name, "address": p.Address, "typecode": p.Typecode, "tel": p.Tel,
+		"longitude": lng, "latitude": lat, "crs": crs, "removed": p.RemovedAt != "",
+	}
+	if parentID != "" {
+		data["parent_id"] = parentID
+		data["childtype"] = childtype
+		data["kind"] = taxonomy.ChildKind(childtype, p.Name)
+	}
+	campus := buildCampus(p.Lng, p.Lat, children, nil)
+	children.toCRS(crs)
+	campus.toCRS(crs)
+	resp := gin.H{"status": "success", "data": data, "children": children}
+	if campus != nil {
+		resp["campus"] = campus
+	}
+	c.JSON(http.StatusOK, resp)
+}
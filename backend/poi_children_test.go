@@ -0,0 +1,115 @@
+package main
+
+import (
+	"fmt"
+	"net/http"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestChildKind(t *testing.T) {
+	cases := []struct {
+		childtype, name, kind string
+	}{
+		{"307", "东直门医院东城院区发热门诊", ChildDepartment},
+		{"318", "东直门医院东城院区急诊科", ChildDepartment},
+		{"319", "东直门医院东城院区住院楼", ChildBuilding},
+		{"309", "中国中医科学院针灸医院", ChildBranch},
+		{"307", "中日友好医院(东门)", ChildEntrance},
+		{"", "北京协和医院-急诊入口", ChildEntrance},
+		{"999", "北京协和医院停车场", ChildOther},
+		{"202", "东直门医院", ChildOther},
+	}
+	for _, tc := range cases {
+		if got := taxonomy.ChildKind(tc.childtype, tc.name); got != tc.kind {
+			t.Errorf("%s/%s: %s, 期望 %s", tc.childtype, tc.name, got, tc.kind)
+		}
+	}
+}
+
+func TestMergedChildrenAttached(t *testing.T) {
+	result, err := ReplayFile(filepath.Join("testdata", "merge", "ledger_beijing.json"), "merged")
+	if err != nil {
+		t.Fatal(err)
+	}
+	var parent map[string]interface{}
+	for _, poi := range result["pois"].([]map[string]interface{}) {
+		if poi["id"] == "B0FFK6SVD1" {
+			parent = poi
+		}
+	}
+	if parent == nil {
+		t.Fatal("结果中没有应急总医院")
+	}
+	children, _ := parent["children"].(*POIChildren)
+	if children == nil || len(children.Departments) == 0 || len(children.Buildings) == 0 {
+		t.Fatalf("子POI未挂到父医院: %+v", children)
+	}
+	for _, d := range children.Departments {
+		if d.Name == "应急总医院本院区急诊" && d.Childtype == "318" {
+			return
+		}
+	}
+	t.Fatalf("缺少急诊: %+v", children.Departments)
+}
+
+func TestHospitalDetailChildren(t *testing.T) {
+	e := setupE2E(t, nil)
+	ledger := []RawPOIRecord{{Typecode: "090101", POIs: []interface{}{
+		map[string]interface{}{"id": "B0PARENT", "name": "测试第一医院", "typecode": "090101", "childtype": []interface{}{},
+			"parent": []interface{}{}, "location": "116.400000,39.900000"},
+		map[string]interface{}{"id": "B0DEPT", "name": "测试第一医院心内科", "typecode": "090101", "childtype": "307",
+			"parent": "B0PARENT", "location": "116.400200,39.900100"},
+		map[string]interface{}{"id": "B0GATE", "name": "测试第一医院(东门)", "typecode": "090101", "childtype": "308",
+			"parent": "B0PARENT", "location": "116.401000,39.900000"},
+		map[string]interface{}{"id": "B0WARD", "name": "测试第一医院住院楼", "typecode": "090101", "childtype": "319",
+			"parent": "B0PARENT", "location": "116.400100,39.899800"},
+	}}}
+	if _, err := upsertCrawledPOIs(1, ledger, time.Now()); err != nil {
+		t.Fatal(err)
+	}
+
+	w, body := e.get("/api/pois/B0PARENT")
+	if w.Code != http.StatusOK {
+		t.Fatalf("POI详情 %d: %s", w.Code, w.Body.String())
+	}
+	children := body["children"].(map[string]interface{})
+	if len(children["departments"].([]interface{})) != 1 || len(children["entrances"].([]interface{})) != 1 ||
+		len(children["buildings"].([]interface{})) != 1 {
+		t.Fatalf("子POI分组: %v", children)
+	}
+	if _, body := e.get("/api/pois/B0GATE"); body["data"].(map[string]interface{})["parent_id"] != "B0PARENT" ||
+		body["data"].(map[string]interface{})["kind"] != ChildEntrance {
+		t.Fatalf("子POI详情: %v", body["data"])
+	}
+	if w, _ := e.get("/api/pois/B0NONE"); w.Code != http.StatusNotFound {
+		t.Fatalf("不存在的POI %d", w.Code)
+	}
+
+	// 数据库中的医院按名称和位置关联到已抓取POI
+	id := insertTestHospital(t, "北京测试第一医院", 116.40005, 39.90005, "address", "测试路1号")
+	_, body = e.get(fmt.Sprintf("/api/hospitals/%d", id))
+	if body["poi_id"] != "B0PARENT" {
+		t.Fatalf("未关联POI: %v", body)
+	}
+	entrances := body["children"].(map[string]interface{})["entrances"].([]interface{})
+	if len(entrances) != 1 || entrances[0].(map[string]interface{})["name"] != "测试第一医院(东门)" {
+		t.Fatalf("出入口: %v", entrances)
+	}
+	// 院区：东门和住院楼
+	campus := body["campus"].(map[string]interface{})
+	if len(campus["entrances"].([]interface{})) != 2 {
+		t.Fatalf("院区出入口: %v", campus)
+	}
+}
+
+func TestAddMissingColumns(t *testing.T) {
+	setupTestDB(t)
+	db.Exec(`CREATE TABLE legacy_pois (id TEXT PRIMARY KEY, name TEXT)`)
+	addMissingColumns("legacy_pois", [][2]string{{"name", "TEXT"}, {"parent_id", "TEXT"}})
+	addMissingColumns("legacy_pois", [][2]string{{"parent_id", "TEXT"}})
+	if _, err := db.Exec(`INSERT INTO legacy_pois (id, name, parent_id) VALUES ('a', 'b', 'c')`); err != nil {
+		t.Fatalf("补充列失败: %v", err)
+	}
+}
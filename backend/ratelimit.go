@@ -0,0 +1,267 @@
+package main
+
+import (
+	"fmt"
+	"log"
+	"math"
+	"net/http"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+// 上游服务商
+const (
+	ProviderAmap   = "amap"
+	ProviderGoogle = "google"
+)
+
+// 高德配额按北京时间零点重置
+var quotaZone = time.FixedZone("CST", 8*3600)
+
+// 令牌桶
+type tokenBucket struct {
+	tokens float64
+	last   time.Time
+}
+
+// RateLimiter 按客户端（API Key用户或IP）维护令牌桶
+type RateLimiter struct {
+	rate    float64 // 每秒补充令牌数
+	burst   float64
+	mu      sync.Mutex
+	buckets map[string]*tokenBucket
+	now     func() time.Time
+}
+
+// rate、burst须为正数，否则Allow无法计算等待时长
+func NewRateLimiter(rate float64, burst int) *RateLimiter {
+	if rate <= 0 || burst < 1 {
+		panic(fmt.Sprintf("限流参数无效: rate=%v burst=%d", rate, burst))
+	}
+	return &RateLimiter{
+		rate:    rate,
+		burst:   float64(burst),
+		buckets: make(map[string]*tokenBucket),
+		now:     time.Now,
+	}
+}
+
+// 尝试消耗一个令牌，失败时返回需要等待的时长
+func (l *RateLimiter) Allow(key string) (bool, time.Duration) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	now := l.now()
+	b, ok := l.buckets[key]
+	if !ok {
+		if len(l.buckets) >= 10000 {
+			l.sweep(now)
+		}
+		b = &tokenBucket{tokens: l.burst, last: now}
+		l.buckets[key] = b
+	}
+	b.tokens = math.Min(l.burst, b.tokens+now.Sub(b.last).Seconds()*l.rate)
+	b.last = now
+	if b.tokens >= 1 {
+		b.tokens--
+		return true, 0
+	}
+	wait := time.Duration((1 - b.tokens) / l.rate * float64(time.Second))
+	return false, wait
+}
+
+// 清理已回满的空闲桶
+func (l *RateLimiter) sweep(now time.Time) {
+	for key, b := range l.buckets {
+		if b.tokens+now.Sub(b.last).Seconds()*l.rate >= l.burst {
+			delete(l.buckets, key)
+		}
+	}
+}
+
+var clientLimiter *RateLimiter
+
+// 可信的反向代理（TRUSTED_PROXIES，逗号分隔的IP或CIDR），默认不信任任何代理：
+// 只有来自这些地址的请求才按X-Forwarded-For取客户端IP
+func trustedProxies() []string {
+	var proxies []string
+	for _, p := range strings.Split(os.Getenv("TRUSTED_PROXIES"), ",") {
+		if p = strings.TrimSpace(p); p != "" {
+			proxies = append(proxies, p)
+		}
+	}
+	return proxies
+}
+
+// 客户端限流中间件：已鉴权用户按用户ID，匿名请求按IP
+func rateLimitMiddleware(limiter *RateLimiter) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		key := "ip:" + c.ClientIP()
+		if user, ok := currentUser(c); ok {
+			key = fmt.Sprintf("user:%d", user.ID)
+		}
+		if ok, wait := limiter.Allow(key); !ok {
+			setRetryAfter(c, wait)
+			c.AbortWithStatusJSON(http.StatusTooManyRequests, gin.H{
+				"error":       "请求过于频繁",
+				"retry_after": retryAfterSeconds(wait),
+			})
+			return
+		}
+		c.Next()
+	}
+}
+
+// 配额耗尽错误
+type QuotaExceededError struct {
+	Provider   string
+	Limit      int
+	RetryAfter time.Duration
+}
+
+func (e *QuotaExceededError) Error() string {
+	return fmt.Sprintf("%s 每日配额已用尽 (%d)", e.Provider, e.Limit)
+}
+
+// UpstreamQuota 按服务商统计当日上游调用次数
+type UpstreamQuota struct {
+	mu     sync.Mutex
+	limits map[string]int
+	used   map[string]int
+	day    string
+	now    func() time.Time
+}
+
+func NewUpstreamQuota(limits map[string]int) *UpstreamQuota {
+	return &UpstreamQuota{
+		limits: limits,
+		used:   make(map[string]int),
+		now:    time.Now,
+	}
+}
+
+var upstreamQuota *UpstreamQuota
+
+// 预留n次上游调用；未配置上限(<=0)的服务商不限制
+func (q *UpstreamQuota) Reserve(provider string, n int) error {
+	if q == nil {
+		return nil
+	}
+	q.mu.Lock()
+	defer q.mu.Unlock()
+
+	now := q.now().In(quotaZone)
+	if day := now.Format("2006-01-02"); day != q.day {
+		q.day = day
+		q.used = make(map[string]int)
+	}
+	limit := q.limits[provider]
+	if limit > 0 && q.used[provider]+n > limit {
+		y, m, d := now.Date()
+		reset := time.Date(y, m, d+1, 0, 0, 0, 0, quotaZone)
+		return &QuotaExceededError{Provider: provider, Limit: limit, RetryAfter: reset.Sub(now)}
+	}
+	q.used[provider] += n
+	return nil
+}
+
+// 当日剩余配额，未配置上限时返回-1
+func (q *UpstreamQuota) Remaining(provider string) int {
+	if q == nil {
+		return -1
+	}
+	q.mu.Lock()
+	defer q.mu.Unlock()
+
+	limit := q.limits[provider]
+	if limit <= 0 {
+		return -1
+	}
+	if q.now().In(quotaZone).Format("2006-01-02") != q.day {
+		return limit
+	}
+	return limit - q.used[provider]
+}
+
+// 配额使用情况
+func (q *UpstreamQuota) Snapshot() []gin.H {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+
+	var res []gin.H
+	for _, provider := range []string{ProviderAmap, ProviderGoogle} {
+		res = append(res, gin.H{
+			"provider": provider,
+			"day":      q.day,
+			"limit":    q.limits[provider],
+			"used":     q.used[provider],
+		})
+	}
+	return res
+}
+
+// 初始化客户端限流和上游配额
+func initRateLimits() {
+	rps := envPositiveFloat("RATE_LIMIT_RPS", 2)
+	burst := envPositiveInt("RATE_LIMIT_BURST", 20)
+	clientLimiter = NewRateLimiter(rps, burst)
+	upstreamQuota = NewUpstreamQuota(map[string]int{
+		ProviderAmap:   envInt("AMAP_DAILY_QUOTA", 5000),
+		ProviderGoogle: envInt("GOOGLE_DAILY_QUOTA", 1000),
+	})
+	log.Printf("[限流] 客户端 %.1f req/s (突发 %d)，高德日配额 %d，Google日配额 %d",
+		rps, burst, upstreamQuota.limits[ProviderAmap], upstreamQuota.limits[ProviderGoogle])
+}
+
+func setRetryAfter(c *gin.Context, wait time.Duration) {
+	c.Header("Retry-After", strconv.Itoa(retryAfterSeconds(wait)))
+}
+
+func retryAfterSeconds(wait time.Duration) int {
+	return int(math.Ceil(wait.Seconds()))
+}
+
+// 管理员：查看上游配额
+func getUpstreamQuotas(c *gin.Context) {
+	c.JSON(http.StatusOK, gin.H{"status": "success", "data": upstreamQuota.Snapshot()})
+}
+
+func envInt(name string, def int) int {
+	if v := os.Getenv(name); v != "" {
+		if n, err := strconv.Atoi(v); err == nil {
+			return n
+		}
+	}
+	return def
+}
+
+func envFloat(name string, def float64) float64 {
+	if v := os.Getenv(name); v != "" {
+		if f, err := strconv.ParseFloat(v, 64); err == nil {
+			return f
+		}
+	}
+	return def
+}
+
+// 读取正整数配置，非正数时忽略并使用默认值
+func envPositiveInt(name string, def int) int {
+	if n := envInt(name, def); n > 0 {
+		return n
+	}
+	log.Printf("[配置] %s 须为正数，使用默认值 %d", name, def)
+	return def
+}
+
+func envPositiveFloat(name string, def float64) float64 {
+	if f := envFloat(name, def); f > 0 {
+		return f
+	}
+	log.Printf("[配置] %s 须为正数，使用默认值 %v", name, def)
+	return def
+}
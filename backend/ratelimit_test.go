@@ -0,0 +1,132 @@
+package main
+
+import (
+	"fmt"
+	"net/http"
+	"testing"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+func TestRateLimiterRefill(t *testing.T) {
+	now := time.Date(2025, 7, 1, 12, 0, 0, 0, time.UTC)
+	l := NewRateLimiter(2, 3)
+	l.now = func() time.Time { return now }
+
+	for i := 0; i < 3; i++ {
+		if ok, _ := l.Allow("a"); !ok {
+			t.Fatalf("突发第%d次被拒绝", i+1)
+		}
+	}
+	ok, wait := l.Allow("a")
+	if ok || wait != 500*time.Millisecond {
+		t.Fatalf("桶空后: %v %v", ok, wait)
+	}
+	if ok, _ := l.Allow("b"); !ok {
+		t.Fatal("不同客户端的桶相互独立")
+	}
+	// 半个令牌时还需等待一半时间
+	now = now.Add(250 * time.Millisecond)
+	if ok, wait := l.Allow("a"); ok || wait != 250*time.Millisecond {
+		t.Fatalf("补充半个令牌: %v %v", ok, wait)
+	}
+	now = now.Add(250 * time.Millisecond)
+	if ok, _ := l.Allow("a"); !ok {
+		t.Fatal("补满一个令牌后应放行")
+	}
+	// 长时间空闲也不超过burst
+	now = now.Add(time.Hour)
+	for i := 0; i < 3; i++ {
+		l.Allow("a")
+	}
+	if ok, _ := l.Allow("a"); ok {
+		t.Fatal("令牌数不应超过burst")
+	}
+
+	defer func() {
+		if recover() == nil {
+			t.Fatal("rate<=0应被拒绝")
+		}
+	}()
+	NewRateLimiter(0, 10)
+}
+
+func TestRateLimitRetryAfter(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	now := time.Date(2025, 7, 1, 12, 0, 0, 0, time.UTC)
+	l := NewRateLimiter(0.4, 1)
+	l.now = func() time.Time { return now }
+	r := gin.New()
+	r.Use(rateLimitMiddleware(l))
+	r.GET("/", func(c *gin.Context) { c.JSON(http.StatusOK, gin.H{}) })
+
+	if w := doRequest(r, http.MethodGet, "/", nil, nil); w.Code != http.StatusOK {
+		t.Fatalf("首次请求 %d", w.Code)
+	}
+	// 等待2.5秒，向上取整为3
+	w := doRequest(r, http.MethodGet, "/", nil, nil)
+	if w.Code != http.StatusTooManyRequests || w.Header().Get("Retry-After") != "3" {
+		t.Fatalf("限流响应 %d Retry-After=%q", w.Code, w.Header().Get("Retry-After"))
+	}
+}
+
+func TestUpstreamQuotaDayRollover(t *testing.T) {
+	// 北京时间 2025-07-01 23:59:00
+	now := time.Date(2025, 7, 1, 15, 59, 0, 0, time.UTC)
+	q := NewUpstreamQuota(map[string]int{ProviderAmap: 3})
+	q.now = func() time.Time { return now }
+
+	if err := q.Reserve(ProviderAmap, 3); err != nil {
+		t.Fatal(err)
+	}
+	err := q.Reserve(ProviderAmap, 1)
+	qe, ok := err.(*QuotaExceededError)
+	if !ok || qe.RetryAfter != time.Minute {
+		t.Fatalf("配额耗尽: %v", err)
+	}
+	if q.Remaining(ProviderAmap) != 0 {
+		t.Fatalf("剩余 %d", q.Remaining(ProviderAmap))
+	}
+	if err := q.Reserve(ProviderGoogle, 100); err != nil {
+		t.Fatal("未配置上限不限制")
+	}
+
+	// 北京时间23:59:59仍是同一天
+	now = time.Date(2025, 7, 1, 15, 59, 59, 0, time.UTC)
+	if q.Reserve(ProviderAmap, 1) == nil {
+		t.Fatal("北京时间零点前不应重置")
+	}
+	// 北京时间零点重置
+	now = time.Date(2025, 7, 1, 16, 0, 0, 0, time.UTC)
+	if q.Remaining(ProviderAmap) != 3 {
+		t.Fatalf("新的一天剩余 %d", q.Remaining(ProviderAmap))
+	}
+	if err := q.Reserve(ProviderAmap, 2); err != nil || q.Remaining(ProviderAmap) != 1 {
+		t.Fatalf("重置后: %v %d", err, q.Remaining(ProviderAmap))
+	}
+}
+
+func TestRateLimitIgnoresSpoofedForwardedFor(t *testing.T) {
+	e := setupE2E(t, nil)
+	clientLimiter = NewRateLimiter(0.001, 2)
+	e.router = setupRouter()
+	// 未配置可信代理：伪造X-Forwarded-For不会换到新的令牌桶
+	for i, want := range []int{http.StatusOK, http.StatusOK, http.StatusTooManyRequests} {
+		w := doRequest(e.router, http.MethodGet, "/api/health", nil, map[string]string{"X-Forwarded-For": fmt.Sprintf("203.0.113.%d", i)})
+		if w.Code != want {
+			t.Fatalf("第%d次请求 %d，应为 %d", i+1, w.Code, want)
+		}
+	}
+
+	// 来自可信代理的请求按X-Forwarded-For区分客户端
+	t.Setenv("TRUSTED_PROXIES", "192.0.2.0/24")
+	clientLimiter = NewRateLimiter(0.001, 1)
+	e.router = setupRouter()
+	for i := 0; i < 3; i++ {
+		w := doRequest(e.router, http.MethodGet, "/api/health", nil, map[string]string{"X-Forwarded-For": fmt.Sprintf("203.0.113.%d", i)})
+		if w.Code != http.StatusOK {
+			t.Fatalf("可信代理转发的第%d个客户端 %d", i+1, w.Code)
+		}
+	}
+}
@@ -0,0 +1,164 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"log"
+	"os"
+	"unicode/utf16"
+)
+
+// 解码台账/POI文件：兼容UTF-8 BOM及Windows下导出的UTF-16文件
+func decodeReplayData(data []byte) []byte {
+	switch {
+	case bytes.HasPrefix(data, []byte{0xEF, 0xBB, 0xBF}):
+		return data[3:]
+	case bytes.HasPrefix(data, []byte{0xFF, 0xFE}), bytes.HasPrefix(data, []byte{0xFE, 0xFF}):
+		bigEndian := data[0] == 0xFE
+		data = data[2:]
+		units := make([]uint16, len(data)/2)
+		for i := range units {
+			if bigEndian {
+				units[i] = uint16(data[2*i])<<8 | uint16(data[2*i+1])
+			} else {
+				units[i] = uint16(data[2*i+1])<<8 | uint16(data[2*i])
+			}
+		}
+		return []byte(string(utf16.Decode(units)))
+	}
+	return data
+}
+
+// 解析重放输入，支持两种格式：
+//   - 台账：[{typecode, page, count, pois}]，如 amap_query_ledger.json
+//   - POI列表：{pois: [...]}，如 test_dongzhimen_*.json、xiehe_merge_test.json，按POI自身typecode分组
+func parseReplayInput(data []byte, typecodes []string) ([]RawPOIRecord, error) {
+	data = bytes.TrimSpace(decodeReplayData(data))
+	if len(data) == 0 {
+		return nil, errors.New("输入为空")
+	}
+	if data[0] == '[' {
+		var ledger []RawPOIRecord
+		if err := json.Unmarshal(data, &ledger); err != nil {
+			return nil, fmt.Errorf("解析台账失败: %w", err)
+		}
+		return ledger, nil
+	}
+	var file struct {
+		POIs []interface{} `json:"pois"`
+	}
+	if err := json.Unmarshal(data, &file); err != nil {
+		return nil, fmt.Errorf("解析POI文件失败: %w", err)
+	}
+	byTypecode := make(map[string][]interface{})
+	var order []string
+	for _, raw := range file.POIs {
+		poi, ok := raw.(map[string]interface{})
+		if !ok {
+			continue
+		}
+		tc, _ := poi["typecode"].(string)
+		if len(tc) > 6 {
+			tc = tc[:6]
+		}
+		if _, seen := byTypecode[tc]; !seen {
+			order = append(order, tc)
+		}
+		byTypecode[tc] = append(byTypecode[tc], poi)
+	}
+	// 先按配置的typecode顺序，其余按首次出现顺序，与在线查询的台账顺序一致
+	var ledger []RawPOIRecord
+	for _, tc := range typecodes {
+		if pois, ok := byTypecode[tc]; ok {
+			ledger = append(ledger, RawPOIRecord{Typecode: tc, Page: 1, Count: len(pois), POIs: pois})
+			delete(byTypecode, tc)
+		}
+	}
+	for _, tc := range order {
+		if pois, ok := byTypecode[tc]; ok {
+			ledger = append(ledger, RawPOIRecord{Typecode: tc, Page: 1, Count: len(pois), POIs: pois})
+		}
+	}
+	return ledger, nil
+}
+
+// Replay 将记录的台账/POI数据送入与接口相同的合并+分类流程，返回合并结果
+func Replay(r io.Reader, profileName string) (map[string]interface{}, error) {
+	profile, ok := mergeProfileByName(profileName)
+	if !ok {
+		return nil, fmt.Errorf("未知的合并配置: %s", profileName)
+	}
+	data, err := ioutil.ReadAll(r)
+	if err != nil {
+		return nil, err
+	}
+	ledger, err := parseReplayInput(data, profile.Typecodes)
+	if err != nil {
+		return nil, err
+	}
+	result := mergeLedger(ledger, profile)
+	classifyMergedPOIs(result)
+	return result, nil
+}
+
+// ReplayFile 重放单个文件
+func ReplayFile(path, profileName string) (map[string]interface{}, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	return Replay(f, profileName)
+}
+
+// replay子命令：go run ./backend replay [-profile merged|around] [-o out.json] [-quiet] <file|->
+func runReplayCommand(args []string) int {
+	fs := flag.NewFlagSet("replay", flag.ContinueOnError)
+	profile := fs.String("profile", "merged", "合并配置：merged（/api/merged-pois）或 around（/api/amap/around）")
+	output := fs.String("o", "", "输出文件，默认标准输出")
+	quiet := fs.Bool("quiet", false, "不输出合并过程日志")
+	fs.Usage = func() {
+		fmt.Fprintln(fs.Output(), "用法: replay [-profile merged|around] [-o out.json] [-quiet] <台账或POI文件，- 表示标准输入>")
+		fs.PrintDefaults()
+	}
+	if err := fs.Parse(args); err != nil {
+		return 2
+	}
+	if fs.NArg() != 1 {
+		fs.Usage()
+		return 2
+	}
+	log.SetOutput(os.Stderr)
+	if *quiet {
+		log.SetOutput(ioutil.Discard)
+	}
+
+	var result map[string]interface{}
+	var err error
+	if fs.Arg(0) == "-" {
+		result, err = Replay(os.Stdin, *profile)
+	} else {
+		result, err = ReplayFile(fs.Arg(0), *profile)
+	}
+	if err != nil {
+		fmt.Fprintln(os.Stderr, "[重放] 失败:", err)
+		return 1
+	}
+	out, _ := json.MarshalIndent(result, "", "  ")
+	out = append(out, '\n')
+	if *output == "" {
+		os.Stdout.Write(out)
+		return 0
+	}
+	if err := ioutil.WriteFile(*output, out, 0644); err != nil {
+		fmt.Fprintln(os.Stderr, "[重放] 写入结果失败:", err)
+		return 1
+	}
+	fmt.Fprintf(os.Stderr, "[重放] 合并结果 %v 条，已写入 %s\n", result["count"], *output)
+	return 0
+}
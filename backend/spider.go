@@ -1,11 +1,11 @@
 package main
 
 import (
+	"context"
+	"database/sql"
 	"encoding/json"
 	"fmt"
-	"io/ioutil"
 	"log"
-	"net/http"
 	"net/url"
 	"os"
 	"strconv"
@@ -60,8 +60,8 @@ type SpiderConfig struct {
 
 // 爬虫实例
 type HospitalSpider struct {
-	config SpiderConfig
-	client *http.Client
+	config   SpiderConfig
+	upstream *UpstreamClient
 }
 
 // 创建新的爬虫实例
@@ -74,9 +74,7 @@ func NewHospitalSpider() *HospitalSpider {
 			MaxResults:          20,
 			DelayBetweenRequests: time.Second * 2,
 		},
-		client: &http.Client{
-			Timeout: time.Second * 30,
-		},
+		upstream: upstreamClient(ProviderGoogle),
 	}
 }
 
@@ -108,36 +106,29 @@ func (s *HospitalSpider) SearchHospitals(lat, lng float64, radius int) ([]Hospit
 
 // 在指定半径内搜索医院
 func (s *HospitalSpider) searchHospitalsInRadius(lat, lng float64, radius int) ([]Hospital, error) {
-	baseURL := "https://maps.googleapis.com/maps/api/place/nearbysearch/json"
+	baseURL := upstreamURL(ProviderGoogle, "/maps/api/place/nearbysearch/json")
 	
+	// 搜索中心为存储坐标系（GCJ-02），转为Google在该位置所用的坐标系
+	wLng, wLat := convertCoord(lng, lat, storageCRS, googleSourceCRS(lng, lat))
 	params := url.Values{}
-	params.Set("location", fmt.Sprintf("%f,%f", lat, lng))
+	params.Set("location", fmt.Sprintf("%f,%f", wLat, wLng))
 	params.Set("radius", strconv.Itoa(radius))
 	params.Set("type", "hospital")
 	params.Set("key", s.config.GoogleMapsAPIKey)
 	
 	reqURL := fmt.Sprintf("%s?%s", baseURL, params.Encode())
 	
-	resp, err := s.client.Get(reqURL)
+	// 超时、重试、配额及Google状态码校验由上游客户端处理
+	body, err := s.upstream.Get(context.Background(), reqURL)
 	if err != nil {
 		return nil, fmt.Errorf("failed to make request: %v", err)
 	}
-	defer resp.Body.Close()
-	
-	body, err := ioutil.ReadAll(resp.Body)
-	if err != nil {
-		return nil, fmt.Errorf("failed to read response: %v", err)
-	}
 	
 	var mapsResp GoogleMapsResponse
 	if err := json.Unmarshal(body, &mapsResp); err != nil {
 		return nil, fmt.Errorf("failed to parse response: %v", err)
 	}
 	
-	if mapsResp.Status != "OK" && mapsResp.Status != "ZERO_RESULTS" {
-		return nil, fmt.Errorf("Google Maps API error: %s", mapsResp.Status)
-	}
-	
 	var hospitals []Hospital
 	for _, result := range mapsResp.Results {
 		hospital := s.convertToHospital(result, lat, lng)
@@ -152,11 +143,14 @@ func (s *HospitalSpider) convertToHospital(result GoogleMapsResult, searchLat, s
 	// 获取详细信息
 	details := s.getPlaceDetails(result.PlaceID)
 	
+	// Google在中国大陆返回GCJ-02、其他地区返回WGS-84，入库前转为存储坐标系
+	gl := result.Geometry.Location
+	lng, lat := convertCoord(gl.Lng, gl.Lat, googleSourceCRS(gl.Lng, gl.Lat), storageCRS)
 	hospital := Hospital{
 		Name:         result.Name,
 		Address:      result.FormattedAddress,
-		Latitude:     result.Geometry.Location.Lat,
-		Longitude:    result.Geometry.Location.Lng,
+		Latitude:     lat,
+		Longitude:    lng,
 		Phone:        details.Phone,
 		HospitalType: s.determineHospitalType(result.Types),
 		BusinessHours: s.formatBusinessHours(details.OpeningHours),
@@ -179,7 +173,7 @@ func (s *HospitalSpider) convertToHospital(result GoogleMapsResult, searchLat, s
 
 // 获取地点详细信息
 func (s *HospitalSpider) getPlaceDetails(placeID string) PlaceDetails {
-	baseURL := "https://maps.googleapis.com/maps/api/place/details/json"
+	baseURL := upstreamURL(ProviderGoogle, "/maps/api/place/details/json")
 	
 	params := url.Values{}
 	params.Set("place_id", placeID)
@@ -188,18 +182,11 @@ func (s *HospitalSpider) getPlaceDetails(placeID string) PlaceDetails {
 	
 	reqURL := fmt.Sprintf("%s?%s", baseURL, params.Encode())
 	
-	resp, err := s.client.Get(reqURL)
+	body, err := s.upstream.Get(context.Background(), reqURL)
 	if err != nil {
 		log.Printf("Error getting place details: %v", err)
 		return PlaceDetails{}
 	}
-	defer resp.Body.Close()
-	
-	body, err := ioutil.ReadAll(resp.Body)
-	if err != nil {
-		log.Printf("Error reading place details response: %v", err)
-		return PlaceDetails{}
-	}
 	
 	var detailsResp PlaceDetailsResponse
 	if err := json.Unmarshal(body, &detailsResp); err != nil {
@@ -273,17 +260,30 @@ func (s *HospitalSpider) SaveHospitals(hospitals []Hospital) error {
 			   time.Now().Format("2006-01-02 15:04:05"), existingID)
 		} else {
 			// 插入新记录
-			_, err = db.Exec(`
-				INSERT INTO hospitals (name, address, latitude, longitude, phone, hospital_type, main_departments, business_hours, qualifications)
-				VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?)
+			var res sql.Result
+			res, err = db.Exec(`
+				INSERT INTO hospitals (name, address, latitude, longitude, phone, hospital_type, main_departments, business_hours, qualifications, crs)
+				VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, ?)
 			`, hospital.Name, hospital.Address, hospital.Latitude, hospital.Longitude, 
-			   hospital.Phone, hospital.HospitalType, hospital.MainDepartments, hospital.BusinessHours, hospital.Qualifications)
+			   hospital.Phone, hospital.HospitalType, hospital.MainDepartments, hospital.BusinessHours, hospital.Qualifications, storageCRS)
+			if err == nil {
+				id, _ := res.LastInsertId()
+				existingID = int(id)
+			}
 		}
 		
 		if err != nil {
 			log.Printf("Error saving hospital %s: %v", hospital.Name, err)
+			continue
+		}
+
+		// 内容有变化时记录历史版本
+		if err := recordHospitalVersion(existingID, "spider", time.Now()); err != nil {
+			log.Printf("[医院历史] 记录 %s 版本失败: %v", hospital.Name, err)
 		}
 	}
+	relinkGrades()
+	invalidateMapPoints()
 	
 	return nil
 }
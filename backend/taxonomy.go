@@ -0,0 +1,276 @@
+package main
+
+import (
+	_ "embed"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"log"
+	"net/http"
+	"os"
+	"sort"
+	"strings"
+
+	"github.com/gin-gonic/gin"
+)
+
+// 医院分类体系：高德typecode → 类别id、多语言名称、图标、排序与显示规则。
+// 默认使用内置的taxonomy.json，可通过TAXONOMY_FILE指定外部文件覆盖
+
+// 显示规则
+const (
+	VisibilityAlways         = "always"          // 总是显示
+	VisibilityChildtypeEmpty = "childtype_empty" // 仅childtype为空（非子POI）时显示
+	VisibilityHidden         = "hidden"          // 不显示
+)
+
+//go:embed taxonomy.json
+var defaultTaxonomyJSON []byte
+
+// 一个医院类别
+type TaxonomyCategory struct {
+	Typecode     string            `json:"typecode,omitempty"`
+	ID           string            `json:"id"`
+	Labels       map[string]string `json:"labels"`      // 分类简称，用于图例、筛选和algo_hospital_category
+	TypeLabels   map[string]string `json:"type_labels"` // 类型全称，用于hospital_category
+	Icon         string            `json:"icon"`        // algo_icon_type
+	LegacyIcon   string            `json:"legacy_icon"` // 旧版icon_type字段
+	DisplayOrder int               `json:"display_order"`
+	Visibility   string            `json:"visibility"`
+}
+
+// 子POI（科室、楼宇、出入口等）的种类
+type ChildKind struct {
+	ID     string            `json:"id"`
+	Labels map[string]string `json:"labels"`
+}
+
+type Taxonomy struct {
+	Version    int                `json:"version"`
+	Languages  []string           `json:"languages"`
+	ChildKinds []ChildKind        `json:"child_kinds"`
+	Childtypes map[string]string  `json:"childtypes"` // 高德childtype → 子POI种类
+	Default    TaxonomyCategory   `json:"default"`
+	Categories []TaxonomyCategory `json:"categories"`
+
+	byTypecode map[string]*TaxonomyCategory
+}
+
+// 子POI种类
+const (
+	ChildDepartment = "department"
+	ChildBuilding   = "building"
+	ChildEntrance   = "entrance"
+	ChildBranch     = "branch"
+	ChildOther      = "other"
+)
+
+var taxonomy = mustParseTaxonomy(defaultTaxonomyJSON)
+
+func mustParseTaxonomy(data []byte) *Taxonomy {
+	t, err := parseTaxonomy(data)
+	if err != nil {
+		panic("内置分类体系无效: " + err.Error())
+	}
+	return t
+}
+
+func parseTaxonomy(data []byte) (*Taxonomy, error) {
+	var t Taxonomy
+	if err := json.Unmarshal(data, &t); err != nil {
+		return nil, err
+	}
+	if len(t.Languages) == 0 {
+		return nil, fmt.Errorf("languages不能为空")
+	}
+	if err := t.Default.validate(t.Languages); err != nil {
+		return nil, fmt.Errorf("default: %v", err)
+	}
+	kinds := map[string]bool{}
+	for _, k := range t.ChildKinds {
+		switch k.ID {
+		case ChildDepartment, ChildBuilding, ChildEntrance, ChildBranch, ChildOther:
+		default:
+			return nil, fmt.Errorf("未知的子POI种类 %q", k.ID)
+		}
+		kinds[k.ID] = true
+	}
+	for childtype, kind := range t.Childtypes {
+		if !kinds[kind] {
+			return nil, fmt.Errorf("childtype %s 的种类 %q 未在child_kinds中定义", childtype, kind)
+		}
+	}
+	t.byTypecode = make(map[string]*TaxonomyCategory, len(t.Categories))
+	for i := range t.Categories {
+		c := &t.Categories[i]
+		if len(c.Typecode) != 6 {
+			return nil, fmt.Errorf("类别 %q 的typecode %q 应为6位", c.ID, c.Typecode)
+		}
+		if t.byTypecode[c.Typecode] != nil {
+			return nil, fmt.Errorf("typecode %s 重复", c.Typecode)
+		}
+		if err := c.validate(t.Languages); err != nil {
+			return nil, fmt.Errorf("typecode %s: %v", c.Typecode, err)
+		}
+		t.byTypecode[c.Typecode] = c
+	}
+	return &t, nil
+}
+
+func (c *TaxonomyCategory) validate(languages []string) error {
+	if c.ID == "" {
+		return fmt.Errorf("缺少id")
+	}
+	for _, lang := range languages {
+		if c.Labels[lang] == "" || c.TypeLabels[lang] == "" {
+			return fmt.Errorf("缺少%s名称", lang)
+		}
+	}
+	switch c.Visibility {
+	case VisibilityAlways, VisibilityChildtypeEmpty, VisibilityHidden:
+	default:
+		return fmt.Errorf("未知的visibility %q", c.Visibility)
+	}
+	if c.Icon == "" || c.LegacyIcon == "" {
+		return fmt.Errorf("缺少图标")
+	}
+	return nil
+}
+
+// 启动时加载外部分类文件，失败时保留内置分类
+func initTaxonomy() {
+	path := os.Getenv("TAXONOMY_FILE")
+	if path == "" {
+		return
+	}
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		log.Printf("[分类体系] 读取 %s 失败，使用内置分类: %v", path, err)
+		return
+	}
+	t, err := parseTaxonomy(data)
+	if err != nil {
+		log.Printf("[分类体系] %s 无效，使用内置分类: %v", path, err)
+		return
+	}
+	taxonomy = t
+	log.Printf("[分类体系] 已从 %s 加载 %d 个类别（版本 %d）", path, len(t.Categories), t.Version)
+}
+
+// 按typecode精确查找类别
+func (t *Taxonomy) Lookup(typecode string) (*TaxonomyCategory, bool) {
+	c, ok := t.byTypecode[typecode]
+	return c, ok
+}
+
+// 类型全称，供hospital_category使用
+func (t *Taxonomy) TypeLabel(typecode, lang string) (string, bool) {
+	c, ok := t.byTypecode[typecode]
+	if !ok {
+		return "", false
+	}
+	return c.TypeLabels[lang], true
+}
+
+// 旧版icon_type，未登记的typecode使用默认图标
+func (t *Taxonomy) LegacyIcon(typecode string) string {
+	if c, ok := t.byTypecode[typecode]; ok {
+		return c.LegacyIcon
+	}
+	return t.Default.LegacyIcon
+}
+
+// 按规则对POI分类：多typecode（如090202|090300）取第一个；返回nil表示不显示
+func (t *Taxonomy) Classify(typecode string, childtypeEmpty bool) *TaxonomyCategory {
+	if i := strings.Index(typecode, "|"); i >= 6 {
+		typecode = typecode[:6]
+	}
+	c, ok := t.byTypecode[typecode]
+	if !ok {
+		return &t.Default
+	}
+	switch c.Visibility {
+	case VisibilityHidden:
+		return nil
+	case VisibilityChildtypeEmpty:
+		if !childtypeEmpty {
+			return nil
+		}
+	}
+	return c
+}
+
+// 子POI种类：名称为出入口（如"XX医院(东门)"）的优先，其余按childtype，未登记的归入other
+func (t *Taxonomy) ChildKind(childtype, name string) string {
+	if isEntranceName(name) {
+		return ChildEntrance
+	}
+	if kind, ok := t.Childtypes[childtype]; ok {
+		return kind
+	}
+	return ChildOther
+}
+
+// 分类体系接口，lang指定名称语言（默认zh），供前端图例和筛选使用
+func getTaxonomy(c *gin.Context) {
+	t := taxonomy
+	lang := c.DefaultQuery("lang", t.Languages[0])
+	supported := false
+	for _, l := range t.Languages {
+		supported = supported || l == lang
+	}
+	if !supported {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "不支持的语言: " + lang, "languages": t.Languages})
+		return
+	}
+
+	type item struct {
+		ID           string            `json:"id"`
+		Label        string            `json:"label"`
+		TypeLabel    string            `json:"type_label"`
+		Labels       map[string]string `json:"labels"`
+		Icon         string            `json:"icon"`
+		DisplayOrder int               `json:"display_order"`
+		Visibility   string            `json:"visibility"`
+		Typecodes    []string          `json:"typecodes"`
+	}
+	// 同一类别id可对应多个typecode，按id合并
+	var items []*item
+	byID := map[string]*item{}
+	for _, cat := range append(append([]TaxonomyCategory{}, t.Categories...), t.Default) {
+		if it := byID[cat.ID]; it != nil {
+			if cat.Typecode != "" {
+				it.Typecodes = append(it.Typecodes, cat.Typecode)
+			}
+			continue
+		}
+		it := &item{
+			ID: cat.ID, Label: cat.Labels[lang], TypeLabel: cat.TypeLabels[lang], Labels: cat.Labels,
+			Icon: cat.Icon, DisplayOrder: cat.DisplayOrder, Visibility: cat.Visibility, Typecodes: []string{},
+		}
+		if cat.Typecode != "" {
+			it.Typecodes = append(it.Typecodes, cat.Typecode)
+		}
+		byID[cat.ID] = it
+		items = append(items, it)
+	}
+	sort.SliceStable(items, func(i, j int) bool {
+		// 不显示的类别排在最后
+		hi, hj := items[i].Visibility == VisibilityHidden, items[j].Visibility == VisibilityHidden
+		if hi != hj {
+			return hj
+		}
+		return items[i].DisplayOrder < items[j].DisplayOrder
+	})
+
+	c.Header("Cache-Control", "public, max-age=3600")
+	c.JSON(http.StatusOK, gin.H{
+		"status":      "success",
+		"version":     t.Version,
+		"lang":        lang,
+		"languages":   t.Languages,
+		"count":       len(items),
+		"data":        items,
+		"child_kinds": t.ChildKinds,
+	})
+}
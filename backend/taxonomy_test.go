@@ -0,0 +1,123 @@
+package main
+
+import (
+	"io/ioutil"
+	"net/http"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestClassifyHospitalRules(t *testing.T) {
+	cases := []struct {
+		typecode  string
+		childtype interface{}
+		cat, icon string
+		order     int
+	}{
+		{"090101", "", "三甲", "icon_tier3_hospital_bold", 1},
+		{"090101", []interface{}{}, "三甲", "icon_tier3_hospital_bold", 1},
+		{"090101", "202", "null", "null", 0},
+		{"090100", nil, "综合医院", "icon_general_hospital_bold", 2},
+		{"090100", "0", "综合医院", "icon_general_hospital_bold", 2},
+		{"090100", "320", "null", "null", 0},
+		{"090201", "", "null", "null", 0},
+		{"090102", "320", "社区医院", "icon_small_red_cross_bold", 3},
+		{"090202|090300", "", "牙科", "icon_tooth", 5},
+		{"090210", "", "精神", "icon_small_red_cross_normal", 13},
+		{"090400", "", "急救中心", "icon_er", 16},
+		{"090601", "", "其他", "icon_default", 99},
+		{"", nil, "其他", "icon_default", 99},
+	}
+	for _, tc := range cases {
+		poi := map[string]interface{}{"name": "测试", "typecode": tc.typecode, "childtype": tc.childtype}
+		cat, icon, order := classifyHospital(poi)
+		if cat != tc.cat || icon != tc.icon || order != tc.order {
+			t.Errorf("%s/%v: %s %s %d", tc.typecode, tc.childtype, cat, icon, order)
+		}
+	}
+}
+
+func TestParseTaxonomyValidation(t *testing.T) {
+	base := `{"version": 1, "languages": ["zh", "en"],
+		"default": {"id": "other", "labels": {"zh": "其他", "en": "Other"}, "type_labels": {"zh": "其他", "en": "Other"},
+			"icon": "icon_default", "legacy_icon": "icon_default", "display_order": 99, "visibility": "always"},
+		"categories": [%s]}`
+	entry := func(typecode, visibility, en string) string {
+		return `{"typecode": "` + typecode + `", "id": "c` + typecode + `", "labels": {"zh": "类", "en": "` + en + `"},
+			"type_labels": {"zh": "类", "en": "Type"}, "icon": "i", "legacy_icon": "i", "visibility": "` + visibility + `"}`
+	}
+	cases := []struct {
+		name, categories, wantErr string
+	}{
+		{"ok", entry("090100", "always", "C"), ""},
+		{"重复typecode", entry("090100", "always", "C") + "," + entry("090100", "hidden", "C"), "重复"},
+		{"typecode长度", entry("0901", "always", "C"), "6位"},
+		{"未知显示规则", entry("090100", "sometimes", "C"), "visibility"},
+		{"缺少英文名称", entry("090100", "always", ""), "缺少en名称"},
+	}
+	for _, tc := range cases {
+		_, err := parseTaxonomy([]byte(strings.Replace(base, "%s", tc.categories, 1)))
+		if tc.wantErr == "" && err != nil || tc.wantErr != "" && (err == nil || !strings.Contains(err.Error(), tc.wantErr)) {
+			t.Errorf("%s: %v", tc.name, err)
+		}
+	}
+}
+
+func TestTaxonomyFileOverride(t *testing.T) {
+	saved := taxonomy
+	defer func() { taxonomy = saved }()
+
+	custom := strings.Replace(string(defaultTaxonomyJSON), `"labels": {"zh": "牙科", "en": "Dental"}`,
+		`"labels": {"zh": "口腔", "en": "Dentistry"}`, 1)
+	path := filepath.Join(t.TempDir(), "taxonomy.json")
+	ioutil.WriteFile(path, []byte(custom), 0644)
+	t.Setenv("TAXONOMY_FILE", path)
+	initTaxonomy()
+	if cat, _, _ := classifyHospital(map[string]interface{}{"typecode": "090202"}); cat != "口腔" {
+		t.Fatalf("外部分类文件未生效: %s", cat)
+	}
+
+	// 无效文件保留原分类
+	ioutil.WriteFile(path, []byte(`{"languages": ["zh"], "categories": []}`), 0644)
+	initTaxonomy()
+	if cat, _, _ := classifyHospital(map[string]interface{}{"typecode": "090202"}); cat != "口腔" {
+		t.Fatalf("无效文件覆盖了分类: %s", cat)
+	}
+}
+
+func TestTaxonomyAPI(t *testing.T) {
+	e := setupE2E(t, nil)
+	w, body := e.get("/api/taxonomy?lang=en")
+	if w.Code != http.StatusOK || body["lang"] != "en" {
+		t.Fatalf("分类体系: %d %s", w.Code, w.Body.String())
+	}
+	data := body["data"].([]interface{})
+	first := data[0].(map[string]interface{})
+	if first["id"] != "tier3_hospital" || first["label"] != "Grade 3A" || first["icon"] != "icon_tier3_hospital_bold" {
+		t.Fatalf("首个类别: %v", first)
+	}
+	if last := data[len(data)-1].(map[string]interface{}); last["visibility"] != VisibilityHidden {
+		t.Fatalf("不显示的类别应排在最后: %v", last)
+	}
+	prev := -1.0
+	for _, v := range data {
+		item := v.(map[string]interface{})
+		if item["visibility"] == VisibilityHidden {
+			continue
+		}
+		if order := item["display_order"].(float64); order <= prev {
+			t.Fatalf("排序错误: %v", data)
+		} else {
+			prev = order
+		}
+	}
+
+	_, body = e.get("/api/taxonomy")
+	if body["lang"] != "zh" || body["data"].([]interface{})[0].(map[string]interface{})["label"] != "三甲" {
+		t.Fatalf("默认中文: %v", body["data"])
+	}
+	if w, _ := e.get("/api/taxonomy?lang=fr"); w.Code != http.StatusBadRequest {
+		t.Fatalf("不支持的语言 %d", w.Code)
+	}
+}
@@ -0,0 +1,529 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"math/rand"
+	"net/http"
+	"net/url"
+	"regexp"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+// 上游调用配置
+type UpstreamConfig struct {
+	BaseURL          string // 服务商接口地址，测试时可指向本地fake服务
+	Timeout          time.Duration
+	MaxRetries       int
+	BaseBackoff      time.Duration
+	MaxBackoff       time.Duration
+	FailureThreshold int
+	Cooldown         time.Duration
+}
+
+// UpstreamClient 封装超时、重试、退避与熔断，按服务商共享
+type UpstreamClient struct {
+	provider  string
+	config    UpstreamConfig
+	http      *http.Client
+	breaker   *CircuitBreaker
+	checkBody func([]byte) error // 解析服务商业务状态码
+	// 每次实际请求前调用（含重试），用于扣减配额、等待QPS时间片
+	beforeAttempt func(context.Context) error
+	sleep         func(context.Context, time.Duration) error
+}
+
+var (
+	upstreamClientsMu sync.Mutex
+	upstreamClients   = map[string]*UpstreamClient{}
+)
+
+func NewUpstreamClient(provider string, config UpstreamConfig, checkBody func([]byte) error) *UpstreamClient {
+	return &UpstreamClient{
+		provider:  provider,
+		config:    config,
+		http:      &http.Client{Timeout: config.Timeout},
+		breaker:   NewCircuitBreaker(config.FailureThreshold, config.Cooldown),
+		checkBody: checkBody,
+		sleep:     sleepContext,
+	}
+}
+
+// 初始化各服务商的上游客户端
+func initUpstreamClients() {
+	clients := newUpstreamClients()
+	upstreamClientsMu.Lock()
+	upstreamClients = clients
+	upstreamClientsMu.Unlock()
+}
+
+func newUpstreamClients() map[string]*UpstreamClient {
+	base := UpstreamConfig{
+		MaxRetries:       envInt("UPSTREAM_MAX_RETRIES", 2),
+		BaseBackoff:      time.Duration(envInt("UPSTREAM_BACKOFF_MS", 200)) * time.Millisecond,
+		MaxBackoff:       2 * time.Second,
+		FailureThreshold: envInt("BREAKER_FAILURE_THRESHOLD", 5),
+		Cooldown:         time.Duration(envInt("BREAKER_COOLDOWN_SEC", 30)) * time.Second,
+	}
+	amapConfig := base
+	amapConfig.BaseURL = strings.TrimRight(getEnvDefault("AMAP_BASE_URL", "https://restapi.amap.com"), "/")
+	amapConfig.Timeout = time.Duration(envInt("AMAP_TIMEOUT_MS", 8000)) * time.Millisecond
+	googleConfig := base
+	googleConfig.BaseURL = strings.TrimRight(getEnvDefault("GOOGLE_BASE_URL", "https://maps.googleapis.com"), "/")
+	googleConfig.Timeout = time.Duration(envInt("GOOGLE_TIMEOUT_MS", 10000)) * time.Millisecond
+
+	amap := NewUpstreamClient(ProviderAmap, amapConfig, checkAmapBody)
+	amap.beforeAttempt = func(ctx context.Context) error {
+		if err := upstreamQuota.Reserve(ProviderAmap, 1); err != nil {
+			return err
+		}
+		if amapQPS == nil {
+			return nil
+		}
+		return amapQPS.Wait(ctx)
+	}
+	google := NewUpstreamClient(ProviderGoogle, googleConfig, checkGoogleBody)
+	google.beforeAttempt = func(ctx context.Context) error {
+		return upstreamQuota.Reserve(ProviderGoogle, 1)
+	}
+	return map[string]*UpstreamClient{ProviderAmap: amap, ProviderGoogle: google}
+}
+
+// 未经initUpstreamClients初始化时（如命令行、测试）按环境变量创建
+func upstreamClient(provider string) *UpstreamClient {
+	upstreamClientsMu.Lock()
+	defer upstreamClientsMu.Unlock()
+	if len(upstreamClients) == 0 {
+		upstreamClients = newUpstreamClients()
+	}
+	return upstreamClients[provider]
+}
+
+// 拼接服务商接口地址
+func upstreamURL(provider, path string) string {
+	return upstreamClient(provider).config.BaseURL + path
+}
+
+// HTTP层错误
+type UpstreamHTTPError struct {
+	Provider   string
+	StatusCode int
+	Body       string
+}
+
+func (e *UpstreamHTTPError) Error() string {
+	return fmt.Sprintf("%s HTTP状态码 %d", e.Provider, e.StatusCode)
+}
+
+// 响应体无法解析
+type MalformedResponseError struct {
+	Provider string
+	Err      error
+}
+
+func (e *MalformedResponseError) Error() string {
+	return fmt.Sprintf("%s 响应解析失败: %v", e.Provider, e.Err)
+}
+
+// 高德infocode分类
+const (
+	AmapErrQuotaExceeded  = "quota_exceeded"
+	AmapErrInvalidKey     = "invalid_key"
+	AmapErrQPSLimit       = "qps_limit"
+	AmapErrServiceBusy    = "service_busy"
+	AmapErrInvalidRequest = "invalid_request"
+	AmapErrUnknown        = "unknown"
+)
+
+var amapInfocodeKinds = map[string]string{
+	"10001": AmapErrInvalidKey,     // INVALID_USER_KEY
+	"10002": AmapErrInvalidKey,     // SERVICE_NOT_AVAILABLE
+	"10003": AmapErrQuotaExceeded,  // DAILY_QUERY_OVER_LIMIT
+	"10004": AmapErrQPSLimit,       // ACCESS_TOO_FREQUENT
+	"10005": AmapErrInvalidKey,     // INVALID_USER_IP
+	"10006": AmapErrInvalidKey,     // INVALID_USER_DOMAIN
+	"10007": AmapErrInvalidKey,     // INVALID_USER_SIGNATURE
+	"10008": AmapErrInvalidKey,     // INVALID_USER_SCODE
+	"10009": AmapErrInvalidKey,     // USERKEY_PLAT_NOMATCH
+	"10010": AmapErrQuotaExceeded,  // IP_QUERY_OVER_LIMIT
+	"10012": AmapErrInvalidKey,     // INSUFFICIENT_PRIVILEGES
+	"10013": AmapErrInvalidKey,     // USER_KEY_RECYCLED
+	"10014": AmapErrQPSLimit,       // QPS_HAS_EXCEEDED_THE_LIMIT
+	"10015": AmapErrServiceBusy,    // GATEWAY_TIMEOUT
+	"10016": AmapErrServiceBusy,    // SERVER_IS_BUSY
+	"10017": AmapErrServiceBusy,    // RESOURCE_UNAVAILABLE
+	"10019": AmapErrQPSLimit,       // CQPS_HAS_EXCEEDED_THE_LIMIT
+	"10020": AmapErrQPSLimit,       // CKQPS_HAS_EXCEEDED_THE_LIMIT
+	"10021": AmapErrQPSLimit,       // CUQPS_HAS_EXCEEDED_THE_LIMIT
+	"10026": AmapErrInvalidRequest, // INVALID_REQUEST
+	"10029": AmapErrQuotaExceeded,  // ABROAD_DAILY_QUERY_OVER_LIMIT
+	"10044": AmapErrQuotaExceeded,  // USER_DAILY_QUERY_OVER_LIMIT
+	"10045": AmapErrQuotaExceeded,  // USER_ABROAD_DAILY_QUERY_OVER_LIMIT
+	"20000": AmapErrInvalidRequest, // INVALID_PARAMS
+	"20001": AmapErrInvalidRequest, // MISSING_REQUIRED_PARAMS
+	"20002": AmapErrInvalidRequest, // ILLEGAL_REQUEST
+	"20003": AmapErrServiceBusy,    // UNKNOWN_ERROR
+}
+
+// 高德业务错误（status != "1"）
+type AmapError struct {
+	Infocode string
+	Info     string
+	Kind     string
+}
+
+func (e *AmapError) Error() string {
+	return fmt.Sprintf("高德API错误 %s (%s): %s", e.Infocode, e.Kind, e.Info)
+}
+
+func classifyAmapInfocode(infocode string) string {
+	if kind, ok := amapInfocodeKinds[infocode]; ok {
+		return kind
+	}
+	if len(infocode) == 5 && infocode[:3] == "300" {
+		return AmapErrServiceBusy // 引擎返回数据异常
+	}
+	return AmapErrUnknown
+}
+
+func checkAmapBody(body []byte) error {
+	var resp struct {
+		Status   string `json:"status"`
+		Info     string `json:"info"`
+		Infocode string `json:"infocode"`
+	}
+	if err := json.Unmarshal(body, &resp); err != nil {
+		return &MalformedResponseError{Provider: ProviderAmap, Err: err}
+	}
+	if resp.Status != "1" {
+		return &AmapError{Infocode: resp.Infocode, Info: resp.Info, Kind: classifyAmapInfocode(resp.Infocode)}
+	}
+	return nil
+}
+
+// Google业务错误
+type GoogleError struct {
+	Status  string
+	Message string
+}
+
+func (e *GoogleError) Error() string {
+	return fmt.Sprintf("Google API错误 %s: %s", e.Status, e.Message)
+}
+
+func checkGoogleBody(body []byte) error {
+	var resp struct {
+		Status       string `json:"status"`
+		ErrorMessage string `json:"error_message"`
+	}
+	if err := json.Unmarshal(body, &resp); err != nil {
+		return &MalformedResponseError{Provider: ProviderGoogle, Err: err}
+	}
+	if resp.Status != "" && resp.Status != "OK" && resp.Status != "ZERO_RESULTS" {
+		return &GoogleError{Status: resp.Status, Message: resp.ErrorMessage}
+	}
+	return nil
+}
+
+// 是否值得重试；QPS超限、配额用尽时重试只会加剧限流，不重试
+func isRetryable(err error) bool {
+	var httpErr *UpstreamHTTPError
+	var amapErr *AmapError
+	var googleErr *GoogleError
+	var malformed *MalformedResponseError
+	var quotaErr *QuotaExceededError
+	switch {
+	case errors.Is(err, context.Canceled), errors.As(err, &quotaErr):
+		return false
+	case errors.As(err, &httpErr):
+		return httpErr.StatusCode >= 500
+	case errors.As(err, &amapErr):
+		return amapErr.Kind == AmapErrServiceBusy
+	case errors.As(err, &googleErr):
+		return googleErr.Status == "UNKNOWN_ERROR"
+	case errors.As(err, &malformed):
+		return true
+	}
+	// 网络错误、超时
+	return true
+}
+
+// 是否应计入熔断失败次数（请求参数错误属于调用方问题，不计入）
+func countsAsFailure(err error) bool {
+	var amapErr *AmapError
+	if errors.As(err, &amapErr) && amapErr.Kind == AmapErrInvalidRequest {
+		return false
+	}
+	var googleErr *GoogleError
+	if errors.As(err, &googleErr) && googleErr.Status == "INVALID_REQUEST" {
+		return false
+	}
+	var quotaErr *QuotaExceededError
+	if errors.As(err, &quotaErr) {
+		return false
+	}
+	return !errors.Is(err, context.Canceled)
+}
+
+// GET请求上游并返回响应体，失败时按策略重试；每次尝试都经过beforeAttempt
+func (u *UpstreamClient) Get(ctx context.Context, rawURL string) ([]byte, error) {
+	if err := u.breaker.Allow(); err != nil {
+		return nil, err
+	}
+	start := time.Now()
+	var lastErr error
+	var lastBody []byte
+	attempts := 0
+	for attempt := 0; attempt <= u.config.MaxRetries; attempt++ {
+		if attempt > 0 {
+			wait := u.backoff(attempt)
+			log.Printf("[上游][%s] 第%d次重试，等待 %v: %s", u.provider, attempt, wait, redactKey(lastErr.Error()))
+			if err := u.sleep(ctx, wait); err != nil {
+				lastErr = err
+				break
+			}
+		}
+		if u.beforeAttempt != nil {
+			if err := u.beforeAttempt(ctx); err != nil {
+				lastErr = err
+				break
+			}
+		}
+		attempts++
+		body, err := u.do(ctx, rawURL)
+		lastBody = body
+		if err == nil {
+			u.breaker.Success()
+			recordUpstreamCall(ctx, u.provider, rawURL, body, nil, attempts, time.Since(start))
+			return body, nil
+		}
+		lastErr = err
+		if ctx.Err() != nil || !isRetryable(err) {
+			break
+		}
+	}
+	if attempts == 0 {
+		// 未实际请求上游（配额用尽或已取消），不记录台账
+		u.breaker.Release()
+		return nil, lastErr
+	}
+	log.Printf("[上游][%s] 请求失败 %s: %s", u.provider, redactKey(rawURL), redactKey(lastErr.Error()))
+	if countsAsFailure(lastErr) {
+		u.breaker.Failure(lastErr)
+	} else {
+		u.breaker.Release()
+	}
+	recordUpstreamCall(ctx, u.provider, rawURL, lastBody, lastErr, attempts, time.Since(start))
+	return nil, lastErr
+}
+
+// 请求一次上游；出错时仍返回已读取的响应体，供台账记录
+func (u *UpstreamClient) do(ctx context.Context, rawURL string) ([]byte, error) {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, rawURL, nil)
+	if err != nil {
+		return nil, err
+	}
+	resp, err := u.http.Do(req)
+	if err != nil {
+		// 网络错误的消息中带有完整URL，隐藏其中的API Key
+		var urlErr *url.Error
+		if errors.As(err, &urlErr) {
+			urlErr.URL = redactKey(urlErr.URL)
+		}
+		return nil, err
+	}
+	defer resp.Body.Close()
+	body, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return nil, err
+	}
+	if resp.StatusCode != http.StatusOK {
+		return body, &UpstreamHTTPError{Provider: u.provider, StatusCode: resp.StatusCode, Body: string(body)}
+	}
+	if u.checkBody != nil {
+		if err := u.checkBody(body); err != nil {
+			return body, err
+		}
+	}
+	return body, nil
+}
+
+// 带抖动的指数退避（full jitter）
+func (u *UpstreamClient) backoff(attempt int) time.Duration {
+	ceiling := u.config.BaseBackoff << uint(attempt-1)
+	if ceiling > u.config.MaxBackoff || ceiling <= 0 {
+		ceiling = u.config.MaxBackoff
+	}
+	return time.Duration(rand.Int63n(int64(ceiling) + 1))
+}
+
+func sleepContext(ctx context.Context, d time.Duration) error {
+	timer := time.NewTimer(d)
+	defer timer.Stop()
+	select {
+	case <-ctx.Done():
+		return ctx.Err()
+	case <-timer.C:
+		return nil
+	}
+}
+
+var keyParamPattern = regexp.MustCompile(`([?&]key=)[^&"\s]*`)
+
+// 日志中隐藏API Key
+func redactKey(rawURL string) string {
+	return keyParamPattern.ReplaceAllString(rawURL, "${1}***")
+}
+
+// 熔断器状态
+const (
+	BreakerClosed   = "closed"
+	BreakerOpen     = "open"
+	BreakerHalfOpen = "half_open"
+)
+
+// 熔断打开时返回的错误
+type CircuitOpenError struct {
+	RetryAfter time.Duration
+}
+
+func (e *CircuitOpenError) Error() string {
+	return "上游熔断中，暂停调用"
+}
+
+// CircuitBreaker 连续失败达到阈值后打开，冷却期结束放行一个探测请求
+type CircuitBreaker struct {
+	mu        sync.Mutex
+	state     string
+	failures  int
+	threshold int
+	cooldown  time.Duration
+	openedAt  time.Time
+	probing   bool
+	lastError string
+	lastErrAt time.Time
+	now       func() time.Time
+}
+
+func NewCircuitBreaker(threshold int, cooldown time.Duration) *CircuitBreaker {
+	return &CircuitBreaker{state: BreakerClosed, threshold: threshold, cooldown: cooldown, now: time.Now}
+}
+
+func (b *CircuitBreaker) Allow() error {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	switch b.state {
+	case BreakerOpen:
+		elapsed := b.now().Sub(b.openedAt)
+		if elapsed < b.cooldown {
+			return &CircuitOpenError{RetryAfter: b.cooldown - elapsed}
+		}
+		b.state = BreakerHalfOpen
+		b.probing = true
+		return nil
+	case BreakerHalfOpen:
+		if b.probing {
+			return &CircuitOpenError{RetryAfter: time.Second}
+		}
+		b.probing = true
+	}
+	return nil
+}
+
+func (b *CircuitBreaker) Success() {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.state = BreakerClosed
+	b.failures = 0
+	b.probing = false
+}
+
+// 调用方放弃请求（如客户端断开），不影响熔断计数
+func (b *CircuitBreaker) Release() {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.probing = false
+}
+
+func (b *CircuitBreaker) Failure(err error) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.failures++
+	b.lastError = redactKey(err.Error())
+	b.lastErrAt = b.now()
+	b.probing = false
+	if b.state == BreakerHalfOpen || (b.threshold > 0 && b.failures >= b.threshold) {
+		if b.state != BreakerOpen {
+			log.Printf("[熔断] 打开熔断，连续失败 %d 次: %s", b.failures, b.lastError)
+		}
+		b.state = BreakerOpen
+		b.openedAt = b.now()
+	}
+}
+
+func (b *CircuitBreaker) Snapshot() gin.H {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	snap := gin.H{
+		"state":                b.state,
+		"consecutive_failures": b.failures,
+		"last_error":           b.lastError,
+	}
+	if !b.lastErrAt.IsZero() {
+		snap["last_error_at"] = b.lastErrAt.Format(time.RFC3339)
+	}
+	if b.state == BreakerOpen {
+		snap["reopen_at"] = b.openedAt.Add(b.cooldown).Format(time.RFC3339)
+	}
+	return snap
+}
+
+// 将上游错误映射为HTTP响应
+func respondUpstreamError(c *gin.Context, err error) {
+	var openErr *CircuitOpenError
+	var quotaErr *QuotaExceededError
+	var amapErr *AmapError
+	var googleErr *GoogleError
+	switch {
+	case errors.As(err, &openErr):
+		setRetryAfter(c, openErr.RetryAfter)
+		c.JSON(http.StatusServiceUnavailable, gin.H{"error": err.Error(), "retry_after": retryAfterSeconds(openErr.RetryAfter)})
+	case errors.As(err, &quotaErr):
+		setRetryAfter(c, quotaErr.RetryAfter)
+		c.JSON(http.StatusTooManyRequests, gin.H{"error": err.Error(), "provider": quotaErr.Provider, "retry_after": retryAfterSeconds(quotaErr.RetryAfter)})
+	case errors.As(err, &amapErr):
+		status := http.StatusBadGateway
+		if amapErr.Kind == AmapErrQuotaExceeded || amapErr.Kind == AmapErrQPSLimit {
+			status = http.StatusTooManyRequests
+		}
+		c.JSON(status, gin.H{"error": err.Error(), "infocode": amapErr.Infocode, "kind": amapErr.Kind})
+	case errors.As(err, &googleErr):
+		c.JSON(http.StatusBadGateway, gin.H{"error": err.Error(), "status": googleErr.Status})
+	default:
+		c.JSON(http.StatusBadGateway, gin.H{"error": "upstream request failed", "detail": redactKey(err.Error())})
+	}
+}
+
+// 健康检查：上游熔断状态
+func getHealth(c *gin.Context) {
+	status := "ok"
+	var upstreams []gin.H
+	for _, provider := range []string{ProviderAmap, ProviderGoogle} {
+		snap := upstreamClient(provider).breaker.Snapshot()
+		snap["provider"] = provider
+		if snap["state"] != BreakerClosed {
+			status = "degraded"
+		}
+		upstreams = append(upstreams, snap)
+	}
+	c.JSON(http.StatusOK, gin.H{"status": status, "upstreams": upstreams, "offline_mode": offlineMode})
+}
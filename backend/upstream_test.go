@@ -0,0 +1,204 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"log"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+func TestCircuitBreakerTransitions(t *testing.T) {
+	now := time.Date(2025, 7, 1, 12, 0, 0, 0, time.UTC)
+	b := NewCircuitBreaker(2, 30*time.Second)
+	b.now = func() time.Time { return now }
+	fail := errors.New("boom")
+
+	b.Failure(fail)
+	if b.state != BreakerClosed || b.Allow() != nil {
+		t.Fatalf("未达阈值应保持关闭: %s", b.state)
+	}
+	b.Success()
+	b.Failure(fail)
+	if b.state != BreakerClosed {
+		t.Fatal("成功后失败计数应清零")
+	}
+	b.Failure(fail)
+	if b.state != BreakerOpen {
+		t.Fatalf("连续失败达到阈值应打开: %s", b.state)
+	}
+	var openErr *CircuitOpenError
+	now = now.Add(10 * time.Second)
+	if err := b.Allow(); !errors.As(err, &openErr) || openErr.RetryAfter != 20*time.Second {
+		t.Fatalf("冷却期内: %v", err)
+	}
+
+	// 冷却结束放行一个探测请求，其余请求仍被拒绝
+	now = now.Add(20 * time.Second)
+	if err := b.Allow(); err != nil || b.state != BreakerHalfOpen {
+		t.Fatalf("探测请求: %v %s", err, b.state)
+	}
+	if err := b.Allow(); !errors.As(err, &openErr) {
+		t.Fatal("探测期间只放行一个请求")
+	}
+	// 探测失败立即重新打开
+	b.Failure(fail)
+	if b.state != BreakerOpen || b.openedAt != now {
+		t.Fatalf("探测失败: %s", b.state)
+	}
+
+	// 探测被调用方放弃时允许下一个探测
+	now = now.Add(30 * time.Second)
+	b.Allow()
+	b.Release()
+	if err := b.Allow(); err != nil || b.state != BreakerHalfOpen {
+		t.Fatalf("放弃探测后: %v %s", err, b.state)
+	}
+	b.Success()
+	if b.state != BreakerClosed || b.failures != 0 || b.Allow() != nil {
+		t.Fatalf("探测成功应关闭: %s", b.state)
+	}
+	if snap := b.Snapshot(); snap["last_error"] != "boom" || snap["state"] != BreakerClosed {
+		t.Fatalf("快照: %v", snap)
+	}
+}
+
+func TestUpstreamBackoffBounds(t *testing.T) {
+	u := NewUpstreamClient(ProviderAmap, UpstreamConfig{BaseBackoff: 100 * time.Millisecond, MaxBackoff: time.Second}, nil)
+	for attempt, ceiling := range map[int]time.Duration{
+		1:  100 * time.Millisecond,
+		2:  200 * time.Millisecond,
+		4:  800 * time.Millisecond,
+		5:  time.Second,
+		70: time.Second, // 移位溢出
+	} {
+		for i := 0; i < 200; i++ {
+			if d := u.backoff(attempt); d < 0 || d > ceiling {
+				t.Fatalf("第%d次重试退避 %v 超出 [0, %v]", attempt, d, ceiling)
+			}
+		}
+	}
+}
+
+func TestClassifyAmapInfocode(t *testing.T) {
+	for code, want := range map[string]string{
+		"10001": AmapErrInvalidKey,
+		"10003": AmapErrQuotaExceeded,
+		"10044": AmapErrQuotaExceeded,
+		"10004": AmapErrQPSLimit,
+		"10021": AmapErrQPSLimit,
+		"10016": AmapErrServiceBusy,
+		"30001": AmapErrServiceBusy,
+		"20000": AmapErrInvalidRequest,
+		"99999": AmapErrUnknown,
+		"":      AmapErrUnknown,
+	} {
+		if got := classifyAmapInfocode(code); got != want {
+			t.Errorf("%q: %s，应为 %s", code, got, want)
+		}
+	}
+	err := checkAmapBody([]byte(`{"status":"0","info":"ACCESS_TOO_FREQUENT","infocode":"10004"}`))
+	var amapErr *AmapError
+	if !errors.As(err, &amapErr) || amapErr.Kind != AmapErrQPSLimit || isRetryable(err) {
+		t.Fatalf("QPS超限不应重试: %v", err)
+	}
+	if err := checkAmapBody([]byte(`{"status":"0","infocode":"10016"}`)); !isRetryable(err) {
+		t.Fatal("服务繁忙应重试")
+	}
+	if err := checkAmapBody([]byte(`{"status":"1"}`)); err != nil {
+		t.Fatal(err)
+	}
+	// HTTP 429同样是限流，不重试；5xx重试
+	if isRetryable(&UpstreamHTTPError{Provider: ProviderAmap, StatusCode: http.StatusTooManyRequests}) {
+		t.Fatal("HTTP 429不应重试")
+	}
+	if !isRetryable(&UpstreamHTTPError{Provider: ProviderAmap, StatusCode: http.StatusBadGateway}) {
+		t.Fatal("HTTP 502应重试")
+	}
+}
+
+func TestUpstreamErrorsHideKey(t *testing.T) {
+	// 已关闭的服务：连接被拒绝，错误消息中带有完整请求URL
+	dead := httptest.NewServer(http.NotFoundHandler())
+	dead.Close()
+	e := setupE2E(t, map[string]string{"AMAP_BASE_URL": dead.URL, "BREAKER_FAILURE_THRESHOLD": "1"})
+	var logs bytes.Buffer
+	log.SetOutput(&logs)
+
+	_, err := upstreamClient(ProviderAmap).Get(context.Background(), dead.URL+"/v3/place/around?key="+e2eAmapKey+"&location="+e2eLocation)
+	if err == nil {
+		t.Fatal("应请求失败")
+	}
+	w := httptest.NewRecorder()
+	c, _ := gin.CreateTestContext(w)
+	respondUpstreamError(c, err)
+	health := doRequest(e.router, http.MethodGet, "/api/health", nil, nil)
+	if !strings.Contains(health.Body.String(), "key=***") {
+		t.Fatalf("健康检查应带有隐藏后的错误: %s", health.Body.String())
+	}
+	for name, out := range map[string]string{"响应": w.Body.String(), "健康检查": health.Body.String(), "日志": logs.String()} {
+		if strings.Contains(out, e2eAmapKey) {
+			t.Errorf("%s泄露了API Key: %s", name, out)
+		}
+	}
+}
+
+func TestUpstreamRetryPerAttemptHook(t *testing.T) {
+	setupTestDB(t)
+	var calls, busy int32
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		atomic.AddInt32(&calls, 1)
+		if atomic.AddInt32(&busy, -1) >= 0 {
+			w.Write([]byte(`{"status":"0","info":"SERVER_IS_BUSY","infocode":"10016"}`))
+			return
+		}
+		if r.URL.Query().Get("qps") != "" {
+			w.Write([]byte(`{"status":"0","info":"ACCESS_TOO_FREQUENT","infocode":"10004"}`))
+			return
+		}
+		w.Write([]byte(`{"status":"1"}`))
+	}))
+	defer srv.Close()
+
+	quota := NewUpstreamQuota(map[string]int{ProviderAmap: 3})
+	u := NewUpstreamClient(ProviderAmap, UpstreamConfig{Timeout: time.Second, MaxRetries: 3, FailureThreshold: 5}, checkAmapBody)
+	u.sleep = func(context.Context, time.Duration) error { return nil }
+	hooks := 0
+	u.beforeAttempt = func(context.Context) error {
+		hooks++
+		return quota.Reserve(ProviderAmap, 1)
+	}
+
+	// 每次重试都扣减配额
+	busy = 1
+	if _, err := u.Get(context.Background(), srv.URL); err != nil || calls != 2 || hooks != 2 || quota.Remaining(ProviderAmap) != 1 {
+		t.Fatalf("重试一次: %v calls=%d hooks=%d remaining=%d", err, calls, hooks, quota.Remaining(ProviderAmap))
+	}
+	// QPS超限不重试
+	calls = 0
+	if _, err := u.Get(context.Background(), srv.URL+"?qps=1"); err == nil || calls != 1 {
+		t.Fatalf("QPS超限: %v calls=%d", err, calls)
+	}
+	// 配额在重试中途用尽：不再请求上游，也不计入熔断
+	calls, busy = 0, 5
+	quota = NewUpstreamQuota(map[string]int{ProviderAmap: 2})
+	_, err := u.Get(context.Background(), srv.URL)
+	var quotaErr *QuotaExceededError
+	if !errors.As(err, &quotaErr) || calls != 2 {
+		t.Fatalf("配额用尽: %v calls=%d", err, calls)
+	}
+	if u.breaker.failures != 1 {
+		t.Fatalf("熔断计数 %d", u.breaker.failures)
+	}
+	calls = 0
+	if _, err := u.Get(context.Background(), srv.URL); !errors.As(err, &quotaErr) || calls != 0 {
+		t.Fatalf("配额已用尽时不应请求: %v calls=%d", err, calls)
+	}
+}
@@ -0,0 +1,340 @@
+package main
+
+import (
+	"archive/zip"
+	"bytes"
+	"encoding/xml"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"path"
+	"strconv"
+	"strings"
+)
+
+// 极简XLSX读写：只解析工作表、共享字符串和单元格文本，不处理样式、公式和日期格式。
+// 数字单元格返回原始值，公式单元格返回缓存的计算结果；写入时文本一律用内联字符串
+
+// 读取时的上限：行列号不超过Excel规格，单个部件解压后不超过64MB，
+// 全部工作表补齐后的单元格总数不超过1000万
+const (
+	xlsxMaxRows     = 1048576
+	xlsxMaxCols     = 16384
+	xlsxMaxPartSize = 64 << 20
+	xlsxMaxCells    = 10000000
+)
+
+// 一个工作表，Rows[i]为第i+1行，缺失的单元格为空串
+type XLSXSheet struct {
+	Name   string
+	Hidden bool
+	Rows   [][]string
+	// 写入时按数字保存的列
+	Numeric []bool
+}
+
+type xlsxWorkbookXML struct {
+	Sheets []struct {
+		Name  string `xml:"name,attr"`
+		State string `xml:"state,attr"`
+		RID   string `xml:"http://schemas.openxmlformats.org/officeDocument/2006/relationships id,attr"`
+	} `xml:"sheets>sheet"`
+}
+
+type xlsxRelsXML struct {
+	Relationships []struct {
+		ID     string `xml:"Id,attr"`
+		Target string `xml:"Target,attr"`
+	} `xml:"Relationship"`
+}
+
+// 共享字符串或内联字符串：纯文本<t>或富文本<r><t>，拼音<rPh>忽略
+type xlsxStringItem struct {
+	T    string `xml:"t"`
+	Runs []struct {
+		T string `xml:"t"`
+	} `xml:"r"`
+}
+
+func (si xlsxStringItem) text() string {
+	if len(si.Runs) == 0 {
+		return si.T
+	}
+	var b strings.Builder
+	b.WriteString(si.T)
+	for _, r := range si.Runs {
+		b.WriteString(r.T)
+	}
+	return b.String()
+}
+
+type xlsxSheetXML struct {
+	Rows []struct {
+		R     int `xml:"r,attr"`
+		Cells []struct {
+			Ref string          `xml:"r,attr"`
+			T   string          `xml:"t,attr"`
+			V   string          `xml:"v"`
+			Is  *xlsxStringItem `xml:"is"`
+		} `xml:"c"`
+	} `xml:"sheetData>row"`
+}
+
+// 读取XLSX文件的全部工作表
+func readXLSX(data []byte) ([]XLSXSheet, error) {
+	zr, err := zip.NewReader(bytes.NewReader(data), int64(len(data)))
+	if err != nil {
+		return nil, fmt.Errorf("不是有效的XLSX文件: %v", err)
+	}
+	files := make(map[string]*zip.File, len(zr.File))
+	for _, f := range zr.File {
+		files[f.Name] = f
+	}
+	readPart := func(name string, v interface{}) error {
+		f, ok := files[name]
+		if !ok {
+			return fmt.Errorf("XLSX缺少 %s", name)
+		}
+		rc, err := f.Open()
+		if err != nil {
+			return err
+		}
+		defer rc.Close()
+		content, err := ioutil.ReadAll(io.LimitReader(rc, xlsxMaxPartSize+1))
+		if err != nil {
+			return err
+		}
+		if len(content) > xlsxMaxPartSize {
+			return fmt.Errorf("%s 解压后超过 %d MB", name, xlsxMaxPartSize>>20)
+		}
+		if err := xml.Unmarshal(content, v); err != nil {
+			return fmt.Errorf("解析 %s 失败: %v", name, err)
+		}
+		return nil
+	}
+
+	var wb xlsxWorkbookXML
+	if err := readPart("xl/workbook.xml", &wb); err != nil {
+		return nil, err
+	}
+	var rels xlsxRelsXML
+	if err := readPart("xl/_rels/workbook.xml.rels", &rels); err != nil {
+		return nil, err
+	}
+	targets := map[string]string{}
+	for _, rel := range rels.Relationships {
+		if strings.HasPrefix(rel.Target, "/") {
+			targets[rel.ID] = strings.TrimPrefix(rel.Target, "/")
+		} else {
+			targets[rel.ID] = path.Join("xl", rel.Target)
+		}
+	}
+	var shared struct {
+		Items []xlsxStringItem `xml:"si"`
+	}
+	if _, ok := files["xl/sharedStrings.xml"]; ok {
+		if err := readPart("xl/sharedStrings.xml", &shared); err != nil {
+			return nil, err
+		}
+	}
+
+	sheets := make([]XLSXSheet, 0, len(wb.Sheets))
+	cellCount := 0
+	for _, s := range wb.Sheets {
+		var sx xlsxSheetXML
+		if err := readPart(targets[s.RID], &sx); err != nil {
+			return nil, err
+		}
+		sheet := XLSXSheet{Name: s.Name, Hidden: s.State == "hidden" || s.State == "veryHidden"}
+		for i, row := range sx.Rows {
+			rowNum := row.R
+			if rowNum == 0 {
+				rowNum = i + 1
+			}
+			if rowNum < 1 || rowNum > xlsxMaxRows {
+				return nil, fmt.Errorf("%s: 行号 %d 超出范围", s.Name, row.R)
+			}
+			for len(sheet.Rows) < rowNum {
+				sheet.Rows = append(sheet.Rows, nil)
+			}
+			cells := sheet.Rows[rowNum-1]
+			for j, c := range row.Cells {
+				col := j
+				if c.Ref != "" {
+					if col, _, err = parseCellRef(c.Ref); err != nil {
+						return nil, fmt.Errorf("%s: %v", s.Name, err)
+					}
+				}
+				if col >= xlsxMaxCols {
+					return nil, fmt.Errorf("%s: 列号 %d 超出范围", s.Name, col+1)
+				}
+				if col >= len(cells) {
+					if cellCount += col + 1 - len(cells); cellCount > xlsxMaxCells {
+						return nil, fmt.Errorf("%s: 单元格超过 %d 个", s.Name, xlsxMaxCells)
+					}
+				}
+				for len(cells) <= col {
+					cells = append(cells, "")
+				}
+				switch c.T {
+				case "s":
+					var idx int
+					if _, err := fmt.Sscanf(c.V, "%d", &idx); err == nil && idx >= 0 && idx < len(shared.Items) {
+						cells[col] = shared.Items[idx].text()
+					}
+				case "inlineStr":
+					if c.Is != nil {
+						cells[col] = c.Is.text()
+					}
+				case "b":
+					cells[col] = map[string]string{"1": "TRUE", "0": "FALSE"}[c.V]
+				default:
+					cells[col] = c.V
+				}
+			}
+			sheet.Rows[rowNum-1] = cells
+		}
+		sheets = append(sheets, sheet)
+	}
+	return sheets, nil
+}
+
+// 单元格引用（如"AB12"）转为从0开始的列号和从1开始的行号
+func parseCellRef(ref string) (col, row int, err error) {
+	i := 0
+	for i < len(ref) && ref[i] >= 'A' && ref[i] <= 'Z' {
+		if col = col*26 + int(ref[i]-'A'+1); col > xlsxMaxCols {
+			return 0, 0, fmt.Errorf("单元格引用超出范围: %s", ref)
+		}
+		i++
+	}
+	if i == 0 {
+		return 0, 0, fmt.Errorf("无效的单元格引用: %s", ref)
+	}
+	row, err = strconv.Atoi(ref[i:])
+	if err != nil {
+		return 0, 0, fmt.Errorf("无效的单元格引用: %s", ref)
+	}
+	if row < 1 || row > xlsxMaxRows {
+		return 0, 0, fmt.Errorf("单元格引用超出范围: %s", ref)
+	}
+	return col - 1, row, nil
+}
+
+// 写入XLSX：每个工作表首行为表头（加粗并冻结），Numeric[i]为true的列按数字写入，其余均为文本
+func writeXLSX(w io.Writer, sheets []XLSXSheet) error {
+	zw := zip.NewWriter(w)
+	add := func(name, content string) error {
+		f, err := zw.Create(name)
+		if err != nil {
+			return err
+		}
+		_, err = io.WriteString(f, content)
+		return err
+	}
+
+	var contentTypes, workbook, rels strings.Builder
+	contentTypes.WriteString(xml.Header + `<Types xmlns="http://schemas.openxmlformats.org/package/2006/content-types">` +
+		`<Default Extension="rels" ContentType="application/vnd.openxmlformats-package.relationships+xml"/>` +
+		`<Default Extension="xml" ContentType="application/xml"/>` +
+		`<Override PartName="/xl/workbook.xml" ContentType="application/vnd.openxmlformats-officedocument.spreadsheetml.sheet.main+xml"/>` +
+		`<Override PartName="/xl/styles.xml" ContentType="application/vnd.openxmlformats-officedocument.spreadsheetml.styles+xml"/>`)
+	workbook.WriteString(xml.Header + `<workbook xmlns="http://schemas.openxmlformats.org/spreadsheetml/2006/main" ` +
+		`xmlns:r="http://schemas.openxmlformats.org/officeDocument/2006/relationships"><sheets>`)
+	rels.WriteString(xml.Header + `<Relationships xmlns="http://schemas.openxmlformats.org/package/2006/relationships">`)
+	for i, s := range sheets {
+		n := i + 1
+		fmt.Fprintf(&contentTypes, `<Override PartName="/xl/worksheets/sheet%d.xml" ContentType="application/vnd.openxmlformats-officedocument.spreadsheetml.worksheet+xml"/>`, n)
+		fmt.Fprintf(&workbook, `<sheet name="%s" sheetId="%d" r:id="rId%d"/>`, xlsxEscape(xlsxSheetName(s.Name, n)), n, n)
+		fmt.Fprintf(&rels, `<Relationship Id="rId%d" Type="http://schemas.openxmlformats.org/officeDocument/2006/relationships/worksheet" Target="worksheets/sheet%d.xml"/>`, n, n)
+	}
+	contentTypes.WriteString(`</Types>`)
+	workbook.WriteString(`</sheets></workbook>`)
+	fmt.Fprintf(&rels, `<Relationship Id="rId%d" Type="http://schemas.openxmlformats.org/officeDocument/2006/relationships/styles" Target="styles.xml"/></Relationships>`, len(sheets)+1)
+
+	parts := [][2]string{
+		{"[Content_Types].xml", contentTypes.String()},
+		{"_rels/.rels", xml.Header + `<Relationships xmlns="http://schemas.openxmlformats.org/package/2006/relationships">` +
+			`<Relationship Id="rId1" Type="http://schemas.openxmlformats.org/officeDocument/2006/relationships/officeDocument" Target="xl/workbook.xml"/></Relationships>`},
+		{"xl/workbook.xml", workbook.String()},
+		{"xl/_rels/workbook.xml.rels", rels.String()},
+		// 样式0为默认，样式1为加粗表头
+		{"xl/styles.xml", xml.Header + `<styleSheet xmlns="http://schemas.openxmlformats.org/spreadsheetml/2006/main">` +
+			`<fonts count="2"><font><sz val="11"/><name val="Calibri"/></font><font><b/><sz val="11"/><name val="Calibri"/></font></fonts>` +
+			`<fills count="2"><fill><patternFill patternType="none"/></fill><fill><patternFill patternType="gray125"/></fill></fills>` +
+			`<borders count="1"><border/></borders><cellStyleXfs count="1"><xf/></cellStyleXfs>` +
+			`<cellXfs count="2"><xf fontId="0"/><xf fontId="1" applyFont="1"/></cellXfs></styleSheet>`},
+	}
+	for _, p := range parts {
+		if err := add(p[0], p[1]); err != nil {
+			return err
+		}
+	}
+	for i, s := range sheets {
+		if err := add(fmt.Sprintf("xl/worksheets/sheet%d.xml", i+1), xlsxSheetContent(s)); err != nil {
+			return err
+		}
+	}
+	return zw.Close()
+}
+
+func xlsxSheetContent(s XLSXSheet) string {
+	var b strings.Builder
+	b.WriteString(xml.Header + `<worksheet xmlns="http://schemas.openxmlformats.org/spreadsheetml/2006/main">`)
+	b.WriteString(`<sheetViews><sheetView workbookViewId="0"><pane ySplit="1" topLeftCell="A2" activePane="bottomLeft" state="frozen"/></sheetView></sheetViews><sheetData>`)
+	for r, row := range s.Rows {
+		fmt.Fprintf(&b, `<row r="%d">`, r+1)
+		for c, v := range row {
+			if v == "" {
+				continue
+			}
+			ref := cellRef(c, r+1)
+			if r > 0 && c < len(s.Numeric) && s.Numeric[c] {
+				if _, err := strconv.ParseFloat(v, 64); err == nil {
+					fmt.Fprintf(&b, `<c r="%s"><v>%s</v></c>`, ref, v)
+					continue
+				}
+			}
+			style := ""
+			if r == 0 {
+				style = ` s="1"`
+			}
+			fmt.Fprintf(&b, `<c r="%s" t="inlineStr"%s><is><t xml:space="preserve">%s</t></is></c>`, ref, style, xlsxEscape(v))
+		}
+		b.WriteString(`</row>`)
+	}
+	b.WriteString(`</sheetData></worksheet>`)
+	return b.String()
+}
+
+func xlsxEscape(s string) string {
+	var b strings.Builder
+	xml.EscapeText(&b, []byte(s))
+	return b.String()
+}
+
+// 工作表名最长31个字符且不能含 []:*?/\
+func xlsxSheetName(name string, n int) string {
+	name = strings.Map(func(r rune) rune {
+		if strings.ContainsRune(`[]:*?/\`, r) {
+			return '_'
+		}
+		return r
+	}, name)
+	if runes := []rune(name); len(runes) > 31 {
+		name = string(runes[:31])
+	}
+	if name == "" {
+		name = fmt.Sprintf("Sheet%d", n)
+	}
+	return name
+}
+
+// 从0开始的列号和从1开始的行号转为单元格引用（如"AB12"）
+func cellRef(col, row int) string {
+	name := ""
+	for col++; col > 0; col = (col - 1) / 26 {
+		name = string(rune('A'+(col-1)%26)) + name
+	}
+	return name + strconv.Itoa(row)
+}
@@ -29,6 +29,10 @@ func main() {
 		fmt.Println("请先设置环境变量AMAP_KEY")
 		return
 	}
+	baseURL := os.Getenv("AMAP_BASE_URL")
+	if baseURL == "" {
+		baseURL = "https://restapi.amap.com"
+	}
 	city := "北京"
 	keyword := "三甲医院"
 	outputFile := "beijing_tier3_hospitals_by_keyword_go.json"
@@ -36,7 +40,7 @@ func main() {
 	allPois := make([]POI, 0)
 	page := 1
 	for {
-		url := fmt.Sprintf("https://restapi.amap.com/v3/place/text?key=%s&keywords=%s&city=%s&citylimit=true&offset=25&page=%d", key, keyword, city, page)
+		url := fmt.Sprintf("%s/v3/place/text?key=%s&keywords=%s&city=%s&citylimit=true&offset=25&page=%d", baseURL, key, keyword, city, page)
 		resp, err := http.Get(url)
 		if err != nil {
 			fmt.Println("请求失败:", err)